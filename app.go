@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sync"
 
 	"seratosync-go/config"
 	"seratosync-go/library"
 	"seratosync-go/serato"
+	"seratosync-go/syncer"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
@@ -17,6 +20,19 @@ type App struct {
 	ctx        context.Context
 	configPath string
 	config     *config.Config
+
+	cancelMu   sync.Mutex
+	cancelSync context.CancelFunc
+
+	// diffMu guards lastDiff, the database changes made by the last sync
+	// or cleanup, for GetLastSyncDiff.
+	diffMu   sync.Mutex
+	lastDiff *serato.DatabaseDiff
+
+	// stepMu guards scan, the scan made by ScanStep for DiffStep and
+	// WriteStep when a sync is run step by step.
+	stepMu sync.Mutex
+	scan   *syncer.Scan
 }
 
 // NewApp creates a new App application struct
@@ -28,24 +44,24 @@ func NewApp() *App {
 // so we can call the runtime methods
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
-	a.logMessage("Application starting up...")
+	a.logInfo("Application starting up...")
 
 	// Load config
 	configPath, err := config.GetDefaultConfigPath()
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error getting default config path: %v", err))
+		a.logError(fmt.Sprintf("Error getting default config path: %v", err))
 		return
 	}
 	a.configPath = configPath
-	a.logMessage(fmt.Sprintf("Using config file at: %s", configPath))
+	a.logInfo(fmt.Sprintf("Using config file at: %s", configPath))
 
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error loading config: %v", err))
+		a.logError(fmt.Sprintf("Error loading config: %v", err))
 		return
 	}
 	a.config = cfg
-	a.logMessage(fmt.Sprintf("Config loaded: Serato DB Path='%s', Music Library Path='%s'", cfg.SeratoDBPath, cfg.MusicLibraryPath))
+	a.logInfo(fmt.Sprintf("Config loaded: Serato DB Path='%s', Music Library Path='%s'", cfg.SeratoDBPath, cfg.MusicLibraryPath))
 }
 
 // GetConfig returns the current configuration.
@@ -53,12 +69,22 @@ func (a *App) GetConfig() *config.Config {
 	return a.config
 }
 
-// SaveConfig saves the configuration.
+// SaveConfig saves the configuration. A config with invalid paths is not
+// saved; the problems are returned instead (see ValidateConfig).
 func (a *App) SaveConfig(cfg *config.Config) error {
+	if problems := config.Validate(cfg); len(problems) > 0 {
+		return config.JoinErrors(problems)
+	}
 	a.config = cfg
 	return config.SaveConfig(a.configPath, cfg)
 }
 
+// ValidateConfig checks cfg without saving it and returns one entry per
+// problem, keyed by the JSON name of the field at fault.
+func (a *App) ValidateConfig(cfg *config.Config) []config.FieldError {
+	return config.Validate(cfg)
+}
+
 // BrowseForDirectory opens a dialog to browse for a directory.
 func (a *App) BrowseForDirectory(dialogTitle string) (string, error) {
 	return runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
@@ -66,222 +92,939 @@ func (a *App) BrowseForDirectory(dialogTitle string) (string, error) {
 	})
 }
 
-// SyncLibrary performs the library synchronization.
-func (a *App) SyncLibrary() (string, error) {
-	a.logMessage("Starting library sync...")
+// AutoDetectSeratoPath returns the Serato folders found in the standard
+// locations, internal first. There is one per external drive Serato has
+// been used with, so the frontend lets the user pick.
+func (a *App) AutoDetectSeratoPath() ([]string, error) {
+	dirs, err := config.DetectSeratoDirs()
+	if err != nil {
+		return nil, err
+	}
+	a.logInfo(fmt.Sprintf("Found %d Serato folders.", len(dirs)))
+	return dirs, nil
+}
+
+// SyncLibrary performs the library synchronization and returns its totals.
+// It is ScanStep followed by WriteStep.
+func (a *App) SyncLibrary() (*syncer.Result, error) {
+	return a.syncLibrary(false, "")
+}
+
+// SyncFolder syncs a single library folder, given relative to the music
+// library root: only that folder is scanned and only its crate is written,
+// while new tracks are still added to the full database.
+func (a *App) SyncFolder(relDir string) (*syncer.Result, error) {
+	return a.syncLibrary(false, relDir)
+}
+
+// PlanSync runs the scan and diff steps of a sync without writing anything
+// and returns what SyncLibrary would change.
+func (a *App) PlanSync() (*syncer.Result, error) {
+	return a.syncLibrary(true, "")
+}
+
+// ScanStep is the first step of a sync run step by step: it scans the
+// music libraries and keeps the scan for DiffStep and WriteStep, replacing
+// any scan from an earlier step.
+func (a *App) ScanStep() (*syncer.Scan, error) {
+	return a.scanStep("")
+}
+
+// DiffStep compares the scan made by ScanStep with the database and
+// returns what WriteStep will change, without writing anything.
+func (a *App) DiffStep() (*syncer.Result, error) {
+	a.stepMu.Lock()
+	scan := a.scan
+	a.stepMu.Unlock()
+	if scan == nil {
+		return nil, fmt.Errorf("nothing to compare; scan the music libraries first")
+	}
+
+	return a.runSync(true, scan)
+}
 
-	// --- Stats counters ---
+// WriteStep syncs the scan made by ScanStep into the database and crates
+// and returns the totals. The database is compared again as it is written,
+// so changes made to it since DiffStep are kept. The scan is used up:
+// another sync starts with ScanStep again.
+func (a *App) WriteStep() (*syncer.Result, error) {
+	a.stepMu.Lock()
+	scan := a.scan
+	a.scan = nil
+	a.stepMu.Unlock()
+	if scan == nil {
+		return nil, fmt.Errorf("nothing to write; scan the music libraries first")
+	}
+	return a.runSync(false, scan)
+}
+
+// PreviewCrate returns the tracks the crate of library folder relDir will
+// hold after a sync, without writing anything (see syncer.PreviewCrate).
+func (a *App) PreviewCrate(relDir string) ([]string, error) {
+	tracks, err := syncer.PreviewCrate(a.ctx, a.config, relDir)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error previewing crate: %v", err))
+		return nil, err
+	}
+	return tracks, nil
+}
 
-	cratesWritten := 0
-	tracksWritten := 0
-	tracksAddedToDb := 0
+// CancelSync aborts a running sync or sync plan. Crate files already
+// written are left in place; the database is only written at the end of a
+// sync, so it is either fully updated or untouched.
+func (a *App) CancelSync() {
+	a.cancelMu.Lock()
+	defer a.cancelMu.Unlock()
+	if a.cancelSync != nil {
+		a.cancelSync()
+	}
+}
 
-	// 1. Read config
-	if a.config.SeratoDBPath == "" || a.config.MusicLibraryPath == "" {
-		a.logMessage("Error: Serato DB path or Music Library path not set.")
-		return "", fmt.Errorf("paths not set")
+// syncLibrary scans the music libraries, or folder in them, and runs the
+// sync on the scan.
+func (a *App) syncLibrary(dryRun bool, folder string) (*syncer.Result, error) {
+	if _, err := a.scanStep(folder); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return a.DiffStep()
 	}
+	return a.WriteStep()
+}
+
+// scanStep is ScanStep limited to folder if it isn't empty.
+func (a *App) scanStep(folder string) (*syncer.Scan, error) {
+	opts := a.syncOptions()
+	opts.Folder = folder
+	ctx, done := a.cancellable()
+	defer done()
 
-	// 2. Scan library
-	a.logMessage(fmt.Sprintf("Scanning music library at %s...", a.config.MusicLibraryPath))
-	libraryMap, err := library.ScanLibrary(a.config.MusicLibraryPath)
+	scan, err := syncer.ScanLibraries(ctx, a.config, opts)
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error scanning library: %v", err))
-		return "", err
+		return nil, err
 	}
-	numDirs, numFiles := library.GetLibraryStats(libraryMap)
-	a.logMessage(fmt.Sprintf("Found %d directories and %d audio files.", numDirs, numFiles))
+	a.stepMu.Lock()
+	a.scan = scan
+	a.stepMu.Unlock()
+	return scan, nil
+}
 
-	// Log first 5 files found
-	filesLogged := 0
-	for _, files := range libraryMap {
-		if filesLogged >= 5 {
-			break
+// runSync runs the shared sync pipeline on scan, cancellable with
+// CancelSync.
+func (a *App) runSync(dryRun bool, scan *syncer.Scan) (*syncer.Result, error) {
+	opts := a.syncOptions()
+	opts.DryRun = dryRun
+	opts.Folder = scan.Folder
+	opts.Scan = scan
+	ctx, done := a.cancellable()
+	defer done()
+
+	result, err := syncer.Run(ctx, a.config, opts)
+	if result != nil && !dryRun {
+		a.setLastDiff(&result.Diff)
+	}
+	return result, err
+}
+
+// syncOptions returns the sync options shared by every sync, wiring its
+// log and progress output to frontend events.
+func (a *App) syncOptions() syncer.Options {
+	progress := make(map[string]*progressReporter)
+	var progressMu sync.Mutex
+	return syncer.Options{
+		Log:        a.logSync,
+		CachePath:  config.ScanCachePath(a.configPath),
+		HistoryDir: config.HistoryDir(a.configPath),
+		Progress: func(phase string, current, total int) {
+			progressMu.Lock()
+			reporter, ok := progress[phase]
+			if !ok {
+				reporter = a.newProgress(phase)
+				progress[phase] = reporter
+			}
+			progressMu.Unlock()
+			reporter.Report(current, total)
+		},
+	}
+}
+
+// cancellable returns a context CancelSync cancels until done is called.
+func (a *App) cancellable() (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.cancelMu.Lock()
+	a.cancelSync = cancel
+	a.cancelMu.Unlock()
+	return ctx, func() {
+		a.cancelMu.Lock()
+		a.cancelSync = nil
+		a.cancelMu.Unlock()
+		cancel()
+	}
+}
+
+// FindDuplicates looks through every music library for audio files with
+// identical contents (see library.FindDuplicateFiles) and returns them
+// grouped by checksum, as full paths. Files are only compared with others
+// in the same library. CancelSync stops the search.
+func (a *App) FindDuplicates() (map[string][]string, error) {
+	ctx, done := a.cancellable()
+	defer done()
+
+	duplicates := make(map[string][]string)
+	for _, root := range a.config.LibraryPaths() {
+		a.logInfo(fmt.Sprintf("Looking for duplicate files in %s...", root))
+		scanOpts := syncer.ScanOptions(a.config)
+		scanOpts.Log = a.logWarn
+		libraryMap, err := library.ScanLibraryWithOptions(ctx, root, scanOpts)
+		if err != nil {
+			a.logError(fmt.Sprintf("Error scanning library: %v", err))
+			return nil, err
 		}
-		for _, file := range files {
-			if filesLogged >= 5 {
-				break
+		groups, err := library.FindDuplicateFilesContext(ctx, libraryMap, root, a.config.ScanWorkers)
+		if err != nil {
+			a.logError(fmt.Sprintf("Error comparing files: %v", err))
+			return nil, err
+		}
+		for sum, files := range groups {
+			for _, relFile := range files {
+				duplicates[sum] = append(duplicates[sum], filepath.Join(root, relFile))
 			}
-			a.logMessage(fmt.Sprintf("  - Found library file: %s", file))
-			filesLogged++
 		}
 	}
+	a.logInfo(fmt.Sprintf("Found %d sets of identical files.", len(duplicates)))
+	return duplicates, nil
+}
+
+// GetLastSyncDiff returns the database records added, removed and changed
+// by the last sync or cleanup, or nil if neither has run yet.
+func (a *App) GetLastSyncDiff() *serato.DatabaseDiff {
+	a.diffMu.Lock()
+	defer a.diffMu.Unlock()
+	return a.lastDiff
+}
+
+// setLastDiff records diff for GetLastSyncDiff.
+func (a *App) setLastDiff(diff *serato.DatabaseDiff) {
+	a.diffMu.Lock()
+	a.lastDiff = diff
+	a.diffMu.Unlock()
+}
 
-	// 3. Read Serato database
-	dbPath := filepath.Join(a.config.SeratoDBPath, "database V2")
-	a.logMessage(fmt.Sprintf("Reading Serato database at %s...", dbPath))
-	existingRecords, pfilSet, libraryPrefix, err := serato.ReadDatabaseV2(dbPath, a.config.MusicLibraryPath)
+// GetSyncHistory returns the manifests of the last limit syncs, newest
+// first, or of every sync kept if limit is zero.
+func (a *App) GetSyncHistory(limit int) ([]syncer.Manifest, error) {
+	return syncer.ReadHistory(config.HistoryDir(a.configPath), limit)
+}
+
+// GetHistory returns the playing sessions in Serato's history, newest
+// first, with the tracks loaded during each. It only reads the history.
+func (a *App) GetHistory() ([]serato.Session, error) {
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return nil, fmt.Errorf("path not set")
+	}
+	sessions, err := a.config.Files().ReadHistory(a.config.SeratoDBPath)
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error reading database: %v", err))
-		return "", err
+		a.logError(fmt.Sprintf("Error reading Serato history: %v", err))
+		return nil, err
 	}
-	a.logMessage(fmt.Sprintf("Found %d tracks in the database for comparison.", len(pfilSet)))
-
-	// Log first 5 tracks found
-	tracksLogged := 0
-	for pfil := range pfilSet {
-		if tracksLogged >= 5 {
-			break
+	for _, session := range sessions {
+		if session.Error != "" {
+			a.logWarn(fmt.Sprintf("Warning: could not read history session %s: %s", session.Name, session.Error))
 		}
-		a.logMessage(fmt.Sprintf("  - Found DB track for comparison: %s", pfil))
-		tracksLogged++
 	}
+	a.logInfo(fmt.Sprintf("Found %d sessions in Serato's history.", len(sessions)))
+	return sessions, nil
+}
 
-	a.logMessage(fmt.Sprintf("Using prefix from library path: %s", libraryPrefix))
+// DatabaseReport is the result of GenerateReport: the report as text and
+// the numbers behind it.
+type DatabaseReport struct {
+	Text  string        `json:"text"`
+	Stats serato.Report `json:"stats"`
+}
 
-	// 4. Detect new tracks by comparing relative paths
-	var relativeTrackPaths []string
-	for _, files := range libraryMap {
-		relativeTrackPaths = append(relativeTrackPaths, files...)
+// GenerateReport generates a database report.
+func (a *App) GenerateReport() (*DatabaseReport, error) {
+	a.logInfo("Generating database report...")
+
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return nil, fmt.Errorf("path not set")
+	}
+
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	records, _, _, err := a.config.Files().ReadDatabaseV2(dbPath, "")
+	if err != nil {
+		a.logError(fmt.Sprintf("Error reading database: %v", err))
+		return nil, err
 	}
 
-	newRelativePaths := library.DetectNewTracks(relativeTrackPaths, pfilSet)
-	a.logMessage(fmt.Sprintf("Found %d new tracks.", len(newRelativePaths)))
+	// Libraries on other drives have their own database.
+	var prefixes []string
+	for _, root := range a.config.LibraryPaths() {
+		if serato.DatabaseDirFor(a.config.SeratoDBPath, root) == a.config.SeratoDBPath {
+			prefixes = append(prefixes, serato.LibraryPrefix(root))
+		}
+	}
 
-	// Build set of affected ptrks (full paths of new tracks)
-	affectedPtrks := make(map[string]struct{})
-	for _, relPfil := range newRelativePaths {
-		fullPfil := serato.BuildPtrk(libraryPrefix, relPfil)
-		affectedPtrks[fullPfil] = struct{}{}
+	stats := serato.BuildReport(records, prefixes)
+	if stats.Version, err = a.config.Files().ReadDatabaseVersion(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error reading database version: %v", err))
+		return nil, err
 	}
+	report := &DatabaseReport{Text: stats.String(), Stats: stats}
+	a.logInfo(report.Text)
+	return report, nil
+}
 
-	// 5. Build crate plans (crates need full paths)
-	cratePlans := library.BuildCratePlans(libraryMap, libraryPrefix, a.config.SeratoDBPath)
+// ExportDatabase writes the parsed database to a JSON file for inspection.
+// If outPath is empty the user is asked where to save it. The path written
+// is returned, or an empty string if the dialog was cancelled.
+func (a *App) ExportDatabase(outPath string) (string, error) {
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return "", fmt.Errorf("path not set")
+	}
 
-	// 6. Write crate files only for crates containing affected tracks
-	a.logMessage("Writing crate files...")
-	for _, plan := range cratePlans {
-		// Check if this crate contains any affected tracks
-		hasAffected := false
-		for _, ptrk := range plan.TrackPaths {
-			if _, ok := affectedPtrks[ptrk]; ok {
-				hasAffected = true
-				break
-			}
-		}
-		if !hasAffected {
-			continue
+	if outPath == "" {
+		var err error
+		outPath, err = runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+			Title:           "Export Database as JSON",
+			DefaultFilename: "database.json",
+		})
+		if err != nil || outPath == "" {
+			return "", err
 		}
+	}
 
-		err := serato.WriteCrateFile(plan.CratePath, plan.TrackPaths)
-		if err != nil {
-			a.logMessage(fmt.Sprintf("Error writing crate file %s: %v", plan.CratePath, err))
-		} else {
-			a.logMessage(fmt.Sprintf("Wrote crate file %s with %d tracks.", filepath.Base(plan.CratePath), len(plan.TrackPaths)))
-			cratesWritten++
-			tracksWritten += len(plan.TrackPaths)
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	a.logInfo(fmt.Sprintf("Exporting database to %s...", outPath))
+	err := a.config.Files().ExportDatabaseJSON(dbPath, outPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error exporting database: %v", err))
+		return "", err
+	}
+	a.logInfo("Database export complete.")
+	return outPath, nil
+}
+
+// ExportSeratoBundle packages the database and every crate into a zip for
+// backing up or moving a Serato setup (see serato.ExportBundle). If
+// outZip is empty the user is asked where to save it. The path written is
+// returned, or an empty string if the dialog was cancelled.
+func (a *App) ExportSeratoBundle(outZip string) (string, error) {
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return "", fmt.Errorf("path not set")
+	}
+
+	if outZip == "" {
+		var err error
+		outZip, err = runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+			Title:           "Export Serato Bundle",
+			DefaultFilename: "serato-bundle.zip",
+		})
+		if err != nil || outZip == "" {
+			return "", err
 		}
 	}
 
-	// 7. Add new tracks to database
-	if len(newRelativePaths) > 0 {
-		a.logMessage(fmt.Sprintf("Adding %d new tracks to the database...", len(newRelativePaths)))
-		var newRecords []serato.Record
-		for _, relPfil := range newRelativePaths {
-			// Construct the full path for the database record
-			fullPfil := serato.BuildPtrk(libraryPrefix, relPfil)
-			newRecord := serato.Record{"pfil": fullPfil}
-			newRecords = append(newRecords, newRecord)
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	a.logInfo(fmt.Sprintf("Exporting the database and crates to %s...", outZip))
+	manifest, err := a.config.Files().ExportBundle(dbPath, outZip)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error exporting bundle: %v", err))
+		return "", err
+	}
+	a.logInfo(fmt.Sprintf("Exported %d tracks and %d crates.", manifest.TrackCount, len(manifest.Crates)))
+	return outZip, nil
+}
+
+// ImportSeratoBundle restores the database and crates from a bundle made
+// by ExportSeratoBundle. The bundle is checked in full before anything is
+// written, and the current database and crates are backed up first. If
+// inZip is empty the user is asked for the file. The path imported is
+// returned, or an empty string if the dialog was cancelled.
+func (a *App) ImportSeratoBundle(inZip string) (string, error) {
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return "", fmt.Errorf("path not set")
+	}
+
+	if inZip == "" {
+		var err error
+		inZip, err = runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+			Title:   "Import Serato Bundle",
+			Filters: []runtime.FileFilter{{DisplayName: "Serato bundles (*.zip)", Pattern: "*.zip"}},
+		})
+		if err != nil || inZip == "" {
+			return "", err
 		}
+	}
 
-		allRecords := append(existingRecords, newRecords...)
+	a.logInfo(fmt.Sprintf("Importing the database and crates from %s...", inZip))
+	bundle, err := a.config.Files().OpenBundle(inZip)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error reading bundle: %v", err))
+		return "", err
+	}
+	defer bundle.Close()
 
-		// Backup database before writing
-		backupPath, err := serato.BackupDatabase(dbPath)
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	if err := serato.CheckDatabaseWritable(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. Nothing was imported.", err))
+		return "", err
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		backupPath, err := a.config.Files().BackupDatabaseTo(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath))
 		if err != nil {
-			a.logMessage(fmt.Sprintf("Error creating database backup: %v", err))
-		} else {
-			a.logMessage(fmt.Sprintf("Database backup created at %s", backupPath))
+			a.logError(fmt.Sprintf("Error creating database backup: %v", err))
+			return "", err
 		}
-
-		err = serato.WriteDatabaseV2Records(dbPath, allRecords)
+		a.logInfo(fmt.Sprintf("Database backup created at %s", backupPath))
+	}
+	if crateFiles, _ := serato.ListCrateFiles(a.config.SeratoDBPath); len(crateFiles) > 0 {
+		backupDir, err := a.config.Files().BackupSubcrates(a.config.SeratoDBPath)
 		if err != nil {
-			a.logMessage(fmt.Sprintf("Error writing updated database: %v", err))
-		} else {
-			tracksAddedToDb = len(newRelativePaths)
-			a.logMessage("Successfully updated database with new tracks.")
+			a.logError(fmt.Sprintf("Error backing up crates: %v", err))
+			return "", err
 		}
+		a.logInfo(fmt.Sprintf("Crates backed up to %s", backupDir))
 	}
 
-	// --- Final Summary ---
-	summary := "Sync Complete!"
-	a.logMessage("--------------------")
-	a.logMessage("SYNC SUMMARY")
-	a.logMessage("--------------------")
-	a.logMessage(fmt.Sprintf("Music Library Files Scanned: %d", numFiles))
-	a.logMessage(fmt.Sprintf("Serato Database Tracks Before Sync: %d", len(existingRecords)))
-	a.logMessage(fmt.Sprintf("New Tracks Detected: %d", len(newRelativePaths)))
-	a.logMessage(fmt.Sprintf("Tracks Added to Database: %d", tracksAddedToDb))
-	a.logMessage(fmt.Sprintf("Total Tracks in Database After Sync: %d", len(existingRecords)+tracksAddedToDb))
-	a.logMessage(fmt.Sprintf("Crate Files Written/Updated: %d", cratesWritten))
-	a.logMessage(fmt.Sprintf("Total Tracks Written to Crates: %d", tracksWritten))
-	a.logMessage("--------------------")
+	if err := bundle.Install(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error importing bundle: %v", err))
+		return "", err
+	}
+	a.logInfo(fmt.Sprintf("Imported %d tracks and %d crates.", bundle.Manifest.TrackCount, len(bundle.Manifest.Crates)))
+	return inZip, nil
+}
 
-	return summary, nil
+// BackupInfo describes a database backup for the restore chooser.
+type BackupInfo struct {
+	Path       string `json:"path"`
+	Timestamp  int64  `json:"timestamp"`
+	TrackCount int    `json:"track_count"`
 }
 
-func (a *App) logMessage(message string) {
-	runtime.EventsEmit(a.ctx, "log", message)
+// ListBackups lists the database backups, newest first. Backups that can't
+// be read as a database are reported with a track count of -1.
+func (a *App) ListBackups() ([]BackupInfo, error) {
+	if a.config.SeratoDBPath == "" {
+		return nil, fmt.Errorf("path not set")
+	}
+
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	backups, err := serato.ListBackupsIn(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]BackupInfo, 0, len(backups))
+	for _, backup := range backups {
+		info := BackupInfo{Path: backup.Path, Timestamp: backup.Timestamp, TrackCount: -1}
+		if dbInfo, err := a.config.Files().InspectDatabase(backup.Path); err == nil {
+			info.TrackCount = dbInfo.TrackCount
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
 }
 
-// GenerateReport generates a database report.
-func (a *App) GenerateReport() (string, error) {
-	a.logMessage("Generating database report...")
+// RestoreBackup replaces the database with the given backup. The current
+// database is backed up first so the restore can itself be undone.
+func (a *App) RestoreBackup(backupPath string) error {
+	a.logInfo(fmt.Sprintf("Restoring database from %s...", backupPath))
 
 	if a.config.SeratoDBPath == "" {
-		a.logMessage("Error: Serato DB path not set.")
-		return "", fmt.Errorf("path not set")
+		a.logError("Error: Serato DB path not set.")
+		return fmt.Errorf("path not set")
 	}
 
-	dbPath := filepath.Join(a.config.SeratoDBPath, "database V2")
-	records, _, _, err := serato.ReadDatabaseV2(dbPath, "")
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	if err := serato.CheckDatabaseWritable(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. The database was not restored.", err))
+		return err
+	}
+	currentBackup, err := a.config.Files().BackupDatabaseTo(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath))
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error reading database: %v", err))
-		return "", err
+		a.logError(fmt.Sprintf("Error creating backup: %v", err))
+		return err
 	}
+	a.logInfo(fmt.Sprintf("Database backup created at %s", currentBackup))
 
-	report := fmt.Sprintf("Database Report:\n- Total tracks: %d", len(records))
-	a.logMessage(report)
-	return report, nil
+	err = a.config.Files().RestoreDatabase(dbPath, backupPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error restoring database: %v", err))
+		return err
+	}
+	a.logInfo("Database restored.")
+	return nil
 }
 
 // CleanDatabase cleans the database.
 func (a *App) CleanDatabase() (string, error) {
-	a.logMessage("Cleaning database...")
+	a.logInfo("Cleaning database...")
 
 	if a.config.SeratoDBPath == "" {
-		a.logMessage("Error: Serato DB path not set.")
+		a.logError("Error: Serato DB path not set.")
 		return "", fmt.Errorf("path not set")
 	}
 
-	dbPath := filepath.Join(a.config.SeratoDBPath, "database V2")
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	if err := a.config.Files().CheckDatabaseV2(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. The database was not cleaned.", err))
+		return "", err
+	}
+	if err := serato.CheckDatabaseWritable(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. The database was not cleaned.", err))
+		return "", err
+	}
 
 	// Backup database
-	backupPath, err := serato.BackupDatabase(dbPath)
+	backupPath, err := a.config.Files().BackupDatabaseTo(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath))
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error creating backup: %v", err))
+		a.logError(fmt.Sprintf("Error creating backup: %v", err))
 		return "", err
 	}
-	a.logMessage(fmt.Sprintf("Database backup created at %s", backupPath))
+	a.logInfo(fmt.Sprintf("Database backup created at %s", backupPath))
 
-	// Read records
-	records, _, _, err := serato.ReadDatabaseV2(dbPath, "")
+	// Read records, skipping any damaged ones so the rest can be saved
+	db, damage, err := a.config.Files().ReadDatabaseLenient(a.ctx, dbPath)
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error reading database: %v", err))
+		a.logError(fmt.Sprintf("Error reading database: %v", err))
 		return "", err
 	}
+	for _, d := range damage {
+		a.logWarn(fmt.Sprintf("Warning: skipped %d damaged bytes at offset %d of the database.", d.Length, d.Offset))
+	}
+	records := db.Records
 
 	// Clean records
-	cleanedRecords, stats := serato.CleanDatabaseRecords(records, true, true)
+	cleanedRecords, stats := serato.CleanDatabaseRecordsWithOptions(records, serato.CleanupOptions{
+		RemoveDuplicates: true,
+		RequireMetadata:  true,
+		MetadataTags:     a.config.MetadataFields,
+		VerifyFiles:      a.config.VerifyFiles,
+		LibraryRoots:     a.config.LibraryPaths(),
+		FuzzyDuplicates:  a.config.FuzzyDuplicates,
+		MatchBySize:      a.config.FuzzyDuplicates,
+	})
 
 	// Write cleaned records
-	err = serato.WriteDatabaseV2Records(dbPath, cleanedRecords)
+	db.Records = cleanedRecords
+	if a.config.DatabaseVersion != "" {
+		db.Version = a.config.DatabaseVersion
+	}
+	err = a.config.Files().WriteDatabase(dbPath, db)
 	if err != nil {
-		a.logMessage(fmt.Sprintf("Error writing cleaned database: %v", err))
+		a.logError(fmt.Sprintf("Error writing cleaned database: %v", err))
 		return "", err
 	}
+	diff := serato.DiffDatabases(records, cleanedRecords)
+	a.setLastDiff(&diff)
+
+	if err := serato.PruneBackupsIn(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath), a.config.BackupsToKeep()); err != nil {
+		a.logWarn(fmt.Sprintf("Error pruning old database backups: %v", err))
+	}
+
+	if a.config.VerifyFiles {
+		a.logInfo(fmt.Sprintf("Removed %d records for missing or empty files.", stats.RemovedMissingFile))
+	}
+	if a.config.FuzzyDuplicates {
+		a.logInfo(fmt.Sprintf("Removed %d fuzzy duplicates.", stats.RemovedFuzzyDuplicates))
+		for _, match := range stats.Duplicates {
+			a.logInfo(fmt.Sprintf("  - Kept %s, removed %s", match.Kept, match.Removed))
+		}
+	}
 
 	result := fmt.Sprintf("Database cleanup complete.\nOriginal records: %d\nCleaned records: %d", stats.OriginalCount, stats.FinalCount)
-	a.logMessage(result)
+	a.logInfo(result)
+	return result, nil
+}
+
+// NormalizePaths rewrites database paths stored with backslashes, as left
+// by databases imported from Windows, to use forward slashes. The database
+// is backed up first and only written if a path changed.
+func (a *App) NormalizePaths() (string, error) {
+	a.logInfo("Normalizing database paths...")
+
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return "", fmt.Errorf("path not set")
+	}
+
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	if err := a.config.Files().CheckDatabaseV2(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. The database was not changed.", err))
+		return "", err
+	}
+	if err := serato.CheckDatabaseWritable(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. The database was not changed.", err))
+		return "", err
+	}
+
+	db, err := a.config.Files().ReadDatabase(a.ctx, dbPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error reading database: %v", err))
+		return "", err
+	}
+	records := db.Records
+	changed, normalized := serato.NormalizeDatabasePaths(records)
+	if changed == 0 {
+		result := "All database paths already use forward slashes."
+		a.logInfo(result)
+		return result, nil
+	}
+
+	backupPath, err := a.config.Files().BackupDatabaseTo(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath))
+	if err != nil {
+		a.logError(fmt.Sprintf("Error creating backup: %v", err))
+		return "", err
+	}
+	a.logInfo(fmt.Sprintf("Database backup created at %s", backupPath))
+
+	db.Records = normalized
+	if a.config.DatabaseVersion != "" {
+		db.Version = a.config.DatabaseVersion
+	}
+	if err := a.config.Files().WriteDatabase(dbPath, db); err != nil {
+		a.logError(fmt.Sprintf("Error writing database: %v", err))
+		return "", err
+	}
+	diff := serato.DiffDatabases(records, normalized)
+	a.setLastDiff(&diff)
+
+	if err := serato.PruneBackupsIn(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath), a.config.BackupsToKeep()); err != nil {
+		a.logWarn(fmt.Sprintf("Error pruning old database backups: %v", err))
+	}
+
+	result := fmt.Sprintf("Rewrote %d database paths with forward slashes.", changed)
+	a.logInfo(result)
+	return result, nil
+}
+
+// CompactDatabase trims trailing garbage, such as a partial chunk left by
+// another tool, from the end of the database (see serato.CompactDatabase),
+// after backing it up.
+func (a *App) CompactDatabase() (string, error) {
+	a.logInfo("Checking the end of the database for garbage...")
+
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return "", fmt.Errorf("path not set")
+	}
+
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	if err := a.config.Files().CheckDatabaseV2(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. The database was not changed.", err))
+		return "", err
+	}
+	garbage, err := a.config.Files().TrailingGarbage(dbPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error reading database: %v", err))
+		return "", err
+	}
+	if garbage == 0 {
+		result := "The database ends with a complete chunk; nothing to trim."
+		a.logInfo(result)
+		return result, nil
+	}
+	if err := serato.CheckDatabaseWritable(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. The database was not changed.", err))
+		return "", err
+	}
+
+	backupPath, err := a.config.Files().BackupDatabaseTo(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath))
+	if err != nil {
+		a.logError(fmt.Sprintf("Error creating backup: %v", err))
+		return "", err
+	}
+	a.logInfo(fmt.Sprintf("Database backup created at %s", backupPath))
+
+	trimmed, err := a.config.Files().CompactDatabase(dbPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error writing database: %v", err))
+		return "", err
+	}
+	if err := serato.PruneBackupsIn(dbPath, a.config.BackupDirFor(a.config.SeratoDBPath), a.config.BackupsToKeep()); err != nil {
+		a.logWarn(fmt.Sprintf("Error pruning old database backups: %v", err))
+	}
+
+	result := fmt.Sprintf("Trimmed %d bytes of garbage from the end of the database.", trimmed)
+	a.logInfo(result)
+	return result, nil
+}
+
+// ListCrates returns the crates in the Serato folder with their track
+// counts, without changing anything.
+func (a *App) ListCrates() ([]serato.CrateInfo, error) {
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return nil, fmt.Errorf("path not set")
+	}
+	crates, err := a.config.Files().ListCrates(a.config.SeratoDBPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error listing crates: %v", err))
+		return nil, err
+	}
+	for _, crate := range crates {
+		if crate.TrackCount < 0 {
+			a.logWarn(fmt.Sprintf("Warning: could not read crate %s: %s", crate.Name, crate.Error))
+		}
+	}
+	return crates, nil
+}
+
+// CleanCrates removes tracks whose files are missing from every crate, both
+// in the Serato folder and in the _Serato_ folders of external drives that
+// hold a music library. Each Subcrates folder is backed up first.
+func (a *App) CleanCrates() (string, error) {
+	a.logInfo("Cleaning crates...")
+
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return "", fmt.Errorf("path not set")
+	}
+	if serato.SeratoRunning() {
+		a.logError(fmt.Sprintf("Error: %v. The crates were not cleaned.", serato.ErrSeratoRunning))
+		return "", serato.ErrSeratoRunning
+	}
+
+	dirs := []string{a.config.SeratoDBPath}
+	roots := make(map[string][]string)
+	for _, root := range a.config.LibraryPaths() {
+		dir := serato.DatabaseDirFor(a.config.SeratoDBPath, root)
+		if _, ok := roots[dir]; !ok && dir != a.config.SeratoDBPath {
+			dirs = append(dirs, dir)
+		}
+		roots[dir] = append(roots[dir], root)
+	}
+
+	total := 0
+	for _, dir := range dirs {
+		if len(roots[dir]) == 0 {
+			continue
+		}
+		backupDir, err := a.config.Files().BackupSubcrates(dir)
+		if err != nil {
+			a.logError(fmt.Sprintf("Error backing up crates in %s: %v", dir, err))
+			return "", err
+		}
+		a.logInfo(fmt.Sprintf("Crate backup created at %s", backupDir))
+
+		cleaned, err := a.config.Files().CleanCrates(dir, roots[dir])
+		for _, crate := range cleaned {
+			a.logInfo(fmt.Sprintf("Removed %d missing tracks from crate %s.", crate.Removed, filepath.Base(crate.Crate)))
+			total += crate.Removed
+		}
+		if err != nil {
+			a.logError(fmt.Sprintf("Error cleaning crates in %s: %v", dir, err))
+			return "", err
+		}
+	}
+
+	result := fmt.Sprintf("Crate cleanup complete.\nMissing tracks removed: %d", total)
+	a.logInfo(result)
 	return result, nil
 }
+
+// RepairReport is the result of RepairLibrary: what it found wrong with the
+// database and crates in the Serato folder, and the backups it made before
+// fixing it. Text sums it up for the log.
+type RepairReport struct {
+	// TrailingBytes is the garbage cut from the end of the database and
+	// DamagedBytes the damaged stretches skipped inside it.
+	TrailingBytes   int                 `json:"trailing_bytes"`
+	DamagedBytes    int                 `json:"damaged_bytes"`
+	PathsNormalized int                 `json:"paths_normalized"`
+	Cleanup         serato.CleanupStats `json:"cleanup"`
+	// CrateTracksRemoved counts tracks whose files are missing, removed
+	// from the crates.
+	CrateTracksRemoved int `json:"crate_tracks_removed"`
+	// UnreadableCrates lists crates that couldn't be read and were left
+	// as they are.
+	UnreadableCrates []string `json:"unreadable_crates"`
+	Backups          []string `json:"backups"`
+	Text             string   `json:"text"`
+}
+
+// RepairLibrary puts the database and crates in the Serato folder right in
+// one go: it checks the database header, cuts garbage from the end of the
+// database and skips damaged stretches inside it (see
+// serato.ReadDatabaseLenient), normalizes path separators, removes broken
+// and duplicate records, and removes tracks whose files are missing from
+// the crates. Records without metadata are kept; CleanDatabase removes
+// those. Everything is worked out before anything is written, and the
+// database and Subcrates folder are backed up once beforehand. A library
+// with nothing to repair is left untouched, so running it again changes
+// nothing.
+func (a *App) RepairLibrary() (*RepairReport, error) {
+	a.logInfo("Repairing the database and crates...")
+
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return nil, fmt.Errorf("path not set")
+	}
+
+	seratoDir := a.config.SeratoDBPath
+	dbPath := a.config.DatabasePath(seratoDir)
+	if err := a.config.Files().CheckDatabaseV2(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. Restore a backup instead; nothing was repaired.", err))
+		return nil, err
+	}
+	if err := serato.CheckDatabaseWritable(dbPath); err != nil {
+		a.logError(fmt.Sprintf("Error: %v. Nothing was repaired.", err))
+		return nil, err
+	}
+
+	// Work out every repair first.
+	report := &RepairReport{UnreadableCrates: []string{}, Backups: []string{}}
+	db, damage, err := a.config.Files().ReadDatabaseLenient(a.ctx, dbPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error reading database: %v", err))
+		return nil, err
+	}
+	dbSize := serato.FileSize(dbPath)
+	for _, d := range damage {
+		if int64(d.Offset+d.Length) == dbSize {
+			report.TrailingBytes += d.Length
+		} else {
+			report.DamagedBytes += d.Length
+		}
+	}
+	records := db.Records
+	var normalized []serato.Record
+	report.PathsNormalized, normalized = serato.NormalizeDatabasePaths(records)
+	var cleaned []serato.Record
+	cleaned, report.Cleanup = serato.CleanDatabaseRecordsWithOptions(normalized, serato.CleanupOptions{
+		RemoveDuplicates: true,
+		VerifyFiles:      a.config.VerifyFiles,
+		LibraryRoots:     a.config.LibraryPaths(),
+		FuzzyDuplicates:  a.config.FuzzyDuplicates,
+		MatchBySize:      a.config.FuzzyDuplicates,
+	})
+	repairDatabase := len(damage) > 0 || report.PathsNormalized > 0 || report.Cleanup.FinalCount != report.Cleanup.OriginalCount
+
+	var roots []string
+	for _, root := range a.config.LibraryPaths() {
+		if serato.DatabaseDirFor(seratoDir, root) == seratoDir {
+			roots = append(roots, root)
+		}
+	}
+	crates, err := a.config.Files().ListCrates(seratoDir)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error listing crates: %v", err))
+		return nil, err
+	}
+	for _, crate := range crates {
+		if crate.TrackCount < 0 {
+			a.logWarn(fmt.Sprintf("Warning: could not read crate %s, which is left as it is: %s", crate.Name, crate.Error))
+			report.UnreadableCrates = append(report.UnreadableCrates, crate.Name)
+		}
+	}
+	missing, _ := a.config.Files().FindMissingCrateTracks(seratoDir, roots)
+	repairCrates := len(missing) > 0
+
+	if !repairDatabase && !repairCrates {
+		report.Text = "The database and crates are healthy; nothing was changed."
+		if len(report.UnreadableCrates) > 0 {
+			report.Text += fmt.Sprintf("\n%d crates could not be read.", len(report.UnreadableCrates))
+		}
+		a.logInfo(report.Text)
+		return report, nil
+	}
+
+	// One backup of everything about to change, before anything is written.
+	if repairDatabase {
+		backupPath, err := a.config.Files().BackupDatabaseTo(dbPath, a.config.BackupDirFor(seratoDir))
+		if err != nil {
+			a.logError(fmt.Sprintf("Error creating backup: %v", err))
+			return nil, err
+		}
+		a.logInfo(fmt.Sprintf("Database backup created at %s", backupPath))
+		report.Backups = append(report.Backups, backupPath)
+	}
+	if repairCrates {
+		backupDir, err := a.config.Files().BackupSubcrates(seratoDir)
+		if err != nil {
+			a.logError(fmt.Sprintf("Error backing up crates in %s: %v", seratoDir, err))
+			return nil, err
+		}
+		a.logInfo(fmt.Sprintf("Crate backup created at %s", backupDir))
+		report.Backups = append(report.Backups, backupDir)
+	}
+
+	if repairDatabase {
+		db.Records = cleaned
+		if a.config.DatabaseVersion != "" {
+			db.Version = a.config.DatabaseVersion
+		}
+		if err := a.config.Files().WriteDatabase(dbPath, db); err != nil {
+			a.logError(fmt.Sprintf("Error writing database: %v", err))
+			return nil, err
+		}
+		diff := serato.DiffDatabases(records, cleaned)
+		a.setLastDiff(&diff)
+		if err := serato.PruneBackupsIn(dbPath, a.config.BackupDirFor(seratoDir), a.config.BackupsToKeep()); err != nil {
+			a.logWarn(fmt.Sprintf("Error pruning old database backups: %v", err))
+		}
+	}
+	if repairCrates {
+		cleanedCrates, err := a.config.Files().CleanCrates(seratoDir, roots)
+		for _, crate := range cleanedCrates {
+			a.logInfo(fmt.Sprintf("Removed %d missing tracks from crate %s.", crate.Removed, filepath.Base(crate.Crate)))
+			report.CrateTracksRemoved += crate.Removed
+		}
+		if err != nil {
+			a.logError(fmt.Sprintf("Error cleaning crates: %v", err))
+			return nil, err
+		}
+	}
+
+	report.Text = fmt.Sprintf("Repair complete.\nGarbage trimmed from the end of the database: %d bytes\nDamaged bytes skipped: %d\nPaths normalized: %d\nBroken or duplicate records removed: %d\nMissing tracks removed from crates: %d",
+		report.TrailingBytes, report.DamagedBytes, report.PathsNormalized, report.Cleanup.OriginalCount-report.Cleanup.FinalCount, report.CrateTracksRemoved)
+	if len(report.UnreadableCrates) > 0 {
+		report.Text += fmt.Sprintf("\nUnreadable crates left as they are: %d", len(report.UnreadableCrates))
+	}
+	a.logInfo(report.Text)
+	return report, nil
+}
+
+// ValidateMetadata reports text in the database and crates that looks
+// wrongly encoded, such as titles full of replacement characters after an
+// import with the wrong encoding. Nothing is changed.
+func (a *App) ValidateMetadata() ([]serato.MetadataWarning, error) {
+	a.logInfo("Checking metadata encoding...")
+
+	if a.config.SeratoDBPath == "" {
+		a.logError("Error: Serato DB path not set.")
+		return nil, fmt.Errorf("path not set")
+	}
+
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	records, _, _, err := a.config.Files().ReadDatabaseV2(dbPath, "")
+	if err != nil {
+		a.logError(fmt.Sprintf("Error reading database: %v", err))
+		return nil, err
+	}
+	warnings := serato.CheckRecordEncoding(records)
+
+	crateWarnings, err := a.config.Files().CheckCrateEncoding(a.config.SeratoDBPath)
+	if err != nil {
+		a.logError(fmt.Sprintf("Error reading crates: %v", err))
+		return nil, err
+	}
+	warnings = append(warnings, crateWarnings...)
+
+	for _, w := range warnings {
+		where := w.Pfil
+		if w.Crate != "" {
+			where = filepath.Base(w.Crate)
+		}
+		a.logWarn(fmt.Sprintf("Warning: %s in %s of %s: %q", w.Problem, w.Tag, where, w.Value))
+	}
+	a.logInfo(fmt.Sprintf("Found %d fields with encoding problems.", len(warnings)))
+	return warnings, nil
+}
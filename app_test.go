@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"seratosync-go/config"
+	"seratosync-go/serato"
+)
+
+// newTestApp returns an App for a Serato folder and library in a temporary
+// directory, with the events it would send to the frontend dropped.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	saved := eventsEmit
+	eventsEmit = func(context.Context, string, ...interface{}) {}
+	t.Cleanup(func() { eventsEmit = saved })
+
+	root := t.TempDir()
+	cfg := &config.Config{
+		SeratoDBPath:     filepath.Join(root, "_Serato_"),
+		MusicLibraryPath: filepath.Join(root, "Music"),
+	}
+	if err := os.MkdirAll(cfg.SeratoDBPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &App{ctx: context.Background(), config: cfg}
+}
+
+func TestRepairLibrary(t *testing.T) {
+	a := newTestApp(t)
+	library := a.config.MusicLibraryPath
+	for _, rel := range []string{"House/a.mp3", "House/b.mp3"} {
+		path := filepath.Join(library, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefix := serato.LibraryPrefix(library)
+	a1 := serato.BuildPtrk(prefix, "House/a.mp3")
+	b1 := serato.BuildPtrk(prefix, "House/b.mp3")
+
+	// A damaged library: a path with Windows separators, a duplicate, a
+	// record without a path, a partial record cut off at the end of the
+	// database, and a crate holding a track whose file is gone.
+	dbPath := a.config.DatabasePath(a.config.SeratoDBPath)
+	damaged := &serato.Database{Version: serato.DatabaseVrsn, Records: []serato.Record{
+		{"pfil": a1, "ttyp": "mp3"},
+		{"pfil": strings.ReplaceAll(b1, "/", `\`), "ttyp": "mp3"},
+		{"pfil": a1, "ttyp": "mp3"},
+		{"ttit": "No path"},
+	}}
+	if err := serato.WriteDatabase(dbPath, damaged); err != nil {
+		t.Fatal(err)
+	}
+	junk := []byte("otrk\x00\x00\x10\x00pfi")
+	file, err := os.OpenFile(dbPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(junk); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	crateFile := serato.CratePathForDir(a.config.SeratoDBPath, "House")
+	if _, err := serato.WriteCrateFile(crateFile, []string{a1, b1, serato.BuildPtrk(prefix, "House/gone.mp3")}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := a.RepairLibrary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TrailingBytes != len(junk) || report.PathsNormalized != 1 || report.CrateTracksRemoved != 1 ||
+		report.Cleanup.RemovedDuplicates != 1 || report.Cleanup.RemovedNoPath != 1 || len(report.Backups) != 2 {
+		t.Errorf("RepairLibrary = %+v", report)
+	}
+
+	// The library is healthy: the database reads strictly and the crate
+	// holds only tracks that exist.
+	db, err := serato.ReadDatabase(context.Background(), dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfils []string
+	for _, record := range db.Records {
+		pfils = append(pfils, record["pfil"].(string))
+	}
+	if want := []string{a1, b1}; !reflect.DeepEqual(pfils, want) {
+		t.Errorf("database holds %q, want %q", pfils, want)
+	}
+	if tracks, err := serato.ReadCrateFile(crateFile); err != nil || !reflect.DeepEqual(tracks, []string{a1, b1}) {
+		t.Errorf("crate holds %q, %v", tracks, err)
+	}
+
+	// Repairing it again changes nothing and makes no more backups.
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = a.RepairLibrary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Backups) != 0 || !strings.Contains(report.Text, "healthy") {
+		t.Errorf("second RepairLibrary = %+v", report)
+	}
+	if after, err := os.ReadFile(dbPath); err != nil || string(after) != string(before) {
+		t.Errorf("second repair changed the database: %v", err)
+	}
+}
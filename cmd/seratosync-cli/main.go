@@ -0,0 +1,82 @@
+// Command seratosync-cli runs the library sync without the desktop GUI, for
+// use from scripts and scheduled jobs. Log lines go to stdout; the exit code
+// is non-zero if the sync fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"seratosync-go/config"
+	"seratosync-go/syncer"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	configPath := flag.String("config", "", "path to config.json (default: the app's config location)")
+	dryRun := flag.Bool("dry-run", false, "report changes without writing crates or the database")
+	folder := flag.String("folder", "", "sync only this folder, relative to the music library root")
+	var include, exclude patternList
+	flag.Var(&include, "include", "sync only tracks matching this glob, e.g. '**/*.flac' (repeatable)")
+	flag.Var(&exclude, "exclude", "leave out tracks matching this glob (repeatable)")
+	flag.Parse()
+
+	if *configPath == "" {
+		path, err := config.GetDefaultConfigPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting default config path: %v\n", err)
+			return 1
+		}
+		*configPath = path
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config %s: %v\n", *configPath, err)
+		return 1
+	}
+
+	// Ctrl+C cancels the sync cleanly instead of killing it mid-write.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	_, err = syncer.Run(ctx, cfg, syncer.Options{
+		DryRun:     *dryRun,
+		Folder:     *folder,
+		Include:    include,
+		Exclude:    exclude,
+		CachePath:  config.ScanCachePath(*configPath),
+		HistoryDir: config.HistoryDir(*configPath),
+		Log: func(level syncer.Level, message string) {
+			if level == syncer.LevelInfo {
+				fmt.Println(message)
+			} else {
+				fmt.Fprintln(os.Stderr, message)
+			}
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Sync failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// patternList collects the values of a flag that may be given more than once.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
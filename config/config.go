@@ -2,15 +2,197 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+
+	"seratosync-go/serato"
+)
+
+// DefaultBackupRetention is how many database backups are kept when
+// BackupRetention is not set.
+const DefaultBackupRetention = 10
+
+// DefaultHistoryRetention is how many sync manifests are kept when
+// HistoryRetention is not set.
+const DefaultHistoryRetention = 50
+
+// DefaultMinPrefixMatch is the MinPrefixMatch used when it is not set.
+const DefaultMinPrefixMatch = 1
+
+// CurrentVersion is the config schema version written by this build.
+// Version 0 is the original layout with a single music_library_path.
+const CurrentVersion = 1
+
+// SyncMode is how far a sync goes in making Serato match the music
+// libraries.
+type SyncMode string
+
+const (
+	// SyncAddOnly, the default, adds new tracks to the database and crates
+	// and removes nothing unless PruneMissing is set.
+	SyncAddOnly SyncMode = "add_only"
+	// SyncMirror makes Serato mirror the libraries: tracks deleted from
+	// disk are pruned, crates left empty are removed whatever
+	// KeepEmptyCrates says, and crates are reordered to match their
+	// folders (see OrderedCrates).
+	SyncMirror SyncMode = "mirror"
 )
 
+// ErrUnsupportedVersion is returned when a config file was written by a
+// newer version of the app than this one.
+var ErrUnsupportedVersion = errors.New("unsupported config version")
+
 // Config holds the application configuration.
 type Config struct {
-	SeratoDBPath      string `json:"serato_db_path"`
+	// Version is the schema version the config was written with. Files
+	// without it are version 0.
+	Version          int    `json:"version"`
+	SeratoDBPath     string `json:"serato_db_path"`
 	MusicLibraryPath string `json:"music_library_path"`
+	// MusicLibraryPaths lists every library root to sync. Older configs only
+	// set MusicLibraryPath, which LoadConfig migrates into this list.
+	MusicLibraryPaths []string `json:"music_library_paths"`
+	// SyncMode is SyncAddOnly or SyncMirror. Empty means SyncAddOnly.
+	SyncMode SyncMode `json:"sync_mode,omitempty"`
+	// PruneMissing removes database and crate entries for library files
+	// that no longer exist on disk during sync.
+	PruneMissing bool `json:"prune_missing"`
+	// KeepEmptyCrates keeps the crate of a library folder that lost all its
+	// tracks during pruning instead of deleting the crate file.
+	KeepEmptyCrates bool `json:"keep_empty_crates"`
+	// VerifyFiles makes database cleanup remove records whose file inside a
+	// music library is missing or empty.
+	VerifyFiles bool `json:"verify_files"`
+	// FuzzyDuplicates makes database cleanup also remove duplicates whose
+	// paths differ only in formatting, or that name files with the same
+	// name and size.
+	FuzzyDuplicates bool `json:"fuzzy_duplicates"`
+	// BackupRetention is how many database backups to keep. Zero uses
+	// DefaultBackupRetention and a negative value keeps every backup.
+	BackupRetention int `json:"backup_retention"`
+	// BackupDir is where backups of the database in SeratoDBPath are kept.
+	// Empty keeps them next to the database. Databases on external drives
+	// always keep their backups next to them.
+	BackupDir string `json:"backup_dir,omitempty"`
+	// HistoryRetention is how many sync manifests, the sync history, to
+	// keep. It is separate from BackupRetention. Zero uses
+	// DefaultHistoryRetention and a negative value keeps every manifest.
+	HistoryRetention int `json:"history_retention,omitempty"`
+	// FileRetries is how many times a file operation failing with a
+	// transient error, as network shares return while reconnecting, is
+	// retried. Zero uses serato.DefaultRetries and a negative value turns
+	// retrying off.
+	FileRetries int `json:"file_retries,omitempty"`
+	// PreserveModTimes keeps the modification time of the database and
+	// crate files a sync rewrites (see serato.Files).
+	PreserveModTimes bool `json:"preserve_mod_times,omitempty"`
+	// MinPrefixMatch is the smallest share of the database's tracks, in
+	// percent, that must be under a music library's path when most of the
+	// library's tracks look new. Below it the sync stops before writing
+	// anything, since the library or Serato folder setting is probably
+	// wrong. Zero uses DefaultMinPrefixMatch and a negative value turns
+	// the check off, as the first sync of a library into a database full
+	// of other tracks needs.
+	MinPrefixMatch int `json:"min_prefix_match,omitempty"`
+	// AudioExtensions adds file extensions (e.g. "opus" or ".wma") to the
+	// built-in set of audio files picked up by the scan.
+	AudioExtensions []string `json:"audio_extensions"`
+	// IgnorePatterns lists files and folders to leave out of the scan, as
+	// names ("Samples") or relative paths ("DJ/Stems/*"). They are added to
+	// library.DefaultIgnorePatterns, which skip hidden files and __MACOSX,
+	// and to the patterns in each library's library.IgnoreFileName.
+	IgnorePatterns []string `json:"ignore_patterns"`
+	// FollowSymlinks makes the scan walk into symlinked folders inside a
+	// music library. Otherwise they are skipped with a log message.
+	FollowSymlinks bool `json:"follow_symlinks"`
+	// ScanCache keeps a cache of file sizes, modification times and tags
+	// next to the config file so unchanged files aren't re-read each sync.
+	ScanCache bool `json:"scan_cache"`
+	// ScanWorkers is the number of parallel workers used to scan each
+	// library. Zero uses one per CPU.
+	ScanWorkers int `json:"scan_workers"`
+	// CrateSort orders the tracks added to each crate: "name" (the
+	// default) sorts file names naturally, "date_added" puts the oldest
+	// files first.
+	CrateSort string `json:"crate_sort"`
+	// CrateWorkers is the number of crate files written at once during a
+	// sync. Zero uses one per CPU.
+	CrateWorkers int `json:"crate_workers"`
+	// CrateParent puts every crate the sync writes under one parent crate,
+	// e.g. "Auto-Imported", to keep them apart from crates made by hand.
+	CrateParent string `json:"crate_parent"`
+	// CratePrefixes maps a music library root to a crate its crates are
+	// put under, below CrateParent, e.g. "External" for a library on an
+	// external drive. Libraries with folders of the same name then get
+	// crates of their own. Libraries without an entry get no prefix.
+	CratePrefixes map[string]string `json:"crate_prefixes,omitempty"`
+
+	// FlatCrates names crates after their folder alone instead of
+	// mirroring the folder hierarchy.
+	FlatCrates bool `json:"flat_crates"`
+	// CrateNameUnderscores and CrateNameTitleCase tidy folder names into
+	// crate names: underscores become spaces, and each word starts with a
+	// capital (see serato.CrateTransform). Folders keep their names.
+	// Turning either on renames the crates of earlier syncs on the next
+	// one; turning them off leaves those crates with the tidied names, and
+	// the sync writes crates under the folder names next to them.
+	CrateNameUnderscores bool `json:"crate_name_underscores,omitempty"`
+	CrateNameTitleCase   bool `json:"crate_name_title_case,omitempty"`
+	// RootCrate puts the tracks directly in a music library folder, which
+	// belong to no subfolder, into a crate named after the library folder.
+	// Without it they are added to the database only.
+	RootCrate bool `json:"root_crate"`
+	// OrderedCrates keeps the tracks a sync plans for a crate at the top of
+	// the crate in the planned order (see CrateSort), rewriting crates whose
+	// order differs. Without it a crate that already holds every planned
+	// track is left as it is, whatever its order.
+	OrderedCrates bool `json:"ordered_crates"`
+	// MatchMovedFiles recognizes a track moved to another folder of the
+	// library by its contents (see library.FileSignature) and updates its
+	// database record instead of adding the track again. It needs ScanCache,
+	// which then also keeps each file's signature, and only knows files
+	// scanned with it on before they were moved.
+	MatchMovedFiles bool `json:"match_moved_files"`
+	// DetectRenamedFolders moves the crate of a folder that was renamed
+	// since the last sync, recognized by its file names, to the new name
+	// instead of writing a second crate next to the old one.
+	DetectRenamedFolders bool `json:"detect_renamed_folders"`
+	// WarnAstralPaths also warns about track paths with characters outside
+	// the Basic Multilingual Plane, such as emoji, which some Serato
+	// versions can't display. Paths with control characters are always
+	// warned about (see serato.PathDisplayProblem).
+	WarnAstralPaths bool `json:"warn_astral_paths,omitempty"`
+	// NoCrateFolders lists folders, relative to the music library, that get
+	// no crates, nor do the folders below them. Their tracks are still added
+	// to the database, unlike with IgnorePatterns.
+	NoCrateFolders []string `json:"no_crate_folders"`
+	// SmartCratesPath is a JSON file of rules for smart crates, filled with
+	// the database tracks whose metadata matches each rule (see
+	// library.CrateRule). Empty means folder crates only.
+	SmartCratesPath string `json:"smart_crates_path"`
+	// MetadataFields are the fields, such as "title" or "bpm", of which a
+	// record needs at least one to survive database cleanup. Empty means
+	// title, artist and album.
+	MetadataFields []string `json:"metadata_fields"`
+	// CrateColumns are the columns, such as "song" or "bpm", that crates
+	// created by a sync show. Empty means serato.DefaultCrateColumns.
+	CrateColumns []string `json:"crate_columns,omitempty"`
+	// DatabaseFile is the name of the database file in the Serato folder.
+	// Empty means serato.DatabaseFile ("database V2").
+	DatabaseFile string `json:"database_file,omitempty"`
+	// DatabaseVersion, if set, is written as the database's version
+	// string in place of the one it had, for Serato editions that expect
+	// another. New databases otherwise get serato.DatabaseVrsn.
+	DatabaseVersion string `json:"database_version,omitempty"`
+	// CaseInsensitivePaths matches library files against database paths
+	// regardless of case, so "Song.MP3" on disk is the same track as
+	// "song.mp3" in the database. Unset uses the platform default; see
+	// PathsCaseInsensitive.
+	CaseInsensitivePaths *bool `json:"case_insensitive_paths,omitempty"`
 }
 
 // GetDefaultConfigPath returns the default configuration file path based on the OS.
@@ -49,12 +231,24 @@ func GetDefaultConfigPath() (string, error) {
 	return configPath, nil
 }
 
+// ScanCachePath returns where the scan cache lives for a config file.
+func ScanCachePath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "scan_cache.json")
+}
+
+// HistoryDir returns where sync manifests are kept for a config file.
+func HistoryDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "history")
+}
+
 // LoadConfig loads configuration from a JSON file.
 func LoadConfig(path string) (*Config, error) {
 	configFile, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &Config{}, nil // Return empty config if file doesn't exist
+			config := &Config{Version: CurrentVersion} // Return empty config if file doesn't exist
+			config.applyDefaults()
+			return config, nil
 		}
 		return nil, err
 	}
@@ -67,9 +261,212 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	migrated, err := Migrate(&config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if migrated {
+		// Writing back is best effort: if it fails the same migration
+		// simply runs again on the next load.
+		_ = SaveConfig(path, &config)
+	}
+	config.applyDefaults()
 	return &config, nil
 }
 
+// applyDefaults fills in settings whose default depends on the platform, so
+// the GUI shows the value a sync will actually use.
+func (c *Config) applyDefaults() {
+	if c.CaseInsensitivePaths == nil {
+		caseInsensitive := c.PathsCaseInsensitive()
+		c.CaseInsensitivePaths = &caseInsensitive
+	}
+}
+
+// Migrate upgrades c from the schema version it was written with to
+// CurrentVersion, reporting whether anything had to change. Configs from a
+// newer version are left alone and ErrUnsupportedVersion is returned, since
+// saving them would drop fields this build doesn't know about.
+func Migrate(c *Config) (bool, error) {
+	if c.Version > CurrentVersion {
+		return false, fmt.Errorf("%w: file is version %d, this build supports up to %d", ErrUnsupportedVersion, c.Version, CurrentVersion)
+	}
+	if c.Version == CurrentVersion {
+		return false, nil
+	}
+
+	if c.Version < 1 {
+		// Version 1 added music_library_paths alongside the original
+		// singular music_library_path.
+		if len(c.MusicLibraryPaths) == 0 && c.MusicLibraryPath != "" {
+			c.MusicLibraryPaths = []string{c.MusicLibraryPath}
+		}
+		if c.MusicLibraryPath == "" && len(c.MusicLibraryPaths) > 0 {
+			c.MusicLibraryPath = c.MusicLibraryPaths[0]
+		}
+	}
+
+	c.Version = CurrentVersion
+	return true, nil
+}
+
+// DatabasePath returns the path of the database file in the Serato folder
+// seratoDir.
+func (c *Config) DatabasePath(seratoDir string) string {
+	name := strings.TrimSpace(c.DatabaseFile)
+	if name == "" {
+		name = serato.DatabaseFile
+	}
+	return filepath.Join(seratoDir, name)
+}
+
+// BackupsToKeep returns the backup retention limit for serato.PruneBackups.
+func (c *Config) BackupsToKeep() int {
+	if c.BackupRetention == 0 {
+		return DefaultBackupRetention
+	}
+	return c.BackupRetention
+}
+
+// HistoryToKeep returns the sync history retention limit for
+// syncer.PruneHistory, which keeps everything for a limit below one.
+func (c *Config) HistoryToKeep() int {
+	if c.HistoryRetention == 0 {
+		return DefaultHistoryRetention
+	}
+	return c.HistoryRetention
+}
+
+// PrefixMatchThreshold returns the MinPrefixMatch in effect, or a
+// negative value if the check is off.
+func (c *Config) PrefixMatchThreshold() int {
+	if c.MinPrefixMatch == 0 {
+		return DefaultMinPrefixMatch
+	}
+	return c.MinPrefixMatch
+}
+
+// BackupDirFor returns the folder for serato.BackupDatabaseTo and the
+// other backup functions to keep the backups of the database in the
+// Serato folder seratoDir in.
+func (c *Config) BackupDirFor(seratoDir string) string {
+	if seratoDir != c.SeratoDBPath {
+		return ""
+	}
+	return strings.TrimSpace(c.BackupDir)
+}
+
+// Mode returns the sync mode, SyncAddOnly if SyncMode is not set.
+func (c *Config) Mode() SyncMode {
+	if c.SyncMode == "" {
+		return SyncAddOnly
+	}
+	return c.SyncMode
+}
+
+// PrunesMissing reports whether a sync prunes tracks deleted from disk:
+// with PruneMissing or in SyncMirror mode.
+func (c *Config) PrunesMissing() bool {
+	return c.PruneMissing || c.Mode() == SyncMirror
+}
+
+// KeepsEmptyCrates reports whether a sync keeps crates left empty by
+// pruning: with KeepEmptyCrates, except in SyncMirror mode.
+func (c *Config) KeepsEmptyCrates() bool {
+	return c.KeepEmptyCrates && c.Mode() != SyncMirror
+}
+
+// OrdersCrates reports whether a sync keeps crates in the planned order:
+// with OrderedCrates or in SyncMirror mode.
+func (c *Config) OrdersCrates() bool {
+	return c.OrderedCrates || c.Mode() == SyncMirror
+}
+
+// Files returns the options the serato package reads and writes files
+// with for this config.
+func (c *Config) Files() serato.Files {
+	return serato.Files{
+		Retries:          c.FileRetries,
+		PreserveModTimes: c.PreserveModTimes,
+		CrateColumns:     c.CrateColumnList(),
+	}
+}
+
+// CrateColumnList returns the columns new crates show: CrateColumns, or
+// serato.DefaultCrateColumns if none are set.
+func (c *Config) CrateColumnList() []string {
+	var columns []string
+	for _, column := range c.CrateColumns {
+		if column = strings.ToLower(strings.TrimSpace(column)); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return serato.DefaultCrateColumns
+	}
+	return columns
+}
+
+// PathsCaseInsensitive reports whether paths should be matched regardless of
+// case. Unless CaseInsensitivePaths says otherwise, that is the case on
+// Windows and macOS, whose file systems usually ignore case, and not on
+// Linux.
+func (c *Config) PathsCaseInsensitive() bool {
+	if c.CaseInsensitivePaths != nil {
+		return *c.CaseInsensitivePaths
+	}
+	return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+}
+
+// CrateNaming returns how the sync names crates.
+func (c *Config) CrateNaming() serato.CrateNaming {
+	return serato.CrateNaming{
+		Parent: strings.TrimSpace(c.CrateParent),
+		Flat:   c.FlatCrates,
+		Transform: serato.CrateTransform{
+			Underscores: c.CrateNameUnderscores,
+			TitleCase:   c.CrateNameTitleCase,
+		},
+	}
+}
+
+// CrateNamingFor returns how the sync names the crates of the music library
+// at root: as CrateNaming does, under the root's entry in CratePrefixes.
+func (c *Config) CrateNamingFor(root string) serato.CrateNaming {
+	return c.CrateNaming().Under(c.CratePrefix(root))
+}
+
+// CratePrefix returns the entry in CratePrefixes for the music library at
+// root, or "" if it has none.
+func (c *Config) CratePrefix(root string) string {
+	for prefixRoot, prefix := range c.CratePrefixes {
+		if filepath.Clean(strings.TrimSpace(prefixRoot)) == filepath.Clean(root) {
+			return strings.TrimSpace(prefix)
+		}
+	}
+	return ""
+}
+
+// LibraryPaths returns every configured music library root, without blanks
+// or duplicates. MusicLibraryPath is included even if it is missing from
+// MusicLibraryPaths.
+func (c *Config) LibraryPaths() []string {
+	var paths []string
+	seen := make(map[string]struct{})
+	for _, p := range append([]string{c.MusicLibraryPath}, c.MusicLibraryPaths...) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
 // SaveConfig saves configuration to a JSON file.
 func SaveConfig(path string, config *Config) error {
 	// Create directory if it doesn't exist
@@ -84,6 +481,7 @@ func SaveConfig(path string, config *Config) error {
 	}
 	defer file.Close()
 
+	config.Version = CurrentVersion
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
 	return encoder.Encode(config)
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeConfig writes data as a config file and returns its path.
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigMigratesVersion0(t *testing.T) {
+	path := writeConfig(t, `{"serato_db_path": "/Users/dj/Music/_Serato_", "music_library_path": "/Users/dj/Music/Tracks"}`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != CurrentVersion {
+		t.Errorf("loaded version %d, want %d", cfg.Version, CurrentVersion)
+	}
+	want := []string{"/Users/dj/Music/Tracks"}
+	if !reflect.DeepEqual(cfg.MusicLibraryPaths, want) || cfg.MusicLibraryPath != want[0] {
+		t.Errorf("libraries migrated to %q and %v, want %v", cfg.MusicLibraryPath, cfg.MusicLibraryPaths, want)
+	}
+
+	// The migrated config is written back.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Config
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != CurrentVersion || !reflect.DeepEqual(saved.MusicLibraryPaths, want) {
+		t.Errorf("saved version %d with libraries %v", saved.Version, saved.MusicLibraryPaths)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	cfg := Config{MusicLibraryPaths: []string{"/a", "/b"}}
+	if changed, err := Migrate(&cfg); err != nil || !changed {
+		t.Fatalf("Migrate = %v, %v, want a change", changed, err)
+	}
+	if cfg.MusicLibraryPath != "/a" {
+		t.Errorf("music_library_path migrated to %q, want /a", cfg.MusicLibraryPath)
+	}
+	if changed, err := Migrate(&cfg); err != nil || changed {
+		t.Errorf("Migrate of a current config = %v, %v, want no change", changed, err)
+	}
+}
+
+func TestLoadConfigFutureVersion(t *testing.T) {
+	data := `{"version": 99, "music_library_path": "/Music", "new_field": true}`
+	path := writeConfig(t, data)
+	if _, err := LoadConfig(path); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("LoadConfig error = %v, want %v", err, ErrUnsupportedVersion)
+	}
+	// The file is left alone.
+	if got, err := os.ReadFile(path); err != nil || string(got) != data {
+		t.Errorf("config rewritten as %q, %v", got, err)
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	cfg, err := LoadConfig(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != CurrentVersion {
+		t.Errorf("new config has version %d, want %d", cfg.Version, CurrentVersion)
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"seratosync-go/serato"
+)
+
+// DetectSeratoDirs looks for Serato folders holding a "database V2" file in
+// the standard places: the Music folder in the user's home directory and
+// the root of every mounted external drive. All candidates are returned,
+// internal first, since a user with external drives has several.
+func DetectSeratoDirs() ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return detectSeratoDirs(filepath.Join(home, "Music"), volumeRoots()), nil
+}
+
+// detectSeratoDirs returns the Serato folders with a database under
+// musicDir and each of volumes.
+func detectSeratoDirs(musicDir string, volumes []string) []string {
+	var found []string
+	seen := make(map[string]struct{})
+	for _, parent := range append([]string{musicDir}, volumes...) {
+		dir := filepath.Join(parent, serato.SeratoDirName)
+		if info, err := os.Stat(filepath.Join(dir, serato.DatabaseFile)); err != nil || info.IsDir() {
+			continue
+		}
+		// The boot volume shows up under /Volumes on macOS too.
+		key := dir
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			key = real
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		found = append(found, dir)
+	}
+	return found
+}
+
+// volumeRoots lists the mount points where external drives usually appear.
+func volumeRoots() []string {
+	var roots []string
+	switch runtime.GOOS {
+	case "windows":
+		for letter := 'A'; letter <= 'Z'; letter++ {
+			root := string(letter) + `:\`
+			if _, err := os.Stat(root); err == nil {
+				roots = append(roots, root)
+			}
+		}
+	case "darwin":
+		roots = append(roots, subdirs("/Volumes")...)
+	default:
+		user := os.Getenv("USER")
+		roots = append(roots, subdirs(filepath.Join("/media", user))...)
+		roots = append(roots, subdirs(filepath.Join("/run/media", user))...)
+		roots = append(roots, subdirs("/mnt")...)
+	}
+	return roots
+}
+
+// subdirs returns the folders directly inside dir, or nothing if dir can't
+// be read.
+func subdirs(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+	}
+	return dirs
+}
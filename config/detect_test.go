@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"seratosync-go/serato"
+)
+
+// mkdirs creates each folder below root.
+func mkdirs(t *testing.T, root string, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeDatabaseIn writes an empty database in the Serato folder below
+// parent.
+func writeDatabaseIn(t *testing.T, parent string) string {
+	t.Helper()
+	dir := filepath.Join(parent, serato.SeratoDirName)
+	mkdirs(t, dir)
+	if err := serato.WriteDatabaseV2Records(filepath.Join(dir, serato.DatabaseFile), nil); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDetectSeratoDirs(t *testing.T) {
+	// A home folder and mounted drives laid out as on a Mac.
+	root := t.TempDir()
+	music := filepath.Join(root, "Users", "dj", "Music")
+	volumes := filepath.Join(root, "Volumes")
+	mkdirs(t, root, "Users/dj/Music", "Volumes/Empty/_Serato_", "Volumes/Backup/Music", "Volumes/Stick")
+	internal := writeDatabaseIn(t, music)
+	external := writeDatabaseIn(t, filepath.Join(volumes, "Stick"))
+	// A Serato folder without a database isn't a candidate.
+	if err := os.WriteFile(filepath.Join(volumes, "Empty", "_Serato_", "neworder.pref"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	drives := []string{filepath.Join(volumes, "Backup"), filepath.Join(volumes, "Empty"), filepath.Join(volumes, "Stick")}
+
+	if got, want := detectSeratoDirs(music, drives), []string{internal, external}; !reflect.DeepEqual(got, want) {
+		t.Errorf("detectSeratoDirs = %v, want %v", got, want)
+	}
+	if got := detectSeratoDirs(filepath.Join(root, "missing"), nil); got != nil {
+		t.Errorf("detectSeratoDirs without a Serato folder = %v", got)
+	}
+
+	// The boot volume also appears as a drive, linking back to the home
+	// folder's Serato folder.
+	if runtime.GOOS != "windows" {
+		boot := filepath.Join(volumes, "Macintosh HD")
+		if err := os.Symlink(music, boot); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := detectSeratoDirs(music, append(drives, boot)), []string{internal, external}; !reflect.DeepEqual(got, want) {
+			t.Errorf("detectSeratoDirs with the boot volume = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDetectSeratoDirsHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	internal := writeDatabaseIn(t, filepath.Join(home, "Music"))
+	found, err := DetectSeratoDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) == 0 || found[0] != internal {
+		t.Errorf("DetectSeratoDirs = %v, want %s first", found, internal)
+	}
+}
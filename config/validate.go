@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"seratosync-go/serato"
+)
+
+// FieldError is a problem with one config field, named by its JSON key so
+// the GUI can show it next to the right input.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Validate checks the paths in cfg: the Serato path must hold a
+// "database V2" file, every music library must be an existing directory, and
+// no library may sit inside the Serato folder or inside another library.
+// Empty paths are not reported so a fresh config can still be saved; use
+// ValidateForSync before running a sync.
+func Validate(cfg *Config) []FieldError {
+	var problems []FieldError
+
+	if cfg.SeratoDBPath != "" {
+		dbFile := cfg.DatabasePath(cfg.SeratoDBPath)
+		dbName := filepath.Base(dbFile)
+		if info, err := os.Stat(cfg.SeratoDBPath); err != nil || !info.IsDir() {
+			problems = append(problems, FieldError{"serato_db_path", "folder does not exist: " + cfg.SeratoDBPath})
+		} else if info, err := os.Stat(dbFile); err != nil || info.IsDir() {
+			problems = append(problems, FieldError{"serato_db_path", fmt.Sprintf("no %q file found; choose the _Serato_ folder", dbName)})
+		} else if ok, _ := serato.IsDatabaseV2(dbFile); !ok {
+			problems = append(problems, FieldError{"serato_db_path", fmt.Sprintf("%q is not a Serato database file", dbName)})
+		}
+	}
+
+	roots := cfg.LibraryPaths()
+	for i, root := range roots {
+		field := "music_library_paths"
+		if root == strings.TrimSpace(cfg.MusicLibraryPath) {
+			field = "music_library_path"
+		}
+
+		if info, err := os.Stat(root); err != nil {
+			problems = append(problems, FieldError{field, "folder does not exist: " + root})
+			continue
+		} else if !info.IsDir() {
+			problems = append(problems, FieldError{field, "not a folder: " + root})
+			continue
+		}
+
+		// Crates and backups are written into the Serato folder, so it
+		// and a library must not hold one another.
+		if cfg.SeratoDBPath != "" && isWithin(root, cfg.SeratoDBPath) {
+			problems = append(problems, FieldError{field, root + " is inside the Serato folder"})
+		} else if cfg.SeratoDBPath != "" && isWithin(cfg.SeratoDBPath, root) {
+			problems = append(problems, FieldError{field, root + " contains the Serato folder " + cfg.SeratoDBPath + "; choose the folder your music is in"})
+		}
+		for j, other := range roots {
+			if i != j && isWithin(root, other) {
+				problems = append(problems, FieldError{field, root + " is inside another music library, " + other})
+			}
+		}
+	}
+
+	prefixRoots := make([]string, 0, len(cfg.CratePrefixes))
+	for prefixRoot := range cfg.CratePrefixes {
+		prefixRoots = append(prefixRoots, prefixRoot)
+	}
+	sort.Strings(prefixRoots)
+	for _, prefixRoot := range prefixRoots {
+		known := false
+		for _, root := range roots {
+			if filepath.Clean(strings.TrimSpace(prefixRoot)) == filepath.Clean(root) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			problems = append(problems, FieldError{"crate_prefixes", prefixRoot + " is not one of the music libraries"})
+		}
+	}
+
+	switch cfg.SyncMode {
+	case "", SyncAddOnly, SyncMirror:
+	default:
+		problems = append(problems, FieldError{"sync_mode", fmt.Sprintf("unknown sync mode %q; use %q or %q", cfg.SyncMode, SyncAddOnly, SyncMirror)})
+	}
+
+	// The backup folder is created with the first backup, but it must not
+	// be a file or where the sync would scan the backups.
+	if backupDir := strings.TrimSpace(cfg.BackupDir); backupDir != "" {
+		if info, err := os.Stat(backupDir); err == nil && !info.IsDir() {
+			problems = append(problems, FieldError{"backup_dir", "not a folder: " + backupDir})
+		}
+		for _, root := range roots {
+			if isWithin(backupDir, root) {
+				problems = append(problems, FieldError{"backup_dir", backupDir + " is inside the music library " + root})
+			}
+		}
+	}
+	return problems
+}
+
+// ValidateForSync is Validate but also requires the Serato path and at
+// least one music library to be set.
+func ValidateForSync(cfg *Config) []FieldError {
+	var problems []FieldError
+	if strings.TrimSpace(cfg.SeratoDBPath) == "" {
+		problems = append(problems, FieldError{"serato_db_path", "not set"})
+	}
+	if len(cfg.LibraryPaths()) == 0 {
+		problems = append(problems, FieldError{"music_library_path", "not set"})
+	}
+	return append(problems, Validate(cfg)...)
+}
+
+// JoinErrors combines field errors into one error, or returns nil if there
+// are none.
+func JoinErrors(problems []FieldError) error {
+	errs := make([]error, len(problems))
+	for i, problem := range problems {
+		errs[i] = problem
+	}
+	return errors.Join(errs...)
+}
+
+// isWithin reports whether path is parent or a folder below it.
+func isWithin(path, parent string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absParent, err := filepath.Abs(parent)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absParent, absPath)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"seratosync-go/serato"
+)
+
+// paths returns a Serato folder holding a database and a music library
+// beside it, both in a temporary directory.
+func paths(t *testing.T) (seratoDir, library string) {
+	t.Helper()
+	root := t.TempDir()
+	seratoDir = filepath.Join(root, "_Serato_")
+	library = filepath.Join(root, "Music")
+	for _, dir := range []string{seratoDir, library} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := serato.WriteDatabaseV2Records(filepath.Join(seratoDir, serato.DatabaseFile), nil); err != nil {
+		t.Fatal(err)
+	}
+	return seratoDir, library
+}
+
+// fields returns the field named by each problem.
+func fields(problems []FieldError) []string {
+	var names []string
+	for _, problem := range problems {
+		names = append(names, problem.Field)
+	}
+	return names
+}
+
+func TestValidate(t *testing.T) {
+	seratoDir, library := paths(t)
+	notAFolder := filepath.Join(library, "track.mp3")
+	if err := os.WriteFile(notAFolder, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	notADatabase := t.TempDir()
+	if err := os.WriteFile(filepath.Join(notADatabase, serato.DatabaseFile), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{"valid", Config{SeratoDBPath: seratoDir, MusicLibraryPath: library}, nil},
+		{"empty", Config{}, nil},
+		{"missing Serato folder", Config{SeratoDBPath: missing, MusicLibraryPath: library}, []string{"serato_db_path"}},
+		{"no database", Config{SeratoDBPath: t.TempDir(), MusicLibraryPath: library}, []string{"serato_db_path"}},
+		{"not a database", Config{SeratoDBPath: notADatabase, MusicLibraryPath: library}, []string{"serato_db_path"}},
+		{"missing library", Config{SeratoDBPath: seratoDir, MusicLibraryPath: missing}, []string{"music_library_path"}},
+		{"library is a file", Config{SeratoDBPath: seratoDir, MusicLibraryPath: notAFolder}, []string{"music_library_path"}},
+		{"library inside Serato folder", Config{SeratoDBPath: seratoDir, MusicLibraryPath: seratoDir}, []string{"music_library_path"}},
+		{"library holds Serato folder", Config{SeratoDBPath: seratoDir, MusicLibraryPath: filepath.Dir(seratoDir)}, []string{"music_library_path"}},
+		{"unknown sync mode", Config{SyncMode: "merge"}, []string{"sync_mode"}},
+		{"unknown prefix root", Config{MusicLibraryPath: library, CratePrefixes: map[string]string{missing: "x"}}, []string{"crate_prefixes"}},
+		{"backup folder in library", Config{MusicLibraryPath: library, BackupDir: filepath.Join(library, "backups")}, []string{"backup_dir"}},
+		{"backup folder is a file", Config{BackupDir: notAFolder}, []string{"backup_dir"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fields(Validate(&tt.cfg)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate reported %v (%v), want %v", got, Validate(&tt.cfg), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNestedLibraries(t *testing.T) {
+	seratoDir, library := paths(t)
+	inner := filepath.Join(library, "House")
+	if err := os.Mkdir(inner, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{SeratoDBPath: seratoDir, MusicLibraryPaths: []string{library, inner}}
+	want := []string{"music_library_paths"}
+	if got := fields(Validate(&cfg)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate reported %v, want %v", Validate(&cfg), want)
+	}
+}
+
+func TestValidateSeratoNesting(t *testing.T) {
+	seratoDir, _ := paths(t)
+	inside := filepath.Join(seratoDir, "Music")
+	// A sibling whose name merely starts with the Serato folder's.
+	sibling := seratoDir + " Music"
+	for _, dir := range []string{inside, sibling} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		library string
+		want    string
+	}{
+		{inside, "is inside the Serato folder"},
+		{filepath.Dir(seratoDir), "contains the Serato folder"},
+		{sibling, ""},
+	}
+	for _, tt := range tests {
+		problems := Validate(&Config{SeratoDBPath: seratoDir, MusicLibraryPath: tt.library})
+		if tt.want == "" {
+			if problems != nil {
+				t.Errorf("library %s: Validate reported %v", tt.library, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || problems[0].Field != "music_library_path" || !strings.Contains(problems[0].Message, tt.want) {
+			t.Errorf("library %s: Validate reported %v, want %q", tt.library, problems, tt.want)
+		}
+	}
+}
+
+func TestValidateForSync(t *testing.T) {
+	if got, want := fields(ValidateForSync(&Config{})), []string{"serato_db_path", "music_library_path"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ValidateForSync of an empty config reported %v, want %v", got, want)
+	}
+	seratoDir, library := paths(t)
+	if problems := ValidateForSync(&Config{SeratoDBPath: seratoDir, MusicLibraryPath: library}); problems != nil {
+		t.Errorf("ValidateForSync reported %v", problems)
+	}
+	if JoinErrors(nil) != nil {
+		t.Error("JoinErrors(nil) is not nil")
+	}
+}
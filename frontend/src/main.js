@@ -1,32 +1,254 @@
-import { GetConfig, SaveConfig, BrowseForDirectory, SyncLibrary, GenerateReport, CleanDatabase } from '../wailsjs/go/main/App';
+import { AutoDetectSeratoPath, GetConfig, GetHistory, GetLastSyncDiff, GetSyncHistory, SaveConfig, BrowseForDirectory, PlanSync, SyncLibrary, SyncFolder, CancelSync, ValidateConfig, ValidateMetadata, GenerateReport, CleanCrates, CleanDatabase, ExportDatabase, NormalizePaths, CompactDatabase, RepairLibrary, PreviewCrate, FindDuplicates, ListCrates, ListBackups, RestoreBackup, ExportSeratoBundle, ImportSeratoBundle } from '../wailsjs/go/main/App';
 import { EventsOn } from '../wailsjs/runtime';
 
 document.addEventListener('DOMContentLoaded', () => {
     const seratoDbPathInput = document.getElementById('serato-db-path');
     const musicLibraryPathInput = document.getElementById('music-library-path');
+    const extraLibraryPathsInput = document.getElementById('extra-library-paths');
+    const ignorePatternsInput = document.getElementById('ignore-patterns');
+    const crateParentInput = document.getElementById('crate-parent');
+    const flatCratesInput = document.getElementById('flat-crates');
+    const crateNameUnderscoresInput = document.getElementById('crate-name-underscores');
+    const crateNameTitleCaseInput = document.getElementById('crate-name-title-case');
+    const rootCrateInput = document.getElementById('root-crate');
+    const orderedCratesInput = document.getElementById('ordered-crates');
+    const cratePrefixesInput = document.getElementById('crate-prefixes');
+    const noCrateFoldersInput = document.getElementById('no-crate-folders');
+    const smartCratesPathInput = document.getElementById('smart-crates-path');
+    const backupDirInput = document.getElementById('backup-dir');
+    const followSymlinksInput = document.getElementById('follow-symlinks');
+    const caseInsensitivePathsInput = document.getElementById('case-insensitive-paths');
+    const syncModeInput = document.getElementById('sync-mode');
+    const pruneMissingInput = document.getElementById('prune-missing');
+    const keepEmptyCratesInput = document.getElementById('keep-empty-crates');
+    const detectRenamedFoldersInput = document.getElementById('detect-renamed-folders');
+    const warnAstralPathsInput = document.getElementById('warn-astral-paths');
+    const matchMovedFilesInput = document.getElementById('match-moved-files');
+    const preserveModTimesInput = document.getElementById('preserve-mod-times');
+    const verifyFilesInput = document.getElementById('verify-files');
+    const fuzzyDuplicatesInput = document.getElementById('fuzzy-duplicates');
+    const metadataFieldsInput = document.getElementById('metadata-fields');
+    const crateColumnsInput = document.getElementById('crate-columns');
+    const seratoDbCandidates = document.getElementById('serato-db-candidates');
+    const detectSeratoDbBtn = document.getElementById('detect-serato-db');
     const browseSeratoDbBtn = document.getElementById('browse-serato-db');
     const browseMusicLibraryBtn = document.getElementById('browse-music-library');
     const saveConfigBtn = document.getElementById('save-config');
+    const previewSyncBtn = document.getElementById('preview-sync');
     const syncLibraryBtn = document.getElementById('sync-library');
+    const syncFolderPathInput = document.getElementById('sync-folder-path');
+    const syncFolderBtn = document.getElementById('sync-folder');
+    const previewCrateBtn = document.getElementById('preview-crate');
+    const cancelSyncBtn = document.getElementById('cancel-sync');
     const generateReportBtn = document.getElementById('generate-report');
+    const showHistoryBtn = document.getElementById('show-history');
+    const showSeratoHistoryBtn = document.getElementById('show-serato-history');
     const cleanDatabaseBtn = document.getElementById('clean-database');
+    const cleanCratesBtn = document.getElementById('clean-crates');
+    const listCratesBtn = document.getElementById('list-crates');
+    const normalizePathsBtn = document.getElementById('normalize-paths');
+    const compactDatabaseBtn = document.getElementById('compact-database');
+    const repairLibraryBtn = document.getElementById('repair-library');
+    const validateMetadataBtn = document.getElementById('validate-metadata');
+    const exportDatabaseBtn = document.getElementById('export-database');
+    const findDuplicatesBtn = document.getElementById('find-duplicates');
+    const backupList = document.getElementById('backup-list');
+    const refreshBackupsBtn = document.getElementById('refresh-backups');
+    const restoreBackupBtn = document.getElementById('restore-backup');
+    const exportBundleBtn = document.getElementById('export-bundle');
+    const importBundleBtn = document.getElementById('import-bundle');
+
+    const loadBackups = () => {
+        ListBackups().then(backups => {
+            backupList.innerHTML = '';
+            (backups || []).forEach(backup => {
+                const option = document.createElement('option');
+                const when = new Date(backup.timestamp * 1000).toLocaleString();
+                const tracks = backup.track_count >= 0 ? `${backup.track_count} tracks` : 'unreadable';
+                option.value = backup.path;
+                option.textContent = `${when} (${tracks})`;
+                backupList.appendChild(option);
+            });
+        }).catch(() => {
+            backupList.innerHTML = '';
+        });
+    };
+    // Shows validation problems next to the field they belong to.
+    const showFieldErrors = problems => {
+        document.querySelectorAll('.field-error').forEach(el => {
+            const messages = (problems || [])
+                .filter(problem => problem.field === el.dataset.field)
+                .map(problem => problem.message);
+            el.textContent = messages.join('\n');
+        });
+        return (problems || []).length === 0;
+    };
+    const syncSummaryCard = document.getElementById('sync-summary-card');
+    const syncSummary = document.getElementById('sync-summary');
+
+    // Renders the totals returned by a sync.
+    const showSummary = result => {
+        const rows = [
+            ['Sync mode', result.sync_mode === 'mirror' ? 'Mirror' : 'Add only'],
+            ['Library files scanned', result.files_scanned],
+            ['Tracks before sync', result.tracks_before],
+            ['New tracks detected', (result.new_tracks || []).length],
+            ['Tracks already in database', result.tracks_existing],
+            ['Database tracks outside the library', result.tracks_outside_library],
+            ['Tracks added', result.tracks_added],
+            ['Deleted tracks pruned', result.tracks_pruned],
+            ['Moved tracks updated', result.tracks_moved],
+            ['Tracks after sync', result.tracks_after],
+            ['Crates written', result.crates_written],
+            ['Tracks written to crates', result.tracks_written],
+            ['Crates pruned', result.crates_pruned],
+            ['Empty crates removed', result.crates_removed],
+            ['Crates renamed', result.crates_renamed],
+            ['Crates already up to date', result.crates_unchanged],
+            ['Unreadable paths skipped', (result.skipped || []).length],
+            ['Paths Serato may not display', (result.undisplayable || []).length],
+        ];
+        syncSummary.innerHTML = '';
+        rows.forEach(([label, value]) => {
+            const dt = document.createElement('dt');
+            dt.textContent = label;
+            const dd = document.createElement('dd');
+            dd.textContent = value;
+            syncSummary.append(dt, dd);
+        });
+        syncSummaryCard.hidden = false;
+        showChanges();
+    };
+    const reportCard = document.getElementById('report-card');
+    const reportPre = document.getElementById('report');
+    const historyCard = document.getElementById('history-card');
+    const historyPre = document.getElementById('history');
+    const seratoHistoryCard = document.getElementById('serato-history-card');
+    const seratoHistoryPre = document.getElementById('serato-history');
+    const cratesCard = document.getElementById('crates-card');
+    const cratesPre = document.getElementById('crates');
+    const duplicatesCard = document.getElementById('duplicates-card');
+    const duplicatesPre = document.getElementById('duplicates');
+    const cratePreviewCard = document.getElementById('crate-preview-card');
+    const cratePreviewPre = document.getElementById('crate-preview');
+    const changesCard = document.getElementById('changes-card');
+    const changesDiv = document.getElementById('changes');
+    // Lists the records the last sync or cleanup added, removed or changed,
+    // one collapsible section each.
+    const showChanges = () => {
+        GetLastSyncDiff().then(diff => {
+            changesDiv.innerHTML = '';
+            if (!diff) {
+                changesCard.hidden = true;
+                return;
+            }
+            const section = (title, items, render) => {
+                const details = document.createElement('details');
+                const summary = document.createElement('summary');
+                summary.textContent = `${title} (${items.length})`;
+                details.appendChild(summary);
+                const list = document.createElement('ul');
+                items.forEach(item => {
+                    const li = document.createElement('li');
+                    render(li, item);
+                    list.appendChild(li);
+                });
+                details.appendChild(list);
+                changesDiv.appendChild(details);
+            };
+            section('Added', diff.added || [], (li, pfil) => { li.textContent = pfil; });
+            section('Removed', diff.removed || [], (li, pfil) => { li.textContent = pfil; });
+            section('Changed', diff.changed || [], (li, change) => {
+                li.textContent = change.pfil;
+                const fields = document.createElement('ul');
+                change.fields.forEach(field => {
+                    const fieldLi = document.createElement('li');
+                    fieldLi.textContent = `${field.tag}: ${field.before || '(none)'} → ${field.after || '(none)'}`;
+                    fields.appendChild(fieldLi);
+                });
+                li.appendChild(fields);
+            });
+            changesCard.hidden = false;
+        });
+    };
     const logsDiv = document.getElementById('logs');
+    const syncProgress = document.getElementById('sync-progress');
+    const syncProgressLabel = document.getElementById('sync-progress-label');
 
-    // Load initial config
+    // Load initial config. Fields without a control on the page are kept
+    // so saving doesn't drop them.
+    let loadedConfig = {};
     GetConfig().then(config => {
-        seratoDbPathInput.value = config.serato_db_path;
-        musicLibraryPathInput.value = config.music_library_path;
+        loadedConfig = config || {};
+        seratoDbPathInput.value = loadedConfig.serato_db_path;
+        musicLibraryPathInput.value = loadedConfig.music_library_path;
+        extraLibraryPathsInput.value = (loadedConfig.music_library_paths || [])
+            .filter(path => path !== loadedConfig.music_library_path)
+            .join('\n');
+        ignorePatternsInput.value = (loadedConfig.ignore_patterns || []).join('\n');
+        crateParentInput.value = loadedConfig.crate_parent || '';
+        flatCratesInput.checked = !!loadedConfig.flat_crates;
+        crateNameUnderscoresInput.checked = !!loadedConfig.crate_name_underscores;
+        crateNameTitleCaseInput.checked = !!loadedConfig.crate_name_title_case;
+        rootCrateInput.checked = !!loadedConfig.root_crate;
+        orderedCratesInput.checked = !!loadedConfig.ordered_crates;
+        cratePrefixesInput.value = Object.entries(loadedConfig.crate_prefixes || {})
+            .map(([root, prefix]) => `${root} = ${prefix}`)
+            .join('\n');
+        noCrateFoldersInput.value = (loadedConfig.no_crate_folders || []).join('\n');
+        smartCratesPathInput.value = loadedConfig.smart_crates_path || '';
+        backupDirInput.value = loadedConfig.backup_dir || '';
+        followSymlinksInput.checked = !!loadedConfig.follow_symlinks;
+        caseInsensitivePathsInput.checked = !!loadedConfig.case_insensitive_paths;
+        syncModeInput.value = loadedConfig.sync_mode || 'add_only';
+        pruneMissingInput.checked = !!loadedConfig.prune_missing;
+        keepEmptyCratesInput.checked = !!loadedConfig.keep_empty_crates;
+        detectRenamedFoldersInput.checked = !!loadedConfig.detect_renamed_folders;
+        warnAstralPathsInput.checked = !!loadedConfig.warn_astral_paths;
+        matchMovedFilesInput.checked = !!loadedConfig.match_moved_files;
+        preserveModTimesInput.checked = !!loadedConfig.preserve_mod_times;
+        verifyFilesInput.checked = !!loadedConfig.verify_files;
+        fuzzyDuplicatesInput.checked = !!loadedConfig.fuzzy_duplicates;
+        metadataFieldsInput.value = (loadedConfig.metadata_fields || []).join(', ');
+        crateColumnsInput.value = (loadedConfig.crate_columns || []).join(', ');
     });
 
-    // Log messages
-    EventsOn('log', message => {
+    // Log messages, styled by level
+    EventsOn('log_entry', entry => {
         const p = document.createElement('p');
-        p.textContent = message;
+        p.textContent = entry.message;
+        p.className = `log-${entry.level}`;
         logsDiv.appendChild(p);
         logsDiv.scrollTop = logsDiv.scrollHeight;
     });
 
+    // Progress updates
+    EventsOn('progress', progress => {
+        syncProgress.value = progress.percent;
+        let label = `${progress.phase}: ${progress.current}/${progress.total}`;
+        if (progress.eta_seconds > 0) {
+            label += ` (about ${Math.ceil(progress.eta_seconds)}s left)`;
+        }
+        syncProgressLabel.textContent = label;
+    });
+
     // Button listeners
+    // Fills in the first Serato folder found; the others are offered as
+    // suggestions on the input.
+    detectSeratoDbBtn.addEventListener('click', () => {
+        AutoDetectSeratoPath().then(dirs => {
+            seratoDbCandidates.innerHTML = '';
+            (dirs || []).forEach(dir => {
+                const option = document.createElement('option');
+                option.value = dir;
+                seratoDbCandidates.appendChild(option);
+            });
+            if (dirs && dirs.length > 0) {
+                seratoDbPathInput.value = dirs[0];
+            }
+        });
+    });
+
     browseSeratoDbBtn.addEventListener('click', () => {
         BrowseForDirectory('Select Serato Database Directory').then(path => {
             if (path) {
@@ -44,24 +266,211 @@ document.addEventListener('DOMContentLoaded', () => {
     });
 
     saveConfigBtn.addEventListener('click', () => {
+        const extraPaths = extraLibraryPathsInput.value
+            .split('\n')
+            .map(path => path.trim())
+            .filter(path => path);
+        // Each line maps a library path to its crate; the last "=" splits
+        // them, since paths may contain one.
+        const cratePrefixes = {};
+        cratePrefixesInput.value.split('\n').forEach(line => {
+            const split = line.lastIndexOf('=');
+            const root = line.slice(0, split).trim();
+            const prefix = line.slice(split + 1).trim();
+            if (split > 0 && root && prefix) {
+                cratePrefixes[root] = prefix;
+            }
+        });
         const config = {
+            ...loadedConfig,
             serato_db_path: seratoDbPathInput.value,
             music_library_path: musicLibraryPathInput.value,
+            music_library_paths: [musicLibraryPathInput.value, ...extraPaths],
+            ignore_patterns: ignorePatternsInput.value
+                .split('\n')
+                .map(pattern => pattern.trim())
+                .filter(pattern => pattern),
+            crate_parent: crateParentInput.value.trim(),
+            flat_crates: flatCratesInput.checked,
+            crate_name_underscores: crateNameUnderscoresInput.checked,
+            crate_name_title_case: crateNameTitleCaseInput.checked,
+            root_crate: rootCrateInput.checked,
+            ordered_crates: orderedCratesInput.checked,
+            crate_prefixes: cratePrefixes,
+            no_crate_folders: noCrateFoldersInput.value
+                .split('\n')
+                .map(folder => folder.trim())
+                .filter(folder => folder),
+            smart_crates_path: smartCratesPathInput.value.trim(),
+            backup_dir: backupDirInput.value.trim(),
+            follow_symlinks: followSymlinksInput.checked,
+            case_insensitive_paths: caseInsensitivePathsInput.checked,
+            sync_mode: syncModeInput.value,
+            prune_missing: pruneMissingInput.checked,
+            keep_empty_crates: keepEmptyCratesInput.checked,
+            detect_renamed_folders: detectRenamedFoldersInput.checked,
+            warn_astral_paths: warnAstralPathsInput.checked,
+            match_moved_files: matchMovedFilesInput.checked,
+            preserve_mod_times: preserveModTimesInput.checked,
+            verify_files: verifyFilesInput.checked,
+            fuzzy_duplicates: fuzzyDuplicatesInput.checked,
+            metadata_fields: metadataFieldsInput.value
+                .split(',')
+                .map(field => field.trim())
+                .filter(field => field),
+            crate_columns: crateColumnsInput.value
+                .split(',')
+                .map(column => column.trim())
+                .filter(column => column),
         };
-        SaveConfig(config).then(() => {
-            // Log or show notification
+        ValidateConfig(config).then(problems => {
+            if (!showFieldErrors(problems)) {
+                return;
+            }
+            SaveConfig(config).then(() => {
+                loadedConfig = config;
+            });
+        });
+    });
+
+    previewSyncBtn.addEventListener('click', () => {
+        PlanSync().then(plan => {
+            const newTracks = (plan.new_tracks || []).length;
+            const crates = (plan.crates_to_write || []).length;
+            const message = `Sync will add ${newTracks} tracks, update ${crates} crates` +
+                ` and remove ${plan.tracks_to_prune} deleted tracks. Run it now?`;
+            if (window.confirm(message)) {
+                SyncLibrary().then(showSummary);
+            }
         });
     });
 
     syncLibraryBtn.addEventListener('click', () => {
-        SyncLibrary();
+        SyncLibrary().then(showSummary);
+    });
+
+    syncFolderBtn.addEventListener('click', () => {
+        const folder = syncFolderPathInput.value.trim();
+        if (folder) {
+            SyncFolder(folder).then(showSummary);
+        }
+    });
+
+    previewCrateBtn.addEventListener('click', () => {
+        const folder = syncFolderPathInput.value.trim();
+        if (folder) {
+            PreviewCrate(folder).then(tracks => {
+                cratePreviewPre.textContent = (tracks || []).join('\n') || 'The crate will be empty.';
+                cratePreviewCard.hidden = false;
+            });
+        }
+    });
+
+    cancelSyncBtn.addEventListener('click', () => {
+        CancelSync();
     });
 
     generateReportBtn.addEventListener('click', () => {
-        GenerateReport();
+        GenerateReport().then(report => {
+            reportPre.textContent = report.text;
+            reportCard.hidden = false;
+        });
+    });
+
+    showHistoryBtn.addEventListener('click', () => {
+        GetSyncHistory(20).then(manifests => {
+            historyPre.textContent = (manifests || []).map(manifest => {
+                const result = manifest.result || {};
+                const when = new Date(manifest.time).toLocaleString();
+                const folder = manifest.folder ? ` (${manifest.folder})` : '';
+                return `${when}${folder}: ${result.tracks_added} added, ${result.tracks_pruned} pruned, ${result.crates_written} crates written`;
+            }).join('\n') || 'No syncs recorded yet.';
+            historyCard.hidden = false;
+        });
+    });
+
+    // Lists each session of Serato's own history with the tracks played.
+    showSeratoHistoryBtn.addEventListener('click', () => {
+        GetHistory().then(sessions => {
+            seratoHistoryPre.textContent = (sessions || []).map(session => {
+                if (session.error) {
+                    return `Session ${session.name}: could not be read (${session.error})`;
+                }
+                const tracks = (session.tracks || []).filter(track => track.played);
+                const when = new Date(session.start).toLocaleString();
+                const lines = tracks.map(track => {
+                    const time = new Date(track.start).toLocaleTimeString();
+                    const name = track.artist ? `${track.artist} - ${track.title}` : (track.title || track.path);
+                    return `  ${time}  ${name}`;
+                });
+                return [`${when}: ${tracks.length} tracks played`, ...lines].join('\n');
+            }).join('\n\n') || 'No sessions in the Serato history.';
+            seratoHistoryCard.hidden = false;
+        });
     });
 
     cleanDatabaseBtn.addEventListener('click', () => {
-        CleanDatabase();
+        CleanDatabase().then(showChanges);
+    });
+
+    cleanCratesBtn.addEventListener('click', () => {
+        CleanCrates();
+    });
+
+    normalizePathsBtn.addEventListener('click', () => {
+        NormalizePaths().then(showChanges);
+    });
+    compactDatabaseBtn.addEventListener('click', () => {
+        CompactDatabase();
+    });
+
+    repairLibraryBtn.addEventListener('click', () => {
+        RepairLibrary().then(showChanges);
+    });
+
+    validateMetadataBtn.addEventListener('click', () => {
+        ValidateMetadata();
     });
+
+    exportDatabaseBtn.addEventListener('click', () => {
+        ExportDatabase('');
+    });
+
+    listCratesBtn.addEventListener('click', () => {
+        ListCrates().then(crates => {
+            cratesPre.textContent = (crates || []).map(crate => {
+                const count = crate.track_count < 0 ? `unreadable: ${crate.error}` : `${crate.track_count} tracks`;
+                return `${crate.name} (${count})`;
+            }).join('\n') || 'No crates found.';
+            cratesCard.hidden = false;
+        });
+    });
+
+    findDuplicatesBtn.addEventListener('click', () => {
+        FindDuplicates().then(groups => {
+            duplicatesPre.textContent = Object.values(groups || {})
+                .map(files => files.join('\n'))
+                .join('\n\n') || 'No duplicate files found.';
+            duplicatesCard.hidden = false;
+        });
+    });
+
+    refreshBackupsBtn.addEventListener('click', loadBackups);
+
+    restoreBackupBtn.addEventListener('click', () => {
+        const backupPath = backupList.value;
+        if (backupPath && window.confirm(`Restore the database from ${backupPath}?`)) {
+            RestoreBackup(backupPath).then(loadBackups);
+        }
+    });
+    exportBundleBtn.addEventListener('click', () => {
+        ExportSeratoBundle('');
+    });
+    importBundleBtn.addEventListener('click', () => {
+        if (window.confirm('Replace the database and crates with the ones from a bundle? Both are backed up first.')) {
+            ImportSeratoBundle('').then(loadBackups);
+        }
+    });
+
+    loadBackups();
 });
\ No newline at end of file
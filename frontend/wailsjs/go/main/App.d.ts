@@ -1,15 +1,66 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 import {config} from '../models';
+import {main} from '../models';
+import {serato} from '../models';
+import {syncer} from '../models';
+
+export function AutoDetectSeratoPath():Promise<Array<string>>;
 
 export function BrowseForDirectory(arg1:string):Promise<string>;
 
+export function CancelSync():Promise<void>;
+
+export function CleanCrates():Promise<string>;
+
 export function CleanDatabase():Promise<string>;
 
-export function GenerateReport():Promise<string>;
+export function CompactDatabase():Promise<string>;
+
+export function DiffStep():Promise<syncer.Result>;
+
+export function ExportDatabase(arg1:string):Promise<string>;
+
+export function ExportSeratoBundle(arg1:string):Promise<string>;
+
+export function FindDuplicates():Promise<{[key: string]: Array<string>}>;
+
+export function GenerateReport():Promise<main.DatabaseReport>;
 
 export function GetConfig():Promise<config.Config>;
 
+export function GetHistory():Promise<Array<serato.Session>>;
+
+export function GetLastSyncDiff():Promise<serato.DatabaseDiff>;
+
+export function GetSyncHistory(arg1:number):Promise<Array<syncer.Manifest>>;
+
+export function ImportSeratoBundle(arg1:string):Promise<string>;
+
+export function ListBackups():Promise<Array<main.BackupInfo>>;
+
+export function ListCrates():Promise<Array<serato.CrateInfo>>;
+
+export function NormalizePaths():Promise<string>;
+
+export function PlanSync():Promise<syncer.Result>;
+
+export function PreviewCrate(arg1:string):Promise<Array<string>>;
+
+export function RepairLibrary():Promise<main.RepairReport>;
+
+export function RestoreBackup(arg1:string):Promise<void>;
+
 export function SaveConfig(arg1:config.Config):Promise<void>;
 
-export function SyncLibrary():Promise<string>;
+export function ScanStep():Promise<syncer.Scan>;
+
+export function SyncFolder(arg1:string):Promise<syncer.Result>;
+
+export function SyncLibrary():Promise<syncer.Result>;
+
+export function ValidateConfig(arg1:config.Config):Promise<Array<config.FieldError>>;
+
+export function ValidateMetadata():Promise<Array<serato.MetadataWarning>>;
+
+export function WriteStep():Promise<syncer.Result>;
@@ -2,14 +2,46 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AutoDetectSeratoPath() {
+  return window['go']['main']['App']['AutoDetectSeratoPath']();
+}
+
 export function BrowseForDirectory(arg1) {
   return window['go']['main']['App']['BrowseForDirectory'](arg1);
 }
 
+export function CancelSync() {
+  return window['go']['main']['App']['CancelSync']();
+}
+
+export function CleanCrates() {
+  return window['go']['main']['App']['CleanCrates']();
+}
+
 export function CleanDatabase() {
   return window['go']['main']['App']['CleanDatabase']();
 }
 
+export function CompactDatabase() {
+  return window['go']['main']['App']['CompactDatabase']();
+}
+
+export function DiffStep() {
+  return window['go']['main']['App']['DiffStep']();
+}
+
+export function ExportDatabase(arg1) {
+  return window['go']['main']['App']['ExportDatabase'](arg1);
+}
+
+export function ExportSeratoBundle(arg1) {
+  return window['go']['main']['App']['ExportSeratoBundle'](arg1);
+}
+
+export function FindDuplicates() {
+  return window['go']['main']['App']['FindDuplicates']();
+}
+
 export function GenerateReport() {
   return window['go']['main']['App']['GenerateReport']();
 }
@@ -18,10 +50,74 @@ export function GetConfig() {
   return window['go']['main']['App']['GetConfig']();
 }
 
+export function GetHistory() {
+  return window['go']['main']['App']['GetHistory']();
+}
+
+export function GetLastSyncDiff() {
+  return window['go']['main']['App']['GetLastSyncDiff']();
+}
+
+export function GetSyncHistory(arg1) {
+  return window['go']['main']['App']['GetSyncHistory'](arg1);
+}
+
+export function ImportSeratoBundle(arg1) {
+  return window['go']['main']['App']['ImportSeratoBundle'](arg1);
+}
+
+export function ListBackups() {
+  return window['go']['main']['App']['ListBackups']();
+}
+
+export function ListCrates() {
+  return window['go']['main']['App']['ListCrates']();
+}
+
+export function NormalizePaths() {
+  return window['go']['main']['App']['NormalizePaths']();
+}
+
+export function PlanSync() {
+  return window['go']['main']['App']['PlanSync']();
+}
+
+export function PreviewCrate(arg1) {
+  return window['go']['main']['App']['PreviewCrate'](arg1);
+}
+
+export function RepairLibrary() {
+  return window['go']['main']['App']['RepairLibrary']();
+}
+
+export function RestoreBackup(arg1) {
+  return window['go']['main']['App']['RestoreBackup'](arg1);
+}
+
 export function SaveConfig(arg1) {
   return window['go']['main']['App']['SaveConfig'](arg1);
 }
 
+export function ScanStep() {
+  return window['go']['main']['App']['ScanStep']();
+}
+
+export function SyncFolder(arg1) {
+  return window['go']['main']['App']['SyncFolder'](arg1);
+}
+
 export function SyncLibrary() {
   return window['go']['main']['App']['SyncLibrary']();
 }
+
+export function ValidateConfig(arg1) {
+  return window['go']['main']['App']['ValidateConfig'](arg1);
+}
+
+export function ValidateMetadata() {
+  return window['go']['main']['App']['ValidateMetadata']();
+}
+
+export function WriteStep() {
+  return window['go']['main']['App']['WriteStep']();
+}
@@ -1,8 +1,45 @@
 export namespace config {
 	
 	export class Config {
+	    version: number;
 	    serato_db_path: string;
 	    music_library_path: string;
+	    music_library_paths: string[];
+	    sync_mode?: string;
+	    prune_missing: boolean;
+	    keep_empty_crates: boolean;
+	    verify_files: boolean;
+	    fuzzy_duplicates: boolean;
+	    backup_retention: number;
+	    backup_dir?: string;
+	    history_retention?: number;
+	    file_retries?: number;
+	    preserve_mod_times?: boolean;
+	    min_prefix_match?: number;
+	    audio_extensions: string[];
+	    ignore_patterns: string[];
+	    follow_symlinks: boolean;
+	    scan_cache: boolean;
+	    scan_workers: number;
+	    crate_sort: string;
+	    crate_workers: number;
+	    crate_parent: string;
+	    crate_prefixes?: Record<string, string>;
+	    flat_crates: boolean;
+	    crate_name_underscores?: boolean;
+	    crate_name_title_case?: boolean;
+	    root_crate: boolean;
+	    ordered_crates: boolean;
+	    match_moved_files: boolean;
+	    detect_renamed_folders: boolean;
+	    warn_astral_paths?: boolean;
+	    no_crate_folders: string[];
+	    smart_crates_path: string;
+	    metadata_fields: string[];
+	    crate_columns?: string[];
+	    database_file?: string;
+	    database_version?: string;
+	    case_insensitive_paths?: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new Config(source);
@@ -10,10 +47,594 @@ export namespace config {
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.version = source["version"];
 	        this.serato_db_path = source["serato_db_path"];
 	        this.music_library_path = source["music_library_path"];
+	        this.music_library_paths = source["music_library_paths"];
+	        this.sync_mode = source["sync_mode"];
+	        this.prune_missing = source["prune_missing"];
+	        this.keep_empty_crates = source["keep_empty_crates"];
+	        this.verify_files = source["verify_files"];
+	        this.fuzzy_duplicates = source["fuzzy_duplicates"];
+	        this.backup_retention = source["backup_retention"];
+	        this.backup_dir = source["backup_dir"];
+	        this.history_retention = source["history_retention"];
+	        this.file_retries = source["file_retries"];
+	        this.preserve_mod_times = source["preserve_mod_times"];
+	        this.min_prefix_match = source["min_prefix_match"];
+	        this.audio_extensions = source["audio_extensions"];
+	        this.ignore_patterns = source["ignore_patterns"];
+	        this.follow_symlinks = source["follow_symlinks"];
+	        this.scan_cache = source["scan_cache"];
+	        this.scan_workers = source["scan_workers"];
+	        this.crate_sort = source["crate_sort"];
+	        this.crate_workers = source["crate_workers"];
+	        this.crate_parent = source["crate_parent"];
+	        this.crate_prefixes = source["crate_prefixes"];
+	        this.flat_crates = source["flat_crates"];
+	        this.crate_name_underscores = source["crate_name_underscores"];
+	        this.crate_name_title_case = source["crate_name_title_case"];
+	        this.root_crate = source["root_crate"];
+	        this.ordered_crates = source["ordered_crates"];
+	        this.match_moved_files = source["match_moved_files"];
+	        this.detect_renamed_folders = source["detect_renamed_folders"];
+	        this.warn_astral_paths = source["warn_astral_paths"];
+	        this.no_crate_folders = source["no_crate_folders"];
+	        this.smart_crates_path = source["smart_crates_path"];
+	        this.metadata_fields = source["metadata_fields"];
+	        this.crate_columns = source["crate_columns"];
+	        this.database_file = source["database_file"];
+	        this.database_version = source["database_version"];
+	        this.case_insensitive_paths = source["case_insensitive_paths"];
 	    }
 	}
+	export class FieldError {
+	    field: string;
+	    message: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new FieldError(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.field = source["field"];
+	        this.message = source["message"];
+	    }
+	}
+
+}
+
+export namespace main {
+	
+	export class BackupInfo {
+	    path: string;
+	    timestamp: number;
+	    track_count: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new BackupInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.timestamp = source["timestamp"];
+	        this.track_count = source["track_count"];
+	    }
+	}
+	export class DatabaseReport {
+	    text: string;
+	    stats: serato.Report;
+	
+	    static createFrom(source: any = {}) {
+	        return new DatabaseReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.text = source["text"];
+	        this.stats = this.convertValues(source["stats"], serato.Report);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class RepairReport {
+	    trailing_bytes: number;
+	    damaged_bytes: number;
+	    paths_normalized: number;
+	    cleanup: serato.CleanupStats;
+	    crate_tracks_removed: number;
+	    unreadable_crates: string[];
+	    backups: string[];
+	    text: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RepairReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.trailing_bytes = source["trailing_bytes"];
+	        this.damaged_bytes = source["damaged_bytes"];
+	        this.paths_normalized = source["paths_normalized"];
+	        this.cleanup = this.convertValues(source["cleanup"], serato.CleanupStats);
+	        this.crate_tracks_removed = source["crate_tracks_removed"];
+	        this.unreadable_crates = source["unreadable_crates"];
+	        this.backups = source["backups"];
+	        this.text = source["text"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace serato {
+	
+	export class DuplicateMatch {
+	    kept: string;
+	    removed: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DuplicateMatch(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.kept = source["kept"];
+	        this.removed = source["removed"];
+	    }
+	}
+	export class CleanupStats {
+	    original_count: number;
+	    removed_no_path: number;
+	    removed_no_metadata: number;
+	    removed_duplicates: number;
+	    removed_corrupted: number;
+	    removed_missing_file: number;
+	    removed_fuzzy_duplicates: number;
+	    duplicates?: DuplicateMatch[];
+	    final_count: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new CleanupStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.original_count = source["original_count"];
+	        this.removed_no_path = source["removed_no_path"];
+	        this.removed_no_metadata = source["removed_no_metadata"];
+	        this.removed_duplicates = source["removed_duplicates"];
+	        this.removed_corrupted = source["removed_corrupted"];
+	        this.removed_missing_file = source["removed_missing_file"];
+	        this.removed_fuzzy_duplicates = source["removed_fuzzy_duplicates"];
+	        this.duplicates = this.convertValues(source["duplicates"], DuplicateMatch);
+	        this.final_count = source["final_count"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class CrateInfo {
+	    name: string;
+	    path: string;
+	    track_count: number;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new CrateInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.path = source["path"];
+	        this.track_count = source["track_count"];
+	        this.error = source["error"];
+	    }
+	}
+	export class FieldChange {
+	    tag: string;
+	    before: string;
+	    after: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new FieldChange(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.tag = source["tag"];
+	        this.before = source["before"];
+	        this.after = source["after"];
+	    }
+	}
+	export class HistoryTrack {
+	    path: string;
+	    title?: string;
+	    artist?: string;
+	    album?: string;
+	    genre?: string;
+	    deck?: number;
+	    // Go type: time
+	    start: any;
+	    // Go type: time
+	    end: any;
+	    played: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new HistoryTrack(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.title = source["title"];
+	        this.artist = source["artist"];
+	        this.album = source["album"];
+	        this.genre = source["genre"];
+	        this.deck = source["deck"];
+	        this.start = this.convertValues(source["start"], null);
+	        this.end = this.convertValues(source["end"], null);
+	        this.played = source["played"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class MetadataWarning {
+	    pfil: string;
+	    crate?: string;
+	    tag: string;
+	    value: string;
+	    problem: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MetadataWarning(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.pfil = source["pfil"];
+	        this.crate = source["crate"];
+	        this.tag = source["tag"];
+	        this.value = source["value"];
+	        this.problem = source["problem"];
+	    }
+	}
+	export class RecordChange {
+	    pfil: string;
+	    fields: FieldChange[];
+	
+	    static createFrom(source: any = {}) {
+	        return new RecordChange(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.pfil = source["pfil"];
+	        this.fields = this.convertValues(source["fields"], FieldChange);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DatabaseDiff {
+	    added: string[];
+	    removed: string[];
+	    changed: RecordChange[];
+	
+	    static createFrom(source: any = {}) {
+	        return new DatabaseDiff(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.added = source["added"];
+	        this.removed = source["removed"];
+	        this.changed = this.convertValues(source["changed"], RecordChange);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Report {
+	    version?: string;
+	    total_tracks: number;
+	    genres: {[key: string]: number};
+	    missing_metadata: number;
+	    outside_library: number;
+	    duplicate_paths: number;
+	    added_recently: number;
+	    last_added: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Report(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.version = source["version"];
+	        this.total_tracks = source["total_tracks"];
+	        this.genres = source["genres"];
+	        this.missing_metadata = source["missing_metadata"];
+	        this.outside_library = source["outside_library"];
+	        this.duplicate_paths = source["duplicate_paths"];
+	        this.added_recently = source["added_recently"];
+	        this.last_added = source["last_added"];
+	    }
+	}
+	export class Session {
+	    name: string;
+	    path: string;
+	    // Go type: time
+	    start: any;
+	    // Go type: time
+	    end: any;
+	    tracks: HistoryTrack[];
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Session(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.path = source["path"];
+	        this.start = this.convertValues(source["start"], null);
+	        this.end = this.convertValues(source["end"], null);
+	        this.tracks = this.convertValues(source["tracks"], HistoryTrack);
+	        this.error = source["error"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace syncer {
+	
+	export class Manifest {
+	    // Go type: time
+	    time: any;
+	    folder?: string;
+	    result?: Result;
+	
+	    static createFrom(source: any = {}) {
+	        return new Manifest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.folder = source["folder"];
+	        this.result = this.convertValues(source["result"], Result);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Result {
+	    sync_mode: string;
+	    dry_run: boolean;
+	    new_tracks: string[];
+	    crates_to_write: string[];
+	    tracks_to_prune: number;
+	    files_scanned: number;
+	    tracks_before: number;
+	    tracks_after: number;
+	    tracks_added: number;
+	    tracks_pruned: number;
+	    crates_written: number;
+	    tracks_written: number;
+	    crates_pruned: number;
+	    crates_removed: number;
+	    crates_renamed: number;
+	    tracks_moved: number;
+	    crates_unchanged: number;
+	    tracks_existing: number;
+	    tracks_outside_library: number;
+	    backups: string[];
+	    skipped: string[];
+	    undisplayable: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Result(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.sync_mode = source["sync_mode"];
+	        this.dry_run = source["dry_run"];
+	        this.new_tracks = source["new_tracks"];
+	        this.crates_to_write = source["crates_to_write"];
+	        this.tracks_to_prune = source["tracks_to_prune"];
+	        this.files_scanned = source["files_scanned"];
+	        this.tracks_before = source["tracks_before"];
+	        this.tracks_after = source["tracks_after"];
+	        this.tracks_added = source["tracks_added"];
+	        this.tracks_pruned = source["tracks_pruned"];
+	        this.crates_written = source["crates_written"];
+	        this.tracks_written = source["tracks_written"];
+	        this.crates_pruned = source["crates_pruned"];
+	        this.crates_removed = source["crates_removed"];
+	        this.crates_renamed = source["crates_renamed"];
+	        this.tracks_moved = source["tracks_moved"];
+	        this.crates_unchanged = source["crates_unchanged"];
+	        this.tracks_existing = source["tracks_existing"];
+	        this.tracks_outside_library = source["tracks_outside_library"];
+	        this.backups = source["backups"];
+	        this.skipped = source["skipped"];
+	        this.undisplayable = source["undisplayable"];
+	    }
+	}
+	export class Scan {
+	    // Go type: time
+	    time: any;
+	    folder?: string;
+	    roots: string[];
+	    directories: number;
+	    files_scanned: number;
+	    skipped: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Scan(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.folder = source["folder"];
+	        this.roots = source["roots"];
+	        this.directories = source["directories"];
+	        this.files_scanned = source["files_scanned"];
+	        this.skipped = source["skipped"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 
 }
 
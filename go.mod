@@ -3,6 +3,7 @@ module seratosync-go
 go 1.23
 
 require (
+	github.com/dhowden/tag v0.0.0-20240417053706-3d75831295e8
 	github.com/wailsapp/wails/v2 v2.10.1
 	golang.org/x/text v0.22.0
 )
@@ -0,0 +1,161 @@
+package library
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ScanCacheEntry is what the scan cache remembers about one file. An entry
+// is only trusted while the file's size and modification time match.
+type ScanCacheEntry struct {
+	Size    int64             `json:"size"`
+	ModTime int64             `json:"mod_time"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	// Signature is the file's FileSignature, recorded by scans with
+	// ScanOptions.Signatures.
+	Signature string `json:"signature,omitempty"`
+}
+
+// ScanCache maps absolute file paths to what was learned about them on
+// earlier scans, so unchanged files aren't processed again. It is safe for
+// concurrent use.
+type ScanCache struct {
+	mu      sync.Mutex
+	entries map[string]ScanCacheEntry
+	seen    map[string]struct{}
+	// gone holds the entries dropped by forgetUnseen, for MovedFiles.
+	gone map[string]ScanCacheEntry
+}
+
+// NewScanCache returns an empty cache.
+func NewScanCache() *ScanCache {
+	return &ScanCache{
+		entries: make(map[string]ScanCacheEntry),
+		seen:    make(map[string]struct{}),
+		gone:    make(map[string]ScanCacheEntry),
+	}
+}
+
+// LoadScanCache reads a cache file. A missing file gives an empty cache; a
+// corrupt one gives an empty cache and the decode error, so callers can
+// note it and carry on with a full scan.
+func LoadScanCache(path string) (*ScanCache, error) {
+	cache := NewScanCache()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return cache, err
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		return NewScanCache(), err
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]ScanCacheEntry)
+	}
+	return cache, nil
+}
+
+// SaveScanCache writes the cache to path.
+func SaveScanCache(path string, cache *ScanCache) error {
+	cache.mu.Lock()
+	data, err := json.Marshal(cache.entries)
+	cache.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// observe records that the scan found path with the given file info. A
+// cached entry that no longer matches is discarded. It reports whether the
+// existing entry was still valid.
+func (c *ScanCache) observe(path string, info os.FileInfo) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seen[path] = struct{}{}
+	entry, ok := c.entries[path]
+	if ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+		return true
+	}
+	c.entries[path] = ScanCacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	return false
+}
+
+// forgetUnseen drops entries under root that the last scan didn't find.
+func (c *ScanCache) forgetUnseen(root string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+	for path := range c.entries {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if _, ok := c.seen[path]; !ok {
+			c.gone[path] = c.entries[path]
+			delete(c.entries, path)
+		}
+	}
+}
+
+// ReadTags returns the tags of an audio file, reading them with ReadTags
+// only if the cache has no valid copy.
+func (c *ScanCache) ReadTags(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	valid := ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano()
+	if valid && entry.Tags != nil {
+		return entry.Tags, nil
+	}
+
+	tags, err := ReadTags(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid {
+		entry = ScanCacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	}
+	entry.Tags = tags
+	c.mu.Lock()
+	c.entries[path] = entry
+	c.mu.Unlock()
+	return tags, nil
+}
+
+// sign records the FileSignature of path, which observe has just seen,
+// unless its entry already has one.
+func (c *ScanCache) sign(path string) {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if !ok || entry.Signature != "" {
+		return
+	}
+	signature, err := FileSignature(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	if entry, ok := c.entries[path]; ok {
+		entry.Signature = signature
+		c.entries[path] = entry
+	}
+	c.mu.Unlock()
+}
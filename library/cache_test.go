@@ -0,0 +1,95 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanCacheReusesUnchangedFiles(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "House", "a.mp3")
+	b := filepath.Join(root, "House", "b.mp3")
+	gone := filepath.Join(root, "House", "gone.mp3")
+	if err := os.MkdirAll(filepath.Dir(a), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, title := range map[string]string{a: "Title A", b: "Title B", gone: "Gone"} {
+		if err := os.WriteFile(path, id3v23("TIT2", title), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cachePath := filepath.Join(t.TempDir(), "scan-cache.json")
+
+	cache := NewScanCache()
+	if _, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Cache: cache}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{a, b} {
+		if _, err := cache.ReadTags(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveScanCache(cachePath, cache); err != nil {
+		t.Fatal(err)
+	}
+
+	// a gets a new title of the same length with its old modification
+	// time, so only a cache hit explains reading the old title. b changes
+	// size and gone is deleted.
+	info, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(a, id3v23("TIT2", "Title X"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(a, time.Time{}, info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, id3v23("TIT2", "Title B, remastered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = LoadScanCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Cache: cache}); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{a: "Title A", b: "Title B, remastered"} {
+		tags, err := cache.ReadTags(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tags["ttit"] != want {
+			t.Errorf("%s: title = %q, want %q", filepath.Base(path), tags["ttit"], want)
+		}
+	}
+	if _, ok := cache.entries[gone]; ok {
+		t.Error("cache kept an entry for a deleted file")
+	}
+}
+
+func TestLoadScanCacheCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan-cache.json")
+	if cache, err := LoadScanCache(path); err != nil || len(cache.entries) != 0 {
+		t.Errorf("missing cache = %v, %v, want an empty cache", cache.entries, err)
+	}
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := LoadScanCache(path)
+	if err == nil {
+		t.Error("corrupt cache loaded without an error")
+	}
+	if cache == nil || len(cache.entries) != 0 {
+		t.Error("corrupt cache didn't give an empty cache")
+	}
+}
@@ -0,0 +1,125 @@
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// FindDuplicateFiles returns the files of libraryMap, a scan of root, whose
+// contents are byte-for-byte identical, keyed by the hex SHA-256 of those
+// contents. Each group holds two or more paths relative to root, sorted.
+// Only files sharing their size with another file are hashed. Empty files
+// and files that can't be read are left out.
+func FindDuplicateFiles(libraryMap LibraryMap, root string) map[string][]string {
+	groups, _ := FindDuplicateFilesContext(context.Background(), libraryMap, root, 0)
+	return groups
+}
+
+// FindDuplicateFilesContext is FindDuplicateFiles hashing with workers
+// goroutines, or runtime.NumCPU() if workers is zero. It stops with
+// ctx.Err() as soon as ctx is cancelled.
+func FindDuplicateFilesContext(ctx context.Context, libraryMap LibraryMap, root string, workers int) (map[string][]string, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	bySize := make(map[int64][]string)
+	for _, files := range libraryMap {
+		for _, relFile := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			info, err := os.Stat(filepath.Join(root, relFile))
+			if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+				continue
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], relFile)
+		}
+	}
+
+	candidates := make(chan string)
+	var mu sync.Mutex
+	byHash := make(map[string][]string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for relFile := range candidates {
+				sum, err := hashFile(ctx, filepath.Join(root, relFile))
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				byHash[sum] = append(byHash[sum], relFile)
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+		for _, relFile := range files {
+			select {
+			case candidates <- relFile:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(candidates)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	for sum, files := range byHash {
+		if len(files) < 2 {
+			continue
+		}
+		sort.Strings(files)
+		groups[sum] = files
+	}
+	return groups, nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path, giving up with
+// ctx.Err() if ctx is cancelled while it reads.
+func hashFile(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, contextReader{ctx, file}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contextReader fails reads with ctx.Err() once ctx is cancelled, so a
+// long copy stops part way.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,50 @@
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindDuplicateFiles(t *testing.T) {
+	root := makeLibrary(t)
+	files := map[string]string{
+		"House/a.mp3":       "same audio",
+		"Imports/a (1).mp3": "same audio",
+		"Techno/b.mp3":      "diff audio", // same size, other contents
+		"Techno/c.mp3":      "unique",
+		"Empty/d.mp3":       "",
+		"Empty/e.mp3":       "",
+	}
+	libraryMap := make(LibraryMap)
+	for rel, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Dir(filepath.FromSlash(rel))
+		libraryMap[dir] = append(libraryMap[dir], filepath.FromSlash(rel))
+	}
+
+	sum := sha256.Sum256([]byte("same audio"))
+	want := map[string][]string{
+		hex.EncodeToString(sum[:]): {filepath.FromSlash("House/a.mp3"), filepath.FromSlash("Imports/a (1).mp3")},
+	}
+	if got := FindDuplicateFiles(libraryMap, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindDuplicateFiles = %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FindDuplicateFilesContext(ctx, libraryMap, root, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled search: err = %v, want %v", err, context.Canceled)
+	}
+}
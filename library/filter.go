@@ -0,0 +1,122 @@
+package library
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// TrackFilter narrows a sync to the tracks whose paths, relative to the
+// library root, match glob patterns. Patterns use path.Match syntax plus
+// "**", which matches any number of folders, so "**/*.flac" selects every
+// FLAC file. As with ignore patterns, a pattern without a slash is matched
+// against the file name alone. Case is ignored.
+type TrackFilter struct {
+	include [][]string
+	exclude [][]string
+}
+
+// NewTrackFilter returns a filter keeping the tracks that match any of
+// include, or every track if include is empty, and none of exclude. It
+// returns an error for a malformed pattern.
+func NewTrackFilter(include, exclude []string) (*TrackFilter, error) {
+	f := &TrackFilter{}
+	var err error
+	if f.include, err = compileGlobs(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compileGlobs(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileGlobs(patterns []string) ([][]string, error) {
+	var compiled [][]string
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.Trim(filepath.ToSlash(strings.TrimSpace(pattern)), "/"))
+		if pattern == "" {
+			continue
+		}
+		parts := strings.Split(pattern, "/")
+		for _, part := range parts {
+			if _, err := path.Match(part, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+		if len(parts) == 1 {
+			parts = []string{"**", parts[0]}
+		}
+		compiled = append(compiled, parts)
+	}
+	return compiled, nil
+}
+
+// Empty reports whether the filter keeps every track.
+func (f *TrackFilter) Empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// Match reports whether the filter keeps the track at rel.
+func (f *TrackFilter) Match(rel string) bool {
+	parts := strings.Split(strings.ToLower(filepath.ToSlash(rel)), "/")
+	included := len(f.include) == 0
+	for _, pattern := range f.include {
+		if matchGlob(pattern, parts) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range f.exclude {
+		if matchGlob(pattern, parts) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns libraryMap with only the tracks the filter keeps, dropping
+// folders left empty. libraryMap itself is left as it is.
+func (f *TrackFilter) Apply(libraryMap LibraryMap) LibraryMap {
+	if f.Empty() {
+		return libraryMap
+	}
+	kept := make(LibraryMap, len(libraryMap))
+	for relDir, files := range libraryMap {
+		var matched []string
+		for _, file := range files {
+			if f.Match(file) {
+				matched = append(matched, file)
+			}
+		}
+		if len(matched) > 0 {
+			kept[relDir] = matched
+		}
+	}
+	return kept
+}
+
+// matchGlob matches path components against pattern components, with "**"
+// standing for any number of components, including none.
+func matchGlob(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchGlob(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchGlob(pattern[1:], parts[1:])
+}
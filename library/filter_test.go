@@ -0,0 +1,65 @@
+package library
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTrackFilterMatch(t *testing.T) {
+	tests := []struct {
+		include, exclude []string
+		rel              string
+		want             bool
+	}{
+		{nil, nil, "House/a.mp3", true},
+		{[]string{"**/*.flac"}, nil, "House/Deep/a.FLAC", true},
+		{[]string{"**/*.flac"}, nil, "a.flac", true},
+		{[]string{"**/*.flac"}, nil, "House/a.mp3", false},
+		// A pattern without a slash matches the file name in any folder.
+		{[]string{"*.flac"}, nil, "House/Deep/a.flac", true},
+		{[]string{"house/*"}, nil, "House/a.mp3", true},
+		{[]string{"house/*"}, nil, "House/Deep/a.mp3", false},
+		{[]string{"house/**"}, nil, "House/Deep/a.mp3", true},
+		{nil, []string{"Bootlegs/**"}, "Bootlegs/a.mp3", false},
+		{nil, []string{"Bootlegs/**"}, "House/a.mp3", true},
+		// Excludes win over includes.
+		{[]string{"**/*.flac"}, []string{"Live/**"}, "Live/a.flac", false},
+	}
+	for _, tt := range tests {
+		f, err := NewTrackFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Match(filepath.FromSlash(tt.rel)); got != tt.want {
+			t.Errorf("include %q, exclude %q: Match(%q) = %v, want %v", tt.include, tt.exclude, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestTrackFilterInvalidPattern(t *testing.T) {
+	if _, err := NewTrackFilter([]string{"House/[a-"}, nil); err == nil {
+		t.Error("NewTrackFilter accepted a malformed include pattern")
+	}
+	if _, err := NewTrackFilter(nil, []string{"["}); err == nil {
+		t.Error("NewTrackFilter accepted a malformed exclude pattern")
+	}
+}
+
+func TestTrackFilterApply(t *testing.T) {
+	libraryMap := LibraryMap{
+		"House":  {filepath.FromSlash("House/a.flac"), filepath.FromSlash("House/b.mp3")},
+		"Techno": {filepath.FromSlash("Techno/c.mp3")},
+	}
+	f, err := NewTrackFilter([]string{"**/*.flac"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LibraryMap{"House": {filepath.FromSlash("House/a.flac")}}
+	if got := f.Apply(libraryMap); !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+	if len(libraryMap["House"]) != 2 {
+		t.Error("Apply changed its argument")
+	}
+}
@@ -0,0 +1,86 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// signatureChunk is how much of each end of a file FileSignature reads.
+const signatureChunk = 64 << 10
+
+// FileSignature identifies a file by its contents without reading all of
+// it: the file's size and a SHA-256 of its first and last 64 KiB. Audio
+// files with the same signature are taken to be the same recording. A
+// file's signature doesn't change when it is moved or renamed.
+func FileSignature(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, file, signatureChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+	if info.Size() > signatureChunk {
+		tail := max(info.Size()-signatureChunk, signatureChunk)
+		if _, err := io.Copy(hash, io.NewSectionReader(file, tail, info.Size()-tail)); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%d-%s", info.Size(), hex.EncodeToString(hash.Sum(nil))), nil
+}
+
+// MovedFiles recognizes files that were moved within the library root:
+// it maps each of newTracks, paths relative to root that the database
+// doesn't have yet, to the old path relative to root of the file with the
+// same signature that the last scan no longer found. Only files cache
+// recorded signatures for can be matched (see ScanOptions.Signatures), so
+// a file must have been scanned with signatures before it was moved.
+// Signatures shared by several new or gone files are ambiguous and left
+// out.
+func MovedFiles(cache *ScanCache, root string, newTracks []string) map[string]string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	goneBySignature := make(map[string][]string)
+	for path, entry := range cache.gone {
+		if entry.Signature == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		goneBySignature[entry.Signature] = append(goneBySignature[entry.Signature], rel)
+	}
+	if len(goneBySignature) == 0 {
+		return nil
+	}
+
+	newBySignature := make(map[string][]string)
+	for _, relFile := range newTracks {
+		entry, ok := cache.entries[filepath.Join(root, relFile)]
+		if ok && entry.Signature != "" {
+			newBySignature[entry.Signature] = append(newBySignature[entry.Signature], relFile)
+		}
+	}
+
+	moved := make(map[string]string)
+	for signature, newFiles := range newBySignature {
+		oldFiles := goneBySignature[signature]
+		if len(newFiles) == 1 && len(oldFiles) == 1 {
+			moved[newFiles[0]] = oldFiles[0]
+		}
+	}
+	return moved
+}
@@ -0,0 +1,96 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileSignature(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 20000)
+	changedMiddle := bytes.Clone(big)
+	changedMiddle[len(big)/2] = 'x'
+	changedEnd := bytes.Clone(big)
+	changedEnd[len(big)-1] = 'x'
+
+	signature := func(name string, data []byte) string {
+		t.Helper()
+		sig, err := FileSignature(writeFixture(t, name, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	sig := signature("a.mp3", big)
+	if other := signature("renamed.mp3", big); other != sig {
+		t.Errorf("signature changed with the file name: %s, %s", sig, other)
+	}
+	// Only the ends of the file are read.
+	if other := signature("middle.mp3", changedMiddle); other != sig {
+		t.Errorf("signature changed with the middle of the file")
+	}
+	if other := signature("end.mp3", changedEnd); other == sig {
+		t.Errorf("signature unchanged by the end of the file")
+	}
+	if other := signature("short.mp3", big[:len(big)-1]); other == sig {
+		t.Errorf("signature unchanged by the size")
+	}
+}
+
+func TestMovedFiles(t *testing.T) {
+	root := makeLibrary(t)
+	write := func(rel, data string) {
+		t.Helper()
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("House/a.mp3", "track a")
+	write("House/b.mp3", "track b")
+	write("House/c.mp3", "track c")
+
+	// Each scan starts from the cache the last one saved, as in a sync.
+	cachePath := filepath.Join(t.TempDir(), "cache.json")
+	var cache *ScanCache
+	scan := func() {
+		t.Helper()
+		var err error
+		if cache, err = LoadScanCache(cachePath); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Cache: cache, Signatures: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := SaveScanCache(cachePath, cache); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan()
+	for _, rel := range []string{"House/a.mp3", "House/b.mp3"} {
+		if err := os.Remove(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Archive/a.mp3", "track a")
+	// Two copies of b make its move ambiguous.
+	write("Archive/b.mp3", "track b")
+	write("Archive/b (copy).mp3", "track b")
+	write("Archive/d.mp3", "track d")
+	scan()
+
+	newTracks := []string{
+		filepath.FromSlash("Archive/a.mp3"), filepath.FromSlash("Archive/b.mp3"),
+		filepath.FromSlash("Archive/b (copy).mp3"), filepath.FromSlash("Archive/d.mp3"),
+	}
+	want := map[string]string{filepath.FromSlash("Archive/a.mp3"): filepath.FromSlash("House/a.mp3")}
+	if got := MovedFiles(cache, root, newTracks); !reflect.DeepEqual(got, want) {
+		t.Errorf("MovedFiles = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package library
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultIgnorePatterns are skipped by a scan unless ScanOptions.Ignore is
+// set: hidden files and folders (including .Trash and .DS_Store) and the
+// __MACOSX folders left behind by zip files made on a Mac.
+var DefaultIgnorePatterns = []string{".*", "__MACOSX"}
+
+// IgnoreFileName is the name of the optional ignore file a scan reads from
+// the library root. Like a .gitignore, it holds one glob pattern per line;
+// blank lines and lines starting with "#" are skipped. Unlike the patterns
+// in ScanOptions.Ignore, its patterns are anchored at the library root, so
+// "Samples" skips only the top-level Samples folder and "**/Samples" skips
+// every one. "**" matches any number of folders and case is ignored. A file
+// or folder is left out if it matches either list.
+const IgnoreFileName = ".seratosyncignore"
+
+// ignoreRules is what a scan leaves out: the name or path patterns from
+// ScanOptions.Ignore and the anchored patterns from the ignore file.
+type ignoreRules struct {
+	patterns []string
+	anchored [][]string
+}
+
+// loadIgnoreRules combines patterns with those in the ignore file at the
+// library root, if there is one.
+func loadIgnoreRules(libraryRoot string, patterns []string) (ignoreRules, error) {
+	rules := ignoreRules{patterns: patterns}
+	fileName := filepath.Join(libraryRoot, IgnoreFileName)
+	lines, err := readIgnoreFile(fileName)
+	if err != nil {
+		return rules, err
+	}
+	for _, line := range lines {
+		parts := strings.Split(strings.ToLower(strings.Trim(filepath.ToSlash(line), "/")), "/")
+		for _, part := range parts {
+			if _, err := path.Match(part, ""); err != nil {
+				return rules, fmt.Errorf("invalid pattern %q in %s: %w", line, fileName, err)
+			}
+		}
+		rules.anchored = append(rules.anchored, parts)
+	}
+	return rules, nil
+}
+
+// readIgnoreFile returns the patterns in the ignore file at fileName, or
+// none if it doesn't exist.
+func readIgnoreFile(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.Trim(line, "/") == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", fileName, err)
+	}
+	return lines, nil
+}
+
+// match reports whether the file or folder at rel, a path relative to the
+// library root, is left out by the rules.
+func (r ignoreRules) match(rel string) bool {
+	if rel == "." || rel == "" {
+		return false
+	}
+	if isIgnored(rel, r.patterns) {
+		return true
+	}
+	parts := strings.Split(strings.ToLower(filepath.ToSlash(rel)), "/")
+	for _, pattern := range r.anchored {
+		if matchGlob(pattern, parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnored reports whether the file or folder at rel, a path relative to
+// the library root, matches one of patterns. Patterns without a slash are
+// matched against the name alone, so "Samples" skips every folder of that
+// name; patterns with a slash are matched against the whole relative path.
+// Matching uses path.Match syntax and ignores case.
+func isIgnored(rel string, patterns []string) bool {
+	if rel == "." || rel == "" {
+		return false
+	}
+	rel = strings.ToLower(filepath.ToSlash(rel))
+	name := path.Base(rel)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.Trim(filepath.ToSlash(strings.TrimSpace(pattern)), "/"))
+		if pattern == "" {
+			continue
+		}
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, err := path.Match(pattern, target); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScanLibraryIgnoreFile(t *testing.T) {
+	root := makeLibrary(t,
+		"Samples/kick.wav", "House/Samples/snare.wav", "House/a.mp3",
+		"House/Edits/b.mp3", "Techno/Edits/c.mp3", "Techno/d.mp3", "Techno/promo.mp3",
+	)
+	ignore := `# Samples at the top level only
+Samples/
+
+# Edits in any folder
+**/edits
+**/promo.*
+`
+	if err := os.WriteFile(filepath.Join(root, IgnoreFileName), []byte(ignore), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lib, err := ScanLibrary(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LibraryMap{
+		"House":                             {filepath.FromSlash("House/a.mp3")},
+		filepath.FromSlash("House/Samples"): {filepath.FromSlash("House/Samples/snare.wav")},
+		"Techno":                            {filepath.FromSlash("Techno/d.mp3")},
+	}
+	if !reflect.DeepEqual(lib, want) {
+		t.Errorf("scan found %v, want %v", lib, want)
+	}
+
+	// Patterns from the file and from ScanOptions.Ignore both apply.
+	lib, err = ScanLibraryWithOptions(context.Background(), root, ScanOptions{Ignore: []string{"Samples"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(want, filepath.FromSlash("House/Samples"))
+	if !reflect.DeepEqual(lib, want) {
+		t.Errorf("scan with Ignore found %v, want %v", lib, want)
+	}
+}
+
+func TestScanLibraryBadIgnoreFile(t *testing.T) {
+	root := makeLibrary(t, "House/a.mp3")
+	if err := os.WriteFile(filepath.Join(root, IgnoreFileName), []byte("House/[a-\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ScanLibrary(root); err == nil {
+		t.Error("scan with a malformed ignore pattern succeeded")
+	}
+}
@@ -1,8 +1,18 @@
 package library
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"seratosync-go/serato"
 )
@@ -10,36 +20,242 @@ import (
 // LibraryMap is a map of relative directory paths to lists of relative file paths.
 type LibraryMap map[string][]string
 
+// ScanOptions controls how ScanLibraryWithOptions walks a library.
+type ScanOptions struct {
+	// Workers is the number of goroutines checking files found by the walk.
+	// Zero means runtime.NumCPU().
+	Workers int
+	// Progress, if set, is called as files are checked with the number done
+	// and the total. The total comes from a quick counting pass made before
+	// the scan, and Progress may be called from several goroutines.
+	Progress func(current, total int)
+	// Extensions is the set of audio extensions to include, as built by
+	// serato.ExtensionSet. Nil means serato.AudioExts.
+	Extensions map[string]struct{}
+	// Ignore lists patterns for files and folders to leave out (see
+	// isIgnored). Ignored folders are not walked at all. Nil means
+	// DefaultIgnorePatterns. The patterns in the library's ignore file,
+	// if it has one, are left out as well (see IgnoreFileName).
+	Ignore []string
+	// FollowSymlinks walks into symlinked folders. Without it they are
+	// skipped and reported through Log.
+	FollowSymlinks bool
+	// Log, if set, receives warnings about folders the scan skipped.
+	Log func(message string)
+	// Skipped, if set, is called with each file or folder that could not be
+	// read and was left out of the scan, so callers can list them at the
+	// end. The scan only fails if the library root can't be read.
+	Skipped func(path string, err error)
+	// Cache, if set, is updated with every file found. Entries for files
+	// that changed since they were cached are dropped, as are entries under
+	// the library root for files that no longer exist.
+	Cache *ScanCache
+	// Signatures records each file's FileSignature in Cache, reading the
+	// ends of files that are new or changed, so MovedFiles can recognize
+	// them once moved.
+	Signatures bool
+}
+
 // ScanLibrary scans the library directory and returns a mapping of relative directories to audio files.
 func ScanLibrary(libraryRoot string) (LibraryMap, error) {
+	return ScanLibraryWithOptions(context.Background(), libraryRoot, ScanOptions{})
+}
+
+// ScanLibraryWithOptions is ScanLibrary with control over the scan. The
+// directory walk feeds candidate files to a pool of workers; each
+// directory's files are sorted before returning so results are stable.
+// The walk stops with ctx.Err() as soon as ctx is cancelled.
+func ScanLibraryWithOptions(ctx context.Context, libraryRoot string, opts ScanOptions) (LibraryMap, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	exts := opts.Extensions
+	if exts == nil {
+		exts = serato.AudioExts
+	}
+	patterns := opts.Ignore
+	if patterns == nil {
+		patterns = DefaultIgnorePatterns
+	}
+	ignore, err := loadIgnoreRules(libraryRoot, patterns)
+	if err != nil {
+		return nil, err
+	}
+
 	libraryMap := make(LibraryMap)
+	var mu sync.Mutex
+	var firstErr error
+	setErr := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
 
-	err := filepath.Walk(libraryRoot, func(path string, info os.FileInfo, err error) error {
+	total := 0
+	if opts.Progress != nil {
+		var err error
+		total, err = countFiles(ctx, libraryRoot, walkOptions{ignore: ignore, followSymlinks: opts.FollowSymlinks})
 		if err != nil {
-			return err
+			return nil, err
 		}
+		opts.Progress(0, total)
+	}
+	var checked int64
 
-		if !info.IsDir() && serato.IsAudioFile(path) {
-			relDir, err := filepath.Rel(libraryRoot, filepath.Dir(path))
-			if err != nil {
-				return err
-			}
-			relFile, err := filepath.Rel(libraryRoot, path)
-			if err != nil {
-				return err
+	type candidate struct {
+		path string
+		info os.FileInfo
+	}
+	paths := make(chan candidate, workers*4)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range paths {
+				path := c.path
+				if opts.Progress != nil {
+					opts.Progress(int(atomic.AddInt64(&checked, 1)), total)
+				}
+				if !serato.IsAudioFileIn(path, exts) {
+					continue
+				}
+				if opts.Cache != nil {
+					opts.Cache.observe(path, c.info)
+					if opts.Signatures {
+						opts.Cache.sign(path)
+					}
+				}
+				relDir, err := filepath.Rel(libraryRoot, filepath.Dir(path))
+				if err != nil {
+					setErr(err)
+					continue
+				}
+				relFile, err := filepath.Rel(libraryRoot, path)
+				if err != nil {
+					setErr(err)
+					continue
+				}
+				mu.Lock()
+				libraryMap[relDir] = append(libraryMap[relDir], relFile)
+				mu.Unlock()
 			}
-			libraryMap[relDir] = append(libraryMap[relDir], relFile)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	walkOpts := walkOptions{ignore: ignore, followSymlinks: opts.FollowSymlinks, log: opts.Log, skipped: opts.Skipped}
+	walkErr := walkFiles(ctx, libraryRoot, walkOpts, func(path string, info os.FileInfo) error {
+		select {
+		case paths <- candidate{path: path, info: info}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		return nil
 	})
+	close(paths)
+	<-done
+
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if opts.Cache != nil {
+		opts.Cache.forgetUnseen(libraryRoot)
+	}
+
+	SortTracks(libraryMap, libraryRoot, SortByName)
+	return libraryMap, nil
+}
+
+// ScanFolder scans the single folder relDir of a library, without its
+// subfolders, and returns a LibraryMap holding just that folder with paths
+// relative to libraryRoot, as ScanLibrary would. Workers and Progress in
+// opts are not used, and the cache only gains entries since the rest of the
+// library is not seen.
+func ScanFolder(ctx context.Context, libraryRoot, relDir string, opts ScanOptions) (LibraryMap, error) {
+	exts := opts.Extensions
+	if exts == nil {
+		exts = serato.AudioExts
+	}
+	patterns := opts.Ignore
+	if patterns == nil {
+		patterns = DefaultIgnorePatterns
+	}
+	ignore, err := loadIgnoreRules(libraryRoot, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	relDir = filepath.Clean(filepath.FromSlash(relDir))
+	if !filepath.IsLocal(relDir) {
+		return nil, fmt.Errorf("folder %q is not inside the library", relDir)
+	}
+	if ignore.match(relDir) {
+		return nil, fmt.Errorf("folder %q is excluded by the ignore patterns", relDir)
+	}
 
+	dir := filepath.Join(libraryRoot, relDir)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
 
+	libraryMap := make(LibraryMap)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		relFile := filepath.Join(relDir, entry.Name())
+		if ignore.match(relFile) || !serato.IsAudioFileIn(relFile, exts) {
+			continue
+		}
+		// Stat rather than entry.Info so symlinked files are included.
+		fullPath := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(fullPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			if opts.Log != nil {
+				opts.Log(fmt.Sprintf("Skipping %s, which could not be read: %v", fullPath, err))
+			}
+			if opts.Skipped != nil {
+				opts.Skipped(fullPath, err)
+			}
+			continue
+		}
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if opts.Cache != nil {
+			opts.Cache.observe(fullPath, info)
+			if opts.Signatures {
+				opts.Cache.sign(fullPath)
+			}
+		}
+		libraryMap[relDir] = append(libraryMap[relDir], relFile)
+	}
+	SortTracks(libraryMap, libraryRoot, SortByName)
 	return libraryMap, nil
 }
 
+// countFiles counts the files under root that a scan would check.
+func countFiles(ctx context.Context, root string, opts walkOptions) (int, error) {
+	count := 0
+	err := walkFiles(ctx, root, opts, func(path string, info os.FileInfo) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
 // GetLibraryStats gets statistics from the library scan results.
 func GetLibraryStats(libraryMap LibraryMap) (int, int) {
 	numDirs := len(libraryMap)
@@ -50,41 +266,233 @@ func GetLibraryStats(libraryMap LibraryMap) (int, int) {
 	return numDirs, numFiles
 }
 
+// TrackSet returns the relative paths of every file in the library scan,
+// normalized with serato.NormalizePath.
+func TrackSet(libraryMap LibraryMap) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, files := range libraryMap {
+		for _, f := range files {
+			set[serato.NormalizePath(f)] = struct{}{}
+		}
+	}
+	return set
+}
+
 // CratePlan represents a plan to create a crate file.
 type CratePlan struct {
+	// RelDir is the library folder the crate mirrors, or empty for the
+	// parent crate of serato.CrateNaming.
+	RelDir     string
 	CratePath  string
 	TrackPaths []string
 }
 
+// WithoutFolders returns libraryMap without the tracks in folders, given
+// relative to the library root, or in any folder below them. Folder names
+// are compared ignoring case, and libraryMap itself is left as it is.
+func WithoutFolders(libraryMap LibraryMap, folders []string) LibraryMap {
+	var excluded []string
+	for _, folder := range folders {
+		folder = strings.ToLower(strings.Trim(filepath.ToSlash(strings.TrimSpace(folder)), "/"))
+		if folder != "" {
+			excluded = append(excluded, folder)
+		}
+	}
+	if len(excluded) == 0 {
+		return libraryMap
+	}
+
+	kept := make(LibraryMap, len(libraryMap))
+	for relDir, files := range libraryMap {
+		dir := strings.ToLower(filepath.ToSlash(relDir))
+		skip := false
+		for _, folder := range excluded {
+			if dir == folder || strings.HasPrefix(dir, folder+"/") {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept[relDir] = files
+		}
+	}
+	return kept
+}
+
+// RenamedFolders finds library folders that look renamed: a folder whose
+// tracks are all in newTracks, and a folder of goneTracks (database tracks
+// no longer on disk) holding exactly the same file names, ignoring case.
+// Paths are relative to the library root; the result maps each old folder
+// to its new name, both with forward slashes. Folders that match more than
+// one other folder are left out, as are tracks directly in the root.
+func RenamedFolders(libraryMap LibraryMap, newTracks, goneTracks []string) map[string]string {
+	isNew := make(map[string]struct{}, len(newTracks))
+	for _, p := range newTracks {
+		isNew[serato.NormalizePath(p)] = struct{}{}
+	}
+
+	newDirs := make(map[string][]string)
+	for relDir, files := range libraryMap {
+		dir := filepath.ToSlash(relDir)
+		if dir == "." || dir == "" || len(files) == 0 {
+			continue
+		}
+		allNew := true
+		for _, file := range files {
+			if _, ok := isNew[serato.NormalizePath(file)]; !ok {
+				allNew = false
+				break
+			}
+		}
+		if allNew {
+			key := fileNamesKey(files)
+			newDirs[key] = append(newDirs[key], dir)
+		}
+	}
+
+	goneFiles := make(map[string][]string)
+	for _, p := range goneTracks {
+		p = serato.CleanPath(p)
+		if dir := path.Dir(p); dir != "." {
+			goneFiles[dir] = append(goneFiles[dir], p)
+		}
+	}
+	oldDirs := make(map[string][]string)
+	for dir, files := range goneFiles {
+		key := fileNamesKey(files)
+		oldDirs[key] = append(oldDirs[key], dir)
+	}
+
+	renamed := make(map[string]string)
+	for key, olds := range oldDirs {
+		if news := newDirs[key]; len(olds) == 1 && len(news) == 1 {
+			renamed[olds[0]] = news[0]
+		}
+	}
+	return renamed
+}
+
+// fileNamesKey identifies a folder by the sorted, lowercased names of its
+// files.
+func fileNamesKey(files []string) string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = strings.ToLower(path.Base(serato.NormalizePath(file)))
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\n")
+}
+
 // BuildCratePlans builds crate file plans based on library structure.
-func BuildCratePlans(libraryMap LibraryMap, prefix, seratoRoot string) []CratePlan {
+// Every folder between the library root and a folder with tracks gets a
+// crate too, possibly empty, so Serato can show the whole tree: tracks in
+// "House/Deep/2024" also produce plans for "House" and "House/Deep".
+// naming decides the crate names; flat crates get no parent plans, and a
+// naming.Parent crate gets an empty plan of its own. Several folders may
+// map to the same flat crate. Plans are sorted by crate path, and tracks keep the order of libraryMap
+// (see SortTracks).
+func BuildCratePlans(libraryMap LibraryMap, prefix, seratoRoot string, naming serato.CrateNaming) []CratePlan {
 	var cratePlans []CratePlan
 
-	for relDir, files := range libraryMap {
-		if relDir == "." {
-			continue // Skip root directory
+	dirSet := make(map[string]struct{}, len(libraryMap))
+	for relDir := range libraryMap {
+		for dir := relDir; dir != "." && dir != ""; dir = filepath.Dir(dir) {
+			dirSet[dir] = struct{}{}
+			if naming.Flat {
+				// Flat crates have no parents to fill in.
+				break
+			}
 		}
+	}
+	relDirs := make([]string, 0, len(dirSet))
+	for relDir := range dirSet {
+		relDirs = append(relDirs, relDir)
+	}
+	sort.Strings(relDirs)
+
+	for _, relDir := range relDirs {
+		files := libraryMap[relDir]
 
 		var newPtrks []string
 		for _, f := range files {
 			newPtrks = append(newPtrks, serato.BuildPtrk(prefix, f))
 		}
 
-		crateFile := serato.CratePathForDir(seratoRoot, relDir)
-		cratePlans = append(cratePlans, CratePlan{CratePath: crateFile, TrackPaths: newPtrks})
+		crateFile := naming.CratePath(seratoRoot, relDir)
+		cratePlans = append(cratePlans, CratePlan{RelDir: relDir, CratePath: crateFile, TrackPaths: newPtrks})
+	}
+	if len(cratePlans) > 0 {
+		// The parent crate shows up in Serato only if its file exists.
+		for _, parent := range naming.ParentPaths(seratoRoot) {
+			cratePlans = append(cratePlans, CratePlan{CratePath: parent})
+		}
 	}
 
+	sort.SliceStable(cratePlans, func(i, j int) bool {
+		return cratePlans[i].CratePath < cratePlans[j].CratePath
+	})
 	return cratePlans
 }
 
-// DetectNewTracks detects which tracks are new (not in existing database).
-func DetectNewTracks(trackPaths []string, existingPfilSet map[string]struct{}) []string {
-	var newTracks []string
+// RootCratePlan plans a crate called name for the files directly in the
+// library folder, which BuildCratePlans leaves out since they are in no
+// subfolder. The plan's RelDir is ".". It reports false if there are no
+// such files.
+func RootCratePlan(libraryMap LibraryMap, prefix, seratoRoot, name string, naming serato.CrateNaming) (CratePlan, bool) {
+	files := libraryMap["."]
+	if len(files) == 0 {
+		return CratePlan{}, false
+	}
+	ptrks := make([]string, 0, len(files))
+	for _, f := range files {
+		ptrks = append(ptrks, serato.BuildPtrk(prefix, f))
+	}
+	return CratePlan{RelDir: ".", CratePath: naming.CratePath(seratoRoot, name), TrackPaths: ptrks}, true
+}
+
+// TrackMatch splits a library's tracks by whether the database already
+// has them. Both lists keep the form and order of the paths given.
+type TrackMatch struct {
+	New      []string
+	Existing []string
+}
+
+// MatchTracks sorts trackPaths into those the database has and those it
+// doesn't. existingPfilSet holds normalized paths relative to the library,
+// as left by serato.StripLibraryPrefix.
+func MatchTracks(trackPaths []string, existingPfilSet map[string]struct{}) TrackMatch {
+	return matchTracks(trackPaths, existingPfilSet, serato.NormalizePath)
+}
+
+// MatchTracksFold is MatchTracks ignoring case, for libraries on
+// case-insensitive file systems. existingPfilSet may hold paths in any case.
+func MatchTracksFold(trackPaths []string, existingPfilSet map[string]struct{}) TrackMatch {
+	return matchTracks(trackPaths, serato.FoldPathSet(existingPfilSet), serato.FoldPath)
+}
+
+func matchTracks(trackPaths []string, existingPfilSet map[string]struct{}, key func(string) string) TrackMatch {
+	var match TrackMatch
 	for _, p := range trackPaths {
-		cleaned := serato.CleanPath(p)
-		if _, ok := existingPfilSet[cleaned]; !ok {
-			newTracks = append(newTracks, p)
+		if _, ok := existingPfilSet[key(p)]; ok {
+			match.Existing = append(match.Existing, p)
+		} else {
+			match.New = append(match.New, p)
 		}
 	}
-	return newTracks
+	return match
+}
+
+// DetectNewTracks detects which tracks are new (not in existing database).
+// existingPfilSet holds normalized paths, as read by serato.ReadDatabaseV2;
+// the returned paths keep the form of trackPaths. See MatchTracks for the
+// tracks that aren't new as well.
+func DetectNewTracks(trackPaths []string, existingPfilSet map[string]struct{}) []string {
+	return MatchTracks(trackPaths, existingPfilSet).New
+}
+
+// DetectNewTracksFold is DetectNewTracks ignoring case, for libraries on
+// case-insensitive file systems. existingPfilSet may hold paths in any case;
+// the returned paths keep the casing of trackPaths.
+func DetectNewTracksFold(trackPaths []string, existingPfilSet map[string]struct{}) []string {
+	return MatchTracksFold(trackPaths, existingPfilSet).New
 }
@@ -0,0 +1,361 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"seratosync-go/serato"
+)
+
+// relNames are path components for the random library paths, including
+// accented names in both Unicode forms and a backslash in a file name.
+var relNames = []string{
+	"House", "Techno", "a.mp3", "Track 01.flac", "Café", "Cafe\u0301",
+	"🎵 Mix.wav", `AC\DC - Back in Black.mp3`, "x..y",
+}
+
+func TestMatchTracksNoFalsePositives(t *testing.T) {
+	rng := rand.New(rand.NewSource(60))
+	for _, prefix := range []string{"", "Music", "/Users/dj/Music/", `Users\dj\Music`} {
+		for i := 0; i < 200; i++ {
+			// A library of random tracks, about half of them in the database.
+			var tracks []string
+			inDB := make(map[string]bool)
+			pfilSet := make(map[string]struct{})
+			for j := 0; j < 1+rng.Intn(8); j++ {
+				parts := make([]string, 1+rng.Intn(3))
+				for k := range parts {
+					parts[k] = relNames[rng.Intn(len(relNames))]
+				}
+				rel := strings.Join(parts, "/")
+				tracks = append(tracks, rel)
+				if rng.Intn(2) == 0 {
+					inDB[serato.NormalizePath(rel)] = true
+					pfilSet[serato.NormalizePath(serato.BuildPtrk(prefix, rel))] = struct{}{}
+				}
+			}
+			stripped, _ := serato.StripLibraryPrefix(pfilSet, prefix)
+
+			match := MatchTracks(tracks, stripped)
+			for _, rel := range match.New {
+				if inDB[serato.NormalizePath(rel)] {
+					t.Fatalf("prefix %q: %q reported new although the database has it", prefix, rel)
+				}
+			}
+			for _, rel := range match.Existing {
+				if !inDB[serato.NormalizePath(rel)] {
+					t.Fatalf("prefix %q: %q reported existing although the database lacks it", prefix, rel)
+				}
+			}
+			if len(match.New)+len(match.Existing) != len(tracks) {
+				t.Fatalf("prefix %q: %d new + %d existing, want %d tracks", prefix, len(match.New), len(match.Existing), len(tracks))
+			}
+		}
+	}
+}
+
+// makeLibrary creates a library holding the given files, relative to its
+// root, each a few bytes long, and returns the root.
+func makeLibrary(tb testing.TB, files ...string) string {
+	tb.Helper()
+	root := tb.TempDir()
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return root
+}
+
+// largeLibrary returns the files of a library of n tracks spread over
+// folders of 50, with a cover image in each folder.
+func largeLibrary(n int) []string {
+	var files []string
+	for i := 0; i < n; i++ {
+		folder := fmt.Sprintf("Genre %d/Album %d", i/500, i/50)
+		files = append(files, fmt.Sprintf("%s/%03d Track.mp3", folder, i%50))
+		if i%50 == 0 {
+			files = append(files, folder+"/cover.jpg")
+		}
+	}
+	return files
+}
+
+func TestScanLibraryWorkersAgree(t *testing.T) {
+	root := makeLibrary(t, largeLibrary(500)...)
+	serial, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crates, tracks := GetLibraryStats(serial); tracks != 500 || crates != 10 {
+		t.Errorf("serial scan found %d tracks in %d folders, want 500 in 10", tracks, crates)
+	}
+	for _, workers := range []int{0, 4, 32} {
+		parallel, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Workers: workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parallel, serial) {
+			t.Errorf("scan with %d workers differs from the serial scan", workers)
+		}
+	}
+}
+
+func BenchmarkScanLibrary(b *testing.B) {
+	root := makeLibrary(b, largeLibrary(2000)...)
+	benchmarks := []struct {
+		name    string
+		workers int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.NumCPU() * 2},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Workers: bm.workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestScanLibraryCancel(t *testing.T) {
+	root := makeLibrary(t, largeLibrary(2000)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var checked atomic.Int64
+	opts := ScanOptions{Workers: 2, Progress: func(current, total int) {
+		if checked.Add(1) == 20 {
+			cancel()
+		}
+	}}
+
+	start := time.Now()
+	libraryMap, err := ScanLibraryWithOptions(ctx, root, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("scan error = %v, want %v", err, context.Canceled)
+	}
+	if libraryMap != nil {
+		t.Error("a cancelled scan returned a library")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled scan took %v", elapsed)
+	}
+	if n := checked.Load(); n > 500 {
+		t.Errorf("scan checked %d files after being cancelled at 20", n)
+	}
+}
+
+// planNames returns the crate file name and track count of each plan.
+func planNames(plans []CratePlan) []string {
+	names := make([]string, len(plans))
+	for i, plan := range plans {
+		names[i] = fmt.Sprintf("%s:%d", filepath.Base(plan.CratePath), len(plan.TrackPaths))
+	}
+	return names
+}
+
+func TestBuildCratePlansNested(t *testing.T) {
+	libraryMap := LibraryMap{
+		filepath.FromSlash("House/Deep/2024"): {filepath.FromSlash("House/Deep/2024/a.mp3"), filepath.FromSlash("House/Deep/2024/b.mp3")},
+		filepath.FromSlash("House"):           {filepath.FromSlash("House/c.mp3")},
+		filepath.FromSlash("Techno/Dub/Old"):  {filepath.FromSlash("Techno/Dub/Old/d.mp3")},
+	}
+	plans := BuildCratePlans(libraryMap, "Music", "_Serato_", serato.CrateNaming{})
+	want := []string{
+		"House%%Deep%%2024.crate:2",
+		"House%%Deep.crate:0",
+		"House.crate:1",
+		"Techno%%Dub%%Old.crate:1",
+		"Techno%%Dub.crate:0",
+		"Techno.crate:0",
+	}
+	if got := planNames(plans); !reflect.DeepEqual(got, want) {
+		t.Errorf("plans = %v, want %v", got, want)
+	}
+	for _, plan := range plans {
+		if got := serato.DirForCrateName(plan.CratePath); got != plan.RelDir {
+			t.Errorf("crate %s maps back to %q, want %q", filepath.Base(plan.CratePath), got, plan.RelDir)
+		}
+	}
+	if got, want := plans[0].TrackPaths, []string{"Music/House/Deep/2024/a.mp3", "Music/House/Deep/2024/b.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deepest crate holds %v, want %v", got, want)
+	}
+
+	// Under a parent crate, each level nests one deeper.
+	plans = BuildCratePlans(libraryMap, "Music", "_Serato_", serato.CrateNaming{Parent: "Auto/Synced"})
+	if got := filepath.Base(plans[0].CratePath); got != "Auto%%Synced%%House%%Deep%%2024.crate" {
+		t.Errorf("deepest crate = %s", got)
+	}
+	if got := planNames(plans)[:2]; !reflect.DeepEqual(got, []string{"Auto%%Synced%%House%%Deep%%2024.crate:2", "Auto%%Synced%%House%%Deep.crate:0"}) {
+		t.Errorf("plans = %v", got)
+	}
+}
+
+func TestScanLibraryExtensions(t *testing.T) {
+	root := makeLibrary(t, "Live/a.mp3", "Live/b.opus", "Live/c.WMA", "Live/d.Opus", "Live/notes.txt")
+	scan := func(exts map[string]struct{}) []string {
+		t.Helper()
+		lib, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Extensions: exts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lib["Live"]
+	}
+
+	if got, want := scan(nil), []string{"Live/a.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("default scan found %v, want %v", got, want)
+	}
+	// Extensions may be given in any case, with or without the dot.
+	want := []string{"Live/a.mp3", "Live/b.opus", "Live/c.WMA", "Live/d.Opus"}
+	for _, extra := range [][]string{{"opus", ".wma"}, {".OPUS", " WMA "}} {
+		if got := scan(serato.ExtensionSet(extra)); !reflect.DeepEqual(got, want) {
+			t.Errorf("scan with %q found %v, want %v", extra, got, want)
+		}
+	}
+}
+
+func TestScanLibraryIgnore(t *testing.T) {
+	root := makeLibrary(t,
+		"House/a.mp3", "House/.hidden.mp3", "House/Samples/snare.wav",
+		"Samples/kick.wav", "Samples/Loops/loop.wav", "Stems/Vocals/v.wav", "Stems/mix.mp3",
+		".Trash/old.mp3", "__MACOSX/House/._a.mp3", "Live/b.mp3", "Live/Deep/c.mp3",
+	)
+	if err := os.WriteFile(filepath.Join(root, IgnoreFileName), []byte("# Anchored at the root\nlive/deep\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ignore := append([]string{"Samples", "stems/vocals"}, DefaultIgnorePatterns...)
+	lib, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{Ignore: ignore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LibraryMap{"House": {"House/a.mp3"}, "Stems": {"Stems/mix.mp3"}, "Live": {"Live/b.mp3"}}
+	if !reflect.DeepEqual(lib, want) {
+		t.Errorf("scan found %v, want %v", lib, want)
+	}
+	if crates, tracks := GetLibraryStats(lib); crates != 3 || tracks != 3 {
+		t.Errorf("scan counted %d tracks in %d folders, want 3 in 3", tracks, crates)
+	}
+	got := planNames(BuildCratePlans(lib, "Music", "_Serato_", serato.CrateNaming{}))
+	for _, name := range got {
+		if strings.Contains(name, "Samples") || strings.Contains(name, "Vocals") || strings.Contains(name, "Deep") || strings.Contains(name, "Trash") {
+			t.Errorf("crate %s planned for an ignored folder", name)
+		}
+	}
+
+	// Without Ignore the defaults still leave out hidden files and folders.
+	lib, err = ScanLibraryWithOptions(context.Background(), root, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lib[".Trash"]; ok || len(lib["House"]) != 1 {
+		t.Errorf("default scan found %v", lib)
+	}
+	if _, ok := lib["Samples"]; !ok {
+		t.Errorf("default scan left out Samples: %v", lib)
+	}
+}
+
+func TestDetectNewTracksFold(t *testing.T) {
+	existing := map[string]struct{}{"house/song.mp3": {}, "Techno/Loud.mp3": {}}
+	tracks := []string{"House/Song.MP3", "Techno/Loud.mp3", "Techno/New.mp3"}
+
+	if got, want := DetectNewTracks(tracks, existing), []string{"House/Song.MP3", "Techno/New.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectNewTracks = %v, want %v", got, want)
+	}
+	// Ignoring case, only the new track is new, and it keeps its casing.
+	if got, want := DetectNewTracksFold(tracks, existing), []string{"Techno/New.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectNewTracksFold = %v, want %v", got, want)
+	}
+}
+
+func TestMatchTracks(t *testing.T) {
+	existing := map[string]struct{}{"house/song.mp3": {}, "Techno/Loud.mp3": {}, "Gone/Old.mp3": {}}
+	tracks := []string{"House/Song.MP3", "Techno/Loud.mp3", "Techno/New.mp3"}
+
+	want := TrackMatch{New: []string{"House/Song.MP3", "Techno/New.mp3"}, Existing: []string{"Techno/Loud.mp3"}}
+	if got := MatchTracks(tracks, existing); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchTracks = %+v, want %+v", got, want)
+	}
+	want = TrackMatch{New: []string{"Techno/New.mp3"}, Existing: []string{"House/Song.MP3", "Techno/Loud.mp3"}}
+	if got := MatchTracksFold(tracks, existing); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchTracksFold = %+v, want %+v", got, want)
+	}
+}
+
+func TestDetectNewTracksUnicodeForms(t *testing.T) {
+	nfc, nfd := "Café/Beyoncé.mp3", "Cafe\u0301/Beyonce\u0301.mp3"
+	for _, tt := range []struct{ onDisk, inDB string }{{nfd, nfc}, {nfc, nfd}} {
+		existing := map[string]struct{}{serato.NormalizePath(tt.inDB): {}}
+		if got := DetectNewTracks([]string{tt.onDisk, "Café/New.mp3"}, existing); !reflect.DeepEqual(got, []string{"Café/New.mp3"}) {
+			t.Errorf("DetectNewTracks(%+q) against %+q = %+q", tt.onDisk, tt.inDB, got)
+		}
+	}
+}
+
+func TestBuildCratePlansFlat(t *testing.T) {
+	libraryMap := LibraryMap{
+		filepath.FromSlash("House/Deep"):  {filepath.FromSlash("House/Deep/a.mp3")},
+		filepath.FromSlash("Techno/Deep"): {filepath.FromSlash("Techno/Deep/b.mp3")},
+		filepath.FromSlash("Techno"):      {filepath.FromSlash("Techno/c.mp3")},
+	}
+	plans := BuildCratePlans(libraryMap, "Music", "_Serato_", serato.CrateNaming{Parent: "Auto-Imported", Flat: true})
+	// Both Deep folders get a plan for the same crate; the writer merges
+	// them.
+	want := []string{"Auto-Imported%%Deep.crate:1", "Auto-Imported%%Deep.crate:1", "Auto-Imported%%Techno.crate:1", "Auto-Imported.crate:0"}
+	if got := planNames(plans); !reflect.DeepEqual(got, want) {
+		t.Errorf("plans = %v, want %v", got, want)
+	}
+}
+
+func TestWithoutFolders(t *testing.T) {
+	libraryMap := LibraryMap{
+		"Bootlegs":                         {"Bootlegs/a.mp3"},
+		filepath.FromSlash("Bootlegs/90s"): {filepath.FromSlash("Bootlegs/90s/b.mp3")},
+		filepath.FromSlash("House/Edits"):  {filepath.FromSlash("House/Edits/c.mp3")},
+		"House":                            {"House/d.mp3"},
+		"Bootlegs Extra":                   {"Bootlegs Extra/e.mp3"},
+	}
+	got := WithoutFolders(libraryMap, []string{" bootlegs/ ", "House/Edits", ""})
+	want := LibraryMap{"House": {"House/d.mp3"}, "Bootlegs Extra": {"Bootlegs Extra/e.mp3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WithoutFolders = %v, want %v", got, want)
+	}
+	if len(libraryMap) != 5 {
+		t.Error("WithoutFolders changed its argument")
+	}
+}
+
+func TestRenamedFolders(t *testing.T) {
+	libraryMap := LibraryMap{
+		"Deep House":                         {filepath.FromSlash("Deep House/a.mp3"), filepath.FromSlash("Deep House/B.mp3")},
+		filepath.FromSlash("Deep House/Dub"): {filepath.FromSlash("Deep House/Dub/c.mp3")},
+		"Techno":                             {filepath.FromSlash("Techno/d.mp3"), filepath.FromSlash("Techno/e.mp3")},
+		"Copy 1":                             {filepath.FromSlash("Copy 1/f.mp3")},
+		"Copy 2":                             {filepath.FromSlash("Copy 2/f.mp3")},
+	}
+	newTracks := []string{"Deep House/a.mp3", "Deep House/B.mp3", "Deep House/Dub/c.mp3", "Techno/e.mp3", "Copy 1/f.mp3", "Copy 2/f.mp3"}
+	goneTracks := []string{"House/A.mp3", "House/b.mp3", "House/Dub/c.mp3", "Old Techno/d.mp3", "Old Techno/e.mp3", "Copies/f.mp3"}
+
+	// Techno isn't all new, and two folders match Copies.
+	want := map[string]string{"House": "Deep House", "House/Dub": "Deep House/Dub"}
+	if got := RenamedFolders(libraryMap, newTracks, goneTracks); !reflect.DeepEqual(got, want) {
+		t.Errorf("RenamedFolders = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// ReadTags reads the embedded tags of an audio file and returns them keyed by
+// Serato tag name ("ttit", "tart", "talb", "tgen", "tbpm", "tkey", and where
+// the file header allows it "tlen" and "tbit", see ProbeAudio). Tags that are
+// missing from the file are left out of the map; an error is only returned if
+// the file cannot be read at all.
+func ReadTags(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tags := make(map[string]string)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		readWAVTags(file, tags)
+	default:
+		if m, err := tag.ReadFrom(file); err == nil {
+			addMetadata(tags, m)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err == nil {
+			readStreamInfo(file, tags)
+		}
+	}
+
+	if tags["tlen"] == "" {
+		if seconds, kbps, err := probeAudio(path); err == nil {
+			tags["tlen"] = formatLength(seconds)
+			tags["tbit"] = formatBitrate(kbps)
+		}
+	}
+
+	for key, value := range tags {
+		if strings.TrimSpace(value) == "" {
+			delete(tags, key)
+		}
+	}
+	return tags, nil
+}
+
+// addMetadata copies the fields Serato displays from parsed tag metadata.
+func addMetadata(tags map[string]string, m tag.Metadata) {
+	tags["ttit"] = m.Title()
+	tags["tart"] = m.Artist()
+	tags["talb"] = m.Album()
+	tags["tgen"] = m.Genre()
+
+	raw := m.Raw()
+	// BPM and key have no common accessor; their raw names differ per format.
+	for _, name := range []string{"TBPM", "TBP", "tempo", "bpm"} {
+		if v, ok := raw[name]; ok {
+			tags["tbpm"] = strings.TrimSpace(fmt.Sprint(v))
+			break
+		}
+	}
+	for _, name := range []string{"TKEY", "TKE", "initialkey", "key"} {
+		if v, ok := raw[name]; ok {
+			tags["tkey"] = strings.TrimSpace(fmt.Sprint(v))
+			break
+		}
+	}
+}
+
+// readStreamInfo fills in length and bitrate for FLAC files from the
+// STREAMINFO block, which is always the first metadata block.
+func readStreamInfo(file *os.File, tags map[string]string) {
+	header := make([]byte, 8+34)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return
+	}
+	if string(header[0:4]) != "fLaC" || header[4]&0x7F != 0 {
+		return
+	}
+	info := header[8:]
+	sampleRate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	totalSamples := uint64(info[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || totalSamples == 0 {
+		return
+	}
+	seconds := float64(totalSamples) / float64(sampleRate)
+	tags["tlen"] = formatLength(seconds)
+	if stat, err := file.Stat(); err == nil {
+		tags["tbit"] = formatBitrate(float64(stat.Size()*8) / seconds / 1000)
+	}
+}
+
+// readWAVTags walks the RIFF chunks of a WAV file, reading the LIST/INFO
+// tags, an embedded ID3 chunk if present, and the length and bitrate from
+// the fmt and data chunks.
+func readWAVTags(file *os.File, tags map[string]string) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return
+	}
+
+	var byteRate uint32
+	var dataSize uint32
+	for {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(file, chunkHeader); err != nil {
+			break
+		}
+		id := string(chunkHeader[0:4])
+		size := binary.LittleEndian.Uint32(chunkHeader[4:8])
+		start, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			break
+		}
+
+		switch id {
+		case "fmt ":
+			fmtChunk := make([]byte, 16)
+			if size >= 16 {
+				if _, err := io.ReadFull(file, fmtChunk); err == nil {
+					byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+				}
+			}
+		case "data":
+			dataSize = size
+		case "LIST":
+			list := make([]byte, size)
+			if _, err := io.ReadFull(file, list); err == nil && bytes.HasPrefix(list, []byte("INFO")) {
+				readInfoList(list[4:], tags)
+			}
+		case "id3 ", "ID3 ":
+			section := io.NewSectionReader(file, start, int64(size))
+			if m, err := tag.ReadID3v2Tags(section); err == nil {
+				addMetadata(tags, m)
+			}
+		}
+
+		// Chunks are padded to an even length.
+		next := start + int64(size) + int64(size&1)
+		if _, err := file.Seek(next, io.SeekStart); err != nil {
+			break
+		}
+	}
+
+	if byteRate > 0 && dataSize > 0 {
+		tags["tlen"] = formatLength(float64(dataSize) / float64(byteRate))
+		tags["tbit"] = formatBitrate(float64(byteRate) * 8 / 1000)
+	}
+}
+
+// readInfoList reads the sub-chunks of a RIFF LIST/INFO chunk.
+func readInfoList(buf []byte, tags map[string]string) {
+	infoTags := map[string]string{
+		"INAM": "ttit",
+		"IART": "tart",
+		"IPRD": "talb",
+		"IGNR": "tgen",
+	}
+	pos := 0
+	for pos+8 <= len(buf) {
+		id := string(buf[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(buf[pos+4 : pos+8]))
+		start := pos + 8
+		end := start + size
+		if end > len(buf) {
+			break
+		}
+		if key, ok := infoTags[id]; ok && tags[key] == "" {
+			tags[key] = strings.TrimRight(string(buf[start:end]), "\x00")
+		}
+		pos = end + size&1
+	}
+}
+
+// formatLength formats a duration in seconds the way Serato stores "tlen".
+func formatLength(seconds float64) string {
+	minutes := int(seconds) / 60
+	return fmt.Sprintf("%02d:%05.2f", minutes, seconds-float64(minutes*60))
+}
+
+// formatBitrate formats a bitrate in kbps the way Serato stores "tbit".
+func formatBitrate(kbps float64) string {
+	return fmt.Sprintf("%.1fkbps", kbps)
+}
@@ -0,0 +1,176 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFixture writes data to name in a temporary directory and returns
+// its path.
+func writeFixture(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// id3v23 returns an ID3v2.3 tag holding a text frame for each pair of
+// frame ID and text.
+func id3v23(frames ...string) []byte {
+	var body []byte
+	for i := 0; i+1 < len(frames); i += 2 {
+		text := append([]byte{0}, frames[i+1]...) // ISO-8859-1
+		body = append(body, frames[i]...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(text)))
+		body = append(body, 0, 0)
+		body = append(body, text...)
+	}
+	size := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(header, body...)
+}
+
+// flacFile returns a FLAC file of the given length at 44.1 kHz, with a
+// Vorbis comment for each "NAME=value" entry and no audio frames.
+func flacFile(seconds int, comments ...string) []byte {
+	sampleRate := 44100
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate&0x0F)<<4 | 1<<1 // two channels
+	info[13] = 15 << 4                         // 16 bits per sample
+	binary.BigEndian.PutUint32(info[14:18], uint32(seconds*sampleRate))
+
+	var vorbis []byte
+	vorbis = binary.LittleEndian.AppendUint32(vorbis, 4)
+	vorbis = append(vorbis, "test"...)
+	vorbis = binary.LittleEndian.AppendUint32(vorbis, uint32(len(comments)))
+	for _, comment := range comments {
+		vorbis = binary.LittleEndian.AppendUint32(vorbis, uint32(len(comment)))
+		vorbis = append(vorbis, comment...)
+	}
+
+	data := []byte("fLaC")
+	data = append(data, 0, 0, 0, byte(len(info)))
+	data = append(data, info...)
+	data = append(data, 0x80|4, byte(len(vorbis)>>16), byte(len(vorbis)>>8), byte(len(vorbis)))
+	return append(data, vorbis...)
+}
+
+// riffChunk returns a RIFF chunk, padded to an even length.
+func riffChunk(id string, payload []byte) []byte {
+	chunk := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// wavFile returns a 16-bit stereo 44.1 kHz PCM WAV file of silence of the
+// given length, with a LIST/INFO chunk holding each pair of ID and text.
+func wavFile(seconds int, info ...string) []byte {
+	const byteRate = 44100 * 4
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:2], 1) // PCM
+	binary.LittleEndian.PutUint16(format[2:4], 2)
+	binary.LittleEndian.PutUint32(format[4:8], 44100)
+	binary.LittleEndian.PutUint32(format[8:12], byteRate)
+	binary.LittleEndian.PutUint16(format[12:14], 4)
+	binary.LittleEndian.PutUint16(format[14:16], 16)
+
+	list := []byte("INFO")
+	for i := 0; i+1 < len(info); i += 2 {
+		list = append(list, riffChunk(info[i], append([]byte(info[i+1]), 0))...)
+	}
+
+	body := []byte("WAVE")
+	body = append(body, riffChunk("fmt ", format)...)
+	body = append(body, riffChunk("LIST", list)...)
+	body = append(body, riffChunk("data", make([]byte, seconds*byteRate))...)
+	return riffChunk("RIFF", body)
+}
+
+// mp4Atom returns an MP4 atom.
+func mp4Atom(name string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), append([]byte(name), body...)...)
+}
+
+// mp4File returns an MP4 file with no media, holding an iTunes text atom
+// for each pair of atom name and text.
+func mp4File(items ...string) []byte {
+	var ilst [][]byte
+	for i := 0; i+1 < len(items); i += 2 {
+		// Version and flags, with class 1 for UTF-8 text, then the locale.
+		data := append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, items[i+1]...)
+		ilst = append(ilst, mp4Atom(items[i], mp4Atom("data", data)))
+	}
+	meta := mp4Atom("meta", []byte{0, 0, 0, 0}, mp4Atom("ilst", ilst...))
+	return append(mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42isom")), mp4Atom("moov", mp4Atom("udta", meta))...)
+}
+
+func TestReadTags(t *testing.T) {
+	flac := flacFile(3, "TITLE=Title", "ARTIST=Artist", "ALBUM=Album", "GENRE=Disco", "BPM=120", "INITIALKEY=5A")
+	tests := []struct {
+		name string
+		data []byte
+		want map[string]string
+	}{
+		{
+			"a.mp3",
+			id3v23("TIT2", "Title", "TPE1", "Artist", "TALB", "Album", "TCON", "House", "TBPM", "124", "TKEY", "8A"),
+			map[string]string{"ttit": "Title", "tart": "Artist", "talb": "Album", "tgen": "House", "tbpm": "124", "tkey": "8A"},
+		},
+		{
+			"a.m4a",
+			mp4File("\xa9nam", "Title", "\xa9ART", "Artist", "\xa9alb", "Album", "\xa9gen", "Techno"),
+			map[string]string{"ttit": "Title", "tart": "Artist", "talb": "Album", "tgen": "Techno"},
+		},
+		{
+			"a.flac",
+			flac,
+			map[string]string{"ttit": "Title", "tart": "Artist", "talb": "Album", "tgen": "Disco", "tbpm": "120", "tkey": "5A",
+				"tlen": "00:03.00", "tbit": formatBitrate(float64(len(flac)*8) / 3 / 1000)},
+		},
+		{
+			"a.wav",
+			wavFile(2, "INAM", "Title", "IART", "Artist", "IPRD", "Album", "IGNR", "Ambient"),
+			map[string]string{"ttit": "Title", "tart": "Artist", "talb": "Album", "tgen": "Ambient", "tlen": "00:02.00", "tbit": "1411.2kbps"},
+		},
+		{
+			// Tags missing from the file are left out, not set empty.
+			"untagged.mp3",
+			id3v23("TIT2", "Only a title"),
+			map[string]string{"ttit": "Only a title"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadTags(writeFixture(t, tt.name, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadTags = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTagsMissingFile(t *testing.T) {
+	if _, err := ReadTags(filepath.Join(t.TempDir(), "missing.mp3")); err == nil {
+		t.Error("ReadTags of a missing file succeeded")
+	}
+	// A file that isn't audio has no tags, but can be read.
+	if tags, err := ReadTags(writeFixture(t, "notes.mp3", []byte("not audio"))); err != nil || len(tags) != 0 {
+		t.Errorf("ReadTags = %v, %v, want no tags", tags, err)
+	}
+}
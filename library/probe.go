@@ -0,0 +1,334 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupportedFormat is returned by ProbeAudio for files whose format it
+// can't read the length of.
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// mp3SearchLimit is how far past any ID3 tag ProbeAudio looks for the first
+// MP3 frame.
+const mp3SearchLimit = 64 << 10
+
+// ProbeAudio reads the length in seconds and bitrate in kbps of an MP3, WAV
+// or AIFF file from its headers, without decoding any audio. MP3 files are
+// measured from their Xing, Info or VBRI header when present and otherwise
+// assumed to be constant bitrate. Other formats return ErrUnsupportedFormat.
+func ProbeAudio(path string) (durationSec int, bitrate int, err error) {
+	seconds, kbps, err := probeAudio(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(math.Round(seconds)), int(math.Round(kbps)), nil
+}
+
+// probeAudio is ProbeAudio without rounding.
+func probeAudio(path string) (seconds, kbps float64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		seconds, kbps, err = probeMP3(file, stat.Size())
+	case ".wav":
+		seconds, kbps, err = probeWAV(file)
+	case ".aif", ".aiff", ".aifc":
+		seconds, kbps, err = probeAIFF(file, stat.Size())
+	default:
+		return 0, 0, ErrUnsupportedFormat
+	}
+	if err == nil && seconds <= 0 {
+		err = ErrUnsupportedFormat
+	}
+	return seconds, kbps, err
+}
+
+// mp3Frame is a parsed MPEG audio frame header.
+type mp3Frame struct {
+	version    int // 1, 2, or 25 for MPEG 2.5
+	layer      int
+	bitrate    int // kbps
+	sampleRate int
+	padding    int
+	mono       bool
+}
+
+var mp3Bitrates = map[[2]int][15]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mp3SampleRates = map[int][3]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+// parseMP3Frame parses a 4-byte frame header, reporting false if it isn't one.
+func parseMP3Frame(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	var f mp3Frame
+	switch (h[1] >> 3) & 0x03 {
+	case 0:
+		f.version = 25
+	case 2:
+		f.version = 2
+	case 3:
+		f.version = 1
+	default:
+		return mp3Frame{}, false
+	}
+	f.layer = 4 - int((h[1]>>1)&0x03)
+	if f.layer == 4 {
+		return mp3Frame{}, false
+	}
+	bitrateIndex := int(h[2] >> 4)
+	sampleIndex := int((h[2] >> 2) & 0x03)
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleIndex == 3 {
+		return mp3Frame{}, false
+	}
+	tableVersion := f.version
+	if tableVersion == 25 {
+		tableVersion = 2
+	}
+	f.bitrate = mp3Bitrates[[2]int{tableVersion, f.layer}][bitrateIndex]
+	f.sampleRate = mp3SampleRates[f.version][sampleIndex]
+	f.padding = int((h[2] >> 1) & 0x01)
+	f.mono = h[3]>>6 == 3
+	return f, true
+}
+
+// samples returns the number of samples in a frame.
+func (f mp3Frame) samples() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && f.version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// size returns the length of the frame in bytes, header included.
+func (f mp3Frame) size() int {
+	if f.layer == 1 {
+		return (12*f.bitrate*1000/f.sampleRate + f.padding) * 4
+	}
+	return f.samples()/8*f.bitrate*1000/f.sampleRate + f.padding
+}
+
+// sideInfoSize returns the length of the side information that follows the
+// header of a layer III frame, which is where a Xing header starts.
+func (f mp3Frame) sideInfoSize() int {
+	switch {
+	case f.version == 1 && !f.mono:
+		return 32
+	case f.version == 1 || !f.mono:
+		return 17
+	default:
+		return 9
+	}
+}
+
+// probeMP3 finds the first frame after any ID3v2 tag and measures the file
+// from its VBR header, or from the file size at the frame's bitrate.
+func probeMP3(file *os.File, size int64) (float64, float64, error) {
+	start := int64(0)
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, 0, err
+	}
+	if string(header[0:3]) == "ID3" {
+		tagSize := int64(header[6]&0x7F)<<21 | int64(header[7]&0x7F)<<14 | int64(header[8]&0x7F)<<7 | int64(header[9]&0x7F)
+		start = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	}
+
+	buf := make([]byte, mp3SearchLimit)
+	n, err := file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		frame, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+		// Require a second frame right after the first so stray sync
+		// bytes in leftover tag data aren't mistaken for audio.
+		next := i + frame.size()
+		if next+4 <= len(buf) {
+			if _, ok := parseMP3Frame(buf[next:]); !ok {
+				continue
+			}
+		}
+
+		audioStart := start + int64(i)
+		audioBytes := float64(size - audioStart)
+		if frames := mp3VBRFrames(buf[i:], frame); frames > 0 {
+			seconds := float64(frames) * float64(frame.samples()) / float64(frame.sampleRate)
+			return seconds, audioBytes * 8 / seconds / 1000, nil
+		}
+		seconds := audioBytes * 8 / float64(frame.bitrate*1000)
+		return seconds, float64(frame.bitrate), nil
+	}
+	return 0, 0, ErrUnsupportedFormat
+}
+
+// mp3VBRFrames returns the frame count from a Xing, Info or VBRI header in
+// the first frame, or 0 if there isn't one.
+func mp3VBRFrames(frameData []byte, frame mp3Frame) uint32 {
+	xing := 4 + frame.sideInfoSize()
+	if xing+12 <= len(frameData) {
+		id := string(frameData[xing : xing+4])
+		flags := binary.BigEndian.Uint32(frameData[xing+4 : xing+8])
+		if (id == "Xing" || id == "Info") && flags&0x1 != 0 {
+			return binary.BigEndian.Uint32(frameData[xing+8 : xing+12])
+		}
+	}
+	const vbri = 4 + 32
+	if vbri+18 <= len(frameData) && string(frameData[vbri:vbri+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(frameData[vbri+14 : vbri+18])
+	}
+	return 0
+}
+
+// probeWAV reads the fmt and data chunks of a RIFF WAVE file.
+func probeWAV(file *os.File) (float64, float64, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, 0, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, 0, ErrUnsupportedFormat
+	}
+
+	var byteRate, dataSize uint32
+	for byteRate == 0 || dataSize == 0 {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(file, chunkHeader); err != nil {
+			break
+		}
+		size := binary.LittleEndian.Uint32(chunkHeader[4:8])
+		start, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, 0, err
+		}
+		switch string(chunkHeader[0:4]) {
+		case "fmt ":
+			fmtChunk := make([]byte, 16)
+			if size >= 16 {
+				if _, err := io.ReadFull(file, fmtChunk); err == nil {
+					byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+				}
+			}
+		case "data":
+			dataSize = size
+		}
+		// Chunks are padded to an even length.
+		if _, err := file.Seek(start+int64(size)+int64(size&1), io.SeekStart); err != nil {
+			break
+		}
+	}
+	if byteRate == 0 || dataSize == 0 {
+		return 0, 0, ErrUnsupportedFormat
+	}
+	return float64(dataSize) / float64(byteRate), float64(byteRate) * 8 / 1000, nil
+}
+
+// probeAIFF reads the COMM chunk of an AIFF or AIFF-C file. AIFF-C audio may
+// be compressed, so its bitrate comes from the file size instead.
+func probeAIFF(file *os.File, size int64) (float64, float64, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, 0, err
+	}
+	form := string(header[8:12])
+	if string(header[0:4]) != "FORM" || (form != "AIFF" && form != "AIFC") {
+		return 0, 0, ErrUnsupportedFormat
+	}
+
+	for {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(file, chunkHeader); err != nil {
+			return 0, 0, ErrUnsupportedFormat
+		}
+		chunkSize := binary.BigEndian.Uint32(chunkHeader[4:8])
+		start, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !bytes.Equal(chunkHeader[0:4], []byte("COMM")) {
+			if _, err := file.Seek(start+int64(chunkSize)+int64(chunkSize&1), io.SeekStart); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+
+		comm := make([]byte, 18)
+		if chunkSize < 18 {
+			return 0, 0, ErrUnsupportedFormat
+		}
+		if _, err := io.ReadFull(file, comm); err != nil {
+			return 0, 0, err
+		}
+		channels := float64(binary.BigEndian.Uint16(comm[0:2]))
+		frames := float64(binary.BigEndian.Uint32(comm[2:6]))
+		bits := float64(binary.BigEndian.Uint16(comm[6:8]))
+		rate := extendedToFloat(comm[8:18])
+		if rate <= 0 {
+			return 0, 0, ErrUnsupportedFormat
+		}
+		seconds := frames / rate
+		if form == "AIFC" {
+			if seconds <= 0 {
+				return 0, 0, ErrUnsupportedFormat
+			}
+			return seconds, float64(size*8) / seconds / 1000, nil
+		}
+		return seconds, rate * channels * bits / 1000, nil
+	}
+}
+
+// extendedToFloat converts an 80-bit IEEE 754 extended precision number,
+// which AIFF uses for the sample rate.
+func extendedToFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	value := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if b[0]&0x80 != 0 {
+		value = -value
+	}
+	return value
+}
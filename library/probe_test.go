@@ -0,0 +1,107 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// mp3Header is the header of an MPEG-1 layer III frame at 128 kbps and
+// 44.1 kHz in stereo, 417 bytes long.
+var mp3Header = []byte{0xFF, 0xFB, 0x90, 0x00}
+
+// mp3File returns an ID3 tag followed by frames MPEG frames of silence,
+// with a Xing header in the first frame counting vbrFrames if it isn't 0.
+func mp3File(frames int, vbrFrames uint32) []byte {
+	data := id3v23("TIT2", "Title")
+	for i := 0; i < frames; i++ {
+		frame := make([]byte, 417)
+		copy(frame, mp3Header)
+		if i == 0 && vbrFrames > 0 {
+			xing := frame[4+32:]
+			copy(xing, "Xing")
+			binary.BigEndian.PutUint32(xing[4:8], 1) // frame count present
+			binary.BigEndian.PutUint32(xing[8:12], vbrFrames)
+		}
+		data = append(data, frame...)
+	}
+	return data
+}
+
+// aiffFile returns a 16-bit stereo 44.1 kHz AIFF file of silence of the
+// given length.
+func aiffFile(seconds int) []byte {
+	chunk := func(id string, payload []byte) []byte {
+		return append(append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(payload)))...), payload...)
+	}
+	comm := binary.BigEndian.AppendUint16(nil, 2)
+	comm = binary.BigEndian.AppendUint32(comm, uint32(seconds*44100))
+	comm = binary.BigEndian.AppendUint16(comm, 16)
+	comm = append(comm, 0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0) // 44100 as an 80-bit float
+	ssnd := make([]byte, 8+seconds*44100*4)
+	body := append([]byte("AIFF"), chunk("COMM", comm)...)
+	return chunk("FORM", append(body, chunk("SSND", ssnd)...))
+}
+
+func TestProbeAudio(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		wantSeconds int
+		wantKbps    int
+	}{
+		// 77 frames of 417 bytes at 128 kbps last just over 2 seconds.
+		{"cbr.mp3", mp3File(77, 0), 2, 128},
+		// The Xing header says 230 frames of 1152 samples, about 6 seconds,
+		// although only 20 are in the file.
+		{"vbr.mp3", mp3File(20, 230), 6, 11},
+		{"a.wav", wavFile(2), 2, 1411},
+		{"a.aiff", aiffFile(3), 3, 1411},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seconds, kbps, err := ProbeAudio(writeFixture(t, tt.name, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seconds != tt.wantSeconds || kbps != tt.wantKbps {
+				t.Errorf("ProbeAudio = %d s at %d kbps, want %d s at %d kbps", seconds, kbps, tt.wantSeconds, tt.wantKbps)
+			}
+		})
+	}
+}
+
+func TestProbeAudioUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"a.flac", flacFile(3)},
+		{"noise.mp3", bytes.Repeat([]byte("not audio "), 100)},
+		{"short.wav", []byte("RIFF")},
+		{"text.wav", bytes.Repeat([]byte("x"), 64)},
+		{"a.aiff", []byte("FORM\x00\x00\x00\x04AIFF")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seconds, kbps, err := ProbeAudio(writeFixture(t, tt.name, tt.data))
+			if err == nil {
+				t.Errorf("ProbeAudio = %d s at %d kbps, want an error", seconds, kbps)
+			}
+			if tt.name != "short.wav" && !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("ProbeAudio error = %v, want %v", err, ErrUnsupportedFormat)
+			}
+		})
+	}
+}
+
+func TestReadTagsProbesLength(t *testing.T) {
+	tags, err := ReadTags(writeFixture(t, "a.mp3", mp3File(77, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags["tlen"] != "00:02.01" || tags["tbit"] != "128.0kbps" {
+		t.Errorf("ReadTags = %v, want a length of 00:02.01 at 128.0kbps", tags)
+	}
+}
@@ -0,0 +1,142 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"seratosync-go/serato"
+)
+
+// CrateRule defines a smart crate holding every track in the database whose
+// metadata meets its conditions, as opposed to the folder crates built by
+// BuildCratePlans.
+type CrateRule struct {
+	// Name is the crate name. A "/" nests it under other crates, e.g.
+	// "Smart/Peak Time".
+	Name string `json:"name"`
+	// Any makes a track match when any one condition holds instead of all
+	// of them.
+	Any        bool             `json:"any"`
+	Conditions []CrateCondition `json:"conditions"`
+}
+
+// CrateCondition tests one field of a track. Field is a database tag such
+// as "tgen" or one of serato.FieldNames ("genre", "bpm", ...). Text
+// comparisons ignore case; Min and Max compare the field as a number and
+// include the bounds. Every test that is set must pass.
+type CrateCondition struct {
+	Field    string   `json:"field"`
+	Contains string   `json:"contains,omitempty"`
+	Equals   string   `json:"equals,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+}
+
+// LoadCrateRules reads smart crate rules from a JSON file holding an array
+// of CrateRule.
+func LoadCrateRules(path string) ([]CrateRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []CrateRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if strings.TrimSpace(rule.Name) == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if len(rule.Conditions) == 0 {
+			return nil, fmt.Errorf("%s: rule %q has no conditions", path, rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+// BuildSmartCrates evaluates rules against records and returns a crate plan
+// for each rule, with the matching tracks in database order, followed by
+// empty plans for the crates they are nested under. Crates are named with
+// naming's parent but never flattened, and rule names are used as written.
+func BuildSmartCrates(records []serato.Record, rules []CrateRule, seratoRoot string, naming serato.CrateNaming) []CratePlan {
+	naming.Flat = false
+	naming.Transform = serato.CrateTransform{}
+	plans := make([]CratePlan, 0, len(rules))
+	planned := make(map[string]struct{})
+	var parents []string
+	for _, rule := range rules {
+		var trackPaths []string
+		for _, record := range records {
+			pfil, ok := record["pfil"].(string)
+			if ok && rule.matches(record) {
+				trackPaths = append(trackPaths, pfil)
+			}
+		}
+		name := strings.Trim(filepath.ToSlash(strings.TrimSpace(rule.Name)), "/")
+		plans = append(plans, CratePlan{
+			CratePath:  naming.CratePath(seratoRoot, filepath.FromSlash(name)),
+			TrackPaths: trackPaths,
+		})
+		planned[plans[len(plans)-1].CratePath] = struct{}{}
+
+		enclosing := naming
+		if dir := path.Dir(name); dir != "." {
+			enclosing.Parent = path.Join(filepath.ToSlash(naming.Parent), dir)
+		}
+		parents = append(parents, enclosing.ParentPaths(seratoRoot)...)
+	}
+
+	for _, parent := range parents {
+		if _, ok := planned[parent]; !ok {
+			planned[parent] = struct{}{}
+			plans = append(plans, CratePlan{CratePath: parent})
+		}
+	}
+	return plans
+}
+
+func (r CrateRule) matches(record serato.Record) bool {
+	for _, condition := range r.Conditions {
+		ok := condition.matches(record)
+		if r.Any && ok {
+			return true
+		}
+		if !r.Any && !ok {
+			return false
+		}
+	}
+	return !r.Any
+}
+
+func (c CrateCondition) matches(record serato.Record) bool {
+	tag := serato.FieldTag(c.Field)
+	value := strings.TrimSpace(fmt.Sprint(record[tag]))
+	if _, ok := record[tag]; !ok {
+		value = ""
+	}
+
+	if c.Contains != "" && !strings.Contains(strings.ToLower(value), strings.ToLower(c.Contains)) {
+		return false
+	}
+	if c.Equals != "" && !strings.EqualFold(value, c.Equals) {
+		return false
+	}
+	if c.Min != nil || c.Max != nil {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		if c.Min != nil && number < *c.Min {
+			return false
+		}
+		if c.Max != nil && number > *c.Max {
+			return false
+		}
+	}
+	return true
+}
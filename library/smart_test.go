@@ -0,0 +1,98 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"seratosync-go/serato"
+)
+
+func smartRecords() []serato.Record {
+	return []serato.Record{
+		{"pfil": "Music/a.mp3", "tgen": "Techno", "tbpm": "128"},
+		{"pfil": "Music/b.mp3", "tgen": "Minimal techno", "tbpm": "135"},
+		{"pfil": "Music/c.mp3", "tgen": "House", "tbpm": "124"},
+		{"pfil": "Music/d.mp3", "tgen": "Disco"},
+	}
+}
+
+func bound(f float64) *float64 {
+	return &f
+}
+
+func TestBuildSmartCrates(t *testing.T) {
+	rules := []CrateRule{
+		{Name: "Peak Time", Conditions: []CrateCondition{
+			{Field: "genre", Contains: "techno"},
+			{Field: "bpm", Min: bound(120), Max: bound(130)},
+		}},
+		{Name: "Smart/Warm Up", Any: true, Conditions: []CrateCondition{
+			{Field: "tgen", Equals: "house"},
+			{Field: "tgen", Equals: "DISCO"},
+		}},
+		{Name: "Slow", Conditions: []CrateCondition{{Field: "bpm", Max: bound(100)}}},
+	}
+	plans := BuildSmartCrates(smartRecords(), rules, "_Serato_", serato.CrateNaming{Flat: true})
+
+	want := map[string][]string{
+		"Peak Time.crate":      {"Music/a.mp3"},
+		"Smart%%Warm Up.crate": {"Music/c.mp3", "Music/d.mp3"},
+		"Slow.crate":           nil, // d.mp3 has no BPM to compare
+		"Smart.crate":          nil,
+	}
+	var names []string
+	for _, plan := range plans {
+		name := filepath.Base(plan.CratePath)
+		names = append(names, name)
+		if !reflect.DeepEqual(plan.TrackPaths, want[name]) {
+			t.Errorf("%s holds %v, want %v", name, plan.TrackPaths, want[name])
+		}
+	}
+	// The enclosing crate comes after the rules, even with flat naming.
+	wantNames := []string{"Peak Time.crate", "Smart%%Warm Up.crate", "Slow.crate", "Smart.crate"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("crates = %v, want %v", names, wantNames)
+	}
+}
+
+func TestBuildSmartCratesUnderParent(t *testing.T) {
+	rules := []CrateRule{{Name: "Techno", Conditions: []CrateCondition{{Field: "genre", Contains: "techno"}}}}
+	plans := BuildSmartCrates(smartRecords(), rules, "_Serato_", serato.CrateNaming{Parent: "Auto"})
+	want := []string{"Auto%%Techno.crate:2", "Auto.crate:0"}
+	if got := planNames(plans); !reflect.DeepEqual(got, want) {
+		t.Errorf("plans = %v, want %v", got, want)
+	}
+}
+
+func TestLoadCrateRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`[{"name": "Peak Time", "conditions": [{"field": "bpm", "min": 120, "max": 130}]}]`)
+	rules, err := LoadCrateRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "Peak Time" || *rules[0].Conditions[0].Min != 120 || *rules[0].Conditions[0].Max != 130 {
+		t.Errorf("LoadCrateRules = %+v", rules)
+	}
+
+	for data, wantErr := range map[string]string{
+		`[{"name": " ", "conditions": [{"field": "bpm"}]}]`: "rule 1 has no name",
+		`[{"name": "Empty"}]`:                               `rule "Empty" has no conditions`,
+		`{"name": "Not a list"}`:                            path,
+	} {
+		write(data)
+		if _, err := LoadCrateRules(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("LoadCrateRules(%s) error = %v, want %q", data, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TrackSort selects the order of tracks within each crate.
+type TrackSort string
+
+const (
+	// SortByName orders tracks by file name in natural order, so
+	// "track2.mp3" comes before "track10.mp3".
+	SortByName TrackSort = "name"
+	// SortByDateAdded orders tracks by when the file was last modified,
+	// oldest first, falling back to SortByName for ties.
+	SortByDateAdded TrackSort = "date_added"
+)
+
+// SortTracks reorders the files of each folder in libraryMap by the given
+// key. libraryRoot is needed to look up modification times for
+// SortByDateAdded; files that can't be read sort first. Any unknown key
+// sorts by name.
+func SortTracks(libraryMap LibraryMap, libraryRoot string, by TrackSort) {
+	for _, files := range libraryMap {
+		if by != SortByDateAdded {
+			sort.SliceStable(files, func(i, j int) bool {
+				return NaturalLess(filepath.Base(files[i]), filepath.Base(files[j]))
+			})
+			continue
+		}
+
+		modTimes := make(map[string]int64, len(files))
+		for _, f := range files {
+			if info, err := os.Stat(filepath.Join(libraryRoot, f)); err == nil {
+				modTimes[f] = info.ModTime().UnixNano()
+			}
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			if modTimes[files[i]] != modTimes[files[j]] {
+				return modTimes[files[i]] < modTimes[files[j]]
+			}
+			return NaturalLess(filepath.Base(files[i]), filepath.Base(files[j]))
+		})
+	}
+}
+
+// NaturalLess compares two names case-insensitively, treating runs of
+// digits as numbers: "Track 2" < "track 10". Names that compare equal that
+// way fall back to a plain comparison so the order is total.
+func NaturalLess(a, b string) bool {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	i, j := 0, 0
+	for i < len(la) && j < len(lb) {
+		ca, cb := la[i], lb[j]
+		if isDigit(ca) && isDigit(cb) {
+			si, sj := i, j
+			for i < len(la) && isDigit(la[i]) {
+				i++
+			}
+			for j < len(lb) && isDigit(lb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(la[si:i], "0")
+			nb := strings.TrimLeft(lb[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(la)-i != len(lb)-j {
+		return len(la)-i < len(lb)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
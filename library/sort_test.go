@@ -0,0 +1,75 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"seratosync-go/serato"
+)
+
+func TestNaturalLess(t *testing.T) {
+	want := []string{
+		"01 Intro.mp3", "2 Track.mp3", "track2.mp3", "Track3.mp3", "track10.mp3", "track010b.mp3",
+		"Track100.mp3", "trackA.mp3", "Zebra.mp3",
+	}
+	got := []string{"track10.mp3", "Zebra.mp3", "track2.mp3", "trackA.mp3", "Track100.mp3", "2 Track.mp3", "Track3.mp3", "01 Intro.mp3", "track010b.mp3"}
+	sort.Slice(got, func(i, j int) bool { return NaturalLess(got[i], got[j]) })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted as %q, want %q", got, want)
+	}
+	// Names equal but for case or leading zeros still have an order.
+	for _, pair := range [][2]string{{"Track.mp3", "track.mp3"}, {"track01.mp3", "track1.mp3"}} {
+		if NaturalLess(pair[0], pair[1]) == NaturalLess(pair[1], pair[0]) {
+			t.Errorf("%q and %q have no order", pair[0], pair[1])
+		}
+	}
+}
+
+func TestSortTracks(t *testing.T) {
+	root := makeLibrary(t, "Mix/track10.mp3", "Mix/track2.mp3", "Mix/track1.mp3")
+	lib := LibraryMap{"Mix": {"Mix/track10.mp3", "Mix/track2.mp3", "Mix/track1.mp3"}}
+
+	SortTracks(lib, root, SortByName)
+	if want := []string{"Mix/track1.mp3", "Mix/track2.mp3", "Mix/track10.mp3"}; !reflect.DeepEqual(lib["Mix"], want) {
+		t.Errorf("sorted by name as %v, want %v", lib["Mix"], want)
+	}
+
+	// track10 was added first and track1 last.
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"track10.mp3", "track2.mp3", "track1.mp3"} {
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(root, "Mix", name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	SortTracks(lib, root, SortByDateAdded)
+	if want := []string{"Mix/track10.mp3", "Mix/track2.mp3", "Mix/track1.mp3"}; !reflect.DeepEqual(lib["Mix"], want) {
+		t.Errorf("sorted by date added as %v, want %v", lib["Mix"], want)
+	}
+}
+
+func TestScanSortsCrates(t *testing.T) {
+	var files []string
+	for _, name := range []string{"track10.mp3", "track9.mp3", "Track1.mp3", "track2.mp3"} {
+		files = append(files, "B/"+name, "A/"+name)
+	}
+	lib, err := ScanLibrary(makeLibrary(t, files...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A/Track1.mp3", "A/track2.mp3", "A/track9.mp3", "A/track10.mp3"}
+	if !reflect.DeepEqual(lib["A"], want) {
+		t.Errorf("scan found %v, want %v", lib["A"], want)
+	}
+	plans := BuildCratePlans(lib, "Music", "_Serato_", serato.CrateNaming{})
+	if got := planNames(plans); !reflect.DeepEqual(got, []string{"A.crate:4", "B.crate:4"}) {
+		t.Errorf("plans = %v, want A before B", got)
+	}
+	if got := plans[0].TrackPaths[3]; got != "Music/A/track10.mp3" {
+		t.Errorf("last track of crate A = %q", got)
+	}
+}
@@ -0,0 +1,136 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkOptions controls walkFiles.
+type walkOptions struct {
+	ignore         ignoreRules
+	followSymlinks bool
+	log            func(message string)
+	skipped        func(path string, err error)
+}
+
+// walkFiles calls fn for every file under root that isn't ignored, in
+// lexical order, stopping with ctx.Err() if ctx is cancelled. Paths passed
+// to fn are always under root, even for files reached through a symlinked
+// folder, and symlinked files are passed with the info of their target.
+//
+// Symlinked folders are skipped unless followSymlinks is set. When they are
+// followed, each target is walked once, and a link to a folder that contains
+// it is skipped, so a link cycle can't hang the scan.
+//
+// Files and folders that can't be read, such as a folder without
+// permission to list it, are skipped, logged and passed to skipped. Only
+// failing to read root itself stops the walk.
+func walkFiles(ctx context.Context, root string, opts walkOptions, fn func(path string, info fs.FileInfo) error) error {
+	logf := func(format string, args ...interface{}) {
+		if opts.log != nil {
+			opts.log(fmt.Sprintf(format, args...))
+		}
+	}
+	skip := func(path string, err error) {
+		logf("Skipping %s, which could not be read: %v", path, err)
+		if opts.skipped != nil {
+			opts.skipped(path, err)
+		}
+	}
+	// The root itself may be a symlink, which is always followed.
+	start := root
+	visited := make(map[string]struct{})
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		start = real
+		visited[real] = struct{}{}
+	}
+
+	// walk walks dir on disk, reporting its contents as if they were at
+	// logical, which differs from dir inside a followed symlink.
+	var walk func(dir, logical string) error
+	walk = func(dir, logical string) error {
+		return filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(logical, rel)
+			if walkErr != nil {
+				if path == root {
+					return walkErr
+				}
+				skip(path, walkErr)
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if rootRel, err := filepath.Rel(root, path); err == nil && opts.ignore.match(rootRel) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink == 0 {
+				info, err := d.Info()
+				if errors.Is(err, fs.ErrNotExist) {
+					// Deleted since the folder was listed.
+					return nil
+				} else if err != nil {
+					skip(path, err)
+					return nil
+				}
+				return fn(path, info)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				logf("Skipping broken symlink %s: %v", path, err)
+				return nil
+			}
+			if !info.IsDir() {
+				return fn(path, info)
+			}
+			if !opts.followSymlinks {
+				logf("Skipping symlinked folder %s (following symlinks is turned off).", path)
+				return nil
+			}
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				logf("Skipping symlinked folder %s: %v", path, err)
+				return nil
+			}
+			if parent, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil && isWithin(parent, target) {
+				logf("Skipping symlinked folder %s: it links back to %s, which contains it.", path, target)
+				return nil
+			}
+			if _, ok := visited[target]; ok {
+				logf("Skipping symlinked folder %s: %s was already scanned.", path, target)
+				return nil
+			}
+			visited[target] = struct{}{}
+			return walk(target, path)
+		})
+	}
+	return walk(start, root)
+}
+
+// isWithin reports whether path is parent or a folder below it.
+func isWithin(path, parent string) bool {
+	rel, err := filepath.Rel(parent, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,110 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanLibrarySymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges on Windows")
+	}
+	root := makeLibrary(t, "House/a.mp3")
+	external := makeLibrary(t, "Techno/b.mp3")
+	links := map[string]string{
+		"Linked":     external,
+		"Again":      external, // walked first, so Linked is skipped
+		"House/Loop": root,     // a cycle back to the library
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(external, filepath.Join(external, "Techno", "Back")); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []string
+	lib, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{
+		Log: func(message string) { logs = append(logs, message) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LibraryMap{"House": {"House/a.mp3"}}); !reflect.DeepEqual(lib, want) {
+		t.Errorf("scan without following symlinks found %v, want %v", lib, want)
+	}
+	if len(logs) != len(links) {
+		t.Errorf("logged %q, want a line for each of the %d symlinked folders", logs, len(links))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logs = nil
+		lib, err = ScanLibraryWithOptions(context.Background(), root, ScanOptions{
+			FollowSymlinks: true,
+			Log:            func(message string) { logs = append(logs, message) },
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("scan following symlinks did not finish")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each target is scanned once, under the first link to it in lexical
+	// order; the links back up the tree are skipped.
+	want := LibraryMap{
+		"House":        {"House/a.mp3"},
+		"Again/Techno": {"Again/Techno/b.mp3"},
+	}
+	if !reflect.DeepEqual(lib, want) {
+		t.Errorf("scan following symlinks found %v, want %v", lib, want)
+	}
+	for _, message := range logs {
+		if !strings.Contains(message, "Skipping symlinked folder") {
+			t.Errorf("unexpected log line %q", message)
+		}
+	}
+}
+
+func TestScanLibraryUnreadableFolder(t *testing.T) {
+	root := makeLibrary(t, "House/a.mp3", "Locked/b.mp3")
+	locked := filepath.Join(root, "Locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0755) })
+	if _, err := os.ReadDir(locked); err == nil {
+		t.Skip("permissions aren't enforced for this user")
+	}
+
+	var skipped []string
+	lib, err := ScanLibraryWithOptions(context.Background(), root, ScanOptions{
+		Skipped: func(path string, err error) { skipped = append(skipped, path) },
+	})
+	if err != nil {
+		t.Fatalf("scan stopped at an unreadable folder: %v", err)
+	}
+	if want := (LibraryMap{"House": {filepath.FromSlash("House/a.mp3")}}); !reflect.DeepEqual(lib, want) {
+		t.Errorf("library = %v, want %v", lib, want)
+	}
+	if want := []string{locked}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+
+	// Only an unreadable library root stops the scan.
+	if _, err := ScanLibrary(filepath.Join(root, "Missing")); err == nil {
+		t.Error("scanning a missing library succeeded")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+
+	"seratosync-go/syncer"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// LogLevel is the severity of a LogEntry.
+type LogLevel string
+
+const (
+	LogInfo  LogLevel = "info"
+	LogWarn  LogLevel = "warn"
+	LogError LogLevel = "error"
+)
+
+// LogEntry is emitted on the "log_entry" event for every log message, so
+// the frontend can filter and style messages by level. The plain message is
+// still emitted on the "log" event as well.
+type LogEntry struct {
+	Level   LogLevel  `json:"level"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// eventsEmit sends an event to the frontend. It is a variable so tests can
+// call the App's methods without a window to send to.
+var eventsEmit = runtime.EventsEmit
+
+// emitLog sends a message on both log events.
+func (a *App) emitLog(level LogLevel, message string) {
+	eventsEmit(a.ctx, "log", message)
+	eventsEmit(a.ctx, "log_entry", LogEntry{Level: level, Message: message, Time: time.Now()})
+}
+
+func (a *App) logInfo(message string) {
+	a.emitLog(LogInfo, message)
+}
+
+func (a *App) logWarn(message string) {
+	a.emitLog(LogWarn, message)
+}
+
+func (a *App) logError(message string) {
+	a.emitLog(LogError, message)
+}
+
+// logSync logs a message from the syncer at the level it gave, whose
+// names match LogLevel's.
+func (a *App) logSync(level syncer.Level, message string) {
+	a.emitLog(LogLevel(level), message)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// progressInterval limits how often progress events are sent to the frontend.
+const progressInterval = 100 * time.Millisecond
+
+// Progress is emitted on the "progress" event while a sync runs.
+type Progress struct {
+	Phase      string  `json:"phase"`
+	Current    int     `json:"current"`
+	Total      int     `json:"total"`
+	Percent    float64 `json:"percent"`
+	ETASeconds float64 `json:"eta_seconds"`
+}
+
+// progressReporter emits throttled progress events for one phase. Report
+// is safe to call from several goroutines.
+type progressReporter struct {
+	app      *App
+	phase    string
+	started  time.Time
+	mu       sync.Mutex
+	lastEmit time.Time
+}
+
+func (a *App) newProgress(phase string) *progressReporter {
+	return &progressReporter{app: a, phase: phase, started: time.Now()}
+}
+
+// Report records that current of total items are done. The first and last
+// updates are always sent; the ones in between are throttled.
+func (p *progressReporter) Report(current, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if current != 0 && current < total && now.Sub(p.lastEmit) < progressInterval {
+		return
+	}
+	p.lastEmit = now
+
+	progress := Progress{Phase: p.phase, Current: current, Total: total}
+	if total > 0 {
+		progress.Percent = float64(current) * 100 / float64(total)
+	}
+	if current > 0 && current < total {
+		elapsed := now.Sub(p.started).Seconds()
+		progress.ETASeconds = elapsed / float64(current) * float64(total-current)
+	}
+	eventsEmit(p.app.ctx, "progress", progress)
+}
@@ -0,0 +1,104 @@
+package serato
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// renameAttempts is how many times the final rename is tried. On Windows the
+// rename fails while another process (usually Serato) has the target open.
+const renameAttempts = 5
+
+// writeFileAtomic writes a file by streaming into a temporary sibling and
+// renaming it over path once everything is flushed to disk. If write or
+// any later step fails the original file is left untouched. It works
+// through Disk, and a write failing with a transient error is started over
+// (see withRetry), so write may be called more than once.
+func (f Files) writeFileAtomic(path string, write func(w io.Writer) error) error {
+	return f.withRetry(func() error {
+		return f.writeFileAtomicOnce(path, time.Time{}, write)
+	})
+}
+
+// rewriteFileAtomic is writeFileAtomic for rewriting a database or crate:
+// if f.PreserveModTimes is set and path exists, the new file gets its
+// modification time.
+func (f Files) rewriteFileAtomic(path string, write func(w io.Writer) error) error {
+	var modTime time.Time
+	if f.PreserveModTimes {
+		if info, err := Disk.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+	return f.withRetry(func() error {
+		return f.writeFileAtomicOnce(path, modTime, write)
+	})
+}
+
+// newFileMode is the mode of a file written where none existed, as
+// os.Create would give it under the usual umask.
+const newFileMode fs.FileMode = 0644
+
+// writeFileAtomicOnce is a single attempt of writeFileAtomic. A non-zero
+// modTime is set on the new file before it replaces path. The new file
+// keeps the permissions of the one it replaces, or gets newFileMode, since
+// CreateTemp makes it readable by its owner only.
+func (f Files) writeFileAtomicOnce(path string, modTime time.Time, write func(w io.Writer) error) error {
+	mode := newFileMode
+	if info, err := Disk.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	err := Disk.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := Disk.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Clean up the temp file on any failure below.
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			Disk.Remove(tmpPath)
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = Disk.Chmod(tmpPath, mode); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err = Disk.Chtimes(tmpPath, time.Time{}, modTime); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = Disk.Rename(tmpPath, path)
+		if err == nil || attempt == renameAttempts {
+			break
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
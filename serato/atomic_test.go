@@ -0,0 +1,182 @@
+package serato
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// setModTime gives path an old modification time and returns it.
+func setModTime(t *testing.T, path string) time.Time {
+	t.Helper()
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return modTime
+}
+
+func modTimeOf(t *testing.T, path string) time.Time {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ModTime()
+}
+
+func TestRewritePreservesModTime(t *testing.T) {
+	dir := t.TempDir()
+	crateFile := filepath.Join(dir, "Subcrates", "House.crate")
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+
+	for _, files := range []Files{{PreserveModTimes: true}, {}} {
+		if _, err := files.WriteCrateFile(crateFile, []string{"Music/a.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+		crateTime := setModTime(t, crateFile)
+		dbTime := setModTime(t, dbPath)
+
+		if _, err := files.WriteCrateFile(crateFile, []string{"Music/a.mp3", "Music/b.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := files.WriteDatabaseV2Records(dbPath, testRecords("Music/a.mp3", "Music/b.mp3")); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := modTimeOf(t, crateFile); got.Equal(crateTime) != files.PreserveModTimes {
+			t.Errorf("PreserveModTimes %v: crate modification time = %v, was %v", files.PreserveModTimes, got, crateTime)
+		}
+		if got := modTimeOf(t, dbPath); got.Equal(dbTime) != files.PreserveModTimes {
+			t.Errorf("PreserveModTimes %v: database modification time = %v, was %v", files.PreserveModTimes, got, dbTime)
+		}
+	}
+}
+
+func TestNewFileGetsCurrentModTime(t *testing.T) {
+	crateFile := filepath.Join(t.TempDir(), "Subcrates", "House.crate")
+	before := time.Now().Add(-time.Minute)
+	if _, err := (Files{PreserveModTimes: true}).WriteCrateFile(crateFile, []string{"Music/a.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := modTimeOf(t, crateFile); got.Before(before) {
+		t.Errorf("new crate modification time = %v, want the current time", got)
+	}
+}
+
+func TestRewriteKeepsFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no permission bits")
+	}
+	dir := t.TempDir()
+	crateFile := filepath.Join(dir, "Subcrates", "House.crate")
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+
+	// A new file is readable by everyone, as os.Create would make it.
+	if _, err := WriteCrateFile(crateFile, []string{"Music/a.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := modeOf(t, crateFile); got != 0644 {
+		t.Errorf("new crate mode = %v, want %v", got, fs.FileMode(0644))
+	}
+
+	for path, mode := range map[string]fs.FileMode{crateFile: 0644, dbPath: 0664} {
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := WriteCrateFile(crateFile, []string{"Music/a.mp3", "Music/b.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDatabaseV2Records(dbPath, testRecords("Music/a.mp3", "Music/b.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if got := modeOf(t, crateFile); got != 0644 {
+		t.Errorf("rewritten crate mode = %v, want %v", got, fs.FileMode(0644))
+	}
+	if got := modeOf(t, dbPath); got != 0664 {
+		t.Errorf("rewritten database mode = %v, want %v", got, fs.FileMode(0664))
+	}
+}
+
+func modeOf(t *testing.T, path string) fs.FileMode {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+// brokenDisk is the real file system, except that writes to files made
+// with CreateTemp fail half way, and so do renames if failRename is set.
+type brokenDisk struct {
+	FileSystem
+	failRename bool
+}
+
+var errBrokenDisk = errors.New("simulated write failure")
+
+func (d brokenDisk) CreateTemp(dir, pattern string) (File, error) {
+	file, err := d.FileSystem.CreateTemp(dir, pattern)
+	if err != nil || d.failRename {
+		return file, err
+	}
+	return brokenFile{file}, nil
+}
+
+func (d brokenDisk) Rename(oldpath, newpath string) error {
+	if d.failRename {
+		return errBrokenDisk
+	}
+	return d.FileSystem.Rename(oldpath, newpath)
+}
+
+type brokenFile struct {
+	File
+}
+
+func (f brokenFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errBrokenDisk
+}
+
+func TestFailedWriteLeavesOriginal(t *testing.T) {
+	for _, failRename := range []bool{false, true} {
+		dir := t.TempDir()
+		crateFile := filepath.Join(dir, "Subcrates", "House.crate")
+		if _, err := WriteCrateFile(crateFile, []string{"Music/a.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+		dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+		crateBefore, _ := os.ReadFile(crateFile)
+		dbBefore, _ := os.ReadFile(dbPath)
+
+		old := Disk
+		Disk = brokenDisk{FileSystem: old, failRename: failRename}
+		_, crateErr := WriteCrateFile(crateFile, []string{"Music/a.mp3", "Music/b.mp3"})
+		dbErr := WriteDatabaseV2Records(dbPath, testRecords("Music/a.mp3", "Music/b.mp3"))
+		Disk = old
+
+		if !errors.Is(crateErr, errBrokenDisk) || !errors.Is(dbErr, errBrokenDisk) {
+			t.Fatalf("failRename %v: errors = %v, %v, want %v", failRename, crateErr, dbErr, errBrokenDisk)
+		}
+		if after, _ := os.ReadFile(crateFile); !bytes.Equal(after, crateBefore) {
+			t.Errorf("failRename %v: crate changed by a failed write", failRename)
+		}
+		if after, _ := os.ReadFile(dbPath); !bytes.Equal(after, dbBefore) {
+			t.Errorf("failRename %v: database changed by a failed write", failRename)
+		}
+		for _, d := range []string{dir, filepath.Dir(crateFile)} {
+			leftovers, _ := filepath.Glob(filepath.Join(d, ".*.tmp-*"))
+			if len(leftovers) != 0 {
+				t.Errorf("failRename %v: temporary files left behind: %v", failRename, leftovers)
+			}
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package serato
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrChecksumMismatch is returned when a backup's contents don't match the
+// SHA-256 checksum taken of the database it was copied from.
+var ErrChecksumMismatch = errors.New("backup checksum mismatch")
+
+// BackupDatabase creates a backup of the database file. The copy is read
+// back and compared against a SHA-256 checksum of the source before the
+// backup is reported as made; a copy that doesn't match is deleted. The
+// checksum is also written to a "<backup>.sha256" sidecar, in the format of
+// sha256sum, for VerifyBackup. A copy failing with a transient error is
+// started over (see withRetry).
+func (f Files) BackupDatabase(dbPath string) (string, error) {
+	return f.BackupDatabaseTo(dbPath, "")
+}
+
+// BackupDatabase is Files.BackupDatabase with the default options.
+func BackupDatabase(dbPath string) (string, error) {
+	return Files{}.BackupDatabase(dbPath)
+}
+
+// BackupDatabaseTo is BackupDatabase writing the backup into backupDir,
+// which is created if needed, instead of next to the database. An empty
+// backupDir means next to the database.
+func (f Files) BackupDatabaseTo(dbPath, backupDir string) (string, error) {
+	backupDir = backupDirFor(dbPath, backupDir)
+	if err := Disk.MkdirAll(backupDir, 0755); err != nil {
+		return "", err
+	}
+	timestamp := time.Now().Unix()
+	backupPath := filepath.Join(backupDir, fmt.Sprintf("%s.backup.%d", filepath.Base(dbPath), timestamp))
+
+	var sum string
+	err := f.withRetry(func() error {
+		var err error
+		sum, err = copyWithSHA256(dbPath, backupPath)
+		if err != nil {
+			Disk.Remove(backupPath)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = f.withRetry(func() error {
+		sidecar, err := Disk.Create(backupPath + ".sha256")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(sidecar, "%s  %s\n", sum, filepath.Base(backupPath))
+		if cerr := sidecar.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// BackupDatabaseTo is Files.BackupDatabaseTo with the default options.
+func BackupDatabaseTo(dbPath, backupDir string) (string, error) {
+	return Files{}.BackupDatabaseTo(dbPath, backupDir)
+}
+
+// copyWithSHA256 copies src to dst, syncs the copy and checks it against
+// the SHA-256 checksum of what was read, which it returns in hex.
+func copyWithSHA256(src, dst string) (string, error) {
+	source, err := Disk.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	destination, err := Disk.Create(dst)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(destination, io.TeeReader(source, hash))
+	if err == nil {
+		err = destination.Sync()
+	}
+	if cerr := destination.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	return sum, checkSHA256(dst, sum)
+}
+
+// VerifyBackup checks a backup against the checksum in its ".sha256"
+// sidecar, returning an error wrapping ErrChecksumMismatch if they differ.
+// Backups made before sidecars were written have none and pass unchecked.
+func VerifyBackup(backupPath string) error {
+	sidecar, err := Disk.Open(backupPath + ".sha256")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(sidecar)
+	sidecar.Close()
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("%s.sha256 holds no checksum", backupPath)
+	}
+	return checkSHA256(backupPath, fields[0])
+}
+
+// checkSHA256 compares the SHA-256 checksum of the file at path with want,
+// given in hex.
+func checkSHA256(path, want string) error {
+	file, err := Disk.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("checksum is %s, expected %s: %w", got, want, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// BackupSubcrates copies the crate files in seratoDir's Subcrates folder to
+// a new "Subcrates.backup.<unix seconds>" folder next to it, returning its
+// path.
+func (f Files) BackupSubcrates(seratoDir string) (string, error) {
+	subcratesDir := filepath.Join(seratoDir, "Subcrates")
+	backupDir := fmt.Sprintf("%s.backup.%d", subcratesDir, time.Now().Unix())
+
+	crateFiles, err := ListCrateFiles(seratoDir)
+	if err != nil {
+		return "", err
+	}
+	if err := Disk.MkdirAll(backupDir, 0755); err != nil {
+		return "", err
+	}
+	for _, crateFile := range crateFiles {
+		if err := f.copyFile(crateFile, filepath.Join(backupDir, filepath.Base(crateFile))); err != nil {
+			return "", err
+		}
+	}
+	return backupDir, nil
+}
+
+// BackupSubcrates is Files.BackupSubcrates with the default options.
+func BackupSubcrates(seratoDir string) (string, error) {
+	return Files{}.BackupSubcrates(seratoDir)
+}
+
+// copyFile copies src to dst, starting over on a transient error.
+func (f Files) copyFile(src, dst string) error {
+	return f.withRetry(func() error {
+		return copyFileOnce(src, dst)
+	})
+}
+
+func copyFileOnce(src, dst string) error {
+	source, err := Disk.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := Disk.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
+
+// BackupFile is a database backup found by ListBackups.
+type BackupFile struct {
+	Path      string
+	Timestamp int64
+}
+
+// backupDirFor returns the folder the backups of dbPath are kept in:
+// backupDir, or the database's own folder if that is empty.
+func backupDirFor(dbPath, backupDir string) string {
+	if backupDir == "" {
+		return filepath.Dir(dbPath)
+	}
+	return backupDir
+}
+
+// ListBackups returns the backups of dbPath, newest first. Only files named
+// exactly "<database>.backup.<unix seconds>" are considered backups.
+func ListBackups(dbPath string) ([]BackupFile, error) {
+	return ListBackupsIn(dbPath, "")
+}
+
+// ListBackupsIn is ListBackups for backups kept in backupDir (see
+// BackupDatabaseTo). A backupDir that doesn't exist yet holds no backups.
+func ListBackupsIn(dbPath, backupDir string) ([]BackupFile, error) {
+	dir := backupDirFor(dbPath, backupDir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) && backupDir != "" {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(dbPath) + ".backup."
+	var backups []BackupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		digits := strings.TrimPrefix(name, prefix)
+		if digits == "" || strings.Trim(digits, "0123456789") != "" {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupFile{
+			Path:      filepath.Join(dir, name),
+			Timestamp: timestamp,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Timestamp > backups[j].Timestamp
+	})
+	return backups, nil
+}
+
+// PruneBackups deletes all but the keep most recent backups of dbPath, along
+// with their checksum sidecars. A keep of zero or less leaves every backup
+// in place.
+func PruneBackups(dbPath string, keep int) error {
+	return PruneBackupsIn(dbPath, "", keep)
+}
+
+// PruneBackupsIn is PruneBackups for backups kept in backupDir (see
+// BackupDatabaseTo).
+func PruneBackupsIn(dbPath, backupDir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := ListBackupsIn(dbPath, backupDir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := Disk.Remove(backups[i].Path); err != nil {
+			return err
+		}
+		if err := Disk.Remove(backups[i].Path + ".sha256"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreDatabase replaces the database at dbPath with the contents of
+// backupPath. The backup must match its checksum sidecar, if it has one
+// (see VerifyBackup), and parse as a Serato database, and the copy is
+// written atomically so a failed restore leaves the live database as it was.
+func (f Files) RestoreDatabase(dbPath, backupPath string) error {
+	if err := VerifyBackup(backupPath); err != nil {
+		return fmt.Errorf("%s is not a usable database backup: %w", backupPath, err)
+	}
+	if _, err := f.InspectDatabase(backupPath); err != nil {
+		return fmt.Errorf("%s is not a usable database backup: %w", backupPath, err)
+	}
+
+	return f.writeFileAtomic(dbPath, func(w io.Writer) error {
+		source, err := Disk.Open(backupPath)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		_, err = io.Copy(w, source)
+		return err
+	})
+}
+
+// RestoreDatabase is Files.RestoreDatabase with the default options.
+func RestoreDatabase(dbPath, backupPath string) error {
+	return Files{}.RestoreDatabase(dbPath, backupPath)
+}
@@ -0,0 +1,282 @@
+package serato
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// corruptingDisk is the real file system, except that every byte written
+// to a file created with Create is flipped.
+type corruptingDisk struct {
+	FileSystem
+}
+
+func (d corruptingDisk) Create(name string) (File, error) {
+	file, err := d.FileSystem.Create(name)
+	if err != nil || strings.HasSuffix(name, ".sha256") {
+		return file, err
+	}
+	return corruptingFile{file}, nil
+}
+
+type corruptingFile struct {
+	File
+}
+
+func (f corruptingFile) Write(p []byte) (int, error) {
+	flipped := make([]byte, len(p))
+	for i, b := range p {
+		flipped[i] = ^b
+	}
+	return f.File.Write(flipped)
+}
+
+func TestBackupDatabaseRejectsCorruptCopy(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	backupDir := filepath.Join(dir, "backups")
+	useDisk(t, corruptingDisk{Disk})
+
+	_, err := BackupDatabaseTo(dbPath, backupDir)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("BackupDatabaseTo error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if entries, _ := os.ReadDir(backupDir); len(entries) != 0 {
+		t.Errorf("corrupt backup left behind: %v", entries)
+	}
+}
+
+// halfWritingDisk is the real file system, except that writes to files
+// made with Create fail half way, as with brokenDisk.
+type halfWritingDisk struct {
+	FileSystem
+}
+
+func (d halfWritingDisk) Create(name string) (File, error) {
+	file, err := d.FileSystem.Create(name)
+	if err != nil {
+		return file, err
+	}
+	return brokenFile{file}, nil
+}
+
+func TestFailedBackupLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupDir := filepath.Join(dir, "backups")
+	useDisk(t, halfWritingDisk{Disk})
+
+	if _, err := BackupDatabaseTo(dbPath, backupDir); !errors.Is(err, errBrokenDisk) {
+		t.Fatalf("BackupDatabaseTo error = %v, want %v", err, errBrokenDisk)
+	}
+	if entries, _ := os.ReadDir(backupDir); len(entries) != 0 {
+		t.Errorf("partial backup left behind: %v", entries)
+	}
+	if after, _ := os.ReadFile(dbPath); string(after) != string(before) {
+		t.Error("database changed by a failed backup")
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	backupPath, err := BackupDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyBackup(backupPath); err != nil {
+		t.Fatalf("fresh backup: %v", err)
+	}
+
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xFF
+	if err := os.WriteFile(backupPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyBackup(backupPath); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("tampered backup: error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	base := filepath.Base(dbPath)
+	for i := 0; i < 15; i++ {
+		backup := filepath.Join(dir, fmt.Sprintf("%s.backup.%d", base, 1700000000+i))
+		if err := os.WriteFile(backup, []byte("backup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(backup+".sha256", []byte("sum"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Files that only look like backups are never touched.
+	unrelated := []string{
+		base + ".backup.",
+		base + ".backup.12x",
+		base + ".backup.1600000000.bak",
+		"other V2.backup.1600000000",
+		"notes.txt",
+	}
+	for _, name := range unrelated {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := PruneBackups(dbPath, 10); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 10 {
+		t.Fatalf("%d backups left, want 10", len(backups))
+	}
+	for i, backup := range backups {
+		if want := int64(1700000014 - i); backup.Timestamp != want {
+			t.Errorf("backup %d is from %d, want %d", i, backup.Timestamp, want)
+		}
+	}
+	sidecars, _ := filepath.Glob(filepath.Join(dir, "*.sha256"))
+	if len(sidecars) != 10 {
+		t.Errorf("%d checksum sidecars left, want 10", len(sidecars))
+	}
+	for _, name := range append(unrelated, base) {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// A keep of zero or less prunes nothing.
+	if err := PruneBackups(dbPath, 0); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := ListBackups(dbPath); len(backups) != 10 {
+		t.Errorf("keep 0 left %d backups, want 10", len(backups))
+	}
+}
+
+func TestRestoreDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3", "Music/b.mp3", "Music/c.mp3")
+	backupPath, err := BackupDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDatabaseV2Records(dbPath, testRecords("Music/a.mp3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreDatabase(dbPath, backupPath); err != nil {
+		t.Fatal(err)
+	}
+	info, err := InspectDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TrackCount != 3 {
+		t.Errorf("restored database holds %d tracks, want 3", info.TrackCount)
+	}
+	if got := pfilsOf(readRecords(t, dbPath)); !reflect.DeepEqual(got, []string{"Music/a.mp3", "Music/b.mp3", "Music/c.mp3"}) {
+		t.Errorf("restored database holds %v", got)
+	}
+}
+
+func TestRestoreDatabaseRefusesBadBackup(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	before, _ := os.ReadFile(dbPath)
+
+	corrupt, err := BackupDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(corrupt, append(before, 'x'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	notDatabase := filepath.Join(dir, "crate.backup.1")
+	if err := os.WriteFile(notDatabase, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, backup := range []string{corrupt, notDatabase, filepath.Join(dir, "missing")} {
+		if err := RestoreDatabase(dbPath, backup); err == nil {
+			t.Errorf("restore from %s succeeded", filepath.Base(backup))
+		}
+	}
+	if after, _ := os.ReadFile(dbPath); string(after) != string(before) {
+		t.Error("database changed by a refused restore")
+	}
+}
+
+func TestBackupsInCustomDir(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	backupDir := filepath.Join(t.TempDir(), "Serato backups")
+
+	// A folder that doesn't exist yet holds no backups.
+	if backups, err := ListBackupsIn(dbPath, backupDir); err != nil || len(backups) != 0 {
+		t.Errorf("ListBackupsIn before the first backup = %v, %v", backups, err)
+	}
+	backupPath, err := BackupDatabaseTo(dbPath, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(backupPath) != backupDir || !strings.HasPrefix(filepath.Base(backupPath), DatabaseFile+".backup.") {
+		t.Errorf("backup written to %s, want a timestamped file in %s", backupPath, backupDir)
+	}
+	if nextTo, _ := ListBackups(dbPath); len(nextTo) != 0 {
+		t.Errorf("backups next to the database: %v", nextTo)
+	}
+
+	base := filepath.Base(dbPath)
+	for i := 0; i < 5; i++ {
+		old := filepath.Join(backupDir, fmt.Sprintf("%s.backup.%d", base, 1600000000+i))
+		if err := os.WriteFile(old, []byte("backup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := PruneBackupsIn(dbPath, backupDir, 2); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := ListBackupsIn(dbPath, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, backup := range backups {
+		kept = append(kept, backup.Path)
+	}
+	sort.Strings(kept)
+	want := []string{backupPath, filepath.Join(backupDir, fmt.Sprintf("%s.backup.%d", base, 1600000004))}
+	sort.Strings(want)
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+
+	if err := WriteDatabaseV2Records(dbPath, testRecords("Music/b.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreDatabase(dbPath, backupPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := pfilsOf(readRecords(t, dbPath)); !reflect.DeepEqual(got, []string{"Music/a.mp3"}) {
+		t.Errorf("restored database holds %v", got)
+	}
+}
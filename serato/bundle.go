@@ -0,0 +1,285 @@
+package serato
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bundleManifestName is the name of the manifest inside a bundle.
+const bundleManifestName = "manifest.json"
+
+// BundleManifest describes the contents of a bundle made by ExportBundle.
+// Entry names inside the zip always use "/", whatever the platform.
+type BundleManifest struct {
+	Created time.Time `json:"created"`
+	// Database is the entry name of the database file, its file name in
+	// the Serato folder it was exported from.
+	Database        string `json:"database"`
+	DatabaseVersion string `json:"database_version"`
+	TrackCount      int    `json:"track_count"`
+	// Crates lists the entry names of the crate files, "Subcrates/<file>".
+	Crates []string `json:"crates"`
+}
+
+// ExportBundle packages the database at dbPath and the crate files of its
+// Serato folder into a zip at outZip, along with a manifest.json describing
+// them. Every file is read with the package's readers first, and nothing
+// is written if any of them fails to parse. Track paths are stored as
+// Serato keeps them, relative to their drive, so the bundle can be
+// installed on another machine (see OpenBundle).
+func (f Files) ExportBundle(dbPath, outZip string) (BundleManifest, error) {
+	info, err := f.InspectDatabase(dbPath)
+	if err != nil {
+		return BundleManifest{}, fmt.Errorf("%s is not a readable database: %w", dbPath, err)
+	}
+	crateFiles, err := ListCrateFiles(filepath.Dir(dbPath))
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	manifest := BundleManifest{
+		Created:         time.Now(),
+		Database:        filepath.Base(dbPath),
+		DatabaseVersion: info.Version,
+		TrackCount:      info.TrackCount,
+		Crates:          []string{},
+	}
+	for _, crateFile := range crateFiles {
+		if _, err := f.ReadCrateFile(crateFile); err != nil {
+			return BundleManifest{}, fmt.Errorf("%s is not a readable crate: %w", crateFile, err)
+		}
+		manifest.Crates = append(manifest.Crates, path.Join("Subcrates", filepath.Base(crateFile)))
+	}
+
+	err = f.writeFileAtomic(outZip, func(w io.Writer) error {
+		archive := zip.NewWriter(w)
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: bundleManifestName, Method: zip.Deflate, Modified: manifest.Created})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(manifest); err != nil {
+			return err
+		}
+		if err := f.addZipFile(archive, manifest.Database, dbPath); err != nil {
+			return err
+		}
+		for i, crateFile := range crateFiles {
+			if err := f.addZipFile(archive, manifest.Crates[i], crateFile); err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	})
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	return manifest, nil
+}
+
+// ExportBundle is Files.ExportBundle with the default options.
+func ExportBundle(dbPath, outZip string) (BundleManifest, error) {
+	return Files{}.ExportBundle(dbPath, outZip)
+}
+
+// addZipFile copies the file at src into archive as name, keeping its
+// modification time.
+func (f Files) addZipFile(archive *zip.Writer, name, src string) error {
+	source, err := f.openRetry(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, source)
+	return err
+}
+
+// Bundle is a bundle opened by OpenBundle, unpacked into a temporary
+// folder and checked, ready to be installed.
+type Bundle struct {
+	Manifest BundleManifest
+	dir      string
+	files    Files
+}
+
+// OpenBundle unpacks the bundle at zipPath, made by ExportBundle, into a
+// temporary folder and checks every file in it with the package's readers.
+// Entries that aren't listed in the manifest, or whose names would land
+// outside the bundle, are rejected. Close removes the temporary folder.
+func (f Files) OpenBundle(zipPath string) (*Bundle, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var manifest BundleManifest
+	entries := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		entries[file.Name] = file
+	}
+	manifestEntry, ok := entries[bundleManifestName]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s; it is not a Serato bundle", zipPath, bundleManifestName)
+	}
+	if err := readZipJSON(manifestEntry, &manifest); err != nil {
+		return nil, fmt.Errorf("reading the manifest of %s: %w", zipPath, err)
+	}
+
+	if !isBundleName(manifest.Database) || strings.Contains(manifest.Database, "/") {
+		return nil, fmt.Errorf("bad database name %q in the manifest of %s", manifest.Database, zipPath)
+	}
+	listed := map[string]bool{bundleManifestName: true, manifest.Database: true}
+	for _, name := range manifest.Crates {
+		dir, file := path.Split(name)
+		if dir != "Subcrates/" || !isBundleName(file) || !strings.HasSuffix(file, ".crate") {
+			return nil, fmt.Errorf("bad crate name %q in the manifest of %s", name, zipPath)
+		}
+		listed[name] = true
+	}
+	for name := range entries {
+		if !listed[name] {
+			return nil, fmt.Errorf("%s holds %q, which its manifest doesn't list", zipPath, name)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "serato-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{Manifest: manifest, dir: dir, files: f}
+	if err := bundle.unpack(entries); err != nil {
+		bundle.Close()
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// OpenBundle is Files.OpenBundle with the default options.
+func OpenBundle(zipPath string) (*Bundle, error) {
+	return Files{}.OpenBundle(zipPath)
+}
+
+// unpack writes the listed entries into the bundle's folder and checks
+// that they parse.
+func (b *Bundle) unpack(entries map[string]*zip.File) error {
+	names := append([]string{b.Manifest.Database}, b.Manifest.Crates...)
+	for _, name := range names {
+		entry, ok := entries[name]
+		if !ok {
+			return fmt.Errorf("the bundle is missing %s", name)
+		}
+		if err := extractZipFile(entry, b.path(name)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := b.files.InspectDatabase(b.path(b.Manifest.Database)); err != nil {
+		return fmt.Errorf("the bundled database is not readable: %w", err)
+	}
+	for _, name := range b.Manifest.Crates {
+		if _, err := b.files.ReadCrateFile(b.path(name)); err != nil {
+			return fmt.Errorf("the bundled crate %s is not readable: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// path returns where the entry name is unpacked.
+func (b *Bundle) path(name string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(name))
+}
+
+// Install writes the bundle's database to dbPath and its crate files into
+// the Subcrates folder next to it. Each file is replaced atomically, and
+// the database only after it is checked again (see RestoreDatabase).
+// Crates that aren't in the bundle are left as they are.
+func (b *Bundle) Install(dbPath string) error {
+	if err := b.files.RestoreDatabase(dbPath, b.path(b.Manifest.Database)); err != nil {
+		return err
+	}
+	subcratesDir := filepath.Join(filepath.Dir(dbPath), "Subcrates")
+	for _, name := range b.Manifest.Crates {
+		src := b.path(name)
+		err := b.files.writeFileAtomic(filepath.Join(subcratesDir, path.Base(name)), func(w io.Writer) error {
+			source, err := Disk.Open(src)
+			if err != nil {
+				return err
+			}
+			defer source.Close()
+			_, err = io.Copy(w, source)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes the bundle's temporary folder.
+func (b *Bundle) Close() error {
+	return os.RemoveAll(b.dir)
+}
+
+// isBundleName reports whether name is a usable entry name: not empty,
+// not absolute, without "." or ".." components or backslashes.
+func isBundleName(name string) bool {
+	if name == "" || strings.Contains(name, "\\") || path.IsAbs(name) {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// readZipJSON decodes the JSON in entry into v.
+func readZipJSON(entry *zip.File, v interface{}) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return json.NewDecoder(reader).Decode(v)
+}
+
+// extractZipFile writes the contents of entry to dst.
+func extractZipFile(entry *zip.File, dst string) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,110 @@
+package serato
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	source := t.TempDir()
+	dbPath := writeTestDatabase(t, source, "Music/House/a.mp3", "Music/House/Deep/b.mp3")
+	crates := map[string][]string{
+		"House":      {"Music/House/a.mp3"},
+		"House/Deep": {"Music/House/Deep/b.mp3"},
+	}
+	for name, tracks := range crates {
+		if _, err := WriteCrateFile(CratePathForDir(source, filepath.FromSlash(name)), tracks); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outZip := filepath.Join(t.TempDir(), "bundle.zip")
+	manifest, err := ExportBundle(dbPath, outZip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.TrackCount != 2 || manifest.DatabaseVersion != DatabaseVrsn {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	// Entry names use "/" on every platform.
+	sort.Strings(manifest.Crates)
+	if want := []string{"Subcrates/House%%Deep.crate", "Subcrates/House.crate"}; !reflect.DeepEqual(manifest.Crates, want) {
+		t.Errorf("manifest crates = %v, want %v", manifest.Crates, want)
+	}
+
+	bundle, err := OpenBundle(outZip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bundle.Close()
+	target := filepath.Join(t.TempDir(), "_Serato_")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	targetDB := filepath.Join(target, DatabaseFile)
+	if err := bundle.Install(targetDB); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rel := range []string{DatabaseFile, "Subcrates/House.crate", "Subcrates/House%%Deep.crate"} {
+		want, err := os.ReadFile(filepath.Join(source, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs after the round trip", rel)
+		}
+	}
+}
+
+func TestOpenBundleRejectsBadEntries(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	good := filepath.Join(t.TempDir(), "bundle.zip")
+	if _, err := ExportBundle(dbPath, good); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy the bundle, adding an entry that would escape the bundle.
+	archive, err := zip.OpenReader(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, file := range archive.File {
+		if err := w.Copy(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	extra, err := w.Create("../escape.crate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra.Write([]byte("crate"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.zip")
+	if err := os.WriteFile(bad, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle, err := OpenBundle(bad); err == nil {
+		bundle.Close()
+		t.Error("OpenBundle accepted an entry outside the bundle")
+	} else if !strings.Contains(err.Error(), "escape.crate") {
+		t.Errorf("OpenBundle error = %v, want it to name the entry", err)
+	}
+}
@@ -2,27 +2,80 @@ package serato
 
 import (
 	"fmt"
-	"io"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
-	"time"
 )
 
 // CleanupStats holds the statistics of the database cleanup operation.
 type CleanupStats struct {
-	OriginalCount     int `json:"original_count"`
-	RemovedNoPath     int `json:"removed_no_path"`
-	RemovedNoMetadata int `json:"removed_no_metadata"`
-	RemovedDuplicates int `json:"removed_duplicates"`
-	RemovedCorrupted  int `json:"removed_corrupted"`
-	FinalCount        int `json:"final_count"`
+	OriginalCount      int `json:"original_count"`
+	RemovedNoPath      int `json:"removed_no_path"`
+	RemovedNoMetadata  int `json:"removed_no_metadata"`
+	RemovedDuplicates  int `json:"removed_duplicates"`
+	RemovedCorrupted   int `json:"removed_corrupted"`
+	RemovedMissingFile int `json:"removed_missing_file"`
+	// RemovedFuzzyDuplicates counts duplicates found only by
+	// CleanupOptions.FuzzyDuplicates; Duplicates lists each of them.
+	RemovedFuzzyDuplicates int              `json:"removed_fuzzy_duplicates"`
+	Duplicates             []DuplicateMatch `json:"duplicates,omitempty"`
+	FinalCount             int              `json:"final_count"`
 }
 
+// DuplicateMatch records a fuzzy duplicate removed during cleanup and the
+// record that was kept in its place.
+type DuplicateMatch struct {
+	Kept    string `json:"kept"`
+	Removed string `json:"removed"`
+}
+
+// CleanupOptions selects which checks CleanDatabaseRecordsWithOptions runs.
+type CleanupOptions struct {
+	RemoveDuplicates bool
+	// RequireMetadata removes records with none of MetadataTags set.
+	RequireMetadata bool
+	// MetadataTags are the tags, or names from FieldNames, that count as
+	// metadata for RequireMetadata. Empty means DefaultMetadataTags.
+	MetadataTags []string
+	// VerifyFiles removes records whose file is missing or empty. Only
+	// paths under LibraryRoots are checked; anything else may live on a
+	// drive that simply isn't mounted.
+	VerifyFiles  bool
+	LibraryRoots []string
+	// FuzzyDuplicates also treats paths as duplicates when they differ only
+	// by drive letter, separators, percent-encoding, case, or whitespace
+	// around path components.
+	FuzzyDuplicates bool
+	// MatchBySize, with FuzzyDuplicates, also treats two tracks under
+	// LibraryRoots as duplicates when their file names match and the files
+	// on disk have the same size.
+	MatchBySize bool
+}
+
+// DefaultMetadataTags are the tags RequireMetadata looks at unless
+// CleanupOptions.MetadataTags is set: title, artist and album.
+var DefaultMetadataTags = []string{"ttit", "tart", "talb"}
+
 // CleanDatabaseRecords cleans database records by removing corrupted entries and duplicates.
 func CleanDatabaseRecords(records []Record, removeDuplicates, requireMetadata bool) ([]Record, CleanupStats) {
+	return CleanDatabaseRecordsWithOptions(records, CleanupOptions{
+		RemoveDuplicates: removeDuplicates,
+		RequireMetadata:  requireMetadata,
+	})
+}
+
+// CleanDatabaseRecordsWithOptions cleans database records using the checks selected in opts.
+func CleanDatabaseRecordsWithOptions(records []Record, opts CleanupOptions) ([]Record, CleanupStats) {
 	stats := CleanupStats{OriginalCount: len(records)}
 	var cleanedRecords []Record
 	seenPaths := make(map[string]struct{})
+	fuzzySeen := make(map[string]string)
+	metadataTags := opts.MetadataTags
+	if len(metadataTags) == 0 {
+		metadataTags = DefaultMetadataTags
+	}
 
 	for _, record := range records {
 		pfil, ok := record["pfil"].(string)
@@ -36,17 +89,22 @@ func CleanDatabaseRecords(records []Record, removeDuplicates, requireMetadata bo
 			continue
 		}
 
-		if requireMetadata {
-			title, _ := record["ttit"].(string)
-			artist, _ := record["tart"].(string)
-			album, _ := record["talb"].(string)
-			if strings.TrimSpace(title) == "" && strings.TrimSpace(artist) == "" && strings.TrimSpace(album) == "" {
-				stats.RemovedNoMetadata++
-				continue
+		if opts.RequireMetadata && !hasMetadata(record, metadataTags) {
+			stats.RemovedNoMetadata++
+			continue
+		}
+
+		if opts.VerifyFiles {
+			if path, ok := LocateTrack(pfil, opts.LibraryRoots); ok {
+				info, err := os.Stat(path)
+				if err != nil || info.IsDir() || info.Size() == 0 {
+					stats.RemovedMissingFile++
+					continue
+				}
 			}
 		}
 
-		if removeDuplicates {
+		if opts.RemoveDuplicates {
 			normalizedPath := strings.ToLower(strings.ReplaceAll(pfil, "\\", "/"))
 			if _, seen := seenPaths[normalizedPath]; seen {
 				stats.RemovedDuplicates++
@@ -55,6 +113,33 @@ func CleanDatabaseRecords(records []Record, removeDuplicates, requireMetadata bo
 			seenPaths[normalizedPath] = struct{}{}
 		}
 
+		if opts.FuzzyDuplicates {
+			keys := []string{"path:" + fuzzyPathKey(pfil)}
+			if opts.MatchBySize {
+				if path, ok := LocateTrack(pfil, opts.LibraryRoots); ok {
+					if info, err := os.Stat(path); err == nil {
+						name := strings.ToLower(filepath.Base(path))
+						keys = append(keys, fmt.Sprintf("size:%s:%d", name, info.Size()))
+					}
+				}
+			}
+			kept := ""
+			for _, key := range keys {
+				if k, seen := fuzzySeen[key]; seen {
+					kept = k
+					break
+				}
+			}
+			if kept != "" {
+				stats.RemovedFuzzyDuplicates++
+				stats.Duplicates = append(stats.Duplicates, DuplicateMatch{Kept: kept, Removed: pfil})
+				continue
+			}
+			for _, key := range keys {
+				fuzzySeen[key] = pfil
+			}
+		}
+
 		cleanedRecords = append(cleanedRecords, record)
 	}
 
@@ -62,27 +147,288 @@ func CleanDatabaseRecords(records []Record, removeDuplicates, requireMetadata bo
 	return cleanedRecords, stats
 }
 
-// BackupDatabase creates a backup of the database file.
-func BackupDatabase(dbPath string) (string, error) {
-	timestamp := time.Now().Unix()
-	backupPath := fmt.Sprintf("%s.backup.%d", dbPath, timestamp)
+// NormalizeDatabasePaths rewrites backslashes in every record's pfil as
+// forward slashes, the separator Serato uses, returning how many records
+// changed along with the records. Changed records are copies; records and
+// the maps in it are left as they are. Running it again changes nothing.
+func NormalizeDatabasePaths(records []Record) (int, []Record) {
+	changed := 0
+	normalized := make([]Record, len(records))
+	for i, record := range records {
+		normalized[i] = record
+		pfil, ok := record["pfil"].(string)
+		if !ok || !strings.Contains(pfil, "\\") {
+			continue
+		}
+		copied := make(Record, len(record))
+		for tag, value := range record {
+			copied[tag] = value
+		}
+		copied["pfil"] = strings.ReplaceAll(pfil, "\\", "/")
+		normalized[i] = copied
+		changed++
+	}
+	return changed, normalized
+}
 
-	source, err := os.Open(dbPath)
-	if err != nil {
-		return "", err
+// MoveRecords points the records of moved files at their new paths:
+// renames maps old pfil values, as compared by key (NormalizePath or
+// FoldPath), to new ones. It returns the old pfil of each record moved, as
+// written in the database, along with the records. Moved records are
+// copies; records and the maps in it are left as they are.
+func MoveRecords(records []Record, renames map[string]string, key func(string) string) ([]string, []Record) {
+	var moved []string
+	updated := make([]Record, len(records))
+	for i, record := range records {
+		updated[i] = record
+		pfil, ok := record["pfil"].(string)
+		if !ok {
+			continue
+		}
+		newPfil, ok := renames[key(pfil)]
+		if !ok {
+			continue
+		}
+		copied := make(Record, len(record))
+		for tag, value := range record {
+			copied[tag] = value
+		}
+		copied["pfil"] = newPfil
+		updated[i] = copied
+		moved = append(moved, pfil)
+	}
+	return moved, updated
+}
+
+// hasMetadata reports whether record has a non-blank value for any of tags.
+func hasMetadata(record Record, tags []string) bool {
+	for _, tag := range tags {
+		switch v := record[FieldTag(tag)].(type) {
+		case nil:
+		case string:
+			if strings.TrimSpace(v) != "" {
+				return true
+			}
+		case []byte:
+			if len(v) > 0 {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyPathKey normalizes a path for fuzzy duplicate detection: cleaned
+// with CleanPath, percent-decoded, with whitespace trimmed around each
+// component, and lowercased.
+func fuzzyPathKey(pfil string) string {
+	p := CleanPath(pfil)
+	if decoded, err := url.PathUnescape(p); err == nil {
+		p = decoded
 	}
-	defer source.Close()
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return strings.ToLower(strings.Join(parts, "/"))
+}
 
-	destination, err := os.Create(backupPath)
+// PruneMissingRecords drops records whose pfil lies under libraryPrefix but
+// for which exists reports false. exists is given the cleaned path relative
+// to the library root. Records outside the library (e.g. on other drives)
+// are always kept. The cleaned pfil of every removed record is returned
+// alongside the kept ones.
+func PruneMissingRecords(records []Record, libraryPrefix string, exists func(rel string) bool) ([]Record, map[string]struct{}) {
+	var kept []Record
+	removed := make(map[string]struct{})
+	for _, record := range records {
+		pfil, _ := record["pfil"].(string)
+		rel, ok := relativeToPrefix(CleanPath(pfil), libraryPrefix)
+		if !ok {
+			kept = append(kept, record)
+			continue
+		}
+		if exists(rel) {
+			kept = append(kept, record)
+			continue
+		}
+		removed[CleanPath(pfil)] = struct{}{}
+	}
+	return kept, removed
+}
+
+// PruneCrateFile removes tracks listed in removed (cleaned paths) from a
+// crate file. The crate is only rewritten if something was removed, and the
+// number of removed tracks is returned.
+func (f Files) PruneCrateFile(cratePath string, removed map[string]struct{}) (int, error) {
+	crate, err := f.ReadCrateFull(cratePath)
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+
+	var kept []string
+	for _, ptrk := range crate.TrackPaths {
+		if _, ok := removed[CleanPath(ptrk)]; !ok {
+			kept = append(kept, ptrk)
+		}
+	}
+
+	prunedCount := len(crate.TrackPaths) - len(kept)
+	if prunedCount == 0 {
+		return 0, nil
+	}
+	crate.TrackPaths = kept
+	// The paths were read from the crate, so they all write back.
+	_, err = f.WriteCrate(cratePath, crate)
+	return prunedCount, err
+}
+
+// PruneCrateFile is Files.PruneCrateFile with the default options.
+func PruneCrateFile(cratePath string, removed map[string]struct{}) (int, error) {
+	return Files{}.PruneCrateFile(cratePath, removed)
+}
+
+// relativeToPrefix strips libraryPrefix from a cleaned path, reporting
+// whether the path was inside the library at all.
+func relativeToPrefix(cleaned, libraryPrefix string) (string, bool) {
+	if libraryPrefix == "" {
+		return cleaned, true
+	}
+	if !strings.HasPrefix(cleaned, libraryPrefix+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(cleaned, libraryPrefix+"/"), true
+}
+
+// IsManagedCrate reports whether a crate mirrors library folders, as the
+// crates written by a sync do: every track in trackPaths lies directly in a
+// folder under one of the library prefixes that naming maps to cratePath.
+// Crates users put together themselves, and crates with no tracks to go
+// by, are not managed.
+func IsManagedCrate(cratePath string, trackPaths []string, libraryPrefixes []string, naming CrateNaming) bool {
+	if len(trackPaths) == 0 {
+		return false
 	}
-	defer destination.Close()
+	seratoRoot := filepath.Dir(filepath.Dir(cratePath))
+	for _, ptrk := range trackPaths {
+		managed := false
+		for _, prefix := range libraryPrefixes {
+			rel, ok := relativeToPrefix(CleanPath(ptrk), prefix)
+			if !ok || path.Dir(rel) == "." {
+				continue
+			}
+			if naming.CratePath(seratoRoot, filepath.FromSlash(path.Dir(rel))) == cratePath {
+				managed = true
+				break
+			}
+		}
+		if !managed {
+			return false
+		}
+	}
+	return true
+}
+
+// HasChildCrates reports whether any of crateFiles is nested under the
+// crate at cratePath, e.g. "House%%Deep.crate" under "House.crate".
+func HasChildCrates(cratePath string, crateFiles []string) bool {
+	prefix := strings.TrimSuffix(filepath.Base(cratePath), ".crate") + "%%"
+	for _, crateFile := range crateFiles {
+		if strings.HasPrefix(filepath.Base(crateFile), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CrateCleanup is the number of missing tracks CleanCrates removed from one
+// crate.
+type CrateCleanup struct {
+	Crate   string `json:"crate"`
+	Removed int    `json:"removed"`
+}
 
-	_, err = io.Copy(destination, source)
+// CleanCrates removes tracks whose files no longer exist from every crate
+// in seratoDir's Subcrates folder, returning the crates it changed. Track
+// paths are looked up under libraryRoots, which should be the roots whose
+// tracks belong to seratoDir (see DatabaseDirFor); tracks outside them are
+// kept, since they may be on a drive that isn't mounted. A crate that can't
+// be read or written is skipped and the first such error returned.
+func (f Files) CleanCrates(seratoDir string, libraryRoots []string) ([]CrateCleanup, error) {
+	return f.cleanCrates(seratoDir, libraryRoots, true)
+}
+
+// CleanCrates is Files.CleanCrates with the default options.
+func CleanCrates(seratoDir string, libraryRoots []string) ([]CrateCleanup, error) {
+	return Files{}.CleanCrates(seratoDir, libraryRoots)
+}
+
+// FindMissingCrateTracks is CleanCrates without writing anything: it
+// returns the crates CleanCrates would change and how many tracks it would
+// remove from each.
+func (f Files) FindMissingCrateTracks(seratoDir string, libraryRoots []string) ([]CrateCleanup, error) {
+	return f.cleanCrates(seratoDir, libraryRoots, false)
+}
+
+// FindMissingCrateTracks is Files.FindMissingCrateTracks with the default
+// options.
+func FindMissingCrateTracks(seratoDir string, libraryRoots []string) ([]CrateCleanup, error) {
+	return Files{}.FindMissingCrateTracks(seratoDir, libraryRoots)
+}
+
+func (f Files) cleanCrates(seratoDir string, libraryRoots []string, write bool) ([]CrateCleanup, error) {
+	crateFiles, err := ListCrateFiles(seratoDir)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	exists := func(ptrk string) bool {
+		cleaned := CleanPath(ptrk)
+		for _, root := range libraryRoots {
+			rel, ok := relativeToPrefix(cleaned, LibraryPrefix(root))
+			if !ok {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+			return err == nil && !info.IsDir()
+		}
+		return true
 	}
 
-	return backupPath, nil
+	var cleaned []CrateCleanup
+	var firstErr error
+	for _, crateFile := range crateFiles {
+		crate, err := f.ReadCrateFull(crateFile)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		var kept []string
+		for _, ptrk := range crate.TrackPaths {
+			if exists(ptrk) {
+				kept = append(kept, ptrk)
+			}
+		}
+		removed := len(crate.TrackPaths) - len(kept)
+		if removed == 0 {
+			continue
+		}
+		crate.TrackPaths = kept
+		if !write {
+			cleaned = append(cleaned, CrateCleanup{Crate: crateFile, Removed: removed})
+			continue
+		}
+		if _, err := f.WriteCrate(crateFile, crate); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		cleaned = append(cleaned, CrateCleanup{Crate: crateFile, Removed: removed})
+	}
+	return cleaned, firstErr
 }
@@ -0,0 +1,281 @@
+package serato
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"seratosync-go/tlv"
+)
+
+// pfilsOf returns the pfil of each record.
+func pfilsOf(records []Record) []string {
+	pfils := make([]string, len(records))
+	for i, record := range records {
+		pfils[i], _ = record["pfil"].(string)
+	}
+	return pfils
+}
+
+func TestCleanVerifyFiles(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Music")
+	if err := os.MkdirAll(filepath.Join(root, "folder.mp3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"present.mp3": "audio", "empty.mp3": ""} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefix := LibraryPrefix(root)
+	records := testRecords(
+		BuildPtrk(prefix, "present.mp3"),
+		BuildPtrk(prefix, "missing.mp3"),
+		BuildPtrk(prefix, "empty.mp3"),
+		BuildPtrk(prefix, "folder.mp3"),
+		"Unmounted Drive/Music/elsewhere.mp3",
+	)
+
+	// Without VerifyFiles nothing is checked.
+	if cleaned, stats := CleanDatabaseRecordsWithOptions(records, CleanupOptions{LibraryRoots: []string{root}}); len(cleaned) != 5 || stats.RemovedMissingFile != 0 {
+		t.Errorf("cleanup without VerifyFiles kept %d of 5 records", len(cleaned))
+	}
+
+	cleaned, stats := CleanDatabaseRecordsWithOptions(records, CleanupOptions{VerifyFiles: true, LibraryRoots: []string{root}})
+	want := []string{BuildPtrk(prefix, "present.mp3"), "Unmounted Drive/Music/elsewhere.mp3"}
+	if got := pfilsOf(cleaned); !reflect.DeepEqual(got, want) {
+		t.Errorf("cleanup kept %v, want %v", got, want)
+	}
+	if stats.RemovedMissingFile != 3 || stats.FinalCount != 2 {
+		t.Errorf("stats = %+v, want 3 missing files removed and 2 left", stats)
+	}
+}
+
+// otrkPayloads returns the payload of every otrk chunk in a database file.
+func otrkPayloads(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := tlv.IterNestedTLV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payloads [][]byte
+	for _, chunk := range chunks {
+		if chunk.Tag == "otrk" {
+			payloads = append(payloads, chunk.Value)
+		}
+	}
+	return payloads
+}
+
+func TestCleanKeepsRecordBytes(t *testing.T) {
+	// The golden record holds registered tags, tags this package doesn't
+	// know, and registered tags whose payloads don't fit their type.
+	golden, err := os.ReadFile(filepath.Join("testdata", "record.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), DatabaseFile)
+	vrsn, err := tlv.EncodeU16BE(DatabaseVrsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := tlv.MakeChunk("vrsn", vrsn)
+	data = append(data, tlv.MakeChunk("otrk", golden)...)
+	data = append(data, tlv.MakeChunk("otrk", golden)...) // a duplicate
+	if err := os.WriteFile(dbPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ReadDatabase(context.Background(), dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats CleanupStats
+	db.Records, stats = CleanDatabaseRecordsWithOptions(db.Records, CleanupOptions{RemoveDuplicates: true, RequireMetadata: true, FuzzyDuplicates: true})
+	if stats.FinalCount != 1 {
+		t.Fatalf("cleanup kept %d records, want 1", stats.FinalCount)
+	}
+	if err := WriteDatabase(dbPath, db); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := otrkPayloads(t, dbPath)
+	if len(payloads) != 1 || !bytes.Equal(payloads[0], golden) {
+		t.Errorf("cleaned record written as\n%x\nwant\n%x", payloads, golden)
+	}
+}
+
+func TestCleanFuzzyDuplicates(t *testing.T) {
+	records := testRecords(
+		"Music/House/Deep Track.mp3",
+		`C:\Music\House\Deep Track.mp3`,
+		"D:/Music/House/Deep%20Track.mp3",
+		"Music/house/deep track.MP3",
+		"Music/ House /Deep Track.mp3 ",
+		"Music/House/Other Track.mp3",
+		"Music/House/Deep Track (Remix).mp3",
+	)
+
+	// Off by default; the exact check only folds case and separators.
+	if _, stats := CleanDatabaseRecordsWithOptions(records, CleanupOptions{RemoveDuplicates: true}); stats.FinalCount != 6 || stats.RemovedFuzzyDuplicates != 0 {
+		t.Errorf("exact duplicate check kept %d of 7 records, with %d fuzzy duplicates", stats.FinalCount, stats.RemovedFuzzyDuplicates)
+	}
+
+	cleaned, stats := CleanDatabaseRecordsWithOptions(records, CleanupOptions{FuzzyDuplicates: true})
+	want := []string{"Music/House/Deep Track.mp3", "Music/House/Other Track.mp3", "Music/House/Deep Track (Remix).mp3"}
+	if got := pfilsOf(cleaned); !reflect.DeepEqual(got, want) {
+		t.Errorf("cleanup kept %v, want %v", got, want)
+	}
+	if stats.RemovedFuzzyDuplicates != 4 || len(stats.Duplicates) != 4 {
+		t.Fatalf("stats = %+v, want 4 fuzzy duplicates", stats)
+	}
+	for _, match := range stats.Duplicates {
+		if match.Kept != "Music/House/Deep Track.mp3" {
+			t.Errorf("%q reported as a duplicate of %q", match.Removed, match.Kept)
+		}
+	}
+}
+
+func TestCleanDuplicatesBySize(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Music")
+	for name, size := range map[string]int{"Old/track.mp3": 100, "New/Track.mp3": 100, "Other/track.mp3": 200} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefix := LibraryPrefix(root)
+	records := testRecords(BuildPtrk(prefix, "Old/track.mp3"), BuildPtrk(prefix, "New/Track.mp3"), BuildPtrk(prefix, "Other/track.mp3"))
+
+	opts := CleanupOptions{FuzzyDuplicates: true, LibraryRoots: []string{root}}
+	if _, stats := CleanDatabaseRecordsWithOptions(records, opts); stats.FinalCount != 3 {
+		t.Errorf("without MatchBySize cleanup kept %d of 3 records", stats.FinalCount)
+	}
+	opts.MatchBySize = true
+	cleaned, _ := CleanDatabaseRecordsWithOptions(records, opts)
+	want := []string{BuildPtrk(prefix, "Old/track.mp3"), BuildPtrk(prefix, "Other/track.mp3")}
+	if got := pfilsOf(cleaned); !reflect.DeepEqual(got, want) {
+		t.Errorf("cleanup kept %v, want %v", got, want)
+	}
+}
+
+func TestCleanCrates(t *testing.T) {
+	root := t.TempDir()
+	seratoDir := filepath.Join(root, SeratoDirName)
+	library := filepath.Join(root, "Music")
+	if err := os.MkdirAll(filepath.Join(library, "House"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(library, "House", "present.mp3"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefix := LibraryPrefix(library)
+	present, missing := BuildPtrk(prefix, "House/present.mp3"), BuildPtrk(prefix, "House/missing.mp3")
+	elsewhere := "Volumes/Unplugged/Music/x.mp3" // on a drive that isn't mounted
+	house := filepath.Join(seratoDir, "Subcrates", "House.crate")
+	other := filepath.Join(seratoDir, "Subcrates", "Other.crate")
+	if _, err := WriteCrateFile(house, []string{present, missing, elsewhere}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteCrateFile(other, []string{present}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(house)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CrateCleanup{{Crate: house, Removed: 1}}
+	found, err := FindMissingCrateTracks(seratoDir, []string{library})
+	if err != nil || !reflect.DeepEqual(found, want) {
+		t.Errorf("FindMissingCrateTracks = %v, %v, want %v", found, err, want)
+	}
+	if after, _ := os.ReadFile(house); !bytes.Equal(after, before) {
+		t.Error("FindMissingCrateTracks changed the crate")
+	}
+
+	backup, err := BackupSubcrates(seratoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaned, err := CleanCrates(seratoDir, []string{library})
+	if err != nil || !reflect.DeepEqual(cleaned, want) {
+		t.Errorf("CleanCrates = %v, %v, want %v", cleaned, err, want)
+	}
+	if got, err := ReadCrateFile(house); err != nil || !reflect.DeepEqual(got, []string{present, elsewhere}) {
+		t.Errorf("cleaned crate holds %v, %v", got, err)
+	}
+	// The backup holds the crates as they were.
+	if saved, err := os.ReadFile(filepath.Join(backup, "House.crate")); err != nil || !bytes.Equal(saved, before) {
+		t.Errorf("backed up crate differs: %v", err)
+	}
+	if cleaned, err := CleanCrates(seratoDir, []string{library}); err != nil || len(cleaned) != 0 {
+		t.Errorf("second CleanCrates = %v, %v, want nothing to do", cleaned, err)
+	}
+}
+
+func TestNormalizeDatabasePaths(t *testing.T) {
+	records := []Record{
+		{"pfil": `Music\House\a.mp3`, "ttit": "A"},
+		{"pfil": `Music/House\Deep/b.mp3`},
+		{"pfil": "Music/Techno/c.mp3"},
+		{"ttit": "No path"},
+	}
+	changed, normalized := NormalizeDatabasePaths(records)
+	if changed != 2 {
+		t.Errorf("changed = %d, want 2", changed)
+	}
+	want := []string{"Music/House/a.mp3", "Music/House/Deep/b.mp3", "Music/Techno/c.mp3", ""}
+	if got := pfilsOf(normalized); !reflect.DeepEqual(got, want) {
+		t.Errorf("pfils = %q, want %q", got, want)
+	}
+	if normalized[0]["ttit"] != "A" {
+		t.Error("other tags were lost")
+	}
+	if records[0]["pfil"] != `Music\House\a.mp3` {
+		t.Error("NormalizeDatabasePaths changed its argument")
+	}
+
+	// Running it again changes nothing.
+	if again, result := NormalizeDatabasePaths(normalized); again != 0 || !reflect.DeepEqual(result, normalized) {
+		t.Errorf("second run changed %d records", again)
+	}
+}
+
+func TestCleanMetadataTags(t *testing.T) {
+	records := []Record{
+		{"pfil": "Music/title.mp3", "ttit": "Title"},
+		{"pfil": "Music/bpm.mp3", "tbpm": "124"},
+		{"pfil": "Music/blank.mp3", "ttit": "  ", "tart": ""},
+		{"pfil": "Music/bare.mp3"},
+	}
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		// Title, artist and album by default.
+		{nil, []string{"Music/title.mp3"}},
+		{[]string{"bpm"}, []string{"Music/bpm.mp3"}},
+		{[]string{"TBPM", " Title "}, []string{"Music/title.mp3", "Music/bpm.mp3"}},
+		{[]string{"artist"}, nil},
+	}
+	for _, tt := range tests {
+		cleaned, stats := CleanDatabaseRecordsWithOptions(records, CleanupOptions{RequireMetadata: true, MetadataTags: tt.tags})
+		if got := pfilsOf(cleaned); len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("tags %q: kept %q, want %q", tt.tags, got, tt.want)
+		}
+		if stats.RemovedNoMetadata != len(records)-len(tt.want) {
+			t.Errorf("tags %q: RemovedNoMetadata = %d, want %d", tt.tags, stats.RemovedNoMetadata, len(records)-len(tt.want))
+		}
+	}
+}
@@ -1,9 +1,16 @@
 package serato
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"seratosync-go/tlv"
 )
@@ -18,82 +25,574 @@ var AudioExts = map[string]struct{}{
 	".wav":  {},
 	".flac": {},
 	".ogg":  {},
+	".aifc": {},
+	".alac": {},
 }
 
 // CrateVrsn is the version string for crate files.
 const CrateVrsn = "1.0/Serato ScratchLive Crate"
 
+// ErrNotCrate is returned when a file read as a crate is something else.
+var ErrNotCrate = errors.New("not a Serato crate file")
+
 // IsAudioFile checks if a path is an audio file with an allowed extension.
 func IsAudioFile(path string) bool {
+	return IsAudioFileIn(path, AudioExts)
+}
+
+// IsAudioFileIn checks if a path has one of the extensions in exts, which
+// must be lowercase with a leading dot (see ExtensionSet).
+func IsAudioFileIn(path string, exts map[string]struct{}) bool {
 	ext := strings.ToLower(filepath.Ext(path))
-	_, ok := AudioExts[ext]
+	_, ok := exts[ext]
 	return ok
 }
 
+// ExtensionSet returns AudioExts extended with extra extensions. Extra
+// extensions may be given in any case, with or without the leading dot.
+func ExtensionSet(extra []string) map[string]struct{} {
+	exts := make(map[string]struct{}, len(AudioExts)+len(extra))
+	for ext := range AudioExts {
+		exts[ext] = struct{}{}
+	}
+	for _, ext := range extra {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" || ext == "." {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[ext] = struct{}{}
+	}
+	return exts
+}
+
 // CratePathForDir generates the crate file path for a directory.
+//
+// Serato encodes crate hierarchy in the file name: "House/Deep/2024"
+// becomes "Subcrates/House%%Deep%%2024.crate", and that crate is shown
+// under "House%%Deep.crate", which in turn sits under "House.crate".
+//
+// Folder names are escaped with EscapeCrateComponent so a "%" in a name
+// can't be mistaken for a level separator; see DirForCrateName for the
+// reverse mapping.
 func CratePathForDir(seratoRoot, dirRel string) string {
-	subcratesDir := filepath.Join(seratoRoot, "Subcrates")
-	// Join path components with '%%' for the crate filename
-	crateName := strings.ReplaceAll(dirRel, string(filepath.Separator), "%%") + ".crate"
-	return filepath.Join(subcratesDir, crateName)
+	return CrateNaming{}.CratePath(seratoRoot, dirRel)
 }
 
-// BuildPtrk builds a ptrk (track path) string for a relative file.
-func BuildPtrk(prefix, relFile string) string {
-	parts := []string{}
-	if prefix != "" {
-		parts = append(parts, prefix)
+// CrateNaming controls how library folders map to crates. The zero value
+// mirrors the folder hierarchy at the top level, as CratePathForDir does.
+type CrateNaming struct {
+	// Parent puts every crate under this crate, e.g. "Auto-Imported" turns
+	// "House/Deep" into "Auto-Imported%%House%%Deep.crate". Slashes nest
+	// it further.
+	Parent string
+	// Flat names each crate after its folder alone, so "House/Deep" and
+	// "Techno/Deep" share "Deep.crate".
+	Flat bool
+	// Transform tidies each folder's name into its crate's. Parent is used
+	// as it is.
+	Transform CrateTransform
+}
+
+// CrateTransform tidies folder names into crate names, leaving the folders
+// and track paths alone. A transformed name transforms to itself, so a
+// folder lands in the same crate however often it is synced. Folders whose
+// names differ only in what the transform changes, such as "deep_house"
+// and "Deep House" with both rules on, share a crate.
+type CrateTransform struct {
+	// Underscores turns underscores into spaces and collapses runs of
+	// spaces, so "2024_summer_bangers" becomes "2024 summer bangers".
+	Underscores bool
+	// TitleCase capitalizes the first letter of each word and leaves the
+	// rest, so "summer bangers" becomes "Summer Bangers" and "DJ mix"
+	// keeps its "DJ".
+	TitleCase bool
+}
+
+// Apply returns the crate name for the folder name.
+func (t CrateTransform) Apply(name string) string {
+	if t.Underscores {
+		if spaced := strings.Join(strings.Fields(strings.ReplaceAll(name, "_", " ")), " "); spaced != "" {
+			name = spaced
+		}
 	}
-	parts = append(parts, strings.Split(relFile, string(filepath.Separator))...)
-	return strings.Join(parts, "/")
+	if t.TitleCase {
+		runes := []rune(name)
+		for i, r := range runes {
+			if i == 0 || unicode.IsSpace(runes[i-1]) {
+				runes[i] = unicode.ToUpper(r)
+			}
+		}
+		name = string(runes)
+	}
+	return name
 }
 
-// WriteCrateFile writes a crate file with the given track paths.
-func WriteCrateFile(outfile string, trackPaths []string) error {
-	err := os.MkdirAll(filepath.Dir(outfile), 0755)
-	if err != nil {
-		return err
+// Under returns n with its crates put under prefix, below Parent, so
+// "House" becomes "<Parent>%%<prefix>%%House.crate". An empty prefix leaves
+// n as it is.
+func (n CrateNaming) Under(prefix string) CrateNaming {
+	if strings.TrimSpace(prefix) == "" {
+		return n
+	}
+	if n.Parent != "" {
+		prefix = n.Parent + "/" + prefix
 	}
+	n.Parent = prefix
+	return n
+}
 
-	file, err := os.Create(outfile)
-	if err != nil {
+// CratePath returns the crate file for the library folder dirRel.
+func (n CrateNaming) CratePath(seratoRoot, dirRel string) string {
+	parts := strings.Split(dirRel, string(filepath.Separator))
+	if n.Flat {
+		parts = parts[len(parts)-1:]
+	}
+	for i, part := range parts {
+		parts[i] = n.Transform.Apply(part)
+	}
+	return n.crateFile(seratoRoot, append(n.parentParts(), parts...))
+}
+
+// ParentPaths returns the crate files for Parent and each crate above it,
+// outermost first, or nothing if Parent is empty.
+func (n CrateNaming) ParentPaths(seratoRoot string) []string {
+	var paths []string
+	parents := n.parentParts()
+	for i := range parents {
+		paths = append(paths, n.crateFile(seratoRoot, parents[:i+1]))
+	}
+	return paths
+}
+
+func (n CrateNaming) parentParts() []string {
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(n.Parent), "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// crateFile joins crate levels with "%%" into a crate file path.
+func (n CrateNaming) crateFile(seratoRoot string, parts []string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = EscapeCrateComponent(part)
+	}
+	return filepath.Join(seratoRoot, "Subcrates", strings.Join(escaped, "%%")+".crate")
+}
+
+// RenameCrate moves the crate of library folder oldRel to the name for
+// newRel, as CratePathForDir names them, along with the crates nested
+// under it. See CrateNaming.RenameCrate.
+func RenameCrate(seratoRoot, oldRel, newRel string) error {
+	return CrateNaming{}.RenameCrate(seratoRoot, oldRel, newRel)
+}
+
+// RenameCrate moves the crate of library folder oldRel to the file for
+// newRel, so a renamed folder keeps its crate instead of gaining a second
+// one. Unless n is flat, crates nested under it move too, e.g. "House/Deep"
+// follows "House" to "Deep House/Deep". Track paths in the crates are left
+// as they are. It fails without moving anything if the old crate doesn't
+// exist or a crate already has one of the new names. The nested crates
+// move before the crate itself, and if any move fails the ones already
+// made are undone, so the crates are never left split between the names.
+func (n CrateNaming) RenameCrate(seratoRoot, oldRel, newRel string) error {
+	oldPath := n.CratePath(seratoRoot, filepath.FromSlash(oldRel))
+	newPath := n.CratePath(seratoRoot, filepath.FromSlash(newRel))
+	if _, err := Disk.Stat(oldPath); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	vrsnPayload, err := tlv.EncodeU16BE(CrateVrsn)
+	type move struct{ src, dst string }
+	var moves []move
+	if !n.Flat {
+		oldPrefix := strings.TrimSuffix(filepath.Base(oldPath), ".crate") + "%%"
+		newPrefix := strings.TrimSuffix(filepath.Base(newPath), ".crate") + "%%"
+		crateFiles, err := ListCrateFiles(seratoRoot)
+		if err != nil {
+			return err
+		}
+		for _, crateFile := range crateFiles {
+			if name := filepath.Base(crateFile); strings.HasPrefix(name, oldPrefix) {
+				moves = append(moves, move{crateFile, filepath.Join(filepath.Dir(crateFile), newPrefix+strings.TrimPrefix(name, oldPrefix))})
+			}
+		}
+	}
+	moves = append(moves, move{oldPath, newPath})
+	for _, m := range moves {
+		if _, err := Disk.Stat(m.dst); err == nil {
+			return fmt.Errorf("crate %s already exists", filepath.Base(m.dst))
+		}
+	}
+	for i, m := range moves {
+		if err := Disk.Rename(m.src, m.dst); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if undoErr := Disk.Rename(moves[j].dst, moves[j].src); undoErr != nil {
+					err = errors.Join(err, fmt.Errorf("moving crate %s back: %w", filepath.Base(moves[j].src), undoErr))
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// CrateComponentAmbiguous reports whether a folder name would be misread
+// as more than one crate level if written unescaped: it contains "%%",
+// starts or ends with "%", or contains the escape sequence "%25" itself.
+func CrateComponentAmbiguous(name string) bool {
+	return strings.Contains(name, "%%") ||
+		strings.HasPrefix(name, "%") ||
+		strings.HasSuffix(name, "%") ||
+		strings.Contains(name, "%25")
+}
+
+// EscapeCrateComponent escapes a folder name for use as one level of a
+// crate file name. Names that aren't ambiguous (see CrateComponentAmbiguous)
+// are returned unchanged, so "50% Off" stays readable in Serato; otherwise
+// every "%" is written as "%25", e.g. "a%%b" becomes "a%25%25b".
+func EscapeCrateComponent(name string) string {
+	if !CrateComponentAmbiguous(name) {
+		return name
+	}
+	return strings.ReplaceAll(name, "%", "%25")
+}
+
+// DirForCrateName recovers the relative directory for a crate file name
+// written by CratePathForDir, e.g. "House%%Deep.crate" gives
+// "House/Deep" (with the OS separator).
+func DirForCrateName(crateName string) string {
+	name := strings.TrimSuffix(filepath.Base(crateName), ".crate")
+	parts := strings.Split(name, "%%")
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(part, "%25", "%")
+	}
+	return filepath.Join(parts...)
+}
+
+// ListCrateFiles returns the paths of all crate files under the Subcrates folder.
+func ListCrateFiles(seratoRoot string) ([]string, error) {
+	return filepath.Glob(filepath.Join(seratoRoot, "Subcrates", "*.crate"))
+}
+
+// CrateInfo describes a crate file found by ListCrates.
+type CrateInfo struct {
+	// Name is the crate's name as Serato shows it, with "/" between the
+	// names of nested crates, e.g. "House/Deep".
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// TrackCount is -1 if the file couldn't be read; Error says why.
+	TrackCount int    `json:"track_count"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ListCrates describes every crate file under the Subcrates folder,
+// sorted by name. A crate file that can't be read is listed with a
+// TrackCount of -1 rather than failing the listing.
+func (f Files) ListCrates(seratoRoot string) ([]CrateInfo, error) {
+	crateFiles, err := ListCrateFiles(seratoRoot)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	crates := make([]CrateInfo, 0, len(crateFiles))
+	for _, crateFile := range crateFiles {
+		info := CrateInfo{Name: filepath.ToSlash(DirForCrateName(crateFile)), Path: crateFile}
+		if tracks, err := f.ReadCrateFile(crateFile); err != nil {
+			info.TrackCount = -1
+			info.Error = err.Error()
+		} else {
+			info.TrackCount = len(tracks)
+		}
+		crates = append(crates, info)
 	}
-	err = tlv.WriteChunk(file, "vrsn", vrsnPayload)
+	sort.Slice(crates, func(i, j int) bool {
+		return crates[i].Name < crates[j].Name
+	})
+	return crates, nil
+}
+
+// ListCrates is Files.ListCrates with the default options.
+func ListCrates(seratoRoot string) ([]CrateInfo, error) {
+	return Files{}.ListCrates(seratoRoot)
+}
+
+// BuildPtrk builds a ptrk (track path) string for a relative file. The
+// prefix may use either slash as a separator, as library paths from a
+// Windows config do; relFile uses the operating system's, so on macOS and
+// Linux a backslash in a file name ("AC\DC.mp3") stays part of the name.
+// Empty components, as left by leading, trailing or doubled separators, are
+// dropped. The result is therefore already clean: for a prefix from
+// LibraryPrefix and a relFile without backslashes in its names,
+// CleanPath(BuildPtrk(prefix, rel)) is BuildPtrk(prefix, rel) itself, and
+// StripLibraryPrefix turns it back into BuildPtrk("", rel), the form
+// DetectNewTracks compares against. Names with backslashes are compared
+// through CleanPath on both sides, so they still match. New-track
+// detection relies on this round trip.
+func BuildPtrk(prefix, relFile string) string {
+	var parts []string
+	for _, p := range []string{strings.ReplaceAll(prefix, "\\", "/"), filepath.ToSlash(relFile)} {
+		for _, part := range strings.Split(p, "/") {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Crate is the parsed contents of a crate file. Header holds every chunk
+// other than vrsn and otrk (column definitions, sort order and the like)
+// verbatim and in file order, so rewriting a crate keeps its layout.
+type Crate struct {
+	Header     []*tlv.Chunk
+	TrackPaths []string
+	// Version is the version string read from the crate's vrsn chunk,
+	// such as CrateVrsn, or "" for a crate that doesn't exist yet. Crates
+	// are always written with CrateVrsn.
+	Version string
+
+	// path is the crate file, and files the options to save it with, for
+	// crates opened with Library.OpenCrate.
+	path  string
+	files Files
+}
+
+// DefaultCrateColumns are the columns a brand new crate shows unless
+// Files.CrateColumns says otherwise, in Serato's names for them.
+var DefaultCrateColumns = []string{"song", "artist", "bpm", "key", "album", "length"}
+
+// DefaultCrateHeader returns the column and sort chunks for a new crate,
+// showing f.CrateColumns sorted by song title.
+func (f Files) DefaultCrateHeader() []*tlv.Chunk {
+	var header []*tlv.Chunk
+	sortBy, err := tlv.EncodeU16BE("song")
 	if err != nil {
-		return err
+		return nil
 	}
+	osrt := append(tlv.MakeChunk("tvcn", sortBy), tlv.MakeChunk("brev", []byte{0})...)
+	header = append(header, &tlv.Chunk{Tag: "osrt", Size: uint32(len(osrt)), Value: osrt})
 
-	for _, pathStr := range trackPaths {
-		ptrkPayload, err := tlv.EncodeU16BE(pathStr)
+	width, err := tlv.EncodeU16BE("0")
+	if err != nil {
+		return nil
+	}
+	columns := f.CrateColumns
+	if len(columns) == 0 {
+		columns = DefaultCrateColumns
+	}
+	for _, column := range columns {
+		name, err := tlv.EncodeU16BE(column)
 		if err != nil {
-			// Log or handle error, for now we skip the track
 			continue
 		}
-		inner := tlv.MakeChunk("ptrk", ptrkPayload)
-		err = tlv.WriteChunk(file, "otrk", inner)
+		ovct := append(tlv.MakeChunk("tvcn", name), tlv.MakeChunk("tvcw", width)...)
+		header = append(header, &tlv.Chunk{Tag: "ovct", Size: uint32(len(ovct)), Value: ovct})
+	}
+	return header
+}
+
+// DefaultCrateHeader is Files.DefaultCrateHeader with the default
+// options.
+func DefaultCrateHeader() []*tlv.Chunk {
+	return Files{}.DefaultCrateHeader()
+}
+
+// WriteCrateFile writes a crate file with the given track paths. If the
+// crate already exists its header chunks are kept; a new crate gets
+// DefaultCrateHeader. Track paths that can't be written are returned (see
+// WriteCrate).
+func (f Files) WriteCrateFile(outfile string, trackPaths []string) ([]string, error) {
+	crate, err := f.ReadCrateFull(outfile)
+	if err != nil {
+		return nil, err
+	}
+	crate.TrackPaths = trackPaths
+	return f.WriteCrate(outfile, crate)
+}
+
+// WriteCrateFile is Files.WriteCrateFile with the default options.
+func WriteCrateFile(outfile string, trackPaths []string) ([]string, error) {
+	return Files{}.WriteCrateFile(outfile, trackPaths)
+}
+
+// WriteCrate writes a crate file from its header chunks and track paths.
+// A track path that isn't valid UTF-8 can't be stored as Serato's UTF-16
+// text without mangling it, so it is left out of the crate and returned
+// with any others skipped; the rest of the crate is still written.
+func (f Files) WriteCrate(outfile string, crate *Crate) ([]string, error) {
+	var skipped []string
+	err := f.rewriteFileAtomic(outfile, func(file io.Writer) error {
+		skipped = nil
+		vrsnPayload, err := tlv.EncodeU16BE(CrateVrsn)
 		if err != nil {
-			// Log or handle error
-			continue
+			return err
+		}
+		err = tlv.WriteChunk(file, "vrsn", vrsnPayload)
+		if err != nil {
+			return err
+		}
+
+		for _, chunk := range crate.Header {
+			err = tlv.WriteChunk(file, chunk.Tag, chunk.Value)
+			if err != nil {
+				return err
+			}
 		}
+
+		for _, pathStr := range crate.TrackPaths {
+			if !utf8.ValidString(pathStr) {
+				skipped = append(skipped, pathStr)
+				continue
+			}
+			ptrkPayload, err := tlv.EncodeU16BE(pathStr)
+			if err != nil {
+				skipped = append(skipped, pathStr)
+				continue
+			}
+			inner := tlv.MakeChunk("ptrk", ptrkPayload)
+			err = tlv.WriteChunk(file, "otrk", inner)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return skipped, nil
+}
 
-	return nil
+// WriteCrate is Files.WriteCrate with the default options.
+func WriteCrate(outfile string, crate *Crate) ([]string, error) {
+	return Files{}.WriteCrate(outfile, crate)
+}
+
+// WriteCrateFileMerge writes a crate file containing the tracks already in
+// the crate followed by any of trackPaths not yet present. Existing order is
+// preserved, so tracks added manually in Serato survive a sync.
+func (f Files) WriteCrateFileMerge(outfile string, trackPaths []string) ([]string, error) {
+	crate, err := f.ReadCrateFull(outfile)
+	if err != nil {
+		return nil, err
+	}
+	crate.TrackPaths = MergeTrackPaths(crate.TrackPaths, trackPaths)
+	return f.WriteCrate(outfile, crate)
+}
+
+// WriteCrateFileMerge is Files.WriteCrateFileMerge with the default options.
+func WriteCrateFileMerge(outfile string, trackPaths []string) ([]string, error) {
+	return Files{}.WriteCrateFileMerge(outfile, trackPaths)
+}
+
+// WriteCrateFileOrdered writes a crate file holding trackPaths, in their
+// order, followed by any other tracks already in the crate. It is
+// WriteCrateFileMerge for crates whose order the sync decides.
+func (f Files) WriteCrateFileOrdered(outfile string, trackPaths []string) ([]string, error) {
+	crate, err := f.ReadCrateFull(outfile)
+	if err != nil {
+		return nil, err
+	}
+	crate.TrackPaths = MergeTrackPaths(trackPaths, crate.TrackPaths)
+	return f.WriteCrate(outfile, crate)
+}
+
+// WriteCrateFileOrdered is Files.WriteCrateFileOrdered with the default
+// options.
+func WriteCrateFileOrdered(outfile string, trackPaths []string) ([]string, error) {
+	return Files{}.WriteCrateFileOrdered(outfile, trackPaths)
+}
+
+// CrateUpToDate reports whether the crate file at cratePath exists and
+// already holds every one of trackPaths, so writing them would leave it as
+// it is. With ordered, they must also be its first tracks, in the same
+// order, as WriteCrateFileOrdered writes them. Paths are compared with
+// NormalizePath.
+func (f Files) CrateUpToDate(cratePath string, trackPaths []string, ordered bool) (bool, error) {
+	if _, err := Disk.Stat(cratePath); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	existing, err := f.ReadCrateFile(cratePath)
+	if err != nil {
+		return false, err
+	}
+	if ordered {
+		if len(existing) < len(trackPaths) {
+			return false, nil
+		}
+		for i, p := range trackPaths {
+			if NormalizePath(p) != NormalizePath(existing[i]) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	present := make(map[string]struct{}, len(existing))
+	for _, p := range existing {
+		present[NormalizePath(p)] = struct{}{}
+	}
+	for _, p := range trackPaths {
+		if _, ok := present[NormalizePath(p)]; !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CrateUpToDate is Files.CrateUpToDate with the default options.
+func CrateUpToDate(cratePath string, trackPaths []string, ordered bool) (bool, error) {
+	return Files{}.CrateUpToDate(cratePath, trackPaths, ordered)
+}
+
+// MergeTrackPaths returns existing followed by the entries of added that are
+// not already present, with duplicates removed. Paths are compared with
+// NormalizePath, and the first form seen is kept.
+func MergeTrackPaths(existing, added []string) []string {
+	merged := make([]string, 0, len(existing)+len(added))
+	seen := make(map[string]struct{}, len(existing)+len(added))
+	for _, paths := range [][]string{existing, added} {
+		for _, p := range paths {
+			key := NormalizePath(p)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			merged = append(merged, p)
+		}
+	}
+	return merged
 }
 
 // ReadCrateFile reads an existing crate file and extracts track paths.
+func (f Files) ReadCrateFile(cratePath string) ([]string, error) {
+	crate, err := f.ReadCrateFull(cratePath)
+	if err != nil {
+		return nil, err
+	}
+	return crate.TrackPaths, nil
+}
+
+// ReadCrateFile is Files.ReadCrateFile with the default options.
 func ReadCrateFile(cratePath string) ([]string, error) {
-	if _, err := os.Stat(cratePath); os.IsNotExist(err) {
-		return []string{}, nil
+	return Files{}.ReadCrateFile(cratePath)
+}
+
+// ReadCrateFull reads a crate file including its header chunks. A crate
+// that doesn't exist yet reads as empty with DefaultCrateHeader. A crate
+// whose first chunk isn't its vrsn header, or with a second vrsn chunk, is
+// damaged or not a crate, and reading it fails with ErrNotCrate.
+func (f Files) ReadCrateFull(cratePath string) (*Crate, error) {
+	if _, err := Disk.Stat(cratePath); os.IsNotExist(err) {
+		return &Crate{Header: f.DefaultCrateHeader(), TrackPaths: []string{}}, nil
 	}
 
-	file, err := os.Open(cratePath)
+	file, err := f.openRetry(cratePath)
 	if err != nil {
 		return nil, err
 	}
@@ -104,24 +603,41 @@ func ReadCrateFile(cratePath string) ([]string, error) {
 		return nil, err
 	}
 
-	var trackPaths []string
-	for _, chunk := range chunks {
-		if chunk.Tag == "otrk" {
+	crate := &Crate{}
+	for i, chunk := range chunks {
+		if i == 0 && chunk.Tag != "vrsn" {
+			return nil, fmt.Errorf("%s: %w: missing vrsn header, found %q", cratePath, ErrNotCrate, chunk.Tag)
+		} else if i > 0 && chunk.Tag == "vrsn" {
+			return nil, fmt.Errorf("%s: %w: vrsn header out of place as chunk %d", cratePath, ErrNotCrate, i+1)
+		}
+		switch chunk.Tag {
+		case "vrsn":
+			// Kept for callers to check; rewritten from CrateVrsn.
+			crate.Version, _ = tlv.DecodeU16(trimNULs(chunk.Value))
+			crate.Version = strings.TrimSpace(crate.Version)
+		case "otrk":
 			nestedChunks, err := tlv.IterNestedTLV(chunk.Value)
 			if err != nil {
 				continue
 			}
 			for _, nestedChunk := range nestedChunks {
 				if nestedChunk.Tag == "ptrk" {
-					pathStr, err := tlv.DecodeU16BE(nestedChunk.Value)
+					pathStr, err := tlv.DecodeU16(nestedChunk.Value)
 					if err != nil {
 						continue
 					}
-					trackPaths = append(trackPaths, pathStr)
+					crate.TrackPaths = append(crate.TrackPaths, pathStr)
 				}
 			}
+		default:
+			crate.Header = append(crate.Header, chunk)
 		}
 	}
 
-	return trackPaths, nil
+	return crate, nil
+}
+
+// ReadCrateFull is Files.ReadCrateFull with the default options.
+func ReadCrateFull(cratePath string) (*Crate, error) {
+	return Files{}.ReadCrateFull(cratePath)
 }
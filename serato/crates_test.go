@@ -0,0 +1,539 @@
+package serato
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"seratosync-go/tlv"
+)
+
+// ptrkPrefixes are library prefixes as they come from LibraryPrefix and
+// from hand-written configs.
+var ptrkPrefixes = []string{
+	"",
+	"Music",
+	"/Music/",
+	"Users/dj/Music",
+	`Users\dj\Music\`,
+	"Müsic/Sets",
+}
+
+// ptrkNames are path components for randomRelPath: plain, accented (NFC
+// and NFD), astral, and with spaces and dots.
+var ptrkNames = []string{
+	"House", "Techno", "a.mp3", "Track 01.flac", "Café", "Cafe\u0301",
+	"Beyoncé - Halo.m4a", "🎵 Mix.wav", "𠀋.aiff", ".hidden", "x..y",
+}
+
+// randomRelPath returns a relative path of one to four components joined
+// by "/", sometimes with leading, trailing or doubled separators.
+func randomRelPath(rng *rand.Rand) string {
+	n := 1 + rng.Intn(4)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = ptrkNames[rng.Intn(len(ptrkNames))]
+	}
+	sep := "/"
+	if rng.Intn(4) == 0 {
+		sep = "//"
+	}
+	rel := strings.Join(parts, sep)
+	switch rng.Intn(4) {
+	case 0:
+		rel = "/" + rel
+	case 1:
+		rel += "/"
+	}
+	return rel
+}
+
+func TestBuildPtrkRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(60))
+	for i := 0; i < 2000; i++ {
+		prefix := ptrkPrefixes[rng.Intn(len(ptrkPrefixes))]
+		rel := randomRelPath(rng)
+
+		ptrk := BuildPtrk(prefix, rel)
+		if got := CleanPath(ptrk); got != ptrk {
+			t.Fatalf("CleanPath(BuildPtrk(%q, %q)) = %q, want %q", prefix, rel, got, ptrk)
+		}
+		stripped, _ := StripLibraryPrefix(map[string]struct{}{NormalizePath(ptrk): {}}, prefix)
+		want := NormalizePath(BuildPtrk("", rel))
+		if _, ok := stripped[want]; !ok || len(stripped) != 1 {
+			t.Fatalf("StripLibraryPrefix(BuildPtrk(%q, %q)) = %v, want %q", prefix, rel, stripped, want)
+		}
+	}
+}
+
+func TestBuildPtrkWindowsPrefix(t *testing.T) {
+	got := BuildPtrk(LibraryPrefix(`C:\Users\dj\Music\`), "House/a.mp3")
+	if want := "Users/dj/Music/House/a.mp3"; got != want {
+		t.Errorf("BuildPtrk = %q, want %q", got, want)
+	}
+}
+
+func TestBuildPtrkKeepsBackslashInName(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("a backslash is a separator on Windows")
+	}
+	got := BuildPtrk("Music", `Rock/AC\DC - Back in Black.mp3`)
+	if want := `Music/Rock/AC\DC - Back in Black.mp3`; got != want {
+		t.Errorf("BuildPtrk = %q, want %q", got, want)
+	}
+}
+
+// crateColumns returns the column names in a crate's ovct chunks and
+// checks that the crate reads back as a valid one.
+func crateColumns(t *testing.T, crateFile string) []string {
+	t.Helper()
+	crate, err := ReadCrateFull(crateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crate.Version != CrateVrsn {
+		t.Errorf("crate version = %q, want %q", crate.Version, CrateVrsn)
+	}
+	var columns []string
+	for _, chunk := range crate.Header {
+		if chunk.Tag != "ovct" {
+			continue
+		}
+		nested, err := tlv.IterNestedTLV(chunk.Value)
+		if err != nil {
+			t.Fatalf("ovct chunk: %v", err)
+		}
+		for _, column := range nested {
+			if column.Tag == "tvcn" {
+				name, err := tlv.DecodeU16BE(column.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				columns = append(columns, name)
+			}
+		}
+	}
+	return columns
+}
+
+func TestNewCrateColumns(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		files Files
+		want  []string
+	}{
+		{Files{}, DefaultCrateColumns},
+		{Files{CrateColumns: []string{"bpm", "song"}}, []string{"bpm", "song"}},
+	}
+	for i, tt := range tests {
+		crateFile := filepath.Join(dir, "Subcrates", fmt.Sprintf("Crate%d.crate", i))
+		if _, err := tt.files.WriteCrateFile(crateFile, []string{"Music/a.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := crateColumns(t, crateFile); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("columns = %v, want %v", got, tt.want)
+		}
+		if tracks, err := ReadCrateFile(crateFile); err != nil || !reflect.DeepEqual(tracks, []string{"Music/a.mp3"}) {
+			t.Errorf("tracks = %v, %v", tracks, err)
+		}
+
+		// A crate that exists keeps its columns.
+		if _, err := (Files{CrateColumns: []string{"comment"}}).WriteCrateFile(crateFile, []string{"Music/b.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := crateColumns(t, crateFile); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("columns after a rewrite = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestCrateRoundTripAstral(t *testing.T) {
+	crateFile := filepath.Join(t.TempDir(), "Subcrates", "🎵 Mix.crate")
+	tracks := []string{"Music/🎵 Mix/01 - 🔥.mp3", "Music/日本/𠮷野家.flac", "Music/𠀋𠂢𡈽.wav"}
+	if _, err := WriteCrateFile(crateFile, tracks); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadCrateFile(crateFile); err != nil || !reflect.DeepEqual(got, tracks) {
+		t.Errorf("crate holds %q, %v, want %q", got, err, tracks)
+	}
+}
+
+// u16 encodes s as Serato text, failing the test if it can't.
+func u16(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := tlv.EncodeU16BE(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRewriteKeepsCrateHeader(t *testing.T) {
+	crateFile := filepath.Join(t.TempDir(), "Subcrates", "House.crate")
+	// A crate sorted by descending BPM with two narrow columns and a chunk
+	// this package doesn't know.
+	header := [][]byte{
+		tlv.MakeChunk("osrt", append(tlv.MakeChunk("tvcn", u16(t, "bpm")), tlv.MakeChunk("brev", []byte{1})...)),
+		tlv.MakeChunk("ovct", append(tlv.MakeChunk("tvcn", u16(t, "bpm")), tlv.MakeChunk("tvcw", u16(t, "42"))...)),
+		tlv.MakeChunk("ovct", append(tlv.MakeChunk("tvcn", u16(t, "song")), tlv.MakeChunk("tvcw", u16(t, "300"))...)),
+		tlv.MakeChunk("zzzz", []byte{1, 2, 3}),
+	}
+	data := tlv.MakeChunk("vrsn", u16(t, CrateVrsn))
+	for _, chunk := range header {
+		data = append(data, chunk...)
+	}
+	data = append(data, tlv.MakeChunk("otrk", tlv.MakeChunk("ptrk", u16(t, "Music/a.mp3")))...)
+	if err := os.MkdirAll(filepath.Dir(crateFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(crateFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, write := range []func(string, []string) ([]string, error){WriteCrateFile, WriteCrateFileMerge} {
+		if _, err := write(crateFile, []string{"Music/b.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+		crate, err := ReadCrateFull(crateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(crate.Header) != len(header) {
+			t.Fatalf("crate has %d header chunks, want %d", len(crate.Header), len(header))
+		}
+		for i, chunk := range crate.Header {
+			if got := tlv.MakeChunk(chunk.Tag, chunk.Value); !bytes.Equal(got, header[i]) {
+				t.Errorf("header chunk %d = %x, want %x", i, got, header[i])
+			}
+		}
+	}
+	if tracks, err := ReadCrateFile(crateFile); err != nil || !reflect.DeepEqual(tracks, []string{"Music/b.mp3"}) {
+		t.Errorf("tracks = %v, %v", tracks, err)
+	}
+}
+
+func TestCrateNameEscaping(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"50% Off", "50% Off.crate"},
+		{"a%%b", "a%25%25b.crate"},
+		{"%start", "%25start.crate"},
+		{"end%", "end%25.crate"},
+		{"a%25b", "a%2525b.crate"},
+		{filepath.Join("House", "100%%Vinyl"), "House%%100%25%25Vinyl.crate"},
+		{filepath.Join("50% Off", "Deep"), "50% Off%%Deep.crate"},
+	}
+	for _, tt := range tests {
+		crateFile := CrateNaming{}.CratePath("_Serato_", tt.dir)
+		if got := filepath.Base(crateFile); got != tt.want {
+			t.Errorf("crate for %q = %q, want %q", tt.dir, got, tt.want)
+		}
+		if got := DirForCrateName(crateFile); got != tt.dir {
+			t.Errorf("crate %q maps back to %q, want %q", tt.want, got, tt.dir)
+		}
+	}
+}
+
+func TestReadCrateWithBOM(t *testing.T) {
+	crateFile := filepath.Join(t.TempDir(), "House.crate")
+	littleEndian := []byte{0xFF, 0xFE}
+	for _, r := range "Music/Café.mp3" {
+		littleEndian = append(littleEndian, byte(r), byte(r>>8))
+	}
+	data := tlv.MakeChunk("vrsn", append([]byte{0xFE, 0xFF}, u16(t, CrateVrsn)...))
+	data = append(data, tlv.MakeChunk("otrk", tlv.MakeChunk("ptrk", littleEndian))...)
+	data = append(data, tlv.MakeChunk("otrk", tlv.MakeChunk("ptrk", u16(t, "Music/b.mp3")))...)
+	if err := os.WriteFile(crateFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	crate, err := ReadCrateFull(crateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Music/Café.mp3", "Music/b.mp3"}; !reflect.DeepEqual(crate.TrackPaths, want) {
+		t.Errorf("tracks = %q, want %q", crate.TrackPaths, want)
+	}
+	if crate.Version != CrateVrsn {
+		t.Errorf("version = %q, want %q", crate.Version, CrateVrsn)
+	}
+}
+
+func TestReadCrateVersionHeader(t *testing.T) {
+	vrsn := tlv.MakeChunk("vrsn", u16(t, CrateVrsn))
+	otrk := tlv.MakeChunk("otrk", tlv.MakeChunk("ptrk", u16(t, "Music/a.mp3")))
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"present", append(append([]byte{}, vrsn...), otrk...), true},
+		{"missing", otrk, false},
+		{"misplaced", append(append([]byte{}, otrk...), vrsn...), false},
+		{"repeated", append(append(append([]byte{}, vrsn...), otrk...), vrsn...), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crateFile := filepath.Join(t.TempDir(), "House.crate")
+			if err := os.WriteFile(crateFile, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			crate, err := ReadCrateFull(crateFile)
+			if !tt.ok {
+				if !errors.Is(err, ErrNotCrate) {
+					t.Errorf("ReadCrateFull = %v, want %v", err, ErrNotCrate)
+				}
+				// A damaged crate is never written over.
+				if _, err := WriteCrateFile(crateFile, []string{"Music/b.mp3"}); err == nil {
+					t.Error("WriteCrateFile over a damaged crate succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if crate.Version != CrateVrsn || !reflect.DeepEqual(crate.TrackPaths, []string{"Music/a.mp3"}) {
+				t.Errorf("crate = version %q holding %q", crate.Version, crate.TrackPaths)
+			}
+		})
+	}
+
+	// A crate that doesn't exist yet has no version.
+	crate, err := ReadCrateFull(filepath.Join(t.TempDir(), "New.crate"))
+	if err != nil || crate.Version != "" {
+		t.Errorf("new crate = %+v, %v, want no version", crate, err)
+	}
+}
+
+func TestCrateNamingSchemes(t *testing.T) {
+	dir := filepath.Join("House", "Deep")
+	tests := []struct {
+		name    string
+		naming  CrateNaming
+		want    string
+		parents []string
+	}{
+		{"hierarchy", CrateNaming{}, "House%%Deep.crate", nil},
+		{"parent", CrateNaming{Parent: "Auto-Imported"}, "Auto-Imported%%House%%Deep.crate", []string{"Auto-Imported.crate"}},
+		{"nested parent", CrateNaming{Parent: "Auto/Imported/"}, "Auto%%Imported%%House%%Deep.crate", []string{"Auto.crate", "Auto%%Imported.crate"}},
+		{"flat", CrateNaming{Flat: true}, "Deep.crate", nil},
+		{"flat under parent", CrateNaming{Parent: "Auto-Imported", Flat: true}, "Auto-Imported%%Deep.crate", []string{"Auto-Imported.crate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filepath.Base(tt.naming.CratePath("_Serato_", dir)); got != tt.want {
+				t.Errorf("CratePath = %q, want %q", got, tt.want)
+			}
+			var parents []string
+			for _, parent := range tt.naming.ParentPaths("_Serato_") {
+				parents = append(parents, filepath.Base(parent))
+			}
+			if !reflect.DeepEqual(parents, tt.parents) {
+				t.Errorf("ParentPaths = %q, want %q", parents, tt.parents)
+			}
+		})
+	}
+	if got := (CrateNaming{Parent: "Auto"}).Under("Drive").CratePath("_Serato_", "House"); filepath.Base(got) != "Auto%%Drive%%House.crate" {
+		t.Errorf("crate under a library prefix = %q", filepath.Base(got))
+	}
+}
+
+func TestRenameCrate(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"House", "House/Deep", "Housework", "Techno"} {
+		if _, err := WriteCrateFile(CratePathForDir(root, filepath.FromSlash(name)), []string{"Music/" + name + "/a.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RenameCrate(root, "House", "Deep House"); err != nil {
+		t.Fatal(err)
+	}
+	lib := &Library{Root: root}
+	crates, err := lib.Crates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nested crates follow; a crate merely starting with the name doesn't.
+	if want := []string{"Deep House", "Deep House/Deep", "Housework", "Techno"}; !reflect.DeepEqual(crates, want) {
+		t.Errorf("crates = %v, want %v", crates, want)
+	}
+	// Track paths are left as they are.
+	tracks, err := ReadCrateFile(CratePathForDir(root, filepath.FromSlash("Deep House/Deep")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Music/House/Deep/a.mp3"}; !reflect.DeepEqual(tracks, want) {
+		t.Errorf("tracks = %v, want %v", tracks, want)
+	}
+
+	// Nothing moves if the old crate is missing or the new name is taken.
+	if err := RenameCrate(root, "House", "Other"); err == nil {
+		t.Error("renaming a missing crate succeeded")
+	}
+	if err := RenameCrate(root, "Techno", "Housework"); err == nil {
+		t.Error("renaming onto an existing crate succeeded")
+	}
+	if after, _ := lib.Crates(); !reflect.DeepEqual(after, crates) {
+		t.Errorf("crates after failed renames = %v, want %v", after, crates)
+	}
+}
+
+// nthRenameFails is the real file system, except that the rename
+// numbered fail, counting from 1, fails with errBrokenDisk.
+type nthRenameFails struct {
+	FileSystem
+	renames *int
+	fail    int
+}
+
+func (d nthRenameFails) Rename(oldpath, newpath string) error {
+	*d.renames++
+	if *d.renames == d.fail {
+		return errBrokenDisk
+	}
+	return d.FileSystem.Rename(oldpath, newpath)
+}
+
+func TestRenameCrateUndoesPartialMove(t *testing.T) {
+	root := t.TempDir()
+	names := []string{"House", "House/Deep", "House/Deep/Dub", "House/Vocal", "Techno"}
+	for _, name := range names {
+		if _, err := WriteCrateFile(CratePathForDir(root, filepath.FromSlash(name)), []string{"Music/" + name + "/a.mp3"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renames := 0
+	useDisk(t, nthRenameFails{FileSystem: Disk, renames: &renames, fail: 2})
+
+	if err := RenameCrate(root, "House", "Deep House"); !errors.Is(err, errBrokenDisk) {
+		t.Fatalf("RenameCrate = %v, want %v", err, errBrokenDisk)
+	}
+	crates, err := (&Library{Root: root}).Crates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(crates, names) {
+		t.Errorf("crates after a failed rename = %v, want %v", crates, names)
+	}
+	for _, name := range names {
+		tracks, err := ReadCrateFile(CratePathForDir(root, filepath.FromSlash(name)))
+		if want := []string{"Music/" + name + "/a.mp3"}; err != nil || !reflect.DeepEqual(tracks, want) {
+			t.Errorf("crate %s holds %v, %v, want %v", name, tracks, err, want)
+		}
+	}
+}
+
+func TestListCrates(t *testing.T) {
+	root := t.TempDir()
+	crates := map[string][]string{
+		"House":      {"Music/House/a.mp3", "Music/House/b.mp3"},
+		"House/Deep": {"Music/House/Deep/c.mp3"},
+		"Empty":      nil,
+	}
+	for name, tracks := range crates {
+		if _, err := WriteCrateFile(CratePathForDir(root, filepath.FromSlash(name)), tracks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broken := filepath.Join(root, "Subcrates", "Broken.crate")
+	if err := os.WriteFile(broken, []byte("otrk\x00\x00\x01\x00cut short"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := ListCrates(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, fmt.Sprintf("%s:%d", info.Name, info.TrackCount))
+		if (info.TrackCount < 0) != (info.Error != "") {
+			t.Errorf("%s: TrackCount %d with error %q", info.Name, info.TrackCount, info.Error)
+		}
+	}
+	want := []string{"Broken:-1", "Empty:0", "House:2", "House/Deep:1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListCrates = %v, want %v", got, want)
+	}
+
+	if infos, err := ListCrates(t.TempDir()); err != nil || len(infos) != 0 {
+		t.Errorf("ListCrates of a library without crates = %v, %v", infos, err)
+	}
+}
+
+func TestWriteCrateReportsSkippedTracks(t *testing.T) {
+	crateFile := filepath.Join(t.TempDir(), "Subcrates", "House.crate")
+	// Text that isn't UTF-8 can't be encoded as UTF-16.
+	bad := "Music/House/\xff\xfe.mp3"
+	skipped, err := WriteCrateFile(crateFile, []string{"Music/House/a.mp3", bad, "Music/House/b.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{bad}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %q, want %q", skipped, want)
+	}
+	tracks, err := ReadCrateFile(crateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Music/House/a.mp3", "Music/House/b.mp3"}; !reflect.DeepEqual(tracks, want) {
+		t.Errorf("crate holds %q, want %q", tracks, want)
+	}
+
+	if skipped, err := WriteCrateFile(crateFile, []string{"Music/House/a.mp3"}); err != nil || len(skipped) != 0 {
+		t.Errorf("WriteCrateFile of valid tracks = %q, %v, want nothing skipped", skipped, err)
+	}
+}
+
+func TestCrateUpToDate(t *testing.T) {
+	crateFile := filepath.Join(t.TempDir(), "Subcrates", "House.crate")
+	if ok, err := CrateUpToDate(crateFile, []string{"Music/a.mp3"}, false); ok || err != nil {
+		t.Errorf("missing crate: %v, %v, want false", ok, err)
+	}
+	if _, err := WriteCrateFile(crateFile, []string{"Music/b.mp3", "Music/a.mp3", "Music/old.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tracks  []string
+		ordered bool
+		want    bool
+	}{
+		{[]string{"Music/a.mp3", "Music/b.mp3"}, false, true},
+		{[]string{"Music/a.mp3", "Music/c.mp3"}, false, false},
+		{[]string{"Music/a.mp3", "Music/b.mp3"}, true, false},
+		{[]string{"Music/b.mp3", "Music/a.mp3"}, true, true},
+		{[]string{"Music/b.mp3", "Music/a.mp3", "Music/old.mp3", "Music/c.mp3"}, true, false},
+	}
+	for _, tt := range tests {
+		if got, err := CrateUpToDate(crateFile, tt.tracks, tt.ordered); err != nil || got != tt.want {
+			t.Errorf("CrateUpToDate(%q, ordered %v) = %v, %v, want %v", tt.tracks, tt.ordered, got, err, tt.want)
+		}
+	}
+}
+
+func TestCrateNamingUnder(t *testing.T) {
+	tests := []struct {
+		naming CrateNaming
+		prefix string
+		want   string
+	}{
+		{CrateNaming{}, "External", "External%%House%%Deep.crate"},
+		{CrateNaming{}, " ", "House%%Deep.crate"},
+		{CrateNaming{Parent: "Auto"}, "External", "Auto%%External%%House%%Deep.crate"},
+		{CrateNaming{Flat: true}, "External", "External%%Deep.crate"},
+	}
+	for _, tt := range tests {
+		got := filepath.Base(tt.naming.Under(tt.prefix).CratePath("_Serato_", filepath.FromSlash("House/Deep")))
+		if got != tt.want {
+			t.Errorf("%+v under %q: crate = %s, want %s", tt.naming, tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -2,49 +2,362 @@ package serato
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"seratosync-go/tlv"
+
+	"golang.org/x/text/unicode/norm"
 )
 
+// DatabaseVrsn is the version string at the start of a Database V2 file,
+// written to new databases unless another is given.
+const DatabaseVrsn = "2.0/Serato Scratch LIVE Database"
+
+// DatabaseFile is the name of the database file in a Serato folder.
+const DatabaseFile = "database V2"
+
+// ErrNotDatabase is returned when a file that should be a Serato
+// Database V2 file is something else.
+var ErrNotDatabase = errors.New("not a Serato database V2 file")
+
 // Record represents a track record in the Serato database.
 type Record map[string]interface{}
 
-// ReadDatabaseV2 reads all track records from a Serato Database V2 file.
-// It returns the records, a set of file paths with the library prefix stripped,
-// the calculated library prefix, and any error that occurred.
-func ReadDatabaseV2(path string, musicLibraryPath string) ([]Record, map[string]struct{}, string, error) {
-	file, err := os.Open(path)
+// TagOrder is the order in which known tags are written inside a track
+// record. Tags not listed here follow in alphabetical order.
+var TagOrder = []string{
+	"pfil", "ttyp", "ttit", "tart", "talb", "tgen", "tlen", "tbit", "tsmp",
+	"tbpm", "tcom", "tgrp", "tkey", "tadd", "uadd", "tmod",
+}
+
+// Keys returns the record's tags in the order they are written to disk, so
+// repeated writes of the same record produce identical bytes.
+func (r Record) Keys() []string {
+	keys := make([]string, 0, len(r))
+	known := make(map[string]struct{}, len(TagOrder))
+	for _, tag := range TagOrder {
+		known[tag] = struct{}{}
+		if _, ok := r[tag]; ok {
+			keys = append(keys, tag)
+		}
+	}
+	var rest []string
+	for tag := range r {
+		if _, ok := known[tag]; !ok {
+			rest = append(rest, tag)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// NewTrackRecord builds a record for a track being added to the database.
+// It sets the file type from the extension ("mp3") and the date added to
+// now, then copies any non-empty string tags from tags (e.g. "ttit",
+// "tart", "tbpm"). Serato keeps the date added twice, both in Unix seconds:
+// as text in tadd and as a 32-bit integer in uadd.
+func NewTrackRecord(pfil string, tags map[string]string) Record {
+	added := time.Now().Unix()
+	record := Record{
+		"pfil": pfil,
+		"ttyp": strings.TrimPrefix(strings.ToLower(filepath.Ext(pfil)), "."),
+		"tadd": strconv.FormatInt(added, 10),
+		"uadd": uint32(added),
+	}
+	for tag, value := range tags {
+		if TagTypes[tag] != TagString || tag == "pfil" {
+			continue
+		}
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		record[tag] = value
+	}
+	return record
+}
+
+// DatabaseInfo summarizes a database file without keeping its records.
+type DatabaseInfo struct {
+	Version    string
+	TrackCount int
+}
+
+// IsDatabaseV2 reports whether path is a Serato Database V2 file, i.e. a
+// TLV file whose first chunk is a vrsn chunk naming a Serato database, such
+// as DatabaseVrsn. Only the first chunk is read. An error is returned only
+// if path can't be read; anything unrecognized, such as a crate file, is
+// simply false. A zero-length file, as a fresh or interrupted Serato
+// install can leave, counts as a database with no tracks.
+func (f Files) IsDatabaseV2(path string) (bool, error) {
+	file, err := f.openRetry(path)
 	if err != nil {
-		return nil, nil, "", err
+		return false, err
 	}
 	defer file.Close()
+	if info, err := file.Stat(); err != nil {
+		return false, err
+	} else if info.Size() == 0 {
+		return true, nil
+	}
 
-	chunks, err := tlv.IterTLV(file)
+	var valid bool
+	err = tlv.IterTLVFunc(file, func(chunk *tlv.Chunk) error {
+		valid = isDatabaseVrsn(chunk)
+		return tlv.ErrStop
+	})
+	if err != nil && !errors.Is(err, tlv.ErrChunkTooLarge) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+	return valid, nil
+}
+
+// IsDatabaseV2 is Files.IsDatabaseV2 with the default options.
+func IsDatabaseV2(path string) (bool, error) {
+	return Files{}.IsDatabaseV2(path)
+}
+
+// ReadDatabaseVersion returns the version string heading the database at
+// path, such as DatabaseVrsn, or "" for an empty file. It fails with
+// ErrNotDatabase for any other file.
+func (f Files) ReadDatabaseVersion(path string) (string, error) {
+	file, err := f.openRetry(path)
 	if err != nil {
-		return nil, nil, "", err
+		return "", err
+	}
+	defer file.Close()
+
+	var version string
+	empty := true
+	err = tlv.IterTLVFunc(file, func(chunk *tlv.Chunk) error {
+		empty = false
+		version, _ = checkDatabaseHeader(0, chunk)
+		return tlv.ErrStop
+	})
+	if err != nil && !errors.Is(err, tlv.ErrChunkTooLarge) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
 	}
+	if empty && err == nil {
+		return "", nil
+	}
+	if version == "" {
+		return "", fmt.Errorf("%s: %w", path, ErrNotDatabase)
+	}
+	return version, nil
+}
+
+// ReadDatabaseVersion is Files.ReadDatabaseVersion with the default options.
+func ReadDatabaseVersion(path string) (string, error) {
+	return Files{}.ReadDatabaseVersion(path)
+}
+
+// isDatabaseVrsn reports whether chunk is the vrsn header of a database.
+// Serato editions word the version differently, so any version naming a
+// Serato database is accepted, e.g. DatabaseVrsn or
+// "2.0/Serato DJ Database".
+func isDatabaseVrsn(chunk *tlv.Chunk) bool {
+	if chunk.Tag != "vrsn" {
+		return false
+	}
+	version, err := tlv.DecodeU16(trimNULs(chunk.Value))
+	return err == nil && strings.Contains(version, "Serato") && strings.Contains(version, "Database")
+}
+
+// CheckDatabaseV2 is IsDatabaseV2 as a single error: nil for a database,
+// an error wrapping ErrNotDatabase for any other file, or the error from
+// reading path.
+func (f Files) CheckDatabaseV2(path string) error {
+	ok, err := f.IsDatabaseV2(path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", path, ErrNotDatabase)
+	}
+	return nil
+}
+
+// CheckDatabaseV2 is Files.CheckDatabaseV2 with the default options.
+func CheckDatabaseV2(path string) error {
+	return Files{}.CheckDatabaseV2(path)
+}
 
+// InspectDatabase checks that path is a Serato database (see IsDatabaseV2)
+// and counts its tracks. An empty file is a database without tracks or a
+// version.
+func (f Files) InspectDatabase(path string) (DatabaseInfo, error) {
+	var info DatabaseInfo
+	file, err := f.openRetry(path)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+
+	index := 0
+	err = tlv.IterTLVFunc(file, func(chunk *tlv.Chunk) error {
+		version, err := checkDatabaseHeader(index, chunk)
+		if err != nil {
+			return err
+		}
+		if index == 0 {
+			info.Version = version
+		}
+		index++
+		if chunk.Tag == "otrk" {
+			info.TrackCount++
+		}
+		return nil
+	})
+	return info, err
+}
+
+// InspectDatabase is Files.InspectDatabase with the default options.
+func InspectDatabase(path string) (DatabaseInfo, error) {
+	return Files{}.InspectDatabase(path)
+}
+
+// checkDatabaseHeader checks the chunk at index, counting from zero, of a
+// database: the first chunk must be a vrsn header naming a Serato database
+// (see isDatabaseVrsn), whose version it returns with surrounding NULs and
+// spaces trimmed, and no later chunk may be a vrsn. Either problem points
+// to a file that isn't a database or is damaged, and the error wraps
+// ErrNotDatabase.
+func checkDatabaseHeader(index int, chunk *tlv.Chunk) (string, error) {
+	if index > 0 {
+		if chunk.Tag == "vrsn" {
+			return "", fmt.Errorf("%w: vrsn header out of place as chunk %d", ErrNotDatabase, index+1)
+		}
+		return "", nil
+	}
+	if chunk.Tag != "vrsn" {
+		return "", fmt.Errorf("%w: missing vrsn header, found %q", ErrNotDatabase, chunk.Tag)
+	}
+	version, _ := tlv.DecodeU16(trimNULs(chunk.Value))
+	version = strings.TrimSpace(version)
+	if !isDatabaseVrsn(chunk) {
+		return "", fmt.Errorf("%w: unrecognized version header %q", ErrNotDatabase, version)
+	}
+	return version, nil
+}
+
+// ReadDatabaseV2 reads all track records from a Serato Database V2 file.
+// It returns the records, a set of file paths with the library prefix stripped,
+// the calculated library prefix, and any error that occurred. The paths in
+// the set are normalized with NormalizePath. The vrsn header is checked as
+// IterRecords does.
+func (f Files) ReadDatabaseV2(path string, musicLibraryPath string) ([]Record, map[string]struct{}, string, error) {
+	return f.ReadDatabaseV2Context(context.Background(), path, musicLibraryPath)
+}
+
+// ReadDatabaseV2 is Files.ReadDatabaseV2 with the default options.
+func ReadDatabaseV2(path string, musicLibraryPath string) ([]Record, map[string]struct{}, string, error) {
+	return Files{}.ReadDatabaseV2(path, musicLibraryPath)
+}
+
+// ReadDatabaseV2Context is ReadDatabaseV2 but stops with ctx.Err() if ctx
+// is cancelled while reading.
+func (f Files) ReadDatabaseV2Context(ctx context.Context, path string, musicLibraryPath string) ([]Record, map[string]struct{}, string, error) {
 	var records []Record
 	originalPfilSet := make(map[string]struct{})
 
-	for _, chunk := range chunks {
-		if chunk.Tag == "otrk" {
-			record, err := parseRecord(chunk.Value)
-			if err != nil {
-				continue
-			}
-			records = append(records, record)
+	err := f.IterRecords(ctx, path, func(record Record) error {
+		records = append(records, record)
+		if pfil, ok := record["pfil"].(string); ok {
+			originalPfilSet[NormalizePath(pfil)] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
 
-			if pfil, ok := record["pfil"].(string); ok {
-				cleanedPfil := CleanPath(pfil)
-				originalPfilSet[cleanedPfil] = struct{}{}
-			}
+	strippedPfilSet, libraryPrefix := StripLibraryPrefix(originalPfilSet, musicLibraryPath)
+	return records, strippedPfilSet, libraryPrefix, nil
+}
+
+// ReadDatabaseV2Context is Files.ReadDatabaseV2Context with the default
+// options.
+func ReadDatabaseV2Context(ctx context.Context, path string, musicLibraryPath string) ([]Record, map[string]struct{}, string, error) {
+	return Files{}.ReadDatabaseV2Context(ctx, path, musicLibraryPath)
+}
+
+// ReadPfilSet is like ReadDatabaseV2 but only keeps the stripped path set,
+// so the full records never have to be held in memory.
+func (f Files) ReadPfilSet(ctx context.Context, path string, musicLibraryPath string) (map[string]struct{}, string, error) {
+	originalPfilSet := make(map[string]struct{})
+
+	err := f.IterRecords(ctx, path, func(record Record) error {
+		if pfil, ok := record["pfil"].(string); ok {
+			originalPfilSet[NormalizePath(pfil)] = struct{}{}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
+	strippedPfilSet, libraryPrefix := StripLibraryPrefix(originalPfilSet, musicLibraryPath)
+	return strippedPfilSet, libraryPrefix, nil
+}
+
+// ReadPfilSet is Files.ReadPfilSet with the default options.
+func ReadPfilSet(ctx context.Context, path string, musicLibraryPath string) (map[string]struct{}, string, error) {
+	return Files{}.ReadPfilSet(ctx, path, musicLibraryPath)
+}
+
+// IterRecords streams the track records of a Database V2 file, calling fn
+// for each one. Records that fail to parse are skipped. Returning
+// tlv.ErrStop from fn ends iteration early, and cancelling ctx ends it
+// with ctx.Err(). A missing or misplaced vrsn header stops it with an
+// error wrapping ErrNotDatabase (see checkDatabaseHeader); use
+// ReadDatabaseVersion for the version itself.
+func (f Files) IterRecords(ctx context.Context, path string, fn func(Record) error) error {
+	file, err := f.openRetry(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	index := 0
+	return tlv.IterTLVFunc(file, func(chunk *tlv.Chunk) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := checkDatabaseHeader(index, chunk); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		index++
+		if chunk.Tag != "otrk" {
+			return nil
+		}
+		record, err := parseRecord(chunk.Value)
+		if err != nil {
+			return nil
+		}
+		return fn(record)
+	})
+}
+
+// IterRecords is Files.IterRecords with the default options.
+func IterRecords(ctx context.Context, path string, fn func(Record) error) error {
+	return Files{}.IterRecords(ctx, path, fn)
+}
+
+// StripLibraryPrefix removes the cleaned music library path from each
+// cleaned database path, returning the stripped set and the prefix used.
+// Paths outside the library are left out. With several library roots, read
+// the database with an empty library path and call this once per root.
+func StripLibraryPrefix(originalPfilSet map[string]struct{}, musicLibraryPath string) (map[string]struct{}, string) {
 	// The prefix to be stripped is the user's music library path, cleaned for comparison.
 	libraryPrefix := CleanPath(musicLibraryPath)
 	prefixWithSlash := ""
@@ -53,81 +366,475 @@ func ReadDatabaseV2(path string, musicLibraryPath string) ([]Record, map[string]
 	}
 
 	// Strip the library prefix from all database paths for accurate comparison.
+	// The set holds normalized paths (see NormalizePath), so the prefix is
+	// matched normalized too.
+	prefixWithSlash = norm.NFC.String(prefixWithSlash)
 	strippedPfilSet := make(map[string]struct{})
 	for pfil := range originalPfilSet {
 		// Only strip the prefix if the path actually has it. Some DB entries might be from other drives.
+		// Paths outside our target library can't be reliably matched, so they're left out of the set.
 		if libraryPrefix == "" || strings.HasPrefix(pfil, prefixWithSlash) {
 			strippedPfilSet[strings.TrimPrefix(pfil, prefixWithSlash)] = struct{}{}
-		} else {
-			// If the path doesn't have the prefix, it's outside our target library.
-			// We can't reliably match it, so we don't include it in the comparison set.
 		}
 	}
-
-	return records, strippedPfilSet, libraryPrefix, nil
+	return strippedPfilSet, libraryPrefix
 }
 
+// parseRecord decodes the payload of an otrk chunk. A payload that ends in
+// a partial chunk is an error, rather than a record missing that field.
 func parseRecord(data []byte) (Record, error) {
 	record := make(Record)
 	nestedChunks, err := tlv.IterNestedTLV(data)
 	if err != nil {
 		return nil, err
 	}
+	end := 0
+	if len(nestedChunks) > 0 {
+		last := nestedChunks[len(nestedChunks)-1]
+		end = int(last.Offset) + 8 + len(last.Value)
+	}
+	if end != len(data) {
+		return nil, fmt.Errorf("record truncated: %d bytes after its last field: %w", len(data)-end, io.ErrUnexpectedEOF)
+	}
 
 	for _, chunk := range nestedChunks {
-		switch chunk.Tag {
-		case "pfil", "ttyp", "tadd", "talb", "tart", "ttit", "tgen", "tkey", "tcom", "tgrp", "tbit", "tsmp", "tbpm", "tlen", "tmod":
-			cleanValue := bytes.TrimRight(chunk.Value, "\x00")
-			val, err := tlv.DecodeU16BE(cleanValue)
-			if err != nil {
-				return nil, fmt.Errorf("failed to decode tag %s: %w", chunk.Tag, err)
-			}
-			record[chunk.Tag] = val
-		default:
-			record[chunk.Tag] = chunk.Value
+		val, err := decodeTag(chunk.Tag, chunk.Value)
+		if err != nil {
+			return nil, err
 		}
+		record[chunk.Tag] = val
 	}
 
 	return record, nil
 }
 
-// WriteDatabaseV2Records writes track records back to Database V2.
-func WriteDatabaseV2Records(path string, records []Record) error {
-	file, err := os.Create(path)
+// Database is the parsed contents of a Database V2 file. Extra holds every
+// top-level chunk other than vrsn and otrk (library-wide sorting, column
+// layout and the like) verbatim, so rewriting the database keeps them.
+type Database struct {
+	// Version is the version string from the file's vrsn chunk. It is
+	// written back as is; empty writes DatabaseVrsn.
+	Version string
+	Records []Record
+	Extra   []ExtraChunk
+}
+
+// ExtraChunk is a top-level database chunk that isn't a track record,
+// along with its position in the file.
+type ExtraChunk struct {
+	// After is the number of track records that came before the chunk.
+	After int
+	Chunk *tlv.Chunk
+}
+
+// ReadDatabase reads a Database V2 file, keeping its non-track chunks.
+// Records that fail to parse are skipped, as in IterRecords.
+func (f Files) ReadDatabase(ctx context.Context, path string) (*Database, error) {
+	file, err := f.openRetry(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
-	// Write version header
-	vrsnPayload, err := tlv.EncodeU16BE("2.0/Serato Scratch LIVE Database")
+	db := &Database{}
+	err = tlv.IterTLVFunc(file, func(chunk *tlv.Chunk) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		db.add(chunk)
+		return nil
+	})
 	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// ReadDatabase is Files.ReadDatabase with the default options.
+func ReadDatabase(ctx context.Context, path string) (*Database, error) {
+	return Files{}.ReadDatabase(ctx, path)
+}
+
+// ReadDatabaseLenient is ReadDatabase for a database that may be damaged.
+// Rather than failing at the first chunk that can't be read, it skips ahead
+// to the next track record (see tlv.IterTLVLenient) and reports what it
+// skipped, so cleanup can still recover the rest of the tracks.
+func (f Files) ReadDatabaseLenient(ctx context.Context, path string) (*Database, []tlv.Damage, error) {
+	var data []byte
+	err := f.withRetry(func() error {
+		var err error
+		data, err = Disk.ReadFile(path)
 		return err
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-	err = tlv.WriteChunk(file, "vrsn", vrsnPayload)
+
+	db := &Database{}
+	damage, err := tlv.IterTLVLenient(data, []string{"vrsn", "otrk"}, func(chunk *tlv.Chunk) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		db.add(chunk)
+		return nil
+	})
+	if err != nil {
+		return nil, damage, err
+	}
+	return db, damage, nil
+}
+
+// ReadDatabaseLenient is Files.ReadDatabaseLenient with the default options.
+func ReadDatabaseLenient(ctx context.Context, path string) (*Database, []tlv.Damage, error) {
+	return Files{}.ReadDatabaseLenient(ctx, path)
+}
+
+// add adds a top-level chunk read from a database file. Records that fail
+// to parse are skipped, as in IterRecords.
+func (db *Database) add(chunk *tlv.Chunk) {
+	switch chunk.Tag {
+	case "vrsn":
+		if version, err := tlv.DecodeU16(trimNULs(chunk.Value)); err == nil {
+			db.Version = version
+		}
+	case "otrk":
+		if record, err := parseRecord(chunk.Value); err == nil {
+			db.Records = append(db.Records, record)
+		}
+	default:
+		db.Extra = append(db.Extra, ExtraChunk{After: len(db.Records), Chunk: chunk})
+	}
+}
+
+// readDatabaseHeader returns the version and the non-track chunks of the
+// database at path without parsing its records.
+func (f Files) readDatabaseHeader(path string) (string, []ExtraChunk, error) {
+	file, err := f.openRetry(path)
 	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	var version string
+	var extra []ExtraChunk
+	records := 0
+	err = tlv.IterTLVFunc(file, func(chunk *tlv.Chunk) error {
+		switch chunk.Tag {
+		case "vrsn":
+			version, _ = tlv.DecodeU16(trimNULs(chunk.Value))
+		case "otrk":
+			records++
+		default:
+			extra = append(extra, ExtraChunk{After: records, Chunk: chunk})
+		}
+		return nil
+	})
+	return version, extra, err
+}
+
+// WriteDatabaseV2Records writes track records back to Database V2. The
+// version and non-track chunks of the file already at path are kept in
+// place, as far as the new record count allows.
+func (f Files) WriteDatabaseV2Records(path string, records []Record) error {
+	return f.WriteDatabaseV2RecordsVersion(path, "", records)
+}
+
+// WriteDatabaseV2Records is Files.WriteDatabaseV2Records with the default
+// options.
+func WriteDatabaseV2Records(path string, records []Record) error {
+	return Files{}.WriteDatabaseV2Records(path, records)
+}
+
+// WriteDatabaseV2RecordsVersion is WriteDatabaseV2Records writing version
+// as the database version. An empty version keeps the file's own, or
+// writes DatabaseVrsn for a new file.
+func (f Files) WriteDatabaseV2RecordsVersion(path, version string, records []Record) error {
+	db := &Database{Records: records}
+	if fileVersion, extra, err := f.readDatabaseHeader(path); err == nil {
+		db.Version = fileVersion
+		db.Extra = extra
+	}
+	if version != "" {
+		db.Version = version
+	}
+	return f.WriteDatabase(path, db)
+}
+
+// WriteDatabaseV2RecordsVersion is Files.WriteDatabaseV2RecordsVersion with the default
+// options.
+func WriteDatabaseV2RecordsVersion(path, version string, records []Record) error {
+	return Files{}.WriteDatabaseV2RecordsVersion(path, version, records)
+}
+
+// WriteDatabase writes a Database V2 file headed by db.Version, or
+// DatabaseVrsn if that is empty. Each extra chunk is written
+// before the record at its After position, or at the end if there are
+// fewer records than that now. If a file already exists at path it must be
+// a database (see IsDatabaseV2), so a wrongly configured path can't
+// overwrite something else.
+func (f Files) WriteDatabase(path string, db *Database) error {
+	if err := f.CheckDatabaseV2(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
+	return f.rewriteFileAtomic(path, func(file io.Writer) error {
+		// Write version header
+		version := db.Version
+		if version == "" {
+			version = DatabaseVrsn
+		}
+		vrsnPayload, err := tlv.EncodeU16BE(version)
+		if err != nil {
+			return err
+		}
+		err = tlv.WriteChunk(file, "vrsn", vrsnPayload)
+		if err != nil {
+			return err
+		}
 
-	for _, record := range records {
-		var inner bytes.Buffer
-		for key, value := range record {
-			switch v := value.(type) {
-			case string:
-				payload, err := tlv.EncodeU16BE(v)
-				if err != nil {
+		extra := db.Extra
+		writeExtra := func(upTo int) error {
+			for len(extra) > 0 && extra[0].After <= upTo {
+				if err := tlv.WriteChunk(file, extra[0].Chunk.Tag, extra[0].Chunk.Value); err != nil {
 					return err
 				}
-				inner.Write(tlv.MakeChunk(key, payload))
-			case []byte:
-				inner.Write(tlv.MakeChunk(key, v))
+				extra = extra[1:]
 			}
+			return nil
 		}
-		err = tlv.WriteChunk(file, "otrk", inner.Bytes())
+
+		for i, record := range db.Records {
+			if err := writeExtra(i); err != nil {
+				return err
+			}
+			payload, err := encodeRecord(record)
+			if err != nil {
+				return err
+			}
+			err = tlv.WriteChunk(file, "otrk", payload)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, chunk := range extra {
+			if err := tlv.WriteChunk(file, chunk.Chunk.Tag, chunk.Chunk.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteDatabase is Files.WriteDatabase with the default options.
+func WriteDatabase(path string, db *Database) error {
+	return Files{}.WriteDatabase(path, db)
+}
+
+// encodeRecord encodes a record as the payload of an otrk chunk.
+func encodeRecord(record Record) ([]byte, error) {
+	var inner bytes.Buffer
+	for _, key := range record.Keys() {
+		payload, err := encodeTag(key, record[key])
+		if err != nil {
+			return nil, err
+		}
+		inner.Write(tlv.MakeChunk(key, payload))
+	}
+	return inner.Bytes(), nil
+}
+
+// AppendDatabaseV2Records adds records to the end of an existing Database
+// V2 file without rewriting what is already there, which for a large
+// database is much less work than WriteDatabaseV2Records. The file must be
+// a database whose last chunk ends exactly at the end of the file. An empty
+// file is written whole, since it lacks the version header.
+//
+// Unlike the other writes, the append is not atomic: the file is changed
+// in place through Disk. If writing fails part way the file is cut back to
+// its old length, but a crash at that moment can leave a partial record
+// behind (see CompactDatabase), so back the database up first. With
+// f.PreserveModTimes the file keeps its modification time.
+func (f Files) AppendDatabaseV2Records(path string, newRecords []Record) error {
+	if err := f.CheckDatabaseV2(path); err != nil {
+		return err
+	}
+	file, err := f.openRetry(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		file.Close()
+		return f.WriteDatabaseV2Records(path, newRecords)
+	}
+	size, err := chunkBoundaryEnd(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	file.Close()
+
+	var buf bytes.Buffer
+	for _, record := range newRecords {
+		payload, err := encodeRecord(record)
 		if err != nil {
 			return err
 		}
+		buf.Write(tlv.MakeChunk("otrk", payload))
 	}
 
+	if err := f.appendFile(path, size, buf.Bytes()); err != nil {
+		return err
+	}
+	if f.PreserveModTimes {
+		return Disk.Chtimes(path, time.Time{}, info.ModTime())
+	}
+	return nil
+}
+
+// AppendDatabaseV2Records is Files.AppendDatabaseV2Records with the default
+// options.
+func AppendDatabaseV2Records(path string, newRecords []Record) error {
+	return Files{}.AppendDatabaseV2Records(path, newRecords)
+}
+
+// appendFile writes data to the end of path, which is size bytes long,
+// and cuts the file back to size if that fails.
+func (f Files) appendFile(path string, size int64, data []byte) error {
+	file, err := Disk.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if truncErr := Disk.Truncate(path, size); truncErr != nil {
+			return fmt.Errorf("%w (and cutting %s back to %d bytes failed: %v)", err, path, size, truncErr)
+		}
+		return err
+	}
 	return nil
 }
+
+// TrailingGarbage returns how many bytes at the end of the database at
+// path follow its last complete chunk: a partial chunk or junk left by
+// another tool, which makes the database fail to load. The chunks are
+// walked from the start, and the first one whose header is implausible
+// (a tag that isn't four ASCII letters or digits) or whose value runs past
+// the end of the file starts the garbage.
+func (f Files) TrailingGarbage(path string) (int64, error) {
+	file, err := f.openRetry(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end, err := completeChunksEnd(file, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size() - end, nil
+}
+
+// TrailingGarbage is Files.TrailingGarbage with the default options.
+func TrailingGarbage(path string) (int64, error) {
+	return Files{}.TrailingGarbage(path)
+}
+
+// CompactDatabase cuts the database at path back to its last complete
+// chunk (see TrailingGarbage) and returns how many bytes were trimmed. The
+// chunks kept are rewritten byte for byte and atomically; a database with
+// nothing to trim is left untouched. Back the database up first.
+func (f Files) CompactDatabase(path string) (int64, error) {
+	if err := f.CheckDatabaseV2(path); err != nil {
+		return 0, err
+	}
+	file, err := f.openRetry(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end, err := completeChunksEnd(file, info.Size())
+	if err != nil || end == info.Size() {
+		return 0, err
+	}
+
+	err = f.rewriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.Copy(w, io.NewSectionReader(file, 0, end))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size() - end, nil
+}
+
+// CompactDatabase is Files.CompactDatabase with the default options.
+func CompactDatabase(path string) (int64, error) {
+	return Files{}.CompactDatabase(path)
+}
+
+// completeChunksEnd returns where the complete chunks at the start of
+// file, size bytes long, end.
+func completeChunksEnd(file File, size int64) (int64, error) {
+	header := make([]byte, 8)
+	var pos int64
+	for pos+8 <= size {
+		if _, err := file.ReadAt(header, pos); err != nil {
+			return 0, err
+		}
+		for _, c := range header[0:4] {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+				return pos, nil
+			}
+		}
+		end := pos + 8 + int64(binary.BigEndian.Uint32(header[4:8]))
+		if end > size {
+			return pos, nil
+		}
+		pos = end
+	}
+	return pos, nil
+}
+
+// chunkBoundaryEnd walks the chunk headers of file and returns its size if
+// the last chunk ends exactly at the end of the file.
+func chunkBoundaryEnd(file File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	header := make([]byte, 8)
+	var pos int64
+	for pos < size {
+		if pos+8 > size {
+			return 0, fmt.Errorf("truncated chunk header at offset %d", pos)
+		}
+		if _, err := file.ReadAt(header, pos); err != nil {
+			return 0, err
+		}
+		end := pos + 8 + int64(binary.BigEndian.Uint32(header[4:8]))
+		if end > size {
+			return 0, fmt.Errorf("chunk %q at offset %d runs past the end of the file", header[0:4], pos)
+		}
+		pos = end
+	}
+	return size, nil
+}
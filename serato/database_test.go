@@ -0,0 +1,671 @@
+package serato
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"seratosync-go/tlv"
+)
+
+func testRecords(pfils ...string) []Record {
+	records := make([]Record, len(pfils))
+	for i, pfil := range pfils {
+		records[i] = Record{"pfil": pfil, "ttyp": "mp3", "tsng": "Song " + pfil}
+	}
+	return records
+}
+
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	db, err := ReadDatabase(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.Records
+}
+
+func TestAppendDatabaseV2RecordsMatchesRewrite(t *testing.T) {
+	old := testRecords("Music/a.mp3", "Music/b.mp3")
+	added := testRecords("Music/c.mp3", "Music/Café/🎵.mp3")
+
+	appended := filepath.Join(t.TempDir(), DatabaseFile)
+	if err := WriteDatabaseV2Records(appended, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendDatabaseV2Records(appended, added); err != nil {
+		t.Fatal(err)
+	}
+	rewritten := filepath.Join(t.TempDir(), DatabaseFile)
+	if err := WriteDatabaseV2Records(rewritten, append(old, added...)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readRecords(t, appended), readRecords(t, rewritten); !reflect.DeepEqual(got, want) {
+		t.Errorf("appended database holds\n%v\nwant\n%v", got, want)
+	}
+	a, _ := os.ReadFile(appended)
+	b, _ := os.ReadFile(rewritten)
+	if !bytes.Equal(a, b) {
+		t.Error("appended database differs from the rewritten one byte for byte")
+	}
+}
+
+func TestAppendDatabaseV2RecordsRejectsTrailingGarbage(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(), "Music/a.mp3")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("otrk\x00\x00"))
+	file.Close()
+	before, _ := os.ReadFile(path)
+
+	if err := AppendDatabaseV2Records(path, testRecords("Music/b.mp3")); err == nil {
+		t.Fatal("append to a database ending in a partial chunk succeeded")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, before) {
+		t.Error("database changed although the append was refused")
+	}
+}
+
+// failingAppendDisk is the real file system, except that files opened with
+// OpenFile take the first half of a write and then fail.
+type failingAppendDisk struct {
+	FileSystem
+}
+
+func (d failingAppendDisk) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := d.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return halfWriteFile{file}, nil
+}
+
+type halfWriteFile struct {
+	File
+}
+
+var errHalfWrite = errors.New("simulated short write")
+
+func (f halfWriteFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errHalfWrite
+}
+
+func TestAppendDatabaseV2RecordsCutsBackOnFailure(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(), "Music/a.mp3")
+	before, _ := os.ReadFile(path)
+	useDisk(t, failingAppendDisk{Disk})
+
+	err := AppendDatabaseV2Records(path, testRecords("Music/b.mp3"))
+	if !errors.Is(err, errHalfWrite) {
+		t.Fatalf("append error = %v, want %v", err, errHalfWrite)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, before) {
+		t.Errorf("database is %d bytes after a failed append, want the original %d", len(after), len(before))
+	}
+}
+
+func TestAppendDatabaseV2RecordsPreservesModTime(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(), "Music/a.mp3")
+	modTime := setModTime(t, path)
+	if err := (Files{PreserveModTimes: true}).AppendDatabaseV2Records(path, testRecords("Music/b.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if got := modTimeOf(t, path); !got.Equal(modTime) {
+		t.Errorf("modification time = %v, want %v", got, modTime)
+	}
+	if got := readRecords(t, path); len(got) != 2 {
+		t.Errorf("database holds %d records, want 2", len(got))
+	}
+}
+
+func FuzzParseRecord(f *testing.F) {
+	records := append(testRecords("Music/a.mp3", "Music/Café/🎵.mp3"),
+		Record{"pfil": "Music/b.mp3", "bmis": false, "utme": uint32(1700000000), "tadd": "1700000000"})
+	for _, record := range records {
+		data, err := encodeRecord(record)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)-1])
+		f.Add(data[:len(data)/2])
+	}
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		record, err := parseRecord(data)
+		if err != nil {
+			return
+		}
+		encoded, err := encodeRecord(record)
+		if err != nil {
+			t.Fatalf("record %v parsed but doesn't encode: %v", record, err)
+		}
+		again, err := parseRecord(encoded)
+		if err != nil {
+			t.Fatalf("record %v doesn't parse once encoded: %v", record, err)
+		}
+		if !reflect.DeepEqual(again, record) {
+			t.Fatalf("record %v reads back as %v", record, again)
+		}
+	})
+}
+
+func TestParseRecordTruncated(t *testing.T) {
+	data, err := encodeRecord(testRecords("Music/a.mp3")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{len(data) - 1, len(data) - 9, 7} {
+		if record, err := parseRecord(data[:n]); err == nil {
+			t.Errorf("record cut to %d of %d bytes parsed as %v", n, len(data), record)
+		}
+	}
+}
+
+func TestDatabaseRoundTripAstral(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DatabaseFile)
+	records := []Record{
+		{"pfil": "Music/🎵 Mix/01 - 🔥.mp3", "ttyp": "mp3", "tsng": "🔥🔥", "tart": "👩🏽‍🎤"},
+		{"pfil": "Music/日本/𠮷野家.flac", "ttyp": "flac", "tsng": "𠀋𠂢𡈽", "tart": "Beyoncé"},
+	}
+	if err := WriteDatabaseV2Records(path, records); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRecords(t, path); !reflect.DeepEqual(got, records) {
+		t.Errorf("database holds\n%v\nwant\n%v", got, records)
+	}
+}
+
+func TestNewTrackRecordRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DatabaseFile)
+	old := Record{
+		"pfil": "Music/a.mp3", "ttyp": "mp3", "ttit": "Title", "tart": "Artist",
+		"tadd": "1700000000", "uadd": uint32(1700000000), "bmis": false,
+		"utme": uint32(42), "zzzz": []byte{0xDE, 0xAD, 0xBE, 0xEF},
+	}
+	if err := WriteDatabaseV2Records(path, []Record{old}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ReadDatabase(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := NewTrackRecord("Music/House/b.FLAC", map[string]string{"ttit": "New", "tart": " ", "tbpm": "124", "pfil": "ignored"})
+	db.Records = append(db.Records, added)
+	if err := WriteDatabase(path, db); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readRecords(t, path)
+	if len(got) != 2 {
+		t.Fatalf("database holds %d records, want 2", len(got))
+	}
+	if !reflect.DeepEqual(got[0], old) {
+		t.Errorf("existing record reads back as\n%v\nwant\n%v", got[0], old)
+	}
+	if !reflect.DeepEqual(got[1], added) {
+		t.Errorf("new record reads back as\n%v\nwant\n%v", got[1], added)
+	}
+	if added["pfil"] != "Music/House/b.FLAC" || added["ttyp"] != "flac" || added["ttit"] != "New" || added["tbpm"] != "124" {
+		t.Errorf("NewTrackRecord = %v", added)
+	}
+	if _, ok := added["tart"]; ok {
+		t.Error("NewTrackRecord kept a blank artist")
+	}
+	if tadd, uadd := added["tadd"].(string), added["uadd"].(uint32); tadd != fmt.Sprint(uadd) {
+		t.Errorf("tadd %q and uadd %d disagree", tadd, uadd)
+	}
+}
+
+func TestIterRecordsCancel(t *testing.T) {
+	pfils := make([]string, 100)
+	for i := range pfils {
+		pfils[i] = fmt.Sprintf("Music/%03d.mp3", i)
+	}
+	path := writeTestDatabase(t, t.TempDir(), pfils...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	read := 0
+	err := IterRecords(ctx, path, func(Record) error {
+		read++
+		if read == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || read != 10 {
+		t.Errorf("IterRecords read %d records and returned %v, want 10 and %v", read, err, context.Canceled)
+	}
+}
+
+func TestIsDatabaseV2(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	crate := filepath.Join(dir, "House.crate")
+	if _, err := WriteCrateFile(crate, []string{"Music/House/a.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	djVersion, err := tlv.EncodeU16BE("2.0/Serato DJ Database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(38)).Read(random)
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"valid", writeTestDatabase(t, t.TempDir(), "Music/a.mp3"), true},
+		{"other edition", write("dj", tlv.MakeChunk("vrsn", djVersion)), true},
+		{"empty", write("empty", nil), true},
+		{"crate", crate, false},
+		{"random bytes", write("random", random), false},
+		{"text", write("notes.txt", []byte("not a database at all")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := IsDatabaseV2(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Errorf("IsDatabaseV2 = %v, want %v", ok, tt.want)
+			}
+			if err := CheckDatabaseV2(tt.path); tt.want == (err != nil) {
+				t.Errorf("CheckDatabaseV2 = %v", err)
+			}
+		})
+	}
+
+	if _, err := IsDatabaseV2(filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("IsDatabaseV2 of a missing file: %v", err)
+	}
+
+	// A database is never written over anything else.
+	before, err := os.ReadFile(crate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDatabase(crate, &Database{}); !errors.Is(err, ErrNotDatabase) {
+		t.Errorf("WriteDatabase over a crate: %v, want %v", err, ErrNotDatabase)
+	}
+	if err := WriteDatabaseV2Records(crate, nil); !errors.Is(err, ErrNotDatabase) {
+		t.Errorf("WriteDatabaseV2Records over a crate: %v, want %v", err, ErrNotDatabase)
+	}
+	if after, err := os.ReadFile(crate); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("crate changed: %v", err)
+	}
+}
+
+func TestDatabaseRoundTripGolden(t *testing.T) {
+	// The golden database has a sort order and column layout ahead of its
+	// records, and an unknown chunk between them.
+	golden, err := os.ReadFile(filepath.Join("testdata", "database.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), DatabaseFile)
+	if err := os.WriteFile(path, golden, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := ReadDatabase(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var extra []string
+	for _, chunk := range db.Extra {
+		extra = append(extra, fmt.Sprintf("%s@%d", chunk.Chunk.Tag, chunk.After))
+	}
+	if want := []string{"osrt@0", "ovct@0", "ovct@0", "ovct@0", "rlut@2"}; !reflect.DeepEqual(extra, want) {
+		t.Errorf("extra chunks = %v, want %v", extra, want)
+	}
+	if len(db.Records) != 3 {
+		t.Fatalf("read %d records, want 3", len(db.Records))
+	}
+
+	if err := WriteDatabase(path, db); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, golden) {
+		t.Fatalf("database rewritten as\n%x\nwant\n%x", got, golden)
+	}
+
+	// Dropping a record keeps the other chunks where they were.
+	db.Records = append(db.Records[:1], db.Records[2:]...)
+	for i := range db.Extra {
+		if db.Extra[i].After > 1 {
+			db.Extra[i].After--
+		}
+	}
+	if err := WriteDatabase(path, db); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := tlv.IterNestedTLV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, chunk := range chunks {
+		tags = append(tags, chunk.Tag)
+	}
+	if want := []string{"vrsn", "osrt", "ovct", "ovct", "ovct", "otrk", "rlut", "otrk"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("database written with chunks %v, want %v", tags, want)
+	}
+}
+
+func TestReadDatabaseLenientTruncatedMiddle(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(), "Music/a.mp3", "Music/b.mp3", "Music/c.mp3")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := tlv.IterNestedTLV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut ten bytes from the end of the second record, leaving its size
+	// field as it was.
+	second := chunks[2]
+	end := int(second.Offset) + 8 + len(second.Value)
+	damaged := append(bytes.Clone(data[:end-10]), data[end:]...)
+	if err := os.WriteFile(path, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err := ReadDatabase(context.Background(), path); err == nil && len(db.Records) == 3 {
+		t.Fatal("ReadDatabase read all three records of a damaged database")
+	}
+	db, damage, err := ReadDatabaseLenient(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pfilsOf(db.Records), []string{"Music/a.mp3", "Music/c.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered %v, want %v", got, want)
+	}
+	if want := []tlv.Damage{{Offset: int(second.Offset), Length: end - 10 - int(second.Offset)}}; !reflect.DeepEqual(damage, want) {
+		t.Errorf("damage = %+v, want %+v", damage, want)
+	}
+
+	// Writing the recovered records back repairs the file.
+	if err := WriteDatabase(path, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := pfilsOf(readRecords(t, path)); !reflect.DeepEqual(got, []string{"Music/a.mp3", "Music/c.mp3"}) {
+		t.Errorf("repaired database holds %v", got)
+	}
+}
+
+func TestReadDatabaseLenientTrailingJunk(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(), "Music/a.mp3", "Music/b.mp3")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	junk := []byte("otrk\x00\x00\x10\x00pfi")
+	if err := os.WriteFile(path, append(data, junk...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last record is kept; only the junk after it is damage.
+	db, damage, err := ReadDatabaseLenient(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pfilsOf(db.Records), []string{"Music/a.mp3", "Music/b.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered %v, want %v", got, want)
+	}
+	if want := []tlv.Damage{{Offset: len(data), Length: len(junk)}}; !reflect.DeepEqual(damage, want) {
+		t.Errorf("damage = %+v, want %+v", damage, want)
+	}
+}
+
+func TestNewTrackRecordEncoding(t *testing.T) {
+	before := time.Now().Unix()
+	record := NewTrackRecord("Music/House/a.mp3", nil)
+	path := filepath.Join(t.TempDir(), DatabaseFile)
+	if err := WriteDatabaseV2Records(path, []Record{record}); err != nil {
+		t.Fatal(err)
+	}
+	payloads := otrkPayloads(t, path)
+	if len(payloads) != 1 {
+		t.Fatalf("database holds %d records, want 1", len(payloads))
+	}
+	chunks, err := tlv.IterNestedTLV(payloads[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string][]byte)
+	var tags []string
+	for _, chunk := range chunks {
+		fields[chunk.Tag] = chunk.Value
+		tags = append(tags, chunk.Tag)
+	}
+	if want := []string{"pfil", "ttyp", "tadd", "uadd"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("record written with tags %v, want %v", tags, want)
+	}
+
+	// ttyp and tadd are UTF-16 text; uadd is a big-endian 32-bit integer.
+	if want := u16(t, "mp3"); !bytes.Equal(fields["ttyp"], want) {
+		t.Errorf("ttyp = % X, want % X", fields["ttyp"], want)
+	}
+	uadd := fields["uadd"]
+	if len(uadd) != 4 {
+		t.Fatalf("uadd is %d bytes, want 4", len(uadd))
+	}
+	seconds := int64(binary.BigEndian.Uint32(uadd))
+	if seconds < before || seconds > time.Now().Unix() {
+		t.Errorf("uadd = %d, want about %d", seconds, before)
+	}
+	if want := u16(t, strconv.FormatInt(seconds, 10)); !bytes.Equal(fields["tadd"], want) {
+		t.Errorf("tadd = % X, want % X", fields["tadd"], want)
+	}
+}
+
+func TestDatabaseVersion(t *testing.T) {
+	const djVersion = "2.0/Serato DJ Database"
+	path := filepath.Join(t.TempDir(), DatabaseFile)
+
+	// A new database gets DatabaseVrsn unless told otherwise.
+	if err := WriteDatabaseV2Records(path, testRecords("Music/a.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := ReadDatabaseVersion(path); err != nil || version != DatabaseVrsn {
+		t.Errorf("ReadDatabaseVersion = %q, %v, want %q", version, err, DatabaseVrsn)
+	}
+
+	if err := WriteDatabaseV2RecordsVersion(path, djVersion, testRecords("Music/a.mp3", "Music/b.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	db, err := ReadDatabase(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Version != djVersion || len(db.Records) != 2 {
+		t.Errorf("read version %q with %d records, want %q with 2", db.Version, len(db.Records), djVersion)
+	}
+
+	// Rewriting without a version keeps the file's own.
+	if err := WriteDatabaseV2Records(path, testRecords("Music/a.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := ReadDatabaseVersion(path); err != nil || version != djVersion {
+		t.Errorf("ReadDatabaseVersion after rewrite = %q, %v, want %q", version, err, djVersion)
+	}
+
+	crate := filepath.Join(t.TempDir(), "House.crate")
+	if _, err := WriteCrateFile(crate, []string{"Music/a.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDatabaseVersion(crate); !errors.Is(err, ErrNotDatabase) {
+		t.Errorf("ReadDatabaseVersion of a crate: %v, want %v", err, ErrNotDatabase)
+	}
+}
+
+func TestDatabaseVersionHeader(t *testing.T) {
+	vrsn := tlv.MakeChunk("vrsn", u16(t, DatabaseVrsn))
+	otrk := tlv.MakeChunk("otrk", tlv.MakeChunk("pfil", u16(t, "Music/a.mp3")))
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"present", append(append([]byte{}, vrsn...), otrk...), true},
+		{"missing", otrk, false},
+		{"misplaced", append(append([]byte{}, otrk...), vrsn...), false},
+		{"repeated", append(append(append([]byte{}, vrsn...), otrk...), vrsn...), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DatabaseFile)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			records, _, _, err := ReadDatabaseV2(path, "")
+			if !tt.ok {
+				if !errors.Is(err, ErrNotDatabase) {
+					t.Errorf("ReadDatabaseV2 = %v, want %v", err, ErrNotDatabase)
+				}
+				if _, err := InspectDatabase(path); !errors.Is(err, ErrNotDatabase) {
+					t.Errorf("InspectDatabase = %v, want %v", err, ErrNotDatabase)
+				}
+				return
+			}
+			if err != nil || len(records) != 1 {
+				t.Fatalf("ReadDatabaseV2 = %d records, %v, want 1", len(records), err)
+			}
+			info, err := InspectDatabase(path)
+			if err != nil || info.Version != DatabaseVrsn || info.TrackCount != 1 {
+				t.Errorf("InspectDatabase = %+v, %v", info, err)
+			}
+		})
+	}
+}
+
+// copyFixture copies a file from testdata into a temporary directory as
+// the database and returns its path.
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), DatabaseFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEmptyDatabases(t *testing.T) {
+	for _, name := range []string{"empty.database", "header-only.database"} {
+		t.Run(name, func(t *testing.T) {
+			path := copyFixture(t, name)
+			if err := CheckDatabaseV2(path); err != nil {
+				t.Fatal(err)
+			}
+			if info, err := InspectDatabase(path); err != nil || info.TrackCount != 0 {
+				t.Errorf("InspectDatabase = %+v, %v, want no tracks", info, err)
+			}
+			records := readRecords(t, path)
+			if len(records) != 0 {
+				t.Errorf("read %d records, want none", len(records))
+			}
+
+			backup, err := BackupDatabase(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyBackup(backup); err != nil {
+				t.Errorf("VerifyBackup: %v", err)
+			}
+
+			cleaned, stats := CleanDatabaseRecords(records, true, true)
+			if len(cleaned) != 0 || stats.FinalCount != 0 {
+				t.Errorf("cleanup left %d records", len(cleaned))
+			}
+			if err := WriteDatabaseV2Records(path, cleaned); err != nil {
+				t.Fatal(err)
+			}
+			if version, err := ReadDatabaseVersion(path); err != nil || version != DatabaseVrsn {
+				t.Errorf("version after writing = %q, %v, want %q", version, err, DatabaseVrsn)
+			}
+
+			// A first sync appends to the database.
+			path = copyFixture(t, name)
+			if err := AppendDatabaseV2Records(path, testRecords("Music/a.mp3")); err != nil {
+				t.Fatal(err)
+			}
+			if got := pfilsOf(readRecords(t, path)); !reflect.DeepEqual(got, []string{"Music/a.mp3"}) {
+				t.Errorf("records after append = %v", got)
+			}
+			if version, err := ReadDatabaseVersion(path); err != nil || version != DatabaseVrsn {
+				t.Errorf("version after append = %q, %v, want %q", version, err, DatabaseVrsn)
+			}
+		})
+	}
+}
+
+func TestCompactDatabase(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(), "Music/a.mp3", "Music/b.mp3")
+	clean, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trimmed, err := CompactDatabase(path); err != nil || trimmed != 0 {
+		t.Errorf("CompactDatabase of a clean database = %d, %v, want 0", trimmed, err)
+	}
+
+	// Junk after the last chunk: a partial record header and some bytes.
+	junk := []byte("otrk\x00\x00\x10\x00pfi")
+	if err := os.WriteFile(path, append(bytes.Clone(clean), junk...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDatabase(context.Background(), path); err == nil {
+		t.Fatal("ReadDatabase read a database with trailing junk")
+	}
+	if garbage, err := TrailingGarbage(path); err != nil || garbage != int64(len(junk)) {
+		t.Errorf("TrailingGarbage = %d, %v, want %d", garbage, err, len(junk))
+	}
+	trimmed, err := CompactDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trimmed != int64(len(junk)) {
+		t.Errorf("trimmed %d bytes, want %d", trimmed, len(junk))
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, clean) {
+		t.Error("compacted database differs from the original")
+	}
+	if got := pfilsOf(readRecords(t, path)); !reflect.DeepEqual(got, []string{"Music/a.mp3", "Music/b.mp3"}) {
+		t.Errorf("records after compacting = %v", got)
+	}
+}
@@ -0,0 +1,131 @@
+package serato
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// DatabaseDiff lists how the track records of a database changed, by path.
+type DatabaseDiff struct {
+	Added   []string       `json:"added"`
+	Removed []string       `json:"removed"`
+	Changed []RecordChange `json:"changed"`
+}
+
+// RecordChange lists the fields that differ between two versions of the
+// record for one track.
+type RecordChange struct {
+	Pfil   string        `json:"pfil"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// FieldChange is one field of a changed record, with its values formatted
+// for display. A field that was added or removed has an empty Before or
+// After.
+type FieldChange struct {
+	Tag    string `json:"tag"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Merge appends the changes in other, e.g. from another drive's database.
+func (d *DatabaseDiff) Merge(other DatabaseDiff) {
+	d.Added = append(d.Added, other.Added...)
+	d.Removed = append(d.Removed, other.Removed...)
+	d.Changed = append(d.Changed, other.Changed...)
+}
+
+// DiffDatabases compares the records of a database before and after a
+// change. Records are matched by their exact pfil; if a path appears more
+// than once only its first record is compared, and records without a path
+// are ignored. Added and changed tracks are listed in the order of after,
+// removed tracks in the order of before.
+func DiffDatabases(before, after []Record) DatabaseDiff {
+	var diff DatabaseDiff
+	beforeByPath := recordsByPath(before)
+	afterByPath := recordsByPath(after)
+
+	seen := make(map[string]struct{}, len(after))
+	for _, record := range after {
+		pfil, _ := record["pfil"].(string)
+		if _, ok := seen[pfil]; ok || pfil == "" {
+			continue
+		}
+		seen[pfil] = struct{}{}
+
+		old, ok := beforeByPath[pfil]
+		if !ok {
+			diff.Added = append(diff.Added, pfil)
+			continue
+		}
+		if fields := diffRecord(old, record); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, RecordChange{Pfil: pfil, Fields: fields})
+		}
+	}
+
+	seen = make(map[string]struct{}, len(before))
+	for _, record := range before {
+		pfil, _ := record["pfil"].(string)
+		if _, ok := seen[pfil]; ok || pfil == "" {
+			continue
+		}
+		seen[pfil] = struct{}{}
+		if _, ok := afterByPath[pfil]; !ok {
+			diff.Removed = append(diff.Removed, pfil)
+		}
+	}
+	return diff
+}
+
+// recordsByPath indexes records by pfil, keeping the first of duplicates.
+func recordsByPath(records []Record) map[string]Record {
+	byPath := make(map[string]Record, len(records))
+	for _, record := range records {
+		pfil, _ := record["pfil"].(string)
+		if _, ok := byPath[pfil]; !ok && pfil != "" {
+			byPath[pfil] = record
+		}
+	}
+	return byPath
+}
+
+// diffRecord returns the fields that differ between two records, sorted by
+// tag.
+func diffRecord(before, after Record) []FieldChange {
+	tags := make(map[string]struct{}, len(before)+len(after))
+	for tag := range before {
+		tags[tag] = struct{}{}
+	}
+	for tag := range after {
+		tags[tag] = struct{}{}
+	}
+
+	var fields []FieldChange
+	for tag := range tags {
+		oldValue, hadOld := before[tag]
+		newValue, hasNew := after[tag]
+		if hadOld == hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		fields = append(fields, FieldChange{
+			Tag:    tag,
+			Before: formatTagValue(oldValue, hadOld),
+			After:  formatTagValue(newValue, hasNew),
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Tag < fields[j].Tag })
+	return fields
+}
+
+// formatTagValue formats a record value for display. Raw payloads are
+// shown in hex.
+func formatTagValue(value interface{}, ok bool) string {
+	if !ok {
+		return ""
+	}
+	if b, isBytes := value.([]byte); isBytes {
+		return fmt.Sprintf("%x", b)
+	}
+	return fmt.Sprint(value)
+}
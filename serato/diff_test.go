@@ -0,0 +1,64 @@
+package serato
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffDatabases(t *testing.T) {
+	a := Record{"pfil": "Music/a.mp3", "ttyp": "mp3", "tsng": "A", "tbpm": "120"}
+	b := Record{"pfil": "Music/b.mp3", "ttyp": "mp3", "tsng": "B"}
+	c := Record{"pfil": "Music/c.mp3", "ttyp": "mp3", "tsng": "C"}
+
+	tests := []struct {
+		name          string
+		before, after []Record
+		want          DatabaseDiff
+	}{
+		{
+			"add only",
+			[]Record{a}, []Record{a, c, b},
+			DatabaseDiff{Added: []string{"Music/c.mp3", "Music/b.mp3"}},
+		},
+		{
+			"remove only",
+			[]Record{a, b, c}, []Record{b},
+			DatabaseDiff{Removed: []string{"Music/a.mp3", "Music/c.mp3"}},
+		},
+		{
+			"field changes",
+			[]Record{a, b},
+			[]Record{
+				{"pfil": "Music/a.mp3", "ttyp": "mp3", "tsng": "A (Edit)", "tkey": "8A", "bmis": true},
+				{"pfil": "Music/b.mp3", "ttyp": "mp3", "tsng": "B", "tsmp": []byte{0xAC, 0x44}},
+			},
+			DatabaseDiff{Changed: []RecordChange{
+				{"Music/a.mp3", []FieldChange{{"bmis", "", "true"}, {"tbpm", "120", ""}, {"tkey", "", "8A"}, {"tsng", "A", "A (Edit)"}}},
+				{"Music/b.mp3", []FieldChange{{"tsmp", "", "ac44"}}},
+			}},
+		},
+		{
+			"duplicates and records without a path",
+			[]Record{a, {"ttyp": "mp3"}},
+			[]Record{a, {"pfil": "Music/a.mp3", "tsng": "Other"}, {"tsng": "No path"}},
+			DatabaseDiff{},
+		},
+		{"unchanged", []Record{a, b}, []Record{b, a}, DatabaseDiff{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffDatabases(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffDatabases = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseDiffMerge(t *testing.T) {
+	d := DatabaseDiff{Added: []string{"Music/a.mp3"}}
+	d.Merge(DatabaseDiff{Added: []string{"DJ/b.mp3"}, Removed: []string{"DJ/c.mp3"}})
+	want := DatabaseDiff{Added: []string{"Music/a.mp3", "DJ/b.mp3"}, Removed: []string{"DJ/c.mp3"}}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("merged diff = %+v, want %+v", d, want)
+	}
+}
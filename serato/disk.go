@@ -0,0 +1,101 @@
+package serato
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// FileSystem is what the package's reads and writes of databases and
+// crates, atomic writes and backups go through, so tests can substitute a
+// backend that keeps files in memory or fails on demand. OpenFile and
+// Truncate are for AppendDatabaseV2Records, the one write that changes a
+// file in place.
+type FileSystem interface {
+	Open(name string) (File, error)
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	Create(name string) (File, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	CreateTemp(dir, pattern string) (File, error)
+	MkdirAll(path string, perm fs.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Truncate(name string, size int64) error
+	// Chtimes sets the access and modification times of a file, leaving
+	// either as it is if it is the zero time.
+	Chtimes(name string, atime, mtime time.Time) error
+	Chmod(name string, mode fs.FileMode) error
+}
+
+// File is an open file of a FileSystem. *os.File implements it.
+type File interface {
+	io.ReadWriteCloser
+	io.ReaderAt
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Sync() error
+}
+
+// Disk is the FileSystem in use. It defaults to the operating system's.
+var Disk FileSystem = osFileSystem{}
+
+// osFileSystem implements FileSystem with the os package.
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (File, error) {
+	return fileOrNil(os.Open(name))
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Create(name string) (File, error) {
+	return fileOrNil(os.Create(name))
+}
+
+func (osFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return fileOrNil(os.OpenFile(name, flag, perm))
+}
+
+func (osFileSystem) CreateTemp(dir, pattern string) (File, error) {
+	return fileOrNil(os.CreateTemp(dir, pattern))
+}
+
+func (osFileSystem) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (osFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osFileSystem) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// fileOrNil keeps a nil *os.File from turning into a non-nil File.
+func fileOrNil(f *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,71 @@
+package serato
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// watchedDisk is the real file system, noting the name of every file
+// looked at through Open, ReadFile or Stat.
+type watchedDisk struct {
+	FileSystem
+	seen map[string]bool
+}
+
+func (d watchedDisk) Open(name string) (File, error) {
+	d.seen[filepath.Base(name)] = true
+	return d.FileSystem.Open(name)
+}
+
+func (d watchedDisk) ReadFile(name string) ([]byte, error) {
+	d.seen[filepath.Base(name)] = true
+	return d.FileSystem.ReadFile(name)
+}
+
+func (d watchedDisk) Stat(name string) (fs.FileInfo, error) {
+	d.seen[filepath.Base(name)] = true
+	return d.FileSystem.Stat(name)
+}
+
+func TestReadsGoThroughDisk(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	crateFile := filepath.Join(dir, "Subcrates", "House.crate")
+	if _, err := WriteCrateFile(crateFile, []string{"Music/a.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		read func() error
+		want []string
+	}{
+		{"ReadCrateFull", func() error { _, err := ReadCrateFull(crateFile); return err }, []string{"House.crate"}},
+		{"CrateUpToDate", func() error { _, err := CrateUpToDate(crateFile, nil, false); return err }, []string{"House.crate"}},
+		{"WriteCrateFileMerge", func() error { _, err := WriteCrateFileMerge(crateFile, []string{"Music/b.mp3"}); return err }, []string{"House.crate"}},
+		{"ReadDatabase", func() error { _, err := ReadDatabase(context.Background(), dbPath); return err }, []string{DatabaseFile}},
+		{"ReadDatabaseLenient", func() error { _, _, err := ReadDatabaseLenient(context.Background(), dbPath); return err }, []string{DatabaseFile}},
+		{"RenameCrate", func() error { return RenameCrate(dir, "House", "Deep House") }, []string{"Deep House.crate", "House.crate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := watchedDisk{FileSystem: Disk, seen: make(map[string]bool)}
+			useDisk(t, disk)
+			if err := tt.read(); err != nil {
+				t.Fatal(err)
+			}
+			var seen []string
+			for name := range disk.seen {
+				seen = append(seen, name)
+			}
+			sort.Strings(seen)
+			if !reflect.DeepEqual(seen, tt.want) {
+				t.Errorf("looked at %v through Disk, want %v", seen, tt.want)
+			}
+		})
+	}
+}
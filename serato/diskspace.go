@@ -0,0 +1,89 @@
+package serato
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// ErrDiskFull is returned by CheckFreeSpace when a drive doesn't have room
+// for what is about to be written to it.
+var ErrDiskFull = errors.New("not enough free space")
+
+// FreeSpace returns the bytes available to this user on the drive holding
+// dir. It is a variable so tests can pretend a drive is full.
+var FreeSpace = freeSpace
+
+// freeSpaceMargin is kept free on top of what a write needs, for the file
+// system's own bookkeeping.
+const freeSpaceMargin = 1 << 20
+
+// CheckFreeSpace checks that the drive holding dir has room for need more
+// bytes, failing with an error wrapping ErrDiskFull if it doesn't. If the
+// free space can't be found out, as on a platform without a way to ask or
+// for a folder that doesn't exist yet, the check passes.
+func CheckFreeSpace(dir string, need int64) error {
+	free, err := FreeSpace(dir)
+	if err != nil {
+		return nil
+	}
+	if need+freeSpaceMargin > free {
+		return fmt.Errorf("%w on the drive holding %s: about %s is needed but only %s is free", ErrDiskFull, dir, formatBytes(need+freeSpaceMargin), formatBytes(free))
+	}
+	return nil
+}
+
+// RecordSize returns the bytes record takes up in a database file, or 0
+// if it can't be encoded.
+func RecordSize(record Record) int64 {
+	encoded, err := encodeRecord(record)
+	if err != nil {
+		return 0
+	}
+	return 8 + int64(len(encoded))
+}
+
+// CrateSize estimates the bytes of a crate file holding trackPaths with
+// DefaultCrateHeader. Headers copied from an existing crate may be a
+// little larger.
+func (f Files) CrateSize(trackPaths []string) int64 {
+	size := int64(8 + 2*len(CrateVrsn))
+	for _, chunk := range f.DefaultCrateHeader() {
+		size += 8 + int64(len(chunk.Value))
+	}
+	for _, ptrk := range trackPaths {
+		// An otrk chunk holding a ptrk chunk, both with 8-byte headers,
+		// around the path in UTF-16.
+		size += 16 + 2*int64(len(utf16.Encode([]rune(ptrk))))
+	}
+	return size
+}
+
+// CrateSize is Files.CrateSize with the default options.
+func CrateSize(trackPaths []string) int64 {
+	return Files{}.CrateSize(trackPaths)
+}
+
+// FileSize returns the size of the file at path, or 0 if it doesn't exist
+// or can't be read.
+func FileSize(path string) int64 {
+	info, err := Disk.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// formatBytes formats n in the largest unit that keeps it at least 1.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[exp])
+}
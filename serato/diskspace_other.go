@@ -0,0 +1,9 @@
+//go:build !(darwin || linux || freebsd || windows)
+
+package serato
+
+import "errors"
+
+func freeSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
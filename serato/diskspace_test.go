@@ -0,0 +1,67 @@
+package serato
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubFreeSpace makes FreeSpace report free bytes, or err, for the rest
+// of the test.
+func stubFreeSpace(t *testing.T, free int64, err error) {
+	t.Helper()
+	saved := FreeSpace
+	FreeSpace = func(string) (int64, error) { return free, err }
+	t.Cleanup(func() { FreeSpace = saved })
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if free, err := FreeSpace(dir); err == nil && free <= 0 {
+		t.Errorf("FreeSpace = %d, want some room", free)
+	}
+
+	stubFreeSpace(t, 10<<20, nil)
+	if err := CheckFreeSpace(dir, 1<<20); err != nil {
+		t.Errorf("CheckFreeSpace with room: %v", err)
+	}
+	// The margin is kept free too.
+	err := CheckFreeSpace(dir, 10<<20-1<<10)
+	if !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("CheckFreeSpace on a full drive = %v, want %v", err, ErrDiskFull)
+	}
+	if !strings.Contains(err.Error(), "only 10.0 MB is free") {
+		t.Errorf("error doesn't say what is free: %v", err)
+	}
+
+	// A drive that can't be asked doesn't stop a write.
+	stubFreeSpace(t, 0, errors.New("not supported"))
+	if err := CheckFreeSpace(dir, 1<<30); err != nil {
+		t.Errorf("CheckFreeSpace without a free-space query: %v", err)
+	}
+}
+
+func TestSizeEstimates(t *testing.T) {
+	record := NewTrackRecord("Music/a.mp3", map[string]string{"ttit": "Title"})
+	encoded, err := encodeRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := RecordSize(record), int64(8+len(encoded)); got != want {
+		t.Errorf("RecordSize = %d, want %d", got, want)
+	}
+
+	// A crate written with the default header is the size estimated.
+	crateFile := filepath.Join(t.TempDir(), "House.crate")
+	tracks := []string{"Music/a.mp3", "Music/Café.mp3"}
+	if _, err := WriteCrateFile(crateFile, tracks); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := CrateSize(tracks), FileSize(crateFile); got != want {
+		t.Errorf("CrateSize = %d, crate file is %d bytes", got, want)
+	}
+	if got := FileSize(crateFile + ".missing"); got != 0 {
+		t.Errorf("FileSize of a missing file = %d", got)
+	}
+}
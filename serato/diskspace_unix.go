@@ -0,0 +1,13 @@
+//go:build darwin || linux || freebsd
+
+package serato
+
+import "syscall"
+
+func freeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
@@ -0,0 +1,122 @@
+package serato
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// MetadataWarning is a text field that looks wrongly encoded.
+type MetadataWarning struct {
+	// Pfil is the track the field belongs to.
+	Pfil string `json:"pfil"`
+	// Crate is set when the field is a track path in a crate file rather
+	// than part of a database record.
+	Crate   string `json:"crate,omitempty"`
+	Tag     string `json:"tag"`
+	Value   string `json:"value"`
+	Problem string `json:"problem"`
+}
+
+// CheckRecordEncoding looks through the text fields of records for signs of
+// a wrong encoding: replacement characters (U+FFFD) left by a failed
+// decode, control characters, UTF-8 that was decoded as Windows-1252
+// ("CafÃ©" for "Café"), and text that isn't valid UTF-16 at all. Nothing is
+// changed; the fields are only reported.
+func CheckRecordEncoding(records []Record) []MetadataWarning {
+	var warnings []MetadataWarning
+	for _, record := range records {
+		pfil, _ := record["pfil"].(string)
+		for _, tag := range record.Keys() {
+			if TagTypes[tag] != TagString {
+				continue
+			}
+			var problem, value string
+			switch v := record[tag].(type) {
+			case string:
+				value = v
+				problem = encodingProblem(v)
+			case []byte:
+				problem = "not valid UTF-16 text"
+			}
+			if problem != "" {
+				warnings = append(warnings, MetadataWarning{Pfil: pfil, Tag: tag, Value: value, Problem: problem})
+			}
+		}
+	}
+	return warnings
+}
+
+// CheckCrateEncoding is CheckRecordEncoding for the track paths in the
+// crate files of seratoRoot. Crates that can't be read are skipped.
+func (f Files) CheckCrateEncoding(seratoRoot string) ([]MetadataWarning, error) {
+	crateFiles, err := ListCrateFiles(seratoRoot)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []MetadataWarning
+	for _, crateFile := range crateFiles {
+		trackPaths, err := f.ReadCrateFile(crateFile)
+		if err != nil {
+			continue
+		}
+		for _, ptrk := range trackPaths {
+			if problem := encodingProblem(ptrk); problem != "" {
+				warnings = append(warnings, MetadataWarning{Pfil: ptrk, Crate: crateFile, Tag: "ptrk", Value: ptrk, Problem: problem})
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// CheckCrateEncoding is Files.CheckCrateEncoding with the default options.
+func CheckCrateEncoding(seratoRoot string) ([]MetadataWarning, error) {
+	return Files{}.CheckCrateEncoding(seratoRoot)
+}
+
+// encodingProblem describes what looks wrong with decoded text, or returns
+// "" if nothing does.
+func encodingProblem(s string) string {
+	switch {
+	case strings.ContainsRune(s, utf8.RuneError):
+		return "contains replacement characters"
+	case strings.IndexFunc(s, unicode.IsControl) >= 0:
+		return "contains control characters"
+	case isMojibake(s):
+		return "looks like UTF-8 decoded as Windows-1252"
+	}
+	return ""
+}
+
+// PathDisplayProblem describes the first character in a track path that
+// Serato may show as a blank or garbled entry, or returns "" if there is
+// none. Control characters are always reported. Characters outside the
+// Basic Multilingual Plane, such as emoji, are reported if astral is set:
+// they are stored correctly, as UTF-16 surrogate pairs, but not every
+// Serato version draws them.
+func PathDisplayProblem(path string, astral bool) string {
+	for _, r := range path {
+		switch {
+		case unicode.IsControl(r):
+			return fmt.Sprintf("control character U+%04X", r)
+		case astral && r > 0xFFFF:
+			return fmt.Sprintf("character %q (U+%04X) outside the Basic Multilingual Plane", r, r)
+		}
+	}
+	return ""
+}
+
+// isMojibake reports whether s reads as UTF-8 once encoded back to
+// Windows-1252, which is what happens to text that was UTF-8 but got
+// decoded as Windows-1252 or Latin-1. Genuine accented text such as "Café"
+// doesn't: its Windows-1252 bytes aren't valid UTF-8.
+func isMojibake(s string) bool {
+	encoded, err := charmap.Windows1252.NewEncoder().String(s)
+	if err != nil || encoded == s {
+		return false
+	}
+	return utf8.ValidString(encoded)
+}
@@ -0,0 +1,108 @@
+package serato
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"seratosync-go/tlv"
+)
+
+func TestCheckRecordEncoding(t *testing.T) {
+	field := func(tag string, value []byte) []byte { return tlv.MakeChunk(tag, value) }
+	record := func(pfil string, fields ...[]byte) []byte {
+		data := field("pfil", u16(t, pfil))
+		for _, f := range fields {
+			data = append(data, f...)
+		}
+		return tlv.MakeChunk("otrk", data)
+	}
+	data := tlv.MakeChunk("vrsn", u16(t, DatabaseVrsn))
+	data = append(data, record("Music/clean.mp3",
+		field("ttit", u16(t, "Café del Mar")), field("tart", u16(t, "Beyoncé")), field("talb", u16(t, "日本 🎵")))...)
+	data = append(data, record("Music/mojibake.mp3",
+		field("ttit", u16(t, "CafÃ© del Mar")))...)
+	data = append(data, record("Music/replaced.mp3",
+		field("tart", u16(t, "Beyonc\uFFFD")))...) // from an earlier failed decode
+	data = append(data, record("Music/surrogate.mp3",
+		field("tart", []byte{0xD8, 0x3C, 0x00, 'A'}))...) // an unpaired high surrogate
+	data = append(data, record("Music/odd.mp3",
+		field("talb", []byte{0x00, 'A', 0x00}))...) // half a character
+	data = append(data, record("Music/control.mp3",
+		field("tgen", u16(t, "House\x07")))...)
+	path := filepath.Join(t.TempDir(), DatabaseFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := ReadDatabase(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, warning := range CheckRecordEncoding(db.Records) {
+		got = append(got, warning.Pfil+" "+warning.Tag+": "+warning.Problem)
+	}
+	want := []string{
+		"Music/mojibake.mp3 ttit: looks like UTF-8 decoded as Windows-1252",
+		"Music/replaced.mp3 tart: contains replacement characters",
+		"Music/surrogate.mp3 tart: not valid UTF-16 text",
+		"Music/odd.mp3 talb: not valid UTF-16 text",
+		"Music/control.mp3 tgen: contains control characters",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+	// Warnings change nothing.
+	if len(db.Records) != 6 {
+		t.Errorf("database holds %d records, want 6", len(db.Records))
+	}
+}
+
+func TestCheckCrateEncoding(t *testing.T) {
+	seratoDir := t.TempDir()
+	crateFile := filepath.Join(seratoDir, "Subcrates", "House.crate")
+	if _, err := WriteCrateFile(crateFile, []string{"Music/Café.mp3", "Music/CafÃ©.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	warnings, err := CheckCrateEncoding(seratoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MetadataWarning{{Pfil: "Music/CafÃ©.mp3", Crate: crateFile, Tag: "ptrk", Value: "Music/CafÃ©.mp3", Problem: "looks like UTF-8 decoded as Windows-1252"}}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+}
+
+func TestPathDisplayProblem(t *testing.T) {
+	tests := []struct {
+		path   string
+		astral bool
+		want   string
+	}{
+		{"House/Café.mp3", true, ""},
+		{"House/Tab\there.mp3", false, "control character U+0009"},
+		{"House/\x7fDel.mp3", false, "control character U+007F"},
+		{"House/🔥 Mix.mp3", false, ""},
+		{"House/🔥 Mix.mp3", true, `character '🔥' (U+1F525) outside the Basic Multilingual Plane`},
+	}
+	for _, tt := range tests {
+		if got := PathDisplayProblem(tt.path, tt.astral); got != tt.want {
+			t.Errorf("PathDisplayProblem(%q, %v) = %q, want %q", tt.path, tt.astral, got, tt.want)
+		}
+	}
+
+	// Astral characters are stored as surrogate pairs and read back whole.
+	const emoji = "Music/🔥.mp3"
+	encoded := u16(t, emoji)
+	if want := []byte{0xD8, 0x3D, 0xDD, 0x25}; !bytes.Equal(encoded[12:16], want) {
+		t.Errorf("🔥 encoded as %x, want the surrogate pair %x", encoded[12:16], want)
+	}
+	if decoded, err := tlv.DecodeU16BE(encoded); err != nil || decoded != emoji {
+		t.Errorf("DecodeU16BE = %q, %v, want %q", decoded, err, emoji)
+	}
+}
@@ -0,0 +1,47 @@
+package serato
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// ExportDatabaseJSON writes every record in a Database V2 file to outPath
+// as a JSON array of objects keyed by tag. Tags registered in TagTypes are
+// written as strings, booleans or numbers; any other binary tag is written
+// as base64.
+func (f Files) ExportDatabaseJSON(dbPath, outPath string) error {
+	var records []map[string]interface{}
+	err := f.IterRecords(context.Background(), dbPath, func(record Record) error {
+		records = append(records, jsonRecord(record))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if records == nil {
+		records = []map[string]interface{}{}
+	}
+
+	return f.writeFileAtomic(outPath, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	})
+}
+
+// ExportDatabaseJSON is Files.ExportDatabaseJSON with the default options.
+func ExportDatabaseJSON(dbPath, outPath string) error {
+	return Files{}.ExportDatabaseJSON(dbPath, outPath)
+}
+
+// jsonRecord converts a record's values into JSON-friendly forms. Values
+// decoded by parseRecord are used as is; encoding/json writes the
+// remaining []byte values as base64.
+func jsonRecord(record Record) map[string]interface{} {
+	out := make(map[string]interface{}, len(record))
+	for tag, value := range record {
+		out[tag] = value
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package serato
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportDatabaseJSON(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, DatabaseFile)
+	records := []Record{
+		{"pfil": "Music/Café/🎵 \"Mix\".mp3", "ttit": "Title\twith tab", "tart": "Ärtist", "bmis": true, "uadd": uint32(1700000000), "zzzz": []byte{0xFF, 0}},
+		{"pfil": "Music/b.mp3"},
+	}
+	if err := WriteDatabaseV2Records(dbPath, records); err != nil {
+		t.Fatal(err)
+	}
+	outPath := filepath.Join(dir, "database.json")
+	if err := ExportDatabaseJSON(dbPath, outPath); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(data) {
+		t.Fatalf("export is not valid JSON:\n%s", data)
+	}
+	var exported []map[string]interface{}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != len(records) {
+		t.Fatalf("export holds %d records, want %d", len(exported), len(records))
+	}
+	for i, record := range records {
+		for tag, value := range record {
+			if s, ok := value.(string); ok && exported[i][tag] != s {
+				t.Errorf("record %d %s = %#v, want %q", i, tag, exported[i][tag], s)
+			}
+		}
+	}
+	first := exported[0]
+	if first["bmis"] != true || first["uadd"] != float64(1700000000) || first["zzzz"] != "/wA=" {
+		t.Errorf("typed tags exported as bmis %#v, uadd %#v, zzzz %#v", first["bmis"], first["uadd"], first["zzzz"])
+	}
+}
+
+func TestExportDatabaseJSONEmpty(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := writeTestDatabase(t, dir)
+	outPath := filepath.Join(dir, "database.json")
+	if err := ExportDatabaseJSON(dbPath, outPath); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(outPath); string(data) != "[]\n" {
+		t.Errorf("empty database exported as %q, want an empty array", data)
+	}
+}
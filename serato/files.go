@@ -0,0 +1,22 @@
+package serato
+
+// Files holds the options the package's file operations run with. The
+// functions that read and write databases, crates and backups are methods
+// of Files; each has a package-level counterpart using the zero value,
+// which has the default options. A Files is passed by value, so a sync
+// holds its own copy and a change of settings affects the next one only.
+type Files struct {
+	// Retries is how many times an operation that failed with a transient
+	// error, such as a timeout on a network share, is retried. Zero means
+	// DefaultRetries and a negative value turns retrying off.
+	Retries int
+	// PreserveModTimes makes rewrites of databases and crates keep the
+	// modification time of the file they replace, so a sync that changes
+	// little doesn't look like a fresh copy to backup tools. New files get
+	// the current time either way.
+	PreserveModTimes bool
+	// CrateColumns are the columns written to a brand new crate, left to
+	// right. Empty means DefaultCrateColumns. Crates that already exist
+	// keep their own.
+	CrateColumns []string
+}
@@ -0,0 +1,203 @@
+package serato
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"seratosync-go/tlv"
+)
+
+// Session is one playing session from Serato's history, read from a
+// session file ("History/Sessions/<n>.session"). This package only reads
+// the history; Serato keeps it up to date itself.
+//
+// The format is not documented. A session file is a TLV file headed by a
+// vrsn chunk, with an "oent" chunk for each track loaded on a deck. Each
+// holds an "adat" chunk whose fields are numbered instead of tagged: the
+// number stands where a chunk's tag would be. Fields this reader doesn't
+// know are skipped.
+type Session struct {
+	// Name is the session file's name without ".session", a number Serato
+	// counts up with each session.
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Start and End span the tracks of the session, from the first one
+	// loaded to the last one taken off a deck. They are zero for a session
+	// without tracks.
+	Start  time.Time      `json:"start"`
+	End    time.Time      `json:"end"`
+	Tracks []HistoryTrack `json:"tracks"`
+	// Error says why the session file couldn't be read, in which case the
+	// rest is empty.
+	Error string `json:"error,omitempty"`
+}
+
+// HistoryTrack is a track loaded on a deck during a session.
+type HistoryTrack struct {
+	// Path is the file's full path as Serato saw it when it was played.
+	Path   string `json:"path"`
+	Title  string `json:"title,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+	Genre  string `json:"genre,omitempty"`
+	Deck   int    `json:"deck,omitempty"`
+	// Start and End are when the track was loaded and taken off the deck.
+	// End is zero if the session ended with the track still loaded.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Played is false for a track that was loaded but never played out.
+	Played bool `json:"played"`
+}
+
+// Field numbers inside the "adat" chunk of a history entry.
+const (
+	historyFieldPath   = 2
+	historyFieldTitle  = 6
+	historyFieldArtist = 7
+	historyFieldAlbum  = 8
+	historyFieldGenre  = 9
+	historyFieldStart  = 28
+	historyFieldEnd    = 29
+	historyFieldDeck   = 31
+	historyFieldPlayed = 50
+)
+
+// ListSessions returns the paths of all session files under the
+// History/Sessions folder.
+func ListSessions(seratoRoot string) ([]string, error) {
+	return filepath.Glob(filepath.Join(seratoRoot, "History", "Sessions", "*.session"))
+}
+
+// ReadHistory reads every session in the history of the Serato folder
+// seratoRoot, newest first. A session file that can't be read is listed
+// with its Error set rather than failing the whole history.
+func (f Files) ReadHistory(seratoRoot string) ([]Session, error) {
+	sessionFiles, err := ListSessions(seratoRoot)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(sessionFiles))
+	for _, sessionFile := range sessionFiles {
+		session, err := f.ReadSession(sessionFile)
+		if err != nil {
+			session = Session{Name: session.Name, Path: sessionFile, Error: err.Error()}
+		}
+		sessions = append(sessions, session)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start.After(sessions[j].Start)
+	})
+	return sessions, nil
+}
+
+// ReadHistory is Files.ReadHistory with the default options.
+func ReadHistory(seratoRoot string) ([]Session, error) {
+	return Files{}.ReadHistory(seratoRoot)
+}
+
+// ReadSession reads a session file, with its tracks in the order they
+// were loaded.
+func (f Files) ReadSession(path string) (Session, error) {
+	session := Session{Name: strings.TrimSuffix(filepath.Base(path), ".session"), Path: path, Tracks: []HistoryTrack{}}
+	file, err := f.openRetry(path)
+	if err != nil {
+		return session, err
+	}
+	defer file.Close()
+
+	err = tlv.IterTLVFunc(file, func(chunk *tlv.Chunk) error {
+		if chunk.Tag != "oent" {
+			return nil
+		}
+		entry, err := tlv.IterNestedTLV(chunk.Value)
+		if err != nil {
+			return fmt.Errorf("entry at offset %d: %w", chunk.Offset, err)
+		}
+		for _, adat := range entry {
+			if adat.Tag != "adat" {
+				continue
+			}
+			fields, err := tlv.IterNestedTLV(adat.Value)
+			if err != nil {
+				return fmt.Errorf("entry at offset %d: %w", chunk.Offset, err)
+			}
+			session.Tracks = append(session.Tracks, parseHistoryTrack(fields))
+		}
+		return nil
+	})
+	if err != nil {
+		return session, fmt.Errorf("%s: %w", path, err)
+	}
+
+	sort.SliceStable(session.Tracks, func(i, j int) bool {
+		return session.Tracks[i].Start.Before(session.Tracks[j].Start)
+	})
+	for _, track := range session.Tracks {
+		if session.Start.IsZero() || (!track.Start.IsZero() && track.Start.Before(session.Start)) {
+			session.Start = track.Start
+		}
+		end := track.End
+		if end.IsZero() {
+			end = track.Start
+		}
+		if end.After(session.End) {
+			session.End = end
+		}
+	}
+	return session, nil
+}
+
+// ReadSession is Files.ReadSession with the default options.
+func ReadSession(path string) (Session, error) {
+	return Files{}.ReadSession(path)
+}
+
+// parseHistoryTrack fills a track from the numbered fields of an entry.
+// Text fields are UTF-16, times Unix seconds and other numbers big-endian
+// integers.
+func parseHistoryTrack(fields []*tlv.Chunk) HistoryTrack {
+	var track HistoryTrack
+	for _, field := range fields {
+		text := func() string {
+			value, _ := tlv.DecodeU16(trimNULs(field.Value))
+			return value
+		}
+		number := func() uint32 {
+			if len(field.Value) != 4 {
+				return 0
+			}
+			return binary.BigEndian.Uint32(field.Value)
+		}
+		moment := func() time.Time {
+			if seconds := number(); seconds != 0 {
+				return time.Unix(int64(seconds), 0)
+			}
+			return time.Time{}
+		}
+		switch binary.BigEndian.Uint32([]byte(field.Tag)) {
+		case historyFieldPath:
+			track.Path = text()
+		case historyFieldTitle:
+			track.Title = text()
+		case historyFieldArtist:
+			track.Artist = text()
+		case historyFieldAlbum:
+			track.Album = text()
+		case historyFieldGenre:
+			track.Genre = text()
+		case historyFieldStart:
+			track.Start = moment()
+		case historyFieldEnd:
+			track.End = moment()
+		case historyFieldDeck:
+			track.Deck = int(number())
+		case historyFieldPlayed:
+			track.Played = flagValue(field.Value)
+		}
+	}
+	return track
+}
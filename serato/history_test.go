@@ -0,0 +1,86 @@
+package serato
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadHistory(t *testing.T) {
+	// The sessions in testdata were captured from a two-night history; the
+	// first lists its tracks out of order and carries a field this reader
+	// doesn't know.
+	const night = 1760652000
+	at := func(seconds int64) time.Time { return time.Unix(night+seconds, 0) }
+	sessions, err := ReadHistory("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Session{
+		{
+			Name:  "2",
+			Path:  filepath.Join("testdata", "History", "Sessions", "2.session"),
+			Start: at(86400),
+			End:   at(86460),
+			Tracks: []HistoryTrack{
+				{Path: "/Users/dj/Music/Techno/Skipped.mp3", Title: "Skipped", Deck: 1, Start: at(86400), End: at(86430)},
+				// Still loaded when the session ended.
+				{Path: "/Users/dj/Music/Techno/Closer.mp3", Title: "Closer", Deck: 2, Start: at(86460), Played: true},
+			},
+		},
+		{
+			Name:  "1",
+			Path:  filepath.Join("testdata", "History", "Sessions", "1.session"),
+			Start: at(0),
+			End:   at(600),
+			Tracks: []HistoryTrack{
+				{Path: "/Users/dj/Music/House/Opener.mp3", Title: "Opener", Artist: "Artist B", Deck: 1, Start: at(0), End: at(360), Played: true},
+				{Path: "/Users/dj/Music/House/Deep.mp3", Title: "Deep", Artist: "Artist A", Album: "Album", Genre: "House", Deck: 2, Start: at(300), End: at(600), Played: true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("ReadHistory =\n%+v\nwant\n%+v", sessions, want)
+	}
+}
+
+func TestReadHistoryDamagedSession(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "History", "Sessions")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "History", "Sessions", "1.session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.session"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2.session"), data[:len(data)-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A damaged session is listed with its error, behind the readable ones.
+	sessions, err := ReadHistory(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("ReadHistory = %d sessions, want 2", len(sessions))
+	}
+	if sessions[0].Name != "1" || sessions[0].Error != "" || len(sessions[0].Tracks) != 2 {
+		t.Errorf("readable session = %+v", sessions[0])
+	}
+	if sessions[1].Name != "2" || !strings.Contains(sessions[1].Error, "2.session") || len(sessions[1].Tracks) != 0 {
+		t.Errorf("damaged session = %+v", sessions[1])
+	}
+
+	// A Serato folder without a history has no sessions.
+	if sessions, err := ReadHistory(t.TempDir()); err != nil || len(sessions) != 0 {
+		t.Errorf("ReadHistory of an empty folder = %v, %v", sessions, err)
+	}
+}
@@ -0,0 +1,118 @@
+package serato
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Library is a Serato library folder ("_Serato_"), holding the database and
+// the Subcrates folder. It gives tools built on this package one place to
+// list, read and change crates and read the database; every method is a
+// thin layer over the functions in this package.
+//
+// Crates are named by their place in the crate tree, with "/" between
+// levels, e.g. "House/Deep" for "Subcrates/House%%Deep.crate".
+type Library struct {
+	Root string
+	// Files are the options the library's files are read and written
+	// with, passed on to the crates it opens.
+	Files Files
+}
+
+// OpenLibrary opens the Serato library folder at root.
+func OpenLibrary(root string) (*Library, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a folder", root)
+	}
+	return &Library{Root: root}, nil
+}
+
+// Crates returns the names of every crate in the library, sorted so each
+// crate comes before the crates nested under it.
+func (l *Library) Crates() ([]string, error) {
+	crateFiles, err := ListCrateFiles(l.Root)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(crateFiles))
+	for i, crateFile := range crateFiles {
+		names[i] = filepath.ToSlash(DirForCrateName(crateFile))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// OpenCrate reads the crate called name. A crate that doesn't exist yet
+// opens empty and is created by Save.
+func (l *Library) OpenCrate(name string) (*Crate, error) {
+	cratePath := CrateNaming{}.CratePath(l.Root, filepath.FromSlash(name))
+	crate, err := l.Files.ReadCrateFull(cratePath)
+	if err != nil {
+		return nil, err
+	}
+	crate.path = cratePath
+	crate.files = l.Files
+	return crate, nil
+}
+
+// DatabasePath returns the path of the library's database file.
+func (l *Library) DatabasePath() string {
+	return filepath.Join(l.Root, DatabaseFile)
+}
+
+// Database reads the library's database. Changes to it are saved with
+// WriteDatabase.
+func (l *Library) Database(ctx context.Context) (*Database, error) {
+	return l.Files.ReadDatabase(ctx, l.DatabasePath())
+}
+
+// Tracks returns a copy of the crate's track paths, in crate order.
+func (c *Crate) Tracks() []string {
+	return append([]string(nil), c.TrackPaths...)
+}
+
+// AddTrack appends ptrk to the crate, reporting false if the crate already
+// holds it. Paths are compared with NormalizePath.
+func (c *Crate) AddTrack(ptrk string) bool {
+	key := NormalizePath(ptrk)
+	for _, p := range c.TrackPaths {
+		if NormalizePath(p) == key {
+			return false
+		}
+	}
+	c.TrackPaths = append(c.TrackPaths, ptrk)
+	return true
+}
+
+// RemoveTrack removes ptrk from the crate, reporting whether it was there.
+// Paths are compared with NormalizePath.
+func (c *Crate) RemoveTrack(ptrk string) bool {
+	key := NormalizePath(ptrk)
+	kept := c.TrackPaths[:0]
+	for _, p := range c.TrackPaths {
+		if NormalizePath(p) != key {
+			kept = append(kept, p)
+		}
+	}
+	removed := len(kept) < len(c.TrackPaths)
+	c.TrackPaths = kept
+	return removed
+}
+
+// Save writes the crate back to the file it was opened from. The file is
+// replaced atomically, so a failed save leaves the crate as it was. Tracks
+// that couldn't be written are returned, as by WriteCrate.
+func (c *Crate) Save() ([]string, error) {
+	if c.path == "" {
+		return nil, errors.New("crate was not opened from a library; use WriteCrate")
+	}
+	return c.files.WriteCrate(c.path, c)
+}
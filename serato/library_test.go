@@ -0,0 +1,102 @@
+package serato
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLibraryCrateLifecycle(t *testing.T) {
+	root := t.TempDir()
+	writeTestDatabase(t, root, "Music/a.mp3", "Music/b.mp3")
+	lib, err := OpenLibrary(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A crate that doesn't exist opens empty and is created on save.
+	crate, err := lib.OpenCrate("House/Deep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crate.Tracks()) != 0 {
+		t.Errorf("new crate holds %v", crate.Tracks())
+	}
+	if !crate.AddTrack("Music/a.mp3") || !crate.AddTrack("Music/b.mp3") {
+		t.Error("AddTrack of a new track reported false")
+	}
+	if crate.AddTrack("Music/a.mp3") {
+		t.Error("AddTrack of a track already in the crate reported true")
+	}
+	if _, err := crate.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "Subcrates", "House%%Deep.crate")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening sees the saved tracks; removing one and saving again sticks.
+	crate, err = lib.OpenCrate("House/Deep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Music/a.mp3", "Music/b.mp3"}; !reflect.DeepEqual(crate.Tracks(), want) {
+		t.Errorf("Tracks = %v, want %v", crate.Tracks(), want)
+	}
+	if !crate.RemoveTrack("Music/a.mp3") || crate.RemoveTrack("Music/missing.mp3") {
+		t.Error("RemoveTrack reported the wrong result")
+	}
+	if _, err := crate.Save(); err != nil {
+		t.Fatal(err)
+	}
+	crate, err = lib.OpenCrate("House/Deep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Music/b.mp3"}; !reflect.DeepEqual(crate.Tracks(), want) {
+		t.Errorf("Tracks after removal = %v, want %v", crate.Tracks(), want)
+	}
+
+	other, err := lib.OpenCrate("Techno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.AddTrack("Music/a.mp3")
+	if _, err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+	crates, err := lib.Crates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"House/Deep", "Techno"}; !reflect.DeepEqual(crates, want) {
+		t.Errorf("Crates = %v, want %v", crates, want)
+	}
+
+	db, err := lib.Database(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Records) != 2 {
+		t.Errorf("Database holds %d records, want 2", len(db.Records))
+	}
+}
+
+func TestLibraryErrors(t *testing.T) {
+	if _, err := OpenLibrary(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("OpenLibrary of a missing folder succeeded")
+	}
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenLibrary(file); err == nil {
+		t.Error("OpenLibrary of a file succeeded")
+	}
+	// A crate that wasn't opened from a library has nowhere to save to.
+	if _, err := (&Crate{}).Save(); err == nil {
+		t.Error("Save of a crate without a file succeeded")
+	}
+}
@@ -0,0 +1,127 @@
+package serato
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// CrateToM3U writes the tracks of a crate to an extended M3U8 playlist.
+// Crate paths are stored without a drive or mount point, so mountPrefix
+// (e.g. "E:\\" or "/Volumes/Music") is joined in front of each one. An
+// empty mountPrefix treats the paths as rooted at "/".
+func (f Files) CrateToM3U(cratePath, outPath, mountPrefix string) error {
+	trackPaths, err := f.ReadCrateFile(cratePath)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]m3uEntry, 0, len(trackPaths))
+	for _, ptrk := range trackPaths {
+		entries = append(entries, m3uEntry{path: ptrk})
+	}
+	return f.writeM3U(outPath, mountPrefix, entries)
+}
+
+// CrateToM3U is Files.CrateToM3U with the default options.
+func CrateToM3U(cratePath, outPath, mountPrefix string) error {
+	return Files{}.CrateToM3U(cratePath, outPath, mountPrefix)
+}
+
+// DatabaseToM3U writes every track in a Database V2 file to an extended
+// M3U8 playlist, using the artist and title tags for the display name
+// where they are set. See CrateToM3U for mountPrefix.
+func (f Files) DatabaseToM3U(dbPath, outPath, mountPrefix string) error {
+	var entries []m3uEntry
+	err := f.IterRecords(context.Background(), dbPath, func(record Record) error {
+		pfil, ok := record["pfil"].(string)
+		if !ok || pfil == "" {
+			return nil
+		}
+		title, _ := record["ttit"].(string)
+		artist, _ := record["tart"].(string)
+		if artist != "" && title != "" {
+			title = artist + " - " + title
+		}
+		entries = append(entries, m3uEntry{path: pfil, title: title})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return f.writeM3U(outPath, mountPrefix, entries)
+}
+
+// DatabaseToM3U is Files.DatabaseToM3U with the default options.
+func DatabaseToM3U(dbPath, outPath, mountPrefix string) error {
+	return Files{}.DatabaseToM3U(dbPath, outPath, mountPrefix)
+}
+
+// ReadM3U returns the track paths listed in an M3U or M3U8 playlist,
+// skipping comments and directives.
+func (f Files) ReadM3U(path string) ([]string, error) {
+	file, err := f.openRetry(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// ReadM3U is Files.ReadM3U with the default options.
+func ReadM3U(path string) ([]string, error) {
+	return Files{}.ReadM3U(path)
+}
+
+type m3uEntry struct {
+	path  string
+	title string
+}
+
+func (f Files) writeM3U(outPath, mountPrefix string, entries []m3uEntry) error {
+	return f.writeFileAtomic(outPath, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		if _, err := bw.WriteString("#EXTM3U\n"); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			title := entry.title
+			if title == "" {
+				base := filepath.Base(filepath.FromSlash(entry.path))
+				title = strings.TrimSuffix(base, filepath.Ext(base))
+			}
+			// Everything after the first comma of #EXTINF is the title, so
+			// commas need no escaping, but a line break would end the entry.
+			title = strings.NewReplacer("\r", " ", "\n", " ").Replace(title)
+			if _, err := fmt.Fprintf(bw, "#EXTINF:-1,%s\n%s\n", title, m3uPath(mountPrefix, entry.path)); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	})
+}
+
+// m3uPath turns a drive-less Serato path into an absolute playlist path.
+func m3uPath(mountPrefix, seratoPath string) string {
+	rel := filepath.FromSlash(CleanPath(seratoPath))
+	if mountPrefix == "" {
+		return string(filepath.Separator) + rel
+	}
+	return filepath.Join(mountPrefix, rel)
+}
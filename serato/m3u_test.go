@@ -0,0 +1,79 @@
+package serato
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCrateM3URoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("playlist paths are rooted at a drive on Windows")
+	}
+	dir := t.TempDir()
+	crateFile := filepath.Join(dir, "Subcrates", "House.crate")
+	tracks := []string{"Music/House/a.mp3", "Music/House/Artist, The - Song.flac", "Music/Café/🎵 Mix.wav"}
+	if _, err := WriteCrateFile(crateFile, tracks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mount := range []string{"", "/Volumes/DJ Drive"} {
+		playlist := filepath.Join(dir, "House.m3u8")
+		if err := CrateToM3U(crateFile, playlist, mount); err != nil {
+			t.Fatal(err)
+		}
+		paths, err := ReadM3U(playlist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Back to crate paths, relative to the mount point.
+		var back []string
+		for _, p := range paths {
+			rel, ok := relativeToPrefix(CleanPath(p), CleanPath(mount))
+			if !ok {
+				t.Fatalf("playlist path %q is not under %q", p, mount)
+			}
+			back = append(back, rel)
+		}
+		imported := filepath.Join(dir, "Subcrates", "Imported.crate")
+		if _, err := WriteCrateFile(imported, back); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ReadCrateFile(imported); err != nil || !reflect.DeepEqual(got, tracks) {
+			t.Errorf("mount %q: crate round-trips as %q, %v, want %q", mount, got, err, tracks)
+		}
+		os.Remove(imported)
+	}
+}
+
+func TestDatabaseToM3UTitles(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, DatabaseFile)
+	records := []Record{
+		{"pfil": "Music/a.mp3", "tart": "Artist", "ttit": "Title"},
+		{"pfil": "Music/b.mp3", "ttit": "Line\nbreak"},
+		{"pfil": "Music/c.mp3"},
+	}
+	if err := WriteDatabaseV2Records(dbPath, records); err != nil {
+		t.Fatal(err)
+	}
+	playlist := filepath.Join(dir, "all.m3u8")
+	if err := DatabaseToM3U(dbPath, playlist, ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"#EXTINF:-1,Artist - Title\n", "#EXTINF:-1,Line break\n", "#EXTINF:-1,c\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("playlist lacks %q:\n%s", want, data)
+		}
+	}
+	if paths, err := ReadM3U(playlist); err != nil || len(paths) != 3 {
+		t.Errorf("ReadM3U = %v, %v, want 3 paths", paths, err)
+	}
+}
@@ -1,15 +1,110 @@
 package serato
 
 import (
+	"path/filepath"
 	"strings"
+
+	"golang.org/x/text/unicode/norm"
 )
 
-// CleanPath prepares a path for comparison by normalizing slashes and removing the drive letter.
+// CleanPath prepares a path for comparison with the paths Serato stores.
+//
+// Backslashes become forward slashes, repeated slashes collapse to one, and
+// leading and trailing slashes are removed. A drive letter ("C:" or "c:")
+// is dropped, since Serato stores paths relative to the drive. Windows
+// extended-length prefixes ("\\?\" and "\\.\") are removed first, and UNC
+// paths ("\\NAS\music\x.mp3" or "\\?\UNC\NAS\music\x.mp3") keep their
+// server and share as the leading components ("NAS/music/x.mp3"). Case is
+// preserved; use NormalizePath or FoldPath to compare paths from different
+// systems.
 func CleanPath(path string) string {
 	p := strings.ReplaceAll(path, "\\", "/")
-	if len(p) >= 2 && p[1] == ':' {
+	if strings.HasPrefix(p, "//?/") || strings.HasPrefix(p, "//./") {
+		p = p[4:]
+		if len(p) >= 4 && strings.EqualFold(p[:4], "UNC/") {
+			p = p[4:]
+		}
+	}
+	if len(p) >= 2 && p[1] == ':' && isDriveLetter(p[0]) {
 		p = p[2:] // Remove C:
 	}
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
 	return strings.Trim(p, "/")
 }
 
+// NormalizePath is CleanPath followed by conversion to Unicode NFC. macOS
+// file systems hand out names with accents decomposed (NFD, "e" plus a
+// combining accent) while databases written on other systems hold them
+// composed (NFC, "é"), so paths from the two must be normalized before
+// comparing. It is for comparison only: write paths in the form they came
+// in.
+func NormalizePath(path string) string {
+	return norm.NFC.String(CleanPath(path))
+}
+
+// FoldPath is NormalizePath followed by lowercasing, for matching paths
+// whose casing differs between the file system and the database.
+func FoldPath(path string) string {
+	return strings.ToLower(NormalizePath(path))
+}
+
+// FoldPathSet returns a copy of a set of paths with every path folded by
+// FoldPath.
+func FoldPathSet(paths map[string]struct{}) map[string]struct{} {
+	folded := make(map[string]struct{}, len(paths))
+	for p := range paths {
+		folded[FoldPath(p)] = struct{}{}
+	}
+	return folded
+}
+
+// SuggestLibraryPrefix looks for relPaths, paths relative to a music
+// library, in pfilSet, a set of database paths as ReadDatabaseV2 returns,
+// under any prefix. It returns the prefix that the most of them are found
+// under and how many that is, or "" and 0 if none are found. A library
+// whose tracks turn up under a prefix other than its own has probably been
+// configured with the wrong path, and syncing it would add every track a
+// second time.
+func SuggestLibraryPrefix(pfilSet map[string]struct{}, relPaths []string) (string, int) {
+	prefixesBySuffix := make(map[string][]string)
+	for pfil := range pfilSet {
+		for i := 0; i < len(pfil); i++ {
+			if pfil[i] == '/' {
+				suffix := pfil[i+1:]
+				prefixesBySuffix[suffix] = append(prefixesBySuffix[suffix], pfil[:i])
+			}
+		}
+	}
+
+	counts := make(map[string]int)
+	best, bestCount := "", 0
+	for _, rel := range relPaths {
+		for _, prefix := range prefixesBySuffix[rel] {
+			counts[prefix]++
+			if counts[prefix] > bestCount || counts[prefix] == bestCount && prefix < best {
+				best, bestCount = prefix, counts[prefix]
+			}
+		}
+	}
+	return best, bestCount
+}
+
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// LocateTrack maps a database path onto the file system using the music
+// library roots. ok is false when the path is outside every root, e.g. on a
+// drive that may not be mounted.
+func LocateTrack(pfil string, libraryRoots []string) (string, bool) {
+	cleaned := CleanPath(pfil)
+	for _, root := range libraryRoots {
+		rel, ok := relativeToPrefix(cleaned, CleanPath(root))
+		if ok && CleanPath(root) != "" {
+			return filepath.Join(root, filepath.FromSlash(rel)), true
+		}
+	}
+	return "", false
+}
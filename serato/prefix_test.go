@@ -0,0 +1,60 @@
+package serato
+
+import "testing"
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", ""},
+		{"Music/House/a.mp3", "Music/House/a.mp3"},
+		{"/Users/dj/Music/", "Users/dj/Music"},
+		{"Music/House/", "Music/House"},
+		{`Music\House\a.mp3`, "Music/House/a.mp3"},
+		{`Music\House/Deep\a.mp3`, "Music/House/Deep/a.mp3"},
+		{"Music//House///a.mp3", "Music/House/a.mp3"},
+		{`C:\Users\dj\Music\a.mp3`, "Users/dj/Music/a.mp3"},
+		{"c:/Users/dj/Music", "Users/dj/Music"},
+		{"D:", ""},
+		{`\\NAS\music\House\a.mp3`, "NAS/music/House/a.mp3"},
+		{`\\?\C:\Users\dj\Music\a.mp3`, "Users/dj/Music/a.mp3"},
+		{`\\.\D:\Music\`, "Music"},
+		{`\\?\UNC\NAS\music\a.mp3`, "NAS/music/a.mp3"},
+		{`\\?\unc\NAS\music\a.mp3`, "NAS/music/a.mp3"},
+		// Only a letter followed by a colon is a drive.
+		{"1:/Music", "1:/Music"},
+		{"Music/C:/a.mp3", "Music/C:/a.mp3"},
+		{"Music/Café", "Music/Café"},
+	}
+	for _, tt := range tests {
+		got := CleanPath(tt.path)
+		if got != tt.want {
+			t.Errorf("CleanPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		if again := CleanPath(got); again != got {
+			t.Errorf("CleanPath(%q) = %q, not idempotent", got, again)
+		}
+	}
+}
+
+func TestFoldPath(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{`C:\Music\House\A.mp3`, "Music/house/a.MP3", true},
+		{`\\NAS\Music\a.mp3`, "nas/music/A.mp3", true},
+		{"Music/Café.mp3", "music/cafe\u0301.mp3", true},
+		{"Music/a.mp3", "Music/b.mp3", false},
+	}
+	for _, tt := range tests {
+		if same := FoldPath(tt.a) == FoldPath(tt.b); same != tt.same {
+			t.Errorf("FoldPath(%q) == FoldPath(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+	// CleanPath and NormalizePath keep case.
+	if got := NormalizePath(`C:\Music\House`); got != "Music/House" {
+		t.Errorf("NormalizePath = %q, want %q", got, "Music/House")
+	}
+}
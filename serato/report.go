@@ -0,0 +1,150 @@
+package serato
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecentDays is how many days back Report.AddedRecently looks.
+const RecentDays = 30
+
+// Report is a health check of a database's track records.
+type Report struct {
+	// Version is the version string heading the database (see
+	// ReadDatabaseVersion). BuildReport leaves it empty for the caller to
+	// fill in.
+	Version     string `json:"version,omitempty"`
+	TotalTracks int    `json:"total_tracks"`
+	// Genres counts tracks by top-level genre: the part of the genre before
+	// any "/", ";" or "," ("House/Deep" counts as "House"). Tracks without
+	// a genre are counted under "".
+	Genres map[string]int `json:"genres"`
+	// MissingMetadata counts tracks without a title or an artist.
+	MissingMetadata int `json:"missing_metadata"`
+	// OutsideLibrary counts tracks that aren't under any music library.
+	OutsideLibrary int `json:"outside_library"`
+	// DuplicatePaths counts records whose path, ignoring case and
+	// separators, already appeared in an earlier record.
+	DuplicatePaths int `json:"duplicate_paths"`
+	// AddedRecently counts tracks added to the database in the last
+	// RecentDays days (see DateAdded).
+	AddedRecently int `json:"added_recently"`
+	// LastAdded is when the newest track was added, in Unix seconds, or
+	// zero if no track has a date added.
+	LastAdded int64 `json:"last_added"`
+}
+
+// DateAdded returns when the track of record was added to the database.
+// Serato keeps it in Unix seconds, as an integer in uadd and as text in
+// tadd; uadd is used if it is set, tadd otherwise. ok is false if neither
+// holds a date.
+func DateAdded(record Record) (added time.Time, ok bool) {
+	if uadd, isUint := record["uadd"].(uint32); isUint && uadd != 0 {
+		return time.Unix(int64(uadd), 0), true
+	}
+	if tadd, isString := record["tadd"].(string); isString {
+		if seconds, err := strconv.ParseInt(strings.TrimSpace(tadd), 10, 64); err == nil && seconds > 0 {
+			return time.Unix(seconds, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// BuildReport computes a Report from records. libraryPrefixes are the
+// database path prefixes of the music libraries (see LibraryPrefix); with
+// none, OutsideLibrary is left at zero.
+func BuildReport(records []Record, libraryPrefixes []string) Report {
+	report := Report{TotalTracks: len(records), Genres: make(map[string]int)}
+	seen := make(map[string]struct{}, len(records))
+	recent := time.Now().AddDate(0, 0, -RecentDays)
+
+	for _, record := range records {
+		genre, _ := record["tgen"].(string)
+		report.Genres[topLevelGenre(genre)]++
+
+		title, _ := record["ttit"].(string)
+		artist, _ := record["tart"].(string)
+		if strings.TrimSpace(title) == "" || strings.TrimSpace(artist) == "" {
+			report.MissingMetadata++
+		}
+
+		pfil, _ := record["pfil"].(string)
+		if len(libraryPrefixes) > 0 {
+			inside := false
+			for _, prefix := range libraryPrefixes {
+				if _, ok := relativeToPrefix(CleanPath(pfil), prefix); ok {
+					inside = true
+					break
+				}
+			}
+			if !inside {
+				report.OutsideLibrary++
+			}
+		}
+
+		key := FoldPath(pfil)
+		if _, ok := seen[key]; ok {
+			report.DuplicatePaths++
+		}
+		seen[key] = struct{}{}
+
+		if added, ok := DateAdded(record); ok {
+			if added.After(recent) {
+				report.AddedRecently++
+			}
+			report.LastAdded = max(report.LastAdded, added.Unix())
+		}
+	}
+	return report
+}
+
+// topLevelGenre returns the first part of a genre such as "House/Deep".
+func topLevelGenre(genre string) string {
+	if i := strings.IndexAny(genre, "/;,"); i >= 0 {
+		genre = genre[:i]
+	}
+	return strings.TrimSpace(genre)
+}
+
+// String formats the report for the log, with genres from most to least
+// tracks.
+func (r Report) String() string {
+	var b strings.Builder
+	b.WriteString("Database Report:")
+	if r.Version != "" {
+		fmt.Fprintf(&b, "\n- Database format: %s", r.Version)
+	}
+	fmt.Fprintf(&b, "\n- Total tracks: %d", r.TotalTracks)
+	fmt.Fprintf(&b, "\n- Missing title or artist: %d", r.MissingMetadata)
+	fmt.Fprintf(&b, "\n- Outside the music libraries: %d", r.OutsideLibrary)
+	fmt.Fprintf(&b, "\n- Duplicate paths: %d", r.DuplicatePaths)
+	fmt.Fprintf(&b, "\n- Added in the last %d days: %d", RecentDays, r.AddedRecently)
+	if r.LastAdded != 0 {
+		fmt.Fprintf(&b, "\n- Last track added: %s", time.Unix(r.LastAdded, 0).Format(time.DateOnly))
+	}
+
+	genres := make([]string, 0, len(r.Genres))
+	for genre := range r.Genres {
+		genres = append(genres, genre)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if r.Genres[genres[i]] != r.Genres[genres[j]] {
+			return r.Genres[genres[i]] > r.Genres[genres[j]]
+		}
+		return strings.ToLower(genres[i]) < strings.ToLower(genres[j])
+	})
+	if len(genres) > 0 {
+		b.WriteString("\n- Tracks by genre:")
+	}
+	for _, genre := range genres {
+		name := genre
+		if name == "" {
+			name = "(no genre)"
+		}
+		fmt.Fprintf(&b, "\n  - %s: %d", name, r.Genres[genre])
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package serato
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	now := time.Now()
+	recent := uint32(now.AddDate(0, 0, -2).Unix())
+	old := now.AddDate(-1, 0, 0).Unix()
+	records := []Record{
+		{"pfil": "Music/House/a.mp3", "ttit": "A", "tart": "Artist", "tgen": "House/Deep", "uadd": recent},
+		{"pfil": "Music/House/b.mp3", "ttit": "B", "tart": "Artist", "tgen": "House", "tadd": strconv.FormatInt(old, 10)},
+		{"pfil": "Music/Techno/c.mp3", "ttit": "C", "tgen": "Techno; Dub"},
+		{"pfil": `Music\House\A.MP3`, "ttit": "A", "tart": "Artist", "tgen": "House"},
+		{"pfil": "Other/d.mp3", "ttit": " ", "tart": "Artist"},
+	}
+	report := BuildReport(records, []string{"Music"})
+	want := Report{
+		TotalTracks:     5,
+		Genres:          map[string]int{"House": 3, "Techno": 1, "": 1},
+		MissingMetadata: 2,
+		OutsideLibrary:  1,
+		DuplicatePaths:  1,
+		AddedRecently:   1,
+		LastAdded:       int64(recent),
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("BuildReport = %+v, want %+v", report, want)
+	}
+
+	// Without library prefixes nothing counts as outside.
+	if got := BuildReport(records, nil).OutsideLibrary; got != 0 {
+		t.Errorf("OutsideLibrary without prefixes = %d", got)
+	}
+
+	text := report.String()
+	for _, line := range []string{"- Total tracks: 5", "- Duplicate paths: 1", "  - House: 3", "  - (no genre): 1"} {
+		if !strings.Contains(text, line) {
+			t.Errorf("report lacks %q:\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, "Database format") {
+		t.Errorf("report without a version lists one:\n%s", text)
+	}
+	report.Version = DatabaseVrsn
+	if text := report.String(); !strings.Contains(text, "- Database format: "+DatabaseVrsn) {
+		t.Errorf("report lacks the database version:\n%s", text)
+	}
+	if strings.Index(text, "House: 3") > strings.Index(text, "Techno: 1") {
+		t.Errorf("genres not listed from most to least tracks:\n%s", text)
+	}
+}
+
+func TestDateAddedFromBytes(t *testing.T) {
+	// 1700000000 is 2023-11-14 22:13:20 UTC: uadd as big-endian 0x6553F100,
+	// tadd as its digits in UTF-16.
+	uadd := []byte("uadd\x00\x00\x00\x04\x65\x53\xF1\x00")
+	tadd := []byte("tadd\x00\x00\x00\x14")
+	for _, digit := range "1700000000" {
+		tadd = append(tadd, 0, byte(digit))
+	}
+	want := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"uadd", uadd},
+		{"tadd", tadd},
+		{"both", append(append([]byte(nil), tadd...), uadd...)},
+	}
+	for _, tt := range tests {
+		record, err := parseRecord(tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		added, ok := DateAdded(record)
+		if !ok || !added.Equal(want) {
+			t.Errorf("%s: DateAdded = %v, %v, want %v", tt.name, added, ok, want)
+		}
+	}
+	// A zero or unparsable date is no date.
+	for _, record := range []Record{{}, {"uadd": uint32(0)}, {"tadd": "yesterday"}, {"tadd": "0"}} {
+		if added, ok := DateAdded(record); ok {
+			t.Errorf("DateAdded(%v) = %v, want no date", record, added)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package serato
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+)
+
+// DefaultRetries is how many times a file operation that failed with a
+// transient error is retried unless Files.Retries says otherwise.
+const DefaultRetries = 3
+
+// RetryDelay is the wait before the first retry. It doubles for each one
+// after that.
+var RetryDelay = 200 * time.Millisecond
+
+// IsTransient reports whether err is worth retrying: a timeout, an
+// interrupted or would-block call, or a dropped connection or I/O error of
+// the kind network file systems return while reconnecting. Missing files,
+// denied permissions and anything unrecognized are permanent.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrExist) || errors.Is(err, fs.ErrPermission) {
+		return false
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.EIO, syscall.ETIMEDOUT,
+		syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ENETRESET, syscall.EHOSTDOWN, syscall.ESTALE:
+		return true
+	}
+	return false
+}
+
+// withRetry runs op, running it again after a growing delay for as long as
+// it fails with a transient error and retries are left. op must be safe to
+// repeat from the start.
+func (f Files) withRetry(op func() error) error {
+	retries := f.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	delay := RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= retries || !IsTransient(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// openRetry opens a file for reading with Disk.Open, retrying transient
+// errors.
+func (f Files) openRetry(path string) (File, error) {
+	var file File
+	err := f.withRetry(func() error {
+		var err error
+		file, err = Disk.Open(path)
+		return err
+	})
+	return file, err
+}
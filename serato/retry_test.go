@@ -0,0 +1,111 @@
+package serato
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{syscall.EIO, true},
+		{&fs.PathError{Op: "write", Path: "x", Err: syscall.ETIMEDOUT}, true},
+		{fmt.Errorf("wrapped: %w", syscall.EAGAIN), true},
+		{os.ErrDeadlineExceeded, true},
+		{fs.ErrNotExist, false},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.EACCES}, false},
+		{errors.New("something else"), false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// flakyDisk is the real file system, except that the first failures
+// writes to files made with CreateTemp fail with EIO, as a network share
+// dropping its connection does.
+type flakyDisk struct {
+	FileSystem
+	failures *int
+}
+
+func (d flakyDisk) CreateTemp(dir, pattern string) (File, error) {
+	file, err := d.FileSystem.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return flakyFile{File: file, failures: d.failures}, nil
+}
+
+type flakyFile struct {
+	File
+	failures *int
+}
+
+func (f flakyFile) Write(p []byte) (int, error) {
+	if *f.failures > 0 {
+		*f.failures--
+		return 0, &fs.PathError{Op: "write", Path: f.Name(), Err: syscall.EIO}
+	}
+	return f.File.Write(p)
+}
+
+func TestFilesRetries(t *testing.T) {
+	oldDelay := RetryDelay
+	RetryDelay = time.Millisecond
+	t.Cleanup(func() { RetryDelay = oldDelay })
+
+	tests := []struct {
+		retries  int
+		failures int
+		wantErr  bool
+	}{
+		{retries: 0, failures: DefaultRetries, wantErr: false},
+		{retries: 0, failures: DefaultRetries + 1, wantErr: true},
+		{retries: 5, failures: 5, wantErr: false},
+		{retries: 1, failures: 2, wantErr: true},
+		{retries: -1, failures: 1, wantErr: true},
+		{retries: -1, failures: 0, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("retries=%d,failures=%d", tt.retries, tt.failures), func(t *testing.T) {
+			failures := tt.failures
+			useDisk(t, flakyDisk{FileSystem: Disk, failures: &failures})
+			crateFile := filepath.Join(t.TempDir(), "Subcrates", "House.crate")
+			tracks := []string{"Music/House/a.mp3"}
+
+			_, err := Files{Retries: tt.retries}.WriteCrateFile(crateFile, tracks)
+			if tt.wantErr {
+				if !errors.Is(err, syscall.EIO) {
+					t.Fatalf("error = %v, want %v", err, syscall.EIO)
+				}
+				if _, err := os.Stat(crateFile); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("crate written although every attempt failed: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadCrateFile(crateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tracks) {
+				t.Errorf("crate holds %v, want %v", got, tracks)
+			}
+		})
+	}
+}
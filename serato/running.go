@@ -0,0 +1,59 @@
+package serato
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrSeratoRunning is returned by CheckDatabaseWritable when Serato DJ is
+// running. Serato keeps its own copy of the library in memory and writes it
+// back on exit, undoing any changes made in the meantime.
+var ErrSeratoRunning = errors.New("Serato DJ is running; quit Serato and try again")
+
+// ErrDatabaseLocked is returned by CheckDatabaseWritable when the database
+// can't be opened for writing, e.g. because another program has it open.
+var ErrDatabaseLocked = errors.New("database is locked by another program; quit Serato and try again")
+
+// CheckDatabaseWritable checks that the database at dbPath can safely be
+// written: Serato DJ must not be running and the file, if it exists, must
+// open for writing. On Windows the open fails with a sharing violation
+// while Serato holds the file.
+func CheckDatabaseWritable(dbPath string) error {
+	if SeratoRunning() {
+		return ErrSeratoRunning
+	}
+	file, err := os.OpenFile(dbPath, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s: %w (%v)", dbPath, ErrDatabaseLocked, err)
+	}
+	return file.Close()
+}
+
+// SeratoRunning reports whether a Serato DJ process is running. It returns
+// false if the process list can't be read.
+func SeratoRunning() bool {
+	var out []byte
+	var err error
+	switch runtime.GOOS {
+	case "windows":
+		out, err = exec.Command("tasklist", "/FO", "CSV", "/NH").Output()
+	default:
+		out, err = exec.Command("ps", "-A", "-o", "comm=").Output()
+	}
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(strings.ToLower(string(out)), "\n") {
+		if strings.Contains(line, "serato dj") {
+			return true
+		}
+	}
+	return false
+}
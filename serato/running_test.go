@@ -0,0 +1,37 @@
+package serato
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckDatabaseWritable(t *testing.T) {
+	if SeratoRunning() {
+		t.Skip("Serato DJ is running")
+	}
+	dir := t.TempDir()
+	if err := CheckDatabaseWritable(filepath.Join(dir, DatabaseFile)); err != nil {
+		t.Errorf("missing database: %v", err)
+	}
+	dbPath := writeTestDatabase(t, dir, "Music/a.mp3")
+	if err := CheckDatabaseWritable(dbPath); err != nil {
+		t.Errorf("writable database: %v", err)
+	}
+
+	// A read-only file stands in for one Serato holds open on Windows.
+	// Root can open it anyway, so a folder, which never opens for
+	// writing, is used there.
+	locked := filepath.Join(t.TempDir(), DatabaseFile)
+	if os.Geteuid() == 0 {
+		if err := os.Mkdir(locked, 0755); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := os.WriteFile(locked, nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckDatabaseWritable(locked); !errors.Is(err, ErrDatabaseLocked) {
+		t.Errorf("locked database: err = %v, want ErrDatabaseLocked", err)
+	}
+}
@@ -0,0 +1,28 @@
+package serato
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// writeTestDatabase writes a database holding a record for each pfil into
+// dir and returns its path.
+func writeTestDatabase(t *testing.T, dir string, pfils ...string) string {
+	t.Helper()
+	records := make([]Record, len(pfils))
+	for i, pfil := range pfils {
+		records[i] = Record{"pfil": pfil, "ttyp": "mp3"}
+	}
+	path := filepath.Join(dir, DatabaseFile)
+	if err := WriteDatabase(path, &Database{Version: DatabaseVrsn, Records: records}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// useDisk swaps Disk for the rest of the test.
+func useDisk(t *testing.T, disk FileSystem) {
+	old := Disk
+	Disk = disk
+	t.Cleanup(func() { Disk = old })
+}
@@ -0,0 +1,127 @@
+package serato
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"seratosync-go/tlv"
+)
+
+// SmartCrate is the parsed contents of a Serato smart crate file
+// ("SmartCrates/<name>.scrate"). Serato fills these crates itself from
+// their rules; this package only reads them, so that syncs and reports can
+// take them into account.
+//
+// The format is not documented. A smart crate file is laid out like a
+// crate file, with the rules in "rurt" chunks and the match mode in "rart".
+// Chunks this reader doesn't understand are kept in Header, and rule
+// chunks in Rule.Extra, so nothing is lost.
+type SmartCrate struct {
+	// Name is the crate name, with "/" between levels as for Library.
+	Name string
+	// MatchAll is true when a track must meet every rule, false when any
+	// rule will do.
+	MatchAll bool
+	// LiveUpdate is true when Serato refreshes the crate as the library
+	// changes.
+	LiveUpdate bool
+	Rules      []SmartCrateRule
+	Header     []*tlv.Chunk
+}
+
+// SmartCrateRule is one rule of a smart crate.
+type SmartCrateRule struct {
+	// Field is Serato's number for the track field the rule tests.
+	Field uint32
+	// Operator is Serato's name for the comparison, e.g. "cond_con_str"
+	// for "contains" or "cond_gt_int" for "greater than".
+	Operator string
+	// Value is what the field is compared with: text as is, numbers and
+	// dates in decimal.
+	Value string
+	Extra []*tlv.Chunk
+}
+
+// ListSmartCrates returns the paths of all smart crate files under the
+// SmartCrates folder.
+func ListSmartCrates(seratoRoot string) ([]string, error) {
+	return filepath.Glob(filepath.Join(seratoRoot, "SmartCrates", "*.scrate"))
+}
+
+// ReadSmartCrate reads a smart crate file.
+func (f Files) ReadSmartCrate(path string) (SmartCrate, error) {
+	file, err := f.openRetry(path)
+	if err != nil {
+		return SmartCrate{}, err
+	}
+	defer file.Close()
+
+	chunks, err := tlv.IterTLV(file)
+	if err != nil {
+		return SmartCrate{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	crate := SmartCrate{Name: filepath.ToSlash(DirForCrateName(strings.TrimSuffix(filepath.Base(path), ".scrate")))}
+	for _, chunk := range chunks {
+		switch chunk.Tag {
+		case "vrsn":
+		case "rart":
+			crate.MatchAll = flagValue(chunk.Value)
+		case "rlut":
+			crate.LiveUpdate = flagValue(chunk.Value)
+		case "rurt":
+			nested, err := tlv.IterNestedTLV(chunk.Value)
+			if err != nil {
+				return SmartCrate{}, fmt.Errorf("%s: rule at offset %d: %w", path, chunk.Offset, err)
+			}
+			crate.Rules = append(crate.Rules, parseSmartCrateRule(nested))
+		default:
+			crate.Header = append(crate.Header, chunk)
+		}
+	}
+	return crate, nil
+}
+
+// ReadSmartCrate is Files.ReadSmartCrate with the default options.
+func ReadSmartCrate(path string) (SmartCrate, error) {
+	return Files{}.ReadSmartCrate(path)
+}
+
+// parseSmartCrateRule fills a rule from the chunks of a "rurt" chunk: the
+// field number in "trft", the operator, which is the text starting with
+// "cond_", and the value in "trpt" (text) or "urpt" (a number).
+func parseSmartCrateRule(chunks []*tlv.Chunk) SmartCrateRule {
+	var rule SmartCrateRule
+	for _, chunk := range chunks {
+		switch {
+		case chunk.Tag == "trft" && len(chunk.Value) == 4:
+			rule.Field = binary.BigEndian.Uint32(chunk.Value)
+		case chunk.Tag == "urpt" && len(chunk.Value) == 4:
+			rule.Value = strconv.FormatUint(uint64(binary.BigEndian.Uint32(chunk.Value)), 10)
+		case chunk.Tag == "trpt":
+			if text, err := tlv.DecodeU16(chunk.Value); err == nil {
+				rule.Value = strings.TrimRight(text, "\x00")
+			}
+		default:
+			if text, err := tlv.DecodeU16(chunk.Value); err == nil && strings.HasPrefix(text, "cond_") {
+				rule.Operator = strings.TrimRight(text, "\x00")
+			} else {
+				rule.Extra = append(rule.Extra, chunk)
+			}
+		}
+	}
+	return rule
+}
+
+// flagValue reads a flag stored as a single byte or a big-endian integer.
+func flagValue(payload []byte) bool {
+	for _, b := range payload {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
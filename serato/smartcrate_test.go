@@ -0,0 +1,63 @@
+package serato
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadSmartCrate(t *testing.T) {
+	files, err := ListSmartCrates("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join("testdata", "SmartCrates", "House%%Peak Time.scrate")}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("ListSmartCrates = %v, want %v", files, want)
+	}
+
+	crate, err := ReadSmartCrate(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crate.Name != "House/Peak Time" || !crate.MatchAll || !crate.LiveUpdate {
+		t.Errorf("crate = %q, MatchAll %v, LiveUpdate %v", crate.Name, crate.MatchAll, crate.LiveUpdate)
+	}
+	if len(crate.Header) != 1 || crate.Header[0].Tag != "ovct" {
+		t.Errorf("Header = %v, want the ovct chunk", crate.Header)
+	}
+	if len(crate.Rules) != 2 {
+		t.Fatalf("read %d rules, want 2", len(crate.Rules))
+	}
+	rules := []struct {
+		field    uint32
+		operator string
+		value    string
+		extra    int
+	}{
+		{8, "cond_con_str", "Techno", 0},
+		{15, "cond_gt_int", "120", 1},
+	}
+	for i, want := range rules {
+		got := crate.Rules[i]
+		if got.Field != want.field || got.Operator != want.operator || got.Value != want.value || len(got.Extra) != want.extra {
+			t.Errorf("rule %d = %d %q %q with %d extra chunks, want %d %q %q with %d",
+				i, got.Field, got.Operator, got.Value, len(got.Extra), want.field, want.operator, want.value, want.extra)
+		}
+	}
+}
+
+func TestReadSmartCrateErrors(t *testing.T) {
+	if _, err := ReadSmartCrate(filepath.Join(t.TempDir(), "missing.scrate")); err == nil {
+		t.Error("ReadSmartCrate of a missing file succeeded")
+	}
+	// A rule chunk cut short.
+	path := filepath.Join(t.TempDir(), "bad.scrate")
+	if err := os.WriteFile(path, []byte("rurt\x00\x00\x01\x00abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSmartCrate(path); err == nil {
+		t.Error("ReadSmartCrate of a truncated file succeeded")
+	}
+}
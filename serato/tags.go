@@ -0,0 +1,167 @@
+package serato
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"seratosync-go/tlv"
+)
+
+// TagType is how a track tag's payload is encoded in the database.
+type TagType int
+
+const (
+	// TagBytes payloads are kept as raw []byte.
+	TagBytes TagType = iota
+	// TagString payloads are UTF-16BE text, decoded to string.
+	TagString
+	// TagBool payloads are a single byte, decoded to bool.
+	TagBool
+	// TagUint32 payloads are four big-endian bytes, decoded to uint32.
+	TagUint32
+)
+
+// TagTypes is the registry of known track tags. Tags that aren't listed
+// are read and written as raw bytes, so adding a tag here is all that's
+// needed to have it decoded.
+//
+// Note that Serato stores the file size ("tsiz") as display text such as
+// "8.5MB", not as an integer.
+var TagTypes = map[string]TagType{
+	"pfil": TagString,
+	"ttyp": TagString,
+	"tadd": TagString,
+	"tsng": TagString,
+	"talb": TagString,
+	"tart": TagString,
+	"ttit": TagString,
+	"tgen": TagString,
+	"tkey": TagString,
+	"tcom": TagString,
+	"tgrp": TagString,
+	"tlbl": TagString,
+	"ttyr": TagString,
+	"tsiz": TagString,
+	"tbit": TagString,
+	"tsmp": TagString,
+	"tbpm": TagString,
+	"tlen": TagString,
+	"tmod": TagString,
+
+	"bhrt": TagBool,
+	"bmis": TagBool,
+	"bply": TagBool,
+	"blop": TagBool,
+	"bitu": TagBool,
+	"bovc": TagBool,
+	"bcrt": TagBool,
+	"biro": TagBool,
+	"bwlb": TagBool,
+	"bwll": TagBool,
+	"buns": TagBool,
+	"bbgl": TagBool,
+	"bkrk": TagBool,
+
+	"uadd": TagUint32,
+	"ulbl": TagUint32,
+	"utme": TagUint32,
+	"utkn": TagUint32,
+}
+
+// FieldNames maps readable field names, as used in settings and smart crate
+// rules, to the tags that hold them.
+var FieldNames = map[string]string{
+	"title":    "ttit",
+	"artist":   "tart",
+	"album":    "talb",
+	"genre":    "tgen",
+	"bpm":      "tbpm",
+	"key":      "tkey",
+	"comment":  "tcom",
+	"grouping": "tgrp",
+	"label":    "tlbl",
+	"year":     "ttyr",
+	"type":     "ttyp",
+	"path":     "pfil",
+}
+
+// FieldTag returns the tag for a field named in FieldNames, ignoring case
+// and surrounding space. Any other name is taken to be a tag already, such
+// as "tbpm", and returned lowercased.
+func FieldTag(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if tag, ok := FieldNames[name]; ok {
+		return tag
+	}
+	return name
+}
+
+// decodeTag converts a tag payload to its registered Go type. A payload
+// that wouldn't encode back to the same bytes (wrong length, a bool byte
+// other than 0 or 1, text that isn't valid UTF-16) is kept as []byte, so
+// reading and rewriting a record never changes data we don't understand.
+// The normalizations are that trailing NUL characters on text are dropped
+// and text with a byte order mark is re-encoded as big-endian without one.
+func decodeTag(tag string, payload []byte) (interface{}, error) {
+	switch TagTypes[tag] {
+	case TagString:
+		text := trimNULs(payload)
+		val, err := tlv.DecodeU16(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tag %s: %w", tag, err)
+		}
+		if tlv.HasU16BOM(text) {
+			// Written back as plain big-endian, the way Serato writes it.
+			return val, nil
+		}
+		if encoded, err := tlv.EncodeU16BE(val); err != nil || !bytes.Equal(encoded, text) {
+			return payload, nil
+		}
+		return val, nil
+	case TagBool:
+		if len(payload) == 1 && payload[0] <= 1 {
+			return payload[0] == 1, nil
+		}
+	case TagUint32:
+		if len(payload) == 4 {
+			return binary.BigEndian.Uint32(payload), nil
+		}
+	}
+	return payload, nil
+}
+
+// trimNULs drops trailing UTF-16 NUL code units. Only whole code units are
+// removed, so characters such as U+0100 (bytes 01 00) are left intact.
+func trimNULs(payload []byte) []byte {
+	end := len(payload)
+	for end >= 2 && end%2 == 0 && payload[end-2] == 0 && payload[end-1] == 0 {
+		end -= 2
+	}
+	return payload[:end]
+}
+
+// encodeTag converts a record value back to its payload. Integers are
+// written as four big-endian bytes and booleans as a single byte.
+func encodeTag(tag string, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return tlv.EncodeU16BE(v)
+	case []byte:
+		return v, nil
+	case bool:
+		if v {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case uint32:
+		return binary.BigEndian.AppendUint32(nil, v), nil
+	case int:
+		return binary.BigEndian.AppendUint32(nil, uint32(v)), nil
+	case int64:
+		return binary.BigEndian.AppendUint32(nil, uint32(v)), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T for tag %s", value, tag)
+	}
+}
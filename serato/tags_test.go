@@ -0,0 +1,88 @@
+package serato
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeTag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		payload []byte
+		want    interface{}
+	}{
+		// Strings.
+		{"ttit", []byte{0, 'H', 0, 'i'}, "Hi"},
+		{"ttit", []byte{0, 'H', 0, 'i', 0, 0}, "Hi"},
+		{"ttit", []byte{0xFE, 0xFF, 0, 'H', 0, 'i'}, "Hi"},
+		{"ttit", []byte{0xFF, 0xFE, 'H', 0, 'i', 0}, "Hi"},
+		{"ttit", []byte{0x01, 0x00}, "Ā"},
+		{"ttit", []byte{}, ""},
+		// Odd-length text isn't valid UTF-16 and stays raw.
+		{"ttit", []byte{0, 'H', 0}, []byte{0, 'H', 0}},
+		// Booleans.
+		{"bmis", []byte{0}, false},
+		{"bmis", []byte{1}, true},
+		{"bmis", []byte{2}, []byte{2}},
+		{"bmis", []byte{0, 1}, []byte{0, 1}},
+		// Integers.
+		{"uadd", []byte{0x65, 0x53, 0xF1, 0x00}, uint32(0x6553F100)},
+		{"utme", []byte{0, 0, 1}, []byte{0, 0, 1}},
+		// Unregistered tags.
+		{"zzzz", []byte{0, 'H'}, []byte{0, 'H'}},
+	}
+	for _, tt := range tests {
+		got, err := decodeTag(tt.tag, tt.payload)
+		if err != nil {
+			t.Errorf("decodeTag(%s, %x): %v", tt.tag, tt.payload, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeTag(%s, %x) = %#v, want %#v", tt.tag, tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeTag(t *testing.T) {
+	tests := []struct {
+		tag   string
+		value interface{}
+		want  []byte
+		// back is what the payload decodes to.
+		back interface{}
+	}{
+		{"ttit", "Hi", []byte{0, 'H', 0, 'i'}, "Hi"},
+		{"bmis", true, []byte{1}, true},
+		{"bmis", false, []byte{0}, false},
+		{"uadd", uint32(0x6553F100), []byte{0x65, 0x53, 0xF1, 0x00}, uint32(0x6553F100)},
+		{"uadd", 7, []byte{0, 0, 0, 7}, uint32(7)},
+		{"uadd", int64(7), []byte{0, 0, 0, 7}, uint32(7)},
+		{"zzzz", []byte{1, 2}, []byte{1, 2}, []byte{1, 2}},
+	}
+	for _, tt := range tests {
+		got, err := encodeTag(tt.tag, tt.value)
+		if err != nil {
+			t.Errorf("encodeTag(%s, %#v): %v", tt.tag, tt.value, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeTag(%s, %#v) = %x, want %x", tt.tag, tt.value, got, tt.want)
+		}
+		if back, err := decodeTag(tt.tag, got); err != nil || !reflect.DeepEqual(back, tt.back) {
+			t.Errorf("decodeTag(encodeTag(%s, %#v)) = %#v, %v, want %#v", tt.tag, tt.value, back, err, tt.back)
+		}
+	}
+	if _, err := encodeTag("ttit", 1.5); err == nil {
+		t.Error("encodeTag of a float succeeded")
+	}
+}
+
+func TestTagTypesRegistry(t *testing.T) {
+	// Registering a tag is all it takes to have it decoded.
+	TagTypes["zzzz"] = TagUint32
+	defer delete(TagTypes, "zzzz")
+	if got, err := decodeTag("zzzz", []byte{0, 0, 0, 9}); err != nil || got != uint32(9) {
+		t.Errorf("decodeTag of a newly registered tag = %#v, %v", got, err)
+	}
+}
@@ -0,0 +1,79 @@
+package serato
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SeratoDirName is the folder Serato keeps its database and crates in.
+const SeratoDirName = "_Serato_"
+
+// mountParents are folders whose subfolders are mount points for external
+// drives, with the number of path components that name the drive:
+// /Volumes/<drive> on macOS, /media/<user>/<drive> and
+// /run/media/<user>/<drive> on Linux, and /mnt/<drive> (including WSL's
+// /mnt/c).
+var mountParents = []struct {
+	prefix string
+	depth  int
+}{
+	{"/Volumes/", 1},
+	{"/run/media/", 2},
+	{"/media/", 2},
+	{"/mnt/", 1},
+}
+
+// VolumeRoot returns the root of the drive holding path, with forward
+// slashes: "E:/" for a drive letter, the mount point for external drives on
+// macOS and Linux, and "/" otherwise. Network shares (UNC paths) return ""
+// since Serato keeps no per-drive database on them. It works on the path
+// alone and doesn't touch the file system.
+func VolumeRoot(path string) string {
+	p := strings.ReplaceAll(path, "\\", "/")
+	if len(p) >= 2 && p[1] == ':' && isDriveLetter(p[0]) {
+		return strings.ToUpper(p[:1]) + ":/"
+	}
+	if strings.HasPrefix(p, "//") {
+		return ""
+	}
+	for _, mount := range mountParents {
+		if !strings.HasPrefix(p, mount.prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(p, mount.prefix), "/")
+		if len(parts) >= mount.depth && parts[mount.depth-1] != "" {
+			return mount.prefix + strings.Join(parts[:mount.depth], "/")
+		}
+	}
+	return "/"
+}
+
+// SameVolume reports whether two paths are on the same drive.
+func SameVolume(a, b string) bool {
+	return strings.EqualFold(VolumeRoot(a), VolumeRoot(b))
+}
+
+// DatabaseDirFor returns the Serato folder whose database should hold the
+// tracks of libraryRoot. Serato keeps a separate database in a _Serato_
+// folder at the root of each external drive for the tracks on that drive,
+// so a library on another drive than seratoDir belongs to that drive's
+// database; otherwise, or for a network share, it is seratoDir.
+func DatabaseDirFor(seratoDir, libraryRoot string) string {
+	if VolumeRoot(libraryRoot) == "" || SameVolume(seratoDir, libraryRoot) {
+		return seratoDir
+	}
+	return filepath.Join(filepath.FromSlash(VolumeRoot(libraryRoot)), SeratoDirName)
+}
+
+// LibraryPrefix returns the prefix that database paths for tracks in
+// libraryRoot start with, as used by StripLibraryPrefix and BuildPtrk.
+// Serato stores paths relative to the root of the drive they are on, so
+// for a library at "/Volumes/SSD/Music" the prefix is "Music".
+func LibraryPrefix(libraryRoot string) string {
+	p := strings.ReplaceAll(libraryRoot, "\\", "/")
+	root := VolumeRoot(p)
+	if len(p) >= len(root) && strings.EqualFold(p[:len(root)], root) {
+		p = p[len(root):]
+	}
+	return CleanPath(p)
+}
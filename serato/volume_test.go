@@ -0,0 +1,80 @@
+package serato
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeRoot(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{`C:\Users\dj\Music`, "C:/"},
+		{"e:/Music", "E:/"},
+		{"/Volumes/SSD/Music/House", "/Volumes/SSD"},
+		{"/Volumes/SSD", "/Volumes/SSD"},
+		{"/media/dj/USB/Tracks", "/media/dj/USB"},
+		{"/run/media/dj/USB", "/run/media/dj/USB"},
+		{"/mnt/c/Users/dj", "/mnt/c"},
+		{"/Users/dj/Music", "/"},
+		{"/Volumes/", "/"},
+		{`\\nas\share\Music`, ""},
+	}
+	for _, tt := range tests {
+		if got := VolumeRoot(tt.path); got != tt.want {
+			t.Errorf("VolumeRoot(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDatabaseDirForDrives(t *testing.T) {
+	tests := []struct {
+		name        string
+		seratoDir   string
+		libraryRoot string
+		wantDir     string
+		wantPtrk    string
+	}{
+		{
+			"same drive on a Mac",
+			"/Users/dj/Music/_Serato_", "/Users/dj/Music/Tracks",
+			"/Users/dj/Music/_Serato_", "Users/dj/Music/Tracks/House/a.mp3",
+		},
+		{
+			"external drive on a Mac",
+			"/Users/dj/Music/_Serato_", "/Volumes/SSD/Music",
+			filepath.Join("/Volumes/SSD", SeratoDirName), "Music/House/a.mp3",
+		},
+		{
+			"same drive on Windows",
+			`C:\Users\dj\Music\_Serato_`, `c:\Users\dj\Music`,
+			`C:\Users\dj\Music\_Serato_`, "Users/dj/Music/House/a.mp3",
+		},
+		{
+			"external drive on Windows",
+			`C:\Users\dj\Music\_Serato_`, `E:\DJ\Music`,
+			filepath.Join("E:/", SeratoDirName), "DJ/Music/House/a.mp3",
+		},
+		{
+			"library at the drive root",
+			"/Users/dj/Music/_Serato_", "/Volumes/SSD",
+			filepath.Join("/Volumes/SSD", SeratoDirName), "House/a.mp3",
+		},
+		{
+			"network share",
+			"/Users/dj/Music/_Serato_", `\\nas\share\Music`,
+			"/Users/dj/Music/_Serato_", "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DatabaseDirFor(tt.seratoDir, tt.libraryRoot); got != tt.wantDir {
+				t.Errorf("DatabaseDirFor = %q, want %q", got, tt.wantDir)
+			}
+			if tt.wantPtrk == "" {
+				return
+			}
+			if got := BuildPtrk(LibraryPrefix(tt.libraryRoot), "House/a.mp3"); got != tt.wantPtrk {
+				t.Errorf("BuildPtrk = %q, want %q", got, tt.wantPtrk)
+			}
+		})
+	}
+}
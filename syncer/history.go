@@ -0,0 +1,104 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Manifest records what one sync did, for the sync history.
+type Manifest struct {
+	Time time.Time `json:"time"`
+	// Folder is Options.Folder, if the sync was limited to one folder.
+	Folder string  `json:"folder,omitempty"`
+	Result *Result `json:"result"`
+}
+
+// manifestPrefix and manifestSuffix surround the time in manifest file
+// names, which sort in the order the syncs ran.
+const (
+	manifestPrefix = "sync-"
+	manifestSuffix = ".json"
+	manifestTime   = "20060102-150405.000"
+)
+
+// WriteManifest writes manifest as JSON to a new file in dir, named after
+// its time, creating dir if needed. It returns the file's path.
+func WriteManifest(dir string, manifest Manifest) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, manifestPrefix+manifest.Time.UTC().Format(manifestTime)+manifestSuffix)
+	return path, os.WriteFile(path, data, 0644)
+}
+
+// ReadHistory returns the manifests in dir, newest first, up to limit of
+// them; a limit of zero or less returns them all. A missing dir is an empty
+// history, and manifests that can't be read are skipped.
+func ReadHistory(dir string, limit int) ([]Manifest, error) {
+	files, err := listManifests(dir)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []Manifest
+	for _, file := range files {
+		if limit > 0 && len(manifests) == limit {
+			break
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// PruneHistory deletes all but the keep newest manifests in dir. A keep of
+// zero or less leaves every manifest in place.
+func PruneHistory(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	files, err := listManifests(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(files); i++ {
+		if err := os.Remove(files[i]); err != nil {
+			return fmt.Errorf("removing old sync manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// listManifests returns the manifest files in dir, newest first.
+func listManifests(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, manifestPrefix) && strings.HasSuffix(name, manifestSuffix) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files, nil
+}
@@ -0,0 +1,84 @@
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunWritesManifest(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "Techno/b.mp3")
+	historyDir := filepath.Join(t.TempDir(), "history")
+
+	result := f.mustSync(Options{HistoryDir: historyDir})
+	f.mustSync(Options{HistoryDir: historyDir, DryRun: true})
+
+	manifests, err := ReadHistory(historyDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("history holds %d manifests, want 1 (dry runs write none)", len(manifests))
+	}
+	got := manifests[0].Result
+	if got.TracksAdded != 2 || got.CratesWritten != result.CratesWritten || !reflect.DeepEqual(got.NewTracks, result.NewTracks) {
+		t.Errorf("manifest result = %+v, want %+v", got, result)
+	}
+	if !reflect.DeepEqual(got.Backups, result.Backups) {
+		t.Errorf("manifest backups = %v, want %v", got.Backups, result.Backups)
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		manifest := Manifest{Time: start.Add(time.Duration(i) * time.Minute), Result: &Result{TracksAdded: i}}
+		if _, err := WriteManifest(dir, manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := PruneHistory(dir, -1); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 5 {
+		t.Errorf("a negative limit left %d manifests, want 5", len(entries))
+	}
+
+	if err := PruneHistory(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := ReadHistory(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added []int
+	for _, manifest := range manifests {
+		added = append(added, manifest.Result.TracksAdded)
+	}
+	if want := []int{4, 3}; !reflect.DeepEqual(added, want) {
+		t.Errorf("kept manifests of syncs %v, want the newest, %v", added, want)
+	}
+}
+
+func TestRunKeepsHistoryApartFromBackups(t *testing.T) {
+	f := newFixture(t)
+	f.cfg.BackupRetention = 1
+	f.cfg.HistoryRetention = 3
+	historyDir := filepath.Join(t.TempDir(), "history")
+	for i := 0; i < 5; i++ {
+		f.addFile(filepath.ToSlash(filepath.Join("House", string(rune('a'+i))+".mp3")))
+		f.mustSync(Options{HistoryDir: historyDir})
+	}
+
+	manifests, err := ReadHistory(historyDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 3 {
+		t.Errorf("history holds %d manifests, want HistoryRetention's 3", len(manifests))
+	}
+}
@@ -0,0 +1,66 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"seratosync-go/config"
+	"seratosync-go/library"
+	"seratosync-go/serato"
+)
+
+// PreviewCrate returns the track paths the crate of library folder relDir
+// will hold after a sync: the tracks already in the crate followed by the
+// folder's audio files not yet in it, just as the sync merges them, or the
+// other way round if crates are ordered (see config.Config.OrdersCrates).
+// The folder's tracks are planned by library.BuildCratePlans with the
+// settings a sync uses, but only relDir is scanned and nothing is written.
+// relDir "." previews the crate of config.Config.RootCrate.
+func PreviewCrate(ctx context.Context, cfg *config.Config, relDir string) ([]string, error) {
+	dir := filepath.Clean(filepath.FromSlash(relDir))
+	if dir == "." && !cfg.RootCrate {
+		return nil, fmt.Errorf("tracks directly in the library folder get no crate")
+	}
+	if len(library.WithoutFolders(library.LibraryMap{dir: nil}, cfg.NoCrateFolders)) == 0 {
+		return nil, fmt.Errorf("folder %q is set to get no crate", relDir)
+	}
+	roots := rootsContaining(cfg.LibraryPaths(), relDir)
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("folder %q not found in any music library", relDir)
+	}
+	root := roots[0]
+
+	libraryMap, err := library.ScanFolder(ctx, root, relDir, ScanOptions(cfg))
+	if err != nil {
+		return nil, err
+	}
+	library.SortTracks(libraryMap, root, library.TrackSort(cfg.CrateSort))
+
+	seratoDir := serato.DatabaseDirFor(cfg.SeratoDBPath, root)
+	prefix := serato.LibraryPrefix(root)
+	naming := cfg.CrateNamingFor(root)
+	plans := library.BuildCratePlans(libraryMap, prefix, seratoDir, naming)
+	cratePath := naming.CratePath(seratoDir, dir)
+	if dir == "." {
+		cratePath = naming.CratePath(seratoDir, filepath.Base(root))
+		if plan, ok := library.RootCratePlan(libraryMap, prefix, seratoDir, filepath.Base(root), naming); ok {
+			plans = append(plans, plan)
+		}
+	}
+	var planned []string
+	for _, plan := range plans {
+		if plan.RelDir == dir {
+			cratePath, planned = plan.CratePath, plan.TrackPaths
+			break
+		}
+	}
+	existing, err := cfg.Files().ReadCrateFile(cratePath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.OrdersCrates() {
+		return serato.MergeTrackPaths(planned, existing), nil
+	}
+	return serato.MergeTrackPaths(existing, planned), nil
+}
@@ -0,0 +1,47 @@
+package syncer
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"seratosync-go/serato"
+)
+
+func TestPreviewCrateMatchesSync(t *testing.T) {
+	f := newFixture(t, "House/b.mp3", "House/a.mp3", "House/Deep/c.mp3", "Techno/d.mp3")
+	// An existing crate's tracks are kept ahead of the new ones.
+	if _, err := serato.WriteCrateFile(filepath.Join(f.serato, "Subcrates", "House.crate"), []string{f.ptrk("Old/x.mp3")}); err != nil {
+		t.Fatal(err)
+	}
+
+	previews := make(map[string][]string)
+	for _, relDir := range []string{"House", "House/Deep"} {
+		tracks, err := PreviewCrate(context.Background(), f.cfg, relDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		previews[relDir] = tracks
+	}
+	if got := f.crateFiles(); !reflect.DeepEqual(got, []string{"House.crate"}) {
+		t.Errorf("preview wrote crates: %v", got)
+	}
+
+	f.mustSync(Options{})
+	for relDir, crate := range map[string]string{"House": "House.crate", "House/Deep": "House%%Deep.crate"} {
+		if got := f.crate(crate); !reflect.DeepEqual(previews[relDir], got) {
+			t.Errorf("preview of %s = %v, but the sync wrote %v", relDir, previews[relDir], got)
+		}
+	}
+}
+
+func TestPreviewCrateErrors(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "Bootlegs/b.mp3")
+	f.cfg.NoCrateFolders = []string{"Bootlegs"}
+	for _, relDir := range []string{".", "Bootlegs", "Missing"} {
+		if tracks, err := PreviewCrate(context.Background(), f.cfg, relDir); err == nil {
+			t.Errorf("PreviewCrate(%q) = %v, want an error", relDir, tracks)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"seratosync-go/config"
+	"seratosync-go/library"
+)
+
+// Scan is the scan of the music libraries a sync works from. It is made
+// by ScanLibraries and can be handed to Run in Options.Scan, so a sync
+// can be planned and then written without scanning twice.
+type Scan struct {
+	Time time.Time `json:"time"`
+	// Folder is the folder scanned in each library, or empty for all of
+	// them (see Options.Folder).
+	Folder       string   `json:"folder,omitempty"`
+	Roots        []string `json:"roots"`
+	Directories  int      `json:"directories"`
+	FilesScanned int      `json:"files_scanned"`
+	// Skipped lists the files and folders that could not be read.
+	Skipped []string `json:"skipped"`
+
+	roots map[string]library.LibraryMap
+	cache *library.ScanCache
+}
+
+// ScanLibraries scans the configured music libraries, or opts.Folder in
+// the first one containing it, as Run would, without reading or writing
+// the database. opts.DryRun and opts.Scan are ignored.
+func ScanLibraries(ctx context.Context, cfg *config.Config, opts Options) (*Scan, error) {
+	opts.DryRun, opts.Scan = false, nil
+	r := newRun(ctx, cfg, opts)
+	r.log("Scanning music libraries...")
+	libraryPaths, err := r.start()
+	if err != nil {
+		return nil, err
+	}
+
+	scan := &Scan{
+		Time:   time.Now(),
+		Folder: opts.Folder,
+		roots:  make(map[string]library.LibraryMap, len(libraryPaths)),
+		cache:  r.scanCache,
+	}
+	for _, libraryPath := range libraryPaths {
+		libraryMap, err := r.scanRoot(libraryPath)
+		if err != nil {
+			if cerr := r.checkCancelled(); cerr != nil {
+				return nil, cerr
+			}
+			r.logError(fmt.Sprintf("Error scanning library: %v", err))
+			return nil, err
+		}
+		dirs, files := library.GetLibraryStats(libraryMap)
+		r.log(fmt.Sprintf("Found %d directories and %d audio files.", dirs, files))
+		scan.Roots = append(scan.Roots, libraryPath)
+		scan.Directories += dirs
+		scan.FilesScanned += files
+		scan.roots[libraryPath] = libraryMap
+	}
+	scan.Skipped = r.result.Skipped
+	return scan, nil
+}
+
+// scanRoot scans libraryPath, or only opts.Folder in it, or takes it from
+// opts.Scan.
+func (r *run) scanRoot(libraryPath string) (library.LibraryMap, error) {
+	if r.opts.Scan != nil {
+		libraryMap, ok := r.opts.Scan.roots[libraryPath]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the scan given; scan again", libraryPath)
+		}
+		r.log(fmt.Sprintf("Using the scan of %s made at %s.", libraryPath, r.opts.Scan.Time.Format(time.TimeOnly)))
+		return libraryMap, nil
+	}
+
+	scanOpts := ScanOptions(r.cfg)
+	scanOpts.Progress = r.phaseProgress("scan")
+	scanOpts.Cache = r.scanCache
+	scanOpts.Signatures = r.cfg.MatchMovedFiles
+	scanOpts.Log = r.logWarn
+	scanOpts.Skipped = func(path string, err error) {
+		r.result.Skipped = append(r.result.Skipped, path)
+	}
+	if r.opts.Folder != "" {
+		r.log(fmt.Sprintf("Scanning folder %s in music library %s...", r.opts.Folder, libraryPath))
+		return library.ScanFolder(r.ctx, libraryPath, r.opts.Folder, scanOpts)
+	}
+	r.log(fmt.Sprintf("Scanning music library at %s...", libraryPath))
+	return library.ScanLibraryWithOptions(r.ctx, libraryPath, scanOpts)
+}
@@ -0,0 +1,1258 @@
+// Package syncer runs the library-to-Serato sync pipeline shared by the
+// desktop app and the command line tool.
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"seratosync-go/config"
+	"seratosync-go/library"
+	"seratosync-go/serato"
+)
+
+// Result describes the changes a sync makes, or would make in a dry run,
+// along with the totals from the sync summary. In a dry run nothing is
+// written, so the written, added and pruned counts stay zero.
+type Result struct {
+	// SyncMode is the mode the sync ran in (see config.Config.Mode).
+	SyncMode config.SyncMode `json:"sync_mode"`
+
+	DryRun        bool     `json:"dry_run"`
+	NewTracks     []string `json:"new_tracks"`
+	CratesToWrite []string `json:"crates_to_write"`
+	TracksToPrune int      `json:"tracks_to_prune"`
+
+	FilesScanned  int `json:"files_scanned"`
+	TracksBefore  int `json:"tracks_before"`
+	TracksAfter   int `json:"tracks_after"`
+	TracksAdded   int `json:"tracks_added"`
+	TracksPruned  int `json:"tracks_pruned"`
+	CratesWritten int `json:"crates_written"`
+	TracksWritten int `json:"tracks_written"`
+	CratesPruned  int `json:"crates_pruned"`
+	CratesRemoved int `json:"crates_removed"`
+	CratesRenamed int `json:"crates_renamed"`
+	// TracksMoved counts database records pointed at the new path of a
+	// moved file (see config.Config.MatchMovedFiles).
+	TracksMoved int `json:"tracks_moved"`
+	// CratesUnchanged counts crates with new or changed tracks that
+	// already held what the sync would write, so they were left alone.
+	CratesUnchanged int `json:"crates_unchanged"`
+	// TracksExisting counts the library's tracks that were already in the
+	// database.
+	TracksExisting int `json:"tracks_existing"`
+	// TracksOutsideLibrary counts database tracks under none of the
+	// library folders synced. Nearly all of them being outside usually
+	// means a library path doesn't match the database.
+	TracksOutsideLibrary int `json:"tracks_outside_library"`
+
+	// Backups lists the database backups made before writing.
+	Backups []string `json:"backups"`
+	// Skipped lists the files and folders the scan could not read. Their
+	// tracks are neither added nor pruned.
+	Skipped []string `json:"skipped"`
+	// Undisplayable lists the tracks whose paths have characters Serato
+	// may not display (see serato.PathDisplayProblem). They are synced
+	// like any other; renaming them is up to the user.
+	Undisplayable []string `json:"undisplayable"`
+
+	// Diff lists the records changed in every database written. It is left
+	// out of the JSON since it can be large; the app serves it on request.
+	Diff serato.DatabaseDiff `json:"-"`
+}
+
+// Level is the severity of a message sent to Options.Log.
+type Level string
+
+const (
+	LevelInfo  Level = "info"
+	LevelWarn  Level = "warn"
+	LevelError Level = "error"
+)
+
+// Options controls a sync run.
+type Options struct {
+	// DryRun performs the scan and diff but leaves crate files, the
+	// database, and backups untouched. Log lines are marked "[DRY RUN]".
+	DryRun bool
+	// Log receives human-readable progress messages with their level. It
+	// may be nil.
+	Log func(level Level, message string)
+	// Folder, if set, limits the sync to one folder of a music library,
+	// given relative to the library root. Only that folder is scanned, its
+	// subfolders are left alone, and only tracks inside it are pruned. The
+	// first library root containing the folder is used.
+	Folder string
+	// Include and Exclude limit this run to the tracks whose paths match
+	// the glob patterns (see library.TrackFilter). Tracks left out are
+	// neither added to the database nor to crates, but are not treated as
+	// deleted either.
+	Include []string
+	Exclude []string
+	// HistoryDir, if set, is where a manifest of each sync that isn't a
+	// dry run is written (see WriteManifest).
+	HistoryDir string
+	// CachePath is where the scan cache is kept when cfg.ScanCache is set.
+	CachePath string
+	// Progress receives the number of items done and the total for each
+	// phase ("scan", "diff", "crates", "database"). It may be nil and may
+	// be called from several goroutines during the scan.
+	Progress func(phase string, current, total int)
+	// Scan, if set, is used instead of scanning the libraries again. It
+	// must come from ScanLibraries with the same config and Folder.
+	Scan *Scan
+}
+
+// run holds the state of one call to Run.
+type run struct {
+	ctx    context.Context
+	cfg    *config.Config
+	opts   Options
+	result *Result
+	files  serato.Files
+
+	scanCache  *library.ScanCache
+	readTags   func(path string) (map[string]string, error)
+	smartRules []library.CrateRule
+	filter     *library.TrackFilter
+
+	rootsDone  int
+	rootsTotal int
+}
+
+// Run syncs the configured music libraries into the Serato database and
+// crates. Libraries on an external drive are synced into the database
+// Serato keeps on that drive (see serato.DatabaseDirFor). Each database
+// is written before its crates, and a database that can't be written fails
+// the sync before any crate is. Cancelling ctx stops the sync with
+// ctx.Err(); a database is either fully updated or untouched, and crate
+// files already written are left in place.
+func Run(ctx context.Context, cfg *config.Config, opts Options) (*Result, error) {
+	r := newRun(ctx, cfg, opts)
+	r.log("Starting library sync...")
+	libraryPaths, err := r.start()
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := library.NewTrackFilter(opts.Include, opts.Exclude)
+	if err != nil {
+		r.logError(fmt.Sprintf("Error: %v", err))
+		return nil, err
+	}
+	r.filter = filter
+
+	if cfg.SmartCratesPath != "" {
+		r.smartRules, err = library.LoadCrateRules(cfg.SmartCratesPath)
+		if err != nil {
+			r.logError(fmt.Sprintf("Error reading smart crate rules: %v", err))
+			return nil, err
+		}
+	}
+
+	for _, group := range groupByDatabase(cfg.SeratoDBPath, libraryPaths) {
+		if group.seratoDir != cfg.SeratoDBPath {
+			r.log(fmt.Sprintf("%s is on an external drive; syncing it into the drive's Serato database at %s.", strings.Join(group.roots, ", "), group.seratoDir))
+		}
+		if err := r.syncDatabase(group.seratoDir, group.roots); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.scanCache != nil && !opts.DryRun {
+		if err := library.SaveScanCache(opts.CachePath, r.scanCache); err != nil {
+			r.logError(fmt.Sprintf("Error saving scan cache: %v", err))
+		}
+	}
+
+	r.result.TracksAfter = r.result.TracksBefore + r.result.TracksAdded - r.result.TracksPruned
+
+	// --- Final Summary ---
+	r.log("--------------------")
+	r.log("SYNC SUMMARY")
+	r.log("--------------------")
+	if r.result.SyncMode == config.SyncMirror {
+		r.log("Sync Mode: Mirror (deleted tracks pruned, empty crates removed, crates reordered)")
+	} else {
+		r.log("Sync Mode: Add Only")
+	}
+	r.log(fmt.Sprintf("Music Library Files Scanned: %d", r.result.FilesScanned))
+	r.log(fmt.Sprintf("Serato Database Tracks Before Sync: %d", r.result.TracksBefore))
+	r.log(fmt.Sprintf("New Tracks Detected: %d", len(r.result.NewTracks)))
+	r.log(fmt.Sprintf("Tracks Already in Database: %d", r.result.TracksExisting))
+	r.log(fmt.Sprintf("Database Tracks Outside the Library: %d", r.result.TracksOutsideLibrary))
+	r.log(fmt.Sprintf("Tracks Added to Database: %d", r.result.TracksAdded))
+	r.log(fmt.Sprintf("Deleted Tracks Pruned from Database: %d", r.result.TracksPruned))
+	r.log(fmt.Sprintf("Moved Tracks Updated in Database: %d", r.result.TracksMoved))
+	r.log(fmt.Sprintf("Total Tracks in Database After Sync: %d", r.result.TracksAfter))
+	r.log(fmt.Sprintf("Crate Files Written/Updated: %d", r.result.CratesWritten))
+	r.log(fmt.Sprintf("Total Tracks Written to Crates: %d", r.result.TracksWritten))
+	r.log(fmt.Sprintf("Crate Files Pruned: %d", r.result.CratesPruned))
+	r.log(fmt.Sprintf("Empty Crate Files Removed: %d", r.result.CratesRemoved))
+	r.log(fmt.Sprintf("Crates Renamed: %d", r.result.CratesRenamed))
+	r.log(fmt.Sprintf("Crate Files Already Up to Date: %d", r.result.CratesUnchanged))
+	r.log(fmt.Sprintf("Unreadable Files and Folders Skipped: %d", len(r.result.Skipped)))
+	r.log(fmt.Sprintf("Track Paths Serato May Not Display: %d", len(r.result.Undisplayable)))
+	r.log("--------------------")
+	if len(r.result.Skipped) > 0 {
+		r.logWarn("These files and folders could not be read and were left out of the sync:")
+		for _, path := range r.result.Skipped {
+			r.logWarn(fmt.Sprintf("  - %s", path))
+		}
+	}
+
+	if opts.HistoryDir != "" && !opts.DryRun {
+		manifest := Manifest{Time: time.Now(), Folder: opts.Folder, Result: r.result}
+		if _, err := WriteManifest(opts.HistoryDir, manifest); err != nil {
+			r.logError(fmt.Sprintf("Error writing sync manifest: %v", err))
+		} else if err := PruneHistory(opts.HistoryDir, cfg.HistoryToKeep()); err != nil {
+			r.logError(fmt.Sprintf("Error pruning old sync manifests: %v", err))
+		}
+	}
+
+	return r.result, nil
+}
+
+// newRun returns the state of a sync of cfg with opts.
+func newRun(ctx context.Context, cfg *config.Config, opts Options) *run {
+	return &run{
+		ctx:    ctx,
+		cfg:    cfg,
+		opts:   opts,
+		result: &Result{DryRun: opts.DryRun, SyncMode: cfg.Mode()},
+		files:  cfg.Files(),
+	}
+}
+
+// start checks the config, loads the scan cache and returns the library
+// roots to sync.
+func (r *run) start() ([]string, error) {
+	cfg, opts := r.cfg, r.opts
+
+	// 1. Read config
+	libraryPaths := cfg.LibraryPaths()
+	if problems := config.ValidateForSync(cfg); len(problems) > 0 {
+		for _, problem := range problems {
+			r.logError(fmt.Sprintf("Error: invalid configuration: %v", problem))
+		}
+		return nil, config.JoinErrors(problems)
+	}
+
+	if opts.Folder != "" {
+		libraryPaths = rootsContaining(libraryPaths, opts.Folder)
+		if len(libraryPaths) == 0 {
+			r.logError(fmt.Sprintf("Error: folder %s was not found in any music library.", opts.Folder))
+			return nil, fmt.Errorf("folder %q not found in any music library", opts.Folder)
+		}
+		libraryPaths = libraryPaths[:1]
+	}
+	r.rootsTotal = len(libraryPaths)
+
+	// The scan cache is only an optimization: if it can't be loaded the
+	// sync continues with a full scan. A scan made earlier brings its own.
+	r.readTags = library.ReadTags
+	if opts.Scan != nil {
+		if opts.Scan.Folder != opts.Folder {
+			r.logError("Error: the scan given is of a different folder; scan again.")
+			return nil, fmt.Errorf("scan of folder %q used to sync folder %q", opts.Scan.Folder, opts.Folder)
+		}
+		r.scanCache = opts.Scan.cache
+		r.result.Skipped = append(r.result.Skipped, opts.Scan.Skipped...)
+	} else if cfg.ScanCache && opts.CachePath != "" {
+		var err error
+		r.scanCache, err = library.LoadScanCache(opts.CachePath)
+		if err != nil {
+			r.logWarn(fmt.Sprintf("Ignoring unreadable scan cache %s: %v", opts.CachePath, err))
+		}
+	}
+	if r.scanCache != nil {
+		r.readTags = r.scanCache.ReadTags
+	}
+
+	if cfg.MatchMovedFiles && r.scanCache == nil {
+		r.logWarn("Moved files can't be recognized without the scan cache; turn on scan_cache to match them.")
+	}
+	return libraryPaths, nil
+}
+
+// syncDatabase syncs the library roots that belong to the Serato database
+// in seratoDir: it scans them, writes their crates under seratoDir, and
+// adds their new tracks to that database.
+func (r *run) syncDatabase(seratoDir string, libraryPaths []string) error {
+	ctx, cfg, opts := r.ctx, r.cfg, r.opts
+	dryRun := opts.DryRun
+	caseInsensitive := cfg.PathsCaseInsensitive()
+	log := r.log
+
+	// 2. Read Serato database. Paths are kept whole here and stripped per
+	// library root below. An external drive may not have a database yet.
+	dbPath := cfg.DatabasePath(seratoDir)
+	log(fmt.Sprintf("Reading Serato database at %s...", dbPath))
+	if err := r.files.CheckDatabaseV2(dbPath); errors.Is(err, serato.ErrNotDatabase) {
+		r.logError(fmt.Sprintf("Error: %s is not a Serato database; nothing was written.", dbPath))
+		return err
+	}
+	dbExists := true
+	existingRecords, pfilSet, _, err := r.files.ReadDatabaseV2Context(ctx, dbPath, "")
+	if err != nil {
+		if cerr := r.checkCancelled(); cerr != nil {
+			return cerr
+		}
+		if seratoDir == cfg.SeratoDBPath || !errors.Is(err, fs.ErrNotExist) {
+			r.logError(fmt.Sprintf("Error reading database: %v", err))
+			return err
+		}
+		log(fmt.Sprintf("No Serato database on this drive yet; one will be created at %s.", dbPath))
+		dbExists = false
+		pfilSet = make(map[string]struct{})
+	}
+	if !dryRun {
+		if err := serato.CheckDatabaseWritable(dbPath); err != nil {
+			r.logError(fmt.Sprintf("Error: %v. Nothing was written.", err))
+			return err
+		}
+	}
+	beforeRecords := existingRecords
+	log(fmt.Sprintf("Found %d tracks in the database for comparison.", len(pfilSet)))
+	if dbExists {
+		if version, err := r.files.ReadDatabaseVersion(dbPath); err == nil && version != "" {
+			log(fmt.Sprintf("Database version: %s", version))
+		}
+	}
+	r.result.TracksBefore += len(existingRecords)
+
+	// Log first 5 tracks found
+	tracksLogged := 0
+	for pfil := range pfilSet {
+		if tracksLogged >= 5 {
+			break
+		}
+		log(fmt.Sprintf("  - Found DB track for comparison: %s", pfil))
+		tracksLogged++
+	}
+
+	var newRecords []serato.Record
+	var cratePlans []library.CratePlan
+	// prefixesByNaming groups the library prefixes by how their crates are
+	// named, which differs between libraries with a crate prefix.
+	prefixesByNaming := make(map[serato.CrateNaming][]string)
+	affectedPtrks := make(map[string]struct{})
+	removedPfils := make(map[string]struct{})
+	// movedPfils holds the old paths of moved files, which leave their
+	// crates like deleted tracks but keep their database records.
+	movedPfils := make(map[string]struct{})
+	firstNewTrack := len(r.result.NewTracks)
+
+	diffProgress := r.phaseProgress("diff")
+	for _, libraryPath := range libraryPaths {
+		// 3. Scan library
+		libraryMap, err := r.scanRoot(libraryPath)
+		if err != nil {
+			if cerr := r.checkCancelled(); cerr != nil {
+				return cerr
+			}
+			r.logError(fmt.Sprintf("Error scanning library: %v", err))
+			return err
+		}
+		library.SortTracks(libraryMap, libraryPath, library.TrackSort(cfg.CrateSort))
+		rootDirs, rootFiles := library.GetLibraryStats(libraryMap)
+		r.result.FilesScanned += rootFiles
+		log(fmt.Sprintf("Found %d directories and %d audio files.", rootDirs, rootFiles))
+
+		// Pruning still goes by everything on disk, so tracks the filter
+		// leaves out aren't mistaken for deleted ones.
+		scannedMap := libraryMap
+		if !r.filter.Empty() {
+			libraryMap = r.filter.Apply(libraryMap)
+			_, filteredFiles := library.GetLibraryStats(libraryMap)
+			log(fmt.Sprintf("%d audio files match the track filter.", filteredFiles))
+		}
+
+		// Log first 5 files found
+		filesLogged := 0
+		for _, files := range libraryMap {
+			if filesLogged >= 5 {
+				break
+			}
+			for _, file := range files {
+				if filesLogged >= 5 {
+					break
+				}
+				log(fmt.Sprintf("  - Found library file: %s", file))
+				filesLogged++
+			}
+		}
+
+		// Serato stores paths relative to the drive the track is on. When
+		// matching ignores case the prefix is compared folded too, but new
+		// records are written with the library path's own casing.
+		libraryPrefix := serato.LibraryPrefix(libraryPath)
+		var rootPfilSet map[string]struct{}
+		if caseInsensitive {
+			rootPfilSet, _ = serato.StripLibraryPrefix(serato.FoldPathSet(pfilSet), strings.ToLower(libraryPrefix))
+		} else {
+			rootPfilSet, _ = serato.StripLibraryPrefix(pfilSet, libraryPrefix)
+		}
+		log(fmt.Sprintf("Using prefix from library path: %s", libraryPrefix))
+		naming := cfg.CrateNamingFor(libraryPath)
+		prefixesByNaming[naming] = append(prefixesByNaming[naming], libraryPrefix)
+
+		// 4. Detect new tracks by comparing relative paths
+		var relativeTrackPaths []string
+		for _, files := range libraryMap {
+			relativeTrackPaths = append(relativeTrackPaths, files...)
+		}
+
+		r.checkDisplayable(libraryPath, relativeTrackPaths)
+
+		var match library.TrackMatch
+		if caseInsensitive {
+			match = library.MatchTracksFold(relativeTrackPaths, rootPfilSet)
+		} else {
+			match = library.MatchTracks(relativeTrackPaths, rootPfilSet)
+		}
+		newRelativePaths := match.New
+		r.result.TracksExisting += len(match.Existing)
+		log(fmt.Sprintf("Found %d new tracks; %d are already in the database.", len(newRelativePaths), len(match.Existing)))
+		if err := r.checkPrefix(libraryPath, libraryPrefix, pfilSet, relativeTrackPaths, newRelativePaths); err != nil {
+			return err
+		}
+		if cfg.MatchMovedFiles && r.scanCache != nil && opts.Folder == "" {
+			var moved map[string]string
+			newRelativePaths, moved, existingRecords = r.moveRecords(libraryPath, libraryPrefix, newRelativePaths, existingRecords)
+			for oldPfil, newPfil := range moved {
+				movedPfils[serato.CleanPath(oldPfil)] = struct{}{}
+				affectedPtrks[newPfil] = struct{}{}
+			}
+		}
+
+		for _, relPfil := range newRelativePaths {
+			// Construct the full path for the database record
+			fullPfil := serato.BuildPtrk(libraryPrefix, relPfil)
+			affectedPtrks[fullPfil] = struct{}{}
+			r.result.NewTracks = append(r.result.NewTracks, fullPfil)
+			if dryRun {
+				continue
+			}
+			if err := r.checkCancelled(); err != nil {
+				return err
+			}
+			tags, err := r.readTags(filepath.Join(libraryPath, relPfil))
+			if err != nil {
+				r.logWarn(fmt.Sprintf("Could not read tags from %s: %v", relPfil, err))
+			}
+			newRecords = append(newRecords, serato.NewTrackRecord(fullPfil, tags))
+		}
+
+		if cfg.DetectRenamedFolders && opts.Folder == "" {
+			present := library.TrackSet(scannedMap)
+			if caseInsensitive {
+				present = serato.FoldPathSet(present)
+			}
+			// Only folders that still have a crate can have it moved, and
+			// leaving out the rest keeps stale database entries from older
+			// renames from making a match ambiguous.
+			hasCrate := make(map[string]bool)
+			var goneTracks []string
+			for rel := range rootPfilSet {
+				if _, ok := present[rel]; ok {
+					continue
+				}
+				dir := path.Dir(rel)
+				if _, ok := hasCrate[dir]; !ok {
+					_, err := os.Stat(naming.CratePath(seratoDir, filepath.FromSlash(dir)))
+					hasCrate[dir] = err == nil
+				}
+				if hasCrate[dir] {
+					goneTracks = append(goneTracks, rel)
+				}
+			}
+			r.renameCrates(seratoDir, libraryPath, library.RenamedFolders(libraryMap, newRelativePaths, goneTracks))
+		}
+
+		// Find tracks under this library that were deleted from disk
+		if cfg.PrunesMissing() {
+			present := library.TrackSet(scannedMap)
+			if caseInsensitive {
+				present = serato.FoldPathSet(present)
+			}
+			folder := path.Clean(filepath.ToSlash(opts.Folder))
+			exists := func(rel string) bool {
+				if opts.Folder != "" && path.Dir(rel) != folder {
+					// Outside the folder being synced; leave it alone.
+					return true
+				}
+				key := serato.NormalizePath(rel)
+				if caseInsensitive {
+					key = serato.FoldPath(rel)
+				}
+				if _, ok := present[key]; ok {
+					return true
+				}
+				// The scan only sees audio extensions; check anything else on
+				// disk directly. A file that can't be checked, say in a folder
+				// the scan couldn't read, is kept.
+				_, err := os.Stat(filepath.Join(libraryPath, filepath.FromSlash(rel)))
+				return !errors.Is(err, fs.ErrNotExist)
+			}
+			var removed map[string]struct{}
+			existingRecords, removed = serato.PruneMissingRecords(existingRecords, libraryPrefix, exists)
+			log(fmt.Sprintf("Found %d tracks no longer on disk.", len(removed)))
+			for pfil := range removed {
+				removedPfils[pfil] = struct{}{}
+			}
+		}
+
+		// 5. Build crate plans (crates need full paths)
+		crateMap := library.WithoutFolders(libraryMap, cfg.NoCrateFolders)
+		rootPlans := library.BuildCratePlans(crateMap, libraryPrefix, seratoDir, naming)
+		if plan, ok := library.RootCratePlan(crateMap, libraryPrefix, seratoDir, filepath.Base(libraryPath), naming); ok {
+			if cfg.RootCrate {
+				log(fmt.Sprintf("%d tracks directly in %s go into crate %s.", len(plan.TrackPaths), libraryPath, filepath.Base(plan.CratePath)))
+				rootPlans = append(rootPlans, plan)
+			} else {
+				log(fmt.Sprintf("%d tracks directly in %s are in no folder, so they are added to the database without a crate.", len(plan.TrackPaths), libraryPath))
+			}
+		}
+		for _, cratePlan := range rootPlans {
+			name := filepath.Base(cratePlan.RelDir)
+			if serato.CrateComponentAmbiguous(name) {
+				r.logWarn(fmt.Sprintf("Warning: folder name %q contains %% characters that Serato could read as a crate separator; writing it escaped as %q.", name, serato.EscapeCrateComponent(name)))
+			}
+		}
+		r.renameUntransformedCrates(seratoDir, libraryPath, naming, rootPlans)
+		cratePlans = append(cratePlans, rootPlans...)
+		r.rootsDone++
+		diffProgress(r.rootsDone, r.rootsTotal)
+	}
+	r.result.TracksToPrune += len(removedPfils)
+	var syncedPrefixes []string
+	for _, prefixes := range prefixesByNaming {
+		syncedPrefixes = append(syncedPrefixes, prefixes...)
+	}
+	r.result.TracksOutsideLibrary += countOutside(pfilSet, syncedPrefixes, caseInsensitive)
+
+	// Only crates containing affected tracks are written
+	var cratesToWrite []library.CratePlan
+	for _, cratePlan := range cratePlans {
+		// Check if this crate contains any affected tracks
+		hasAffected := false
+		for _, ptrk := range cratePlan.TrackPaths {
+			if _, ok := affectedPtrks[ptrk]; ok {
+				hasAffected = true
+				break
+			}
+		}
+		// Empty parent crates are written if missing so the hierarchy
+		// shows up complete in Serato.
+		if len(cratePlan.TrackPaths) == 0 {
+			if _, err := os.Stat(cratePlan.CratePath); os.IsNotExist(err) {
+				hasAffected = true
+			}
+		}
+		if !hasAffected {
+			continue
+		}
+		// Skip crates that already hold what the write would give them.
+		if upToDate, err := r.files.CrateUpToDate(cratePlan.CratePath, cratePlan.TrackPaths, cfg.OrdersCrates()); err != nil {
+			r.logError(fmt.Sprintf("Error reading crate file %s: %v", cratePlan.CratePath, err))
+		} else if upToDate {
+			r.result.CratesUnchanged++
+			continue
+		}
+
+		r.result.CratesToWrite = append(r.result.CratesToWrite, cratePlan.CratePath)
+		if dryRun {
+			log(fmt.Sprintf("Would write crate file %s with %d tracks.", filepath.Base(cratePlan.CratePath), len(cratePlan.TrackPaths)))
+			continue
+		}
+		cratesToWrite = append(cratesToWrite, cratePlan)
+	}
+	if !dryRun {
+		writesDatabase := len(newRecords) > 0 || len(removedPfils) > 0 || len(movedPfils) > 0
+		if err := r.checkFreeSpace(seratoDir, dbPath, writesDatabase, newRecords, cratesToWrite); err != nil {
+			r.logError(fmt.Sprintf("Error: %v. Nothing was written.", err))
+			return err
+		}
+	}
+
+	// 6. Add new tracks to database and drop deleted ones. The database is
+	// written before the crates so that a failed write stops the sync
+	// before any crate refers to tracks the database doesn't have.
+	if err := r.checkCancelled(); err != nil {
+		return err
+	}
+	if dryRun {
+		for _, fullPfil := range r.result.NewTracks[firstNewTrack:] {
+			log(fmt.Sprintf("Would add track: %s", fullPfil))
+		}
+	} else if len(newRecords) > 0 || len(removedPfils) > 0 || len(movedPfils) > 0 {
+		log(fmt.Sprintf("Adding %d new tracks to the database...", len(newRecords)))
+		allRecords := append(existingRecords, newRecords...)
+		dbProgress := r.phaseProgress("database")
+		dbProgress(0, 1)
+
+		// Backup database before writing. Without a backup that checks out
+		// the database is left alone.
+		if dbExists {
+			backupPath, err := r.files.BackupDatabaseTo(dbPath, cfg.BackupDirFor(seratoDir))
+			if err != nil {
+				r.logError(fmt.Sprintf("Error creating database backup: %v. The database was not changed and no crates were written.", err))
+				return fmt.Errorf("backing up database %s: %w", dbPath, err)
+			}
+			log(fmt.Sprintf("Database backup created at %s", backupPath))
+			r.result.Backups = append(r.result.Backups, backupPath)
+		}
+
+		// With nothing to remove or move, new records are appended rather
+		// than rewriting the whole file, unless the version string has to
+		// change. The append changes the file in place rather than
+		// atomically, which is safe only because of the backup above.
+		if dbExists && len(removedPfils) == 0 && len(movedPfils) == 0 && cfg.DatabaseVersion == "" {
+			err = r.files.AppendDatabaseV2Records(dbPath, newRecords)
+			if err != nil && !errors.Is(err, serato.ErrNotDatabase) {
+				r.logWarn(fmt.Sprintf("Could not append to the database (%v); rewriting it instead.", err))
+				err = r.files.WriteDatabaseV2RecordsVersion(dbPath, cfg.DatabaseVersion, allRecords)
+			}
+		} else {
+			err = r.files.WriteDatabaseV2RecordsVersion(dbPath, cfg.DatabaseVersion, allRecords)
+		}
+		if err != nil {
+			r.logError(fmt.Sprintf("Error writing updated database: %v. No crates were written.", err))
+			return fmt.Errorf("writing database %s: %w", dbPath, err)
+		}
+		r.result.TracksAdded += len(newRecords)
+		r.result.TracksPruned += len(removedPfils)
+		r.result.TracksMoved += len(movedPfils)
+		r.result.Diff.Merge(serato.DiffDatabases(beforeRecords, allRecords))
+		log("Successfully updated database.")
+		if err := serato.PruneBackupsIn(dbPath, cfg.BackupDirFor(seratoDir), cfg.BackupsToKeep()); err != nil {
+			r.logError(fmt.Sprintf("Error pruning old database backups: %v", err))
+		}
+		dbProgress(1, 1)
+	}
+
+	// 7. Write crate files
+	log("Writing crate files...")
+	if err := r.writeCrates(cratesToWrite); err != nil {
+		return err
+	}
+
+	// Remove deleted tracks, and the old paths of moved ones, from every
+	// crate that references them
+	goneFromCrates := removedPfils
+	if len(movedPfils) > 0 {
+		goneFromCrates = make(map[string]struct{}, len(removedPfils)+len(movedPfils))
+		for _, pfils := range []map[string]struct{}{removedPfils, movedPfils} {
+			for pfil := range pfils {
+				goneFromCrates[pfil] = struct{}{}
+			}
+		}
+	}
+	if len(goneFromCrates) > 0 {
+		crateFiles, err := serato.ListCrateFiles(seratoDir)
+		if err != nil {
+			r.logError(fmt.Sprintf("Error listing crate files: %v", err))
+		}
+		for _, crateFile := range crateFiles {
+			if err := r.checkCancelled(); err != nil {
+				return err
+			}
+			trackPaths, err := r.files.ReadCrateFile(crateFile)
+			if err != nil {
+				r.logError(fmt.Sprintf("Error reading crate file %s: %v", crateFile, err))
+				continue
+			}
+			pruned := 0
+			for _, ptrk := range trackPaths {
+				if _, ok := goneFromCrates[serato.CleanPath(ptrk)]; ok {
+					pruned++
+				}
+			}
+			if pruned == 0 {
+				continue
+			}
+			// A crate mirroring a library folder that lost all its tracks is
+			// removed, unless other crates are nested under it.
+			removeEmpty := pruned == len(trackPaths) && !cfg.KeepsEmptyCrates() &&
+				isManagedCrate(crateFile, trackPaths, prefixesByNaming) &&
+				!serato.HasChildCrates(crateFile, crateFiles)
+
+			if dryRun {
+				log(fmt.Sprintf("Would remove %d deleted tracks from crate %s.", pruned, filepath.Base(crateFile)))
+				if removeEmpty {
+					log(fmt.Sprintf("Would remove empty crate %s.", filepath.Base(crateFile)))
+				}
+				continue
+			}
+			if removeEmpty {
+				if err := os.Remove(crateFile); err != nil {
+					r.logError(fmt.Sprintf("Error removing empty crate %s: %v", crateFile, err))
+				} else {
+					log(fmt.Sprintf("Removed empty crate %s.", filepath.Base(crateFile)))
+					r.result.CratesRemoved++
+				}
+				continue
+			}
+			if _, err := r.files.PruneCrateFile(crateFile, goneFromCrates); err != nil {
+				r.logError(fmt.Sprintf("Error pruning crate file %s: %v", crateFile, err))
+			} else {
+				log(fmt.Sprintf("Removed %d deleted tracks from crate %s.", pruned, filepath.Base(crateFile)))
+				r.result.CratesPruned++
+			}
+		}
+	}
+
+	return r.writeSmartCrates(seratoDir, append(existingRecords[:len(existingRecords):len(existingRecords)], newRecords...))
+}
+
+// checkFreeSpace checks that the drives the sync writes to have room for
+// it before anything is written: the Serato folder for the crates and, if
+// writesDatabase, the rewritten database, and the backup folder for the
+// database backup. Every file is written in full next to the one it
+// replaces, so the estimate counts each new file whole.
+func (r *run) checkFreeSpace(seratoDir, dbPath string, writesDatabase bool, newRecords []serato.Record, crates []library.CratePlan) error {
+	need := int64(0)
+	for _, plan := range crates {
+		need += serato.FileSize(plan.CratePath) + r.files.CrateSize(plan.TrackPaths)
+	}
+	var backupNeed int64
+	backupDir := r.cfg.BackupDirFor(seratoDir)
+	if writesDatabase {
+		dbSize := serato.FileSize(dbPath)
+		need += dbSize
+		for _, record := range newRecords {
+			need += serato.RecordSize(record)
+		}
+		if backupDir == "" {
+			need += dbSize
+		} else {
+			backupNeed = dbSize
+		}
+	}
+	if err := serato.CheckFreeSpace(seratoDir, need); err != nil {
+		return err
+	}
+	if backupNeed > 0 {
+		return serato.CheckFreeSpace(backupDir, backupNeed)
+	}
+	return nil
+}
+
+// writeCrates writes crate plans on a pool of cfg.CrateWorkers goroutines.
+// Plans for the same crate file, e.g. the same folder name in two library
+// roots, are merged one after another by a single worker so they don't
+// overwrite each other. A failed write is logged and left out of the
+// counts without stopping the others; only cancellation stops the pool.
+func (r *run) writeCrates(plans []library.CratePlan) error {
+	var groups [][]library.CratePlan
+	groupIndex := make(map[string]int)
+	for _, plan := range plans {
+		i, ok := groupIndex[plan.CratePath]
+		if !ok {
+			i = len(groups)
+			groupIndex[plan.CratePath] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], plan)
+	}
+
+	workers := r.cfg.CrateWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	progress := r.phaseProgress("crates")
+	progress(0, len(plans))
+	var mu sync.Mutex
+	done := 0
+
+	jobs := make(chan []library.CratePlan)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				for _, plan := range group {
+					if r.ctx.Err() != nil {
+						break
+					}
+					write := r.files.WriteCrateFileMerge
+					if r.cfg.OrdersCrates() {
+						write = r.files.WriteCrateFileOrdered
+					}
+					skipped, err := write(plan.CratePath, plan.TrackPaths)
+
+					mu.Lock()
+					if err != nil {
+						r.logError(fmt.Sprintf("Error writing crate file %s: %v", plan.CratePath, err))
+					} else {
+						r.crateWritten("crate file "+filepath.Base(plan.CratePath), len(plan.TrackPaths), skipped)
+					}
+					done++
+					progress(done, len(plans))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, group := range groups {
+		select {
+		case jobs <- group:
+		case <-r.ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return r.checkCancelled()
+}
+
+// moveRecords finds the files among newPaths, new tracks relative to
+// libraryPath, that were moved there from a path the database already has
+// (see library.MovedFiles) and points those records at their new paths.
+// It returns the new tracks that are left, the old pfil of each moved
+// record mapped to its new one, and the updated records.
+func (r *run) moveRecords(libraryPath, libraryPrefix string, newPaths []string, records []serato.Record) ([]string, map[string]string, []serato.Record) {
+	moves := library.MovedFiles(r.scanCache, libraryPath, newPaths)
+	if len(moves) == 0 {
+		return newPaths, nil, records
+	}
+	key := serato.NormalizePath
+	if r.cfg.PathsCaseInsensitive() {
+		key = serato.FoldPath
+	}
+	renames := make(map[string]string, len(moves))
+	for newRel, oldRel := range moves {
+		renames[key(serato.BuildPtrk(libraryPrefix, oldRel))] = serato.BuildPtrk(libraryPrefix, newRel)
+	}
+	movedFrom, records := serato.MoveRecords(records, renames, key)
+
+	// A file whose old path has no record, e.g. one pruned earlier, is
+	// still added as a new track.
+	moved := make(map[string]string, len(movedFrom))
+	movedTo := make(map[string]struct{}, len(movedFrom))
+	for _, oldPfil := range movedFrom {
+		newPfil := renames[key(oldPfil)]
+		moved[oldPfil] = newPfil
+		movedTo[newPfil] = struct{}{}
+		if r.opts.DryRun {
+			r.log(fmt.Sprintf("Would update moved track %s to %s", oldPfil, newPfil))
+		} else {
+			r.log(fmt.Sprintf("%s was moved to %s; updating its database record.", oldPfil, newPfil))
+		}
+	}
+	var remaining []string
+	for _, relPath := range newPaths {
+		if _, ok := movedTo[serato.BuildPtrk(libraryPrefix, relPath)]; !ok {
+			remaining = append(remaining, relPath)
+		}
+	}
+	return remaining, moved, records
+}
+
+// crateWritten logs and counts a crate written with tracks tracks, of
+// which skipped could not be written (see serato.WriteCrate).
+func (r *run) crateWritten(crate string, tracks int, skipped []string) {
+	r.result.CratesWritten++
+	if len(skipped) == 0 {
+		r.log(fmt.Sprintf("Wrote %s with %d tracks.", crate, tracks))
+		r.result.TracksWritten += tracks
+		return
+	}
+	written := max(tracks-len(skipped), 0)
+	r.logWarn(fmt.Sprintf("Wrote %s with %d tracks, %d skipped because their paths could not be stored:", crate, written, len(skipped)))
+	for _, path := range skipped {
+		r.logWarn(fmt.Sprintf("  - %q", path))
+	}
+	r.result.TracksWritten += written
+}
+
+// ErrPrefixMismatch is returned when most of a library's tracks look new
+// but are already in the database under a different path prefix, or
+// hardly any of the database's tracks are under the library's prefix,
+// which means the library or Serato folder is misconfigured.
+var ErrPrefixMismatch = errors.New("library tracks are in the database under a different path")
+
+// minMismatchTracks is how many new tracks a library needs before
+// checkPrefix looks at them; fewer can't do much damage.
+const minMismatchTracks = 10
+
+// checkPrefix guards against a library path that doesn't match how the
+// database stores its tracks. If more than half of the library's tracks
+// look new, the sync stops before writing anything when more than half of
+// those are in the database under another prefix, or when fewer than
+// cfg.PrefixMatchThreshold percent of the database's tracks are under the
+// library's prefix.
+func (r *run) checkPrefix(libraryPath, libraryPrefix string, pfilSet map[string]struct{}, trackPaths, newPaths []string) error {
+	if len(newPaths) < minMismatchTracks || len(newPaths)*2 <= len(trackPaths) || len(pfilSet) == 0 {
+		return nil
+	}
+	matched := 0
+	for pfil := range pfilSet {
+		if underPrefixFold(pfil, libraryPrefix) {
+			matched++
+		}
+	}
+	r.log(fmt.Sprintf("%d of %d database tracks are under the library prefix %q.", matched, len(pfilSet), libraryPrefix))
+
+	keys := make([]string, len(newPaths))
+	set := pfilSet
+	for i, p := range newPaths {
+		keys[i] = serato.NormalizePath(p)
+	}
+	if r.cfg.PathsCaseInsensitive() {
+		set = serato.FoldPathSet(pfilSet)
+		for i, p := range newPaths {
+			keys[i] = serato.FoldPath(p)
+		}
+	}
+	prefix, found := serato.SuggestLibraryPrefix(set, keys)
+	if found*2 > len(newPaths) && !strings.EqualFold(prefix, libraryPrefix) {
+		r.logError(fmt.Sprintf("Error: %d of the %d new tracks in %s are already in the database under %q rather than %q. The music library or Serato folder setting is probably wrong; nothing was written.", found, len(newPaths), libraryPath, prefix, libraryPrefix))
+		return fmt.Errorf("%w: %s is stored under %q", ErrPrefixMismatch, libraryPath, prefix)
+	}
+
+	threshold := r.cfg.PrefixMatchThreshold()
+	if threshold < 0 || matched*100 >= threshold*len(pfilSet) {
+		return nil
+	}
+	r.logError(fmt.Sprintf("Error: only %d of the %d database tracks are under the library prefix %q, below the %d%% minimum, and %d of the %d tracks in %s look new. The music library or Serato folder setting is probably wrong; nothing was written. If the library is new to this database, set min_prefix_match to -1 for its first sync.", matched, len(pfilSet), libraryPrefix, threshold, len(newPaths), len(trackPaths), libraryPath))
+	return fmt.Errorf("%w: %d of %d database tracks are under %q", ErrPrefixMismatch, matched, len(pfilSet), libraryPrefix)
+}
+
+// checkDisplayable warns about the tracks of the library at libraryPath
+// whose paths Serato may not display, so the user can rename them, and
+// lists them in the result.
+func (r *run) checkDisplayable(libraryPath string, trackPaths []string) {
+	var found []string
+	problems := make(map[string]string)
+	for _, rel := range trackPaths {
+		if problem := serato.PathDisplayProblem(rel, r.cfg.WarnAstralPaths); problem != "" {
+			found = append(found, rel)
+			problems[rel] = problem
+		}
+	}
+	sort.Strings(found)
+	for _, rel := range found {
+		full := filepath.Join(libraryPath, rel)
+		r.logWarn(fmt.Sprintf("Warning: Serato may not display %q, which contains a %s; rename it to fix this.", full, problems[rel]))
+		r.result.Undisplayable = append(r.result.Undisplayable, full)
+	}
+}
+
+// countOutside counts the database paths in pfilSet that lie under none of
+// prefixes.
+func countOutside(pfilSet map[string]struct{}, prefixes []string, caseInsensitive bool) int {
+	outside := 0
+	for pfil := range pfilSet {
+		under := false
+		for _, prefix := range prefixes {
+			if caseInsensitive {
+				under = underPrefixFold(pfil, prefix)
+			} else {
+				prefix = serato.NormalizePath(prefix)
+				under = prefix == "" || strings.HasPrefix(pfil, prefix+"/")
+			}
+			if under {
+				break
+			}
+		}
+		if !under {
+			outside++
+		}
+	}
+	return outside
+}
+
+// underPrefixFold reports whether the database path pfil lies under
+// prefix, ignoring case.
+func underPrefixFold(pfil, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return len(pfil) > len(prefix) && strings.EqualFold(pfil[:len(prefix)], prefix) && pfil[len(prefix)] == '/'
+}
+
+// renameCrates moves the crates of renamed library folders, given as old
+// folder to new, to their new names. A folder only counts as renamed if
+// the old one is gone from disk, its crate exists and the new one has none
+// yet.
+func (r *run) renameCrates(seratoDir, libraryPath string, renamed map[string]string) {
+	naming := r.cfg.CrateNamingFor(libraryPath)
+	olds := make([]string, 0, len(renamed))
+	for old := range renamed {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	var moved []string
+	for _, old := range olds {
+		renamedTo := renamed[old]
+		if !naming.Flat && underAny(old, moved) {
+			// Its crate moved along with the parent's.
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(libraryPath, filepath.FromSlash(old))); err == nil {
+			continue
+		}
+		oldCrate := naming.CratePath(seratoDir, filepath.FromSlash(old))
+		newCrate := naming.CratePath(seratoDir, filepath.FromSlash(renamedTo))
+		if oldCrate == newCrate {
+			continue
+		}
+		if _, err := os.Stat(oldCrate); err != nil {
+			continue
+		}
+		if _, err := os.Stat(newCrate); err == nil {
+			continue
+		}
+		moved = append(moved, old)
+		if r.opts.DryRun {
+			r.log(fmt.Sprintf("Would rename crate %s to %s for renamed folder %s.", filepath.Base(oldCrate), filepath.Base(newCrate), renamedTo))
+			continue
+		}
+		if err := naming.RenameCrate(seratoDir, old, renamedTo); err != nil {
+			r.logError(fmt.Sprintf("Error renaming crate %s: %v", filepath.Base(oldCrate), err))
+			continue
+		}
+		r.log(fmt.Sprintf("Renamed crate %s to %s for renamed folder %s.", filepath.Base(oldCrate), filepath.Base(newCrate), renamedTo))
+		r.result.CratesRenamed++
+	}
+}
+
+// renameUntransformedCrates moves the crates of plans, for the library at
+// libraryPath, that still have the plain folder names they got before the
+// crate name transform was turned on to their transformed names, so the
+// sync doesn't write a second crate next to each. A crate is left alone if
+// one already has the new name. Turning the transform off again leaves
+// the crates with their transformed names.
+func (r *run) renameUntransformedCrates(seratoDir, libraryPath string, naming serato.CrateNaming, plans []library.CratePlan) {
+	if naming.Transform == (serato.CrateTransform{}) {
+		return
+	}
+	plain := naming
+	plain.Transform = serato.CrateTransform{}
+	for _, plan := range plans {
+		relDir := plan.RelDir
+		if relDir == "." {
+			relDir = filepath.Base(libraryPath)
+		}
+		oldCrate := plain.CratePath(seratoDir, relDir)
+		if oldCrate == plan.CratePath {
+			continue
+		}
+		if _, err := os.Stat(oldCrate); err != nil {
+			continue
+		}
+		if _, err := os.Stat(plan.CratePath); err == nil {
+			continue
+		}
+		if r.opts.DryRun {
+			r.log(fmt.Sprintf("Would rename crate %s to %s.", filepath.Base(oldCrate), filepath.Base(plan.CratePath)))
+			continue
+		}
+		if err := serato.Disk.Rename(oldCrate, plan.CratePath); err != nil {
+			r.logError(fmt.Sprintf("Error renaming crate %s: %v", filepath.Base(oldCrate), err))
+			continue
+		}
+		r.log(fmt.Sprintf("Renamed crate %s to %s.", filepath.Base(oldCrate), filepath.Base(plan.CratePath)))
+		r.result.CratesRenamed++
+	}
+}
+
+// isManagedCrate reports whether serato.IsManagedCrate holds for the crate
+// with the library prefixes of one of the crate namings.
+func isManagedCrate(crateFile string, trackPaths []string, prefixesByNaming map[serato.CrateNaming][]string) bool {
+	for naming, prefixes := range prefixesByNaming {
+		if serato.IsManagedCrate(crateFile, trackPaths, prefixes, naming) {
+			return true
+		}
+	}
+	return false
+}
+
+// underAny reports whether the folder dir lies inside one of folders.
+func underAny(dir string, folders []string) bool {
+	for _, folder := range folders {
+		if strings.HasPrefix(dir, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSmartCrates rewrites the smart crates of the database in seratoDir
+// to hold the records matching each rule. Unlike folder crates, tracks that
+// no longer match are dropped, and a crate is only written when it is
+// missing or its tracks change. In a dry run the rules are matched against the database as it
+// was, without the new tracks.
+func (r *run) writeSmartCrates(seratoDir string, records []serato.Record) error {
+	if len(r.smartRules) == 0 {
+		return nil
+	}
+	r.log("Writing smart crates...")
+	r.warnSeratoSmartCrates(seratoDir)
+	for _, plan := range library.BuildSmartCrates(records, r.smartRules, seratoDir, r.cfg.CrateNaming()) {
+		if err := r.checkCancelled(); err != nil {
+			return err
+		}
+		name := filepath.Base(plan.CratePath)
+		if _, err := os.Stat(plan.CratePath); err == nil {
+			existing, err := r.files.ReadCrateFile(plan.CratePath)
+			if err != nil {
+				r.logError(fmt.Sprintf("Error reading crate file %s: %v", plan.CratePath, err))
+				continue
+			}
+			if slices.Equal(existing, plan.TrackPaths) {
+				continue
+			}
+		}
+		r.result.CratesToWrite = append(r.result.CratesToWrite, plan.CratePath)
+		if r.opts.DryRun {
+			r.log(fmt.Sprintf("Would write smart crate %s with %d tracks.", name, len(plan.TrackPaths)))
+			continue
+		}
+		skipped, err := r.files.WriteCrateFile(plan.CratePath, plan.TrackPaths)
+		if err != nil {
+			r.logError(fmt.Sprintf("Error writing crate file %s: %v", plan.CratePath, err))
+			continue
+		}
+		r.crateWritten("smart crate "+name, len(plan.TrackPaths), skipped)
+	}
+	return nil
+}
+
+// warnSeratoSmartCrates warns about rules named like one of Serato's own
+// smart crates, which Serato shows next to ours under the same name.
+func (r *run) warnSeratoSmartCrates(seratoDir string) {
+	files, err := serato.ListSmartCrates(seratoDir)
+	if err != nil {
+		return
+	}
+	names := make(map[string]struct{})
+	for _, file := range files {
+		crate, err := r.files.ReadSmartCrate(file)
+		if err != nil {
+			r.logWarn(fmt.Sprintf("Warning: could not read Serato smart crate %s: %v", file, err))
+			continue
+		}
+		names[strings.ToLower(crate.Name)] = struct{}{}
+	}
+	for _, rule := range r.smartRules {
+		name := strings.Trim(filepath.ToSlash(strings.TrimSpace(rule.Name)), "/")
+		if _, ok := names[strings.ToLower(name)]; ok {
+			r.logWarn(fmt.Sprintf("Warning: Serato already has a smart crate named %q; the crate from the rules file will show up alongside it.", name))
+		}
+	}
+}
+
+// log sends an informational message to Options.Log, marked in a dry run.
+func (r *run) log(message string) {
+	r.emit(LevelInfo, message)
+}
+
+// logWarn sends a warning to Options.Log, marked in a dry run.
+func (r *run) logWarn(message string) {
+	r.emit(LevelWarn, message)
+}
+
+// logError sends an error to Options.Log, marked in a dry run.
+func (r *run) logError(message string) {
+	r.emit(LevelError, message)
+}
+
+func (r *run) emit(level Level, message string) {
+	if r.opts.Log == nil {
+		return
+	}
+	if r.opts.DryRun {
+		message = "[DRY RUN] " + message
+	}
+	r.opts.Log(level, message)
+}
+
+// phaseProgress returns a progress callback for one phase.
+func (r *run) phaseProgress(phase string) func(current, total int) {
+	return func(current, total int) {
+		if r.opts.Progress != nil {
+			r.opts.Progress(phase, current, total)
+		}
+	}
+}
+
+// checkCancelled returns ctx.Err(), logging if the sync was cancelled.
+func (r *run) checkCancelled() error {
+	if err := r.ctx.Err(); err != nil {
+		r.log("Sync cancelled.")
+		return err
+	}
+	return nil
+}
+
+// databaseGroup is a Serato folder and the library roots synced into it.
+type databaseGroup struct {
+	seratoDir string
+	roots     []string
+}
+
+// groupByDatabase splits library roots by the Serato database they belong
+// to, keeping the configured database first and the others in the order
+// their roots were given.
+func groupByDatabase(seratoDir string, roots []string) []databaseGroup {
+	groups := []databaseGroup{{seratoDir: seratoDir}}
+	index := map[string]int{seratoDir: 0}
+	for _, root := range roots {
+		dir := serato.DatabaseDirFor(seratoDir, root)
+		i, ok := index[dir]
+		if !ok {
+			i = len(groups)
+			index[dir] = i
+			groups = append(groups, databaseGroup{seratoDir: dir})
+		}
+		groups[i].roots = append(groups[i].roots, root)
+	}
+	if len(groups[0].roots) == 0 {
+		groups = groups[1:]
+	}
+	return groups
+}
+
+// ScanOptions returns the library scan settings cfg asks for, as a sync
+// uses them.
+func ScanOptions(cfg *config.Config) library.ScanOptions {
+	return library.ScanOptions{
+		Workers:        cfg.ScanWorkers,
+		Extensions:     serato.ExtensionSet(cfg.AudioExtensions),
+		Ignore:         append(append([]string{}, library.DefaultIgnorePatterns...), cfg.IgnorePatterns...),
+		FollowSymlinks: cfg.FollowSymlinks,
+	}
+}
+
+// rootsContaining returns the library roots that have folder, given
+// relative to the root, as a directory.
+func rootsContaining(roots []string, folder string) []string {
+	var found []string
+	for _, root := range roots {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(folder)))
+		if err == nil && info.IsDir() {
+			found = append(found, root)
+		}
+	}
+	return found
+}
@@ -0,0 +1,1102 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"seratosync-go/config"
+	"seratosync-go/serato"
+)
+
+// fixture is a music library and a Serato folder holding an empty database,
+// side by side in a temporary directory.
+type fixture struct {
+	t       *testing.T
+	library string
+	serato  string
+	cfg     *config.Config
+}
+
+// newFixture creates a fixture with the given files, relative to the
+// library, each holding a few bytes.
+func newFixture(t *testing.T, files ...string) *fixture {
+	t.Helper()
+	root := t.TempDir()
+	f := &fixture{
+		t:       t,
+		library: filepath.Join(root, "Music"),
+		serato:  filepath.Join(root, "_Serato_"),
+	}
+	if err := os.MkdirAll(f.library, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := serato.WriteDatabase(f.dbPath(), &serato.Database{Version: serato.DatabaseVrsn}); err != nil {
+		t.Fatal(err)
+	}
+	f.cfg = &config.Config{SeratoDBPath: f.serato, MusicLibraryPath: f.library}
+	for _, file := range files {
+		f.addFile(file)
+	}
+	return f
+}
+
+func (f *fixture) dbPath() string {
+	return filepath.Join(f.serato, serato.DatabaseFile)
+}
+
+func (f *fixture) addFile(rel string) {
+	f.t.Helper()
+	path := filepath.Join(f.library, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) removeFile(rel string) {
+	f.t.Helper()
+	if err := os.Remove(filepath.Join(f.library, filepath.FromSlash(rel))); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// ptrk returns the database path of a library file.
+func (f *fixture) ptrk(rel string) string {
+	return serato.BuildPtrk(serato.LibraryPrefix(f.library), rel)
+}
+
+func (f *fixture) sync(opts Options) (*Result, error) {
+	return Run(context.Background(), f.cfg, opts)
+}
+
+// mustSync runs a sync that must succeed.
+func (f *fixture) mustSync(opts Options) *Result {
+	f.t.Helper()
+	result, err := f.sync(opts)
+	if err != nil {
+		f.t.Fatalf("sync: %v", err)
+	}
+	return result
+}
+
+// pfils returns the sorted track paths in the database.
+func (f *fixture) pfils() []string {
+	f.t.Helper()
+	db, err := serato.ReadDatabase(context.Background(), f.dbPath())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var pfils []string
+	for _, record := range db.Records {
+		pfil, _ := record["pfil"].(string)
+		pfils = append(pfils, pfil)
+	}
+	sort.Strings(pfils)
+	return pfils
+}
+
+// crateFiles returns the names of the crate files in the Serato folder.
+func (f *fixture) crateFiles() []string {
+	f.t.Helper()
+	files, err := serato.ListCrateFiles(f.serato)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = filepath.Base(file)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// crate returns the track paths in the crate file of the given name.
+func (f *fixture) crate(name string) []string {
+	f.t.Helper()
+	tracks, err := serato.ReadCrateFile(filepath.Join(f.serato, "Subcrates", name))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return tracks
+}
+
+// logLines collects the messages of a sync with their levels.
+type logLines []string
+
+func (l *logLines) add(level Level, message string) {
+	*l = append(*l, string(level)+": "+message)
+}
+
+// has reports whether a message at level starts with prefix, after the
+// dry run marker.
+func (l logLines) has(level Level, prefix string) bool {
+	for _, line := range l {
+		if strings.HasPrefix(strings.Replace(line, "[DRY RUN] ", "", 1), string(level)+": "+prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l logLines) String() string {
+	return strings.Join(l, "\n")
+}
+
+// failingDisk is the real file system, except that renames onto a file
+// named target fail with errDiskFailure.
+type failingDisk struct {
+	serato.FileSystem
+	target string
+}
+
+var errDiskFailure = errors.New("simulated disk failure")
+
+func (d failingDisk) Rename(oldpath, newpath string) error {
+	if filepath.Base(newpath) == d.target {
+		return errDiskFailure
+	}
+	return d.FileSystem.Rename(oldpath, newpath)
+}
+
+// useDisk swaps serato.Disk for the rest of the test.
+func useDisk(t *testing.T, disk serato.FileSystem) {
+	old := serato.Disk
+	serato.Disk = disk
+	t.Cleanup(func() { serato.Disk = old })
+}
+
+func TestRunFailsWhenDatabaseWriteFails(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	// A version change forces a full rewrite of the database.
+	f.cfg.DatabaseVersion = serato.DatabaseVrsn
+	before, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	useDisk(t, failingDisk{FileSystem: serato.Disk, target: serato.DatabaseFile})
+
+	var logged logLines
+	_, err = f.sync(Options{Log: logged.add})
+	if !errors.Is(err, errDiskFailure) {
+		t.Fatalf("sync error = %v, want %v", err, errDiskFailure)
+	}
+	if crates := f.crateFiles(); len(crates) != 0 {
+		t.Errorf("crates written after the database failed: %v", crates)
+	}
+	after, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("database changed although its write failed")
+	}
+	if !logged.has(LevelError, "Error writing updated database") {
+		t.Errorf("failure not logged as an error:\n%s", logged)
+	}
+}
+
+// corruptingDisk is the real file system, except that files it creates
+// for database backups get every byte written to them flipped.
+type corruptingDisk struct {
+	serato.FileSystem
+}
+
+func (d corruptingDisk) Create(name string) (serato.File, error) {
+	file, err := d.FileSystem.Create(name)
+	if err != nil || !strings.Contains(filepath.Base(name), ".backup.") || strings.HasSuffix(name, ".sha256") {
+		return file, err
+	}
+	return corruptingFile{file}, nil
+}
+
+type corruptingFile struct {
+	serato.File
+}
+
+func (f corruptingFile) Write(p []byte) (int, error) {
+	flipped := make([]byte, len(p))
+	for i, b := range p {
+		flipped[i] = ^b
+	}
+	return f.File.Write(flipped)
+}
+
+func TestRunAbortsWhenBackupIsCorrupt(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	f.mustSync(Options{})
+	f.addFile("House/b.mp3")
+	before, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	useDisk(t, corruptingDisk{serato.Disk})
+
+	_, err = f.sync(Options{})
+	if !errors.Is(err, serato.ErrChecksumMismatch) {
+		t.Fatalf("sync error = %v, want %v", err, serato.ErrChecksumMismatch)
+	}
+	after, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("database written without a good backup")
+	}
+	if tracks := f.crate("House.crate"); len(tracks) != 1 {
+		t.Errorf("House crate = %v, want only the track of the first sync", tracks)
+	}
+}
+
+func TestRunPreservesModTimes(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	f.mustSync(Options{})
+	f.addFile("House/b.mp3")
+	f.cfg.PreserveModTimes = true
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	crateFile := filepath.Join(f.serato, "Subcrates", "House.crate")
+	for _, path := range []string{f.dbPath(), crateFile} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.mustSync(Options{})
+	for _, path := range []string{f.dbPath(), crateFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s modification time = %v, want %v", filepath.Base(path), info.ModTime(), modTime)
+		}
+	}
+	if tracks := f.crate("House.crate"); len(tracks) != 2 {
+		t.Errorf("House crate = %v, want both tracks", tracks)
+	}
+}
+
+func TestRunUsesConfiguredCrateColumns(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	f.cfg.CrateColumns = []string{" BPM ", "song", ""}
+	f.mustSync(Options{})
+
+	crate, err := serato.ReadCrateFull(filepath.Join(f.serato, "Subcrates", "House.crate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := serato.Files{CrateColumns: []string{"bpm", "song"}}.DefaultCrateHeader()
+	if len(crate.Header) != len(want) {
+		t.Fatalf("crate has %d header chunks, want %d", len(crate.Header), len(want))
+	}
+	for i, chunk := range crate.Header {
+		if chunk.Tag != want[i].Tag || string(chunk.Value) != string(want[i].Value) {
+			t.Errorf("header chunk %d = %s %q, want %s %q", i, chunk.Tag, chunk.Value, want[i].Tag, want[i].Value)
+		}
+	}
+}
+
+// writeDatabase replaces the fixture's database with one holding a record
+// for each pfil.
+func (f *fixture) writeDatabase(pfils ...string) {
+	f.t.Helper()
+	records := make([]serato.Record, len(pfils))
+	for i, pfil := range pfils {
+		records[i] = serato.Record{"pfil": pfil, "ttyp": "mp3"}
+	}
+	if err := serato.WriteDatabase(f.dbPath(), &serato.Database{Version: serato.DatabaseVrsn, Records: records}); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// assertUnchanged fails the test if the database differs from before or
+// any crate was written.
+func (f *fixture) assertUnchanged(before []byte) {
+	f.t.Helper()
+	after, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		f.t.Error("database changed")
+	}
+	if crates := f.crateFiles(); len(crates) != 0 {
+		f.t.Errorf("crates written: %v", crates)
+	}
+}
+
+func TestRunAbortsWhenNoDatabaseTrackMatchesPrefix(t *testing.T) {
+	f := newFixture(t)
+	var pfils []string
+	for i := 0; i < 20; i++ {
+		f.addFile(fmt.Sprintf("House/new%02d.mp3", i))
+		pfils = append(pfils, fmt.Sprintf("Elsewhere/old%02d.mp3", i))
+	}
+	f.writeDatabase(pfils...)
+	before, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.sync(Options{})
+	if !errors.Is(err, ErrPrefixMismatch) {
+		t.Fatalf("sync error = %v, want %v", err, ErrPrefixMismatch)
+	}
+	f.assertUnchanged(before)
+
+	// With the check off, the library is synced as new.
+	f.cfg.MinPrefixMatch = -1
+	result := f.mustSync(Options{})
+	if result.TracksAdded != 20 {
+		t.Errorf("TracksAdded = %d, want 20", result.TracksAdded)
+	}
+}
+
+func TestRunAbortsWhenTracksAreUnderAnotherPrefix(t *testing.T) {
+	f := newFixture(t)
+	var pfils []string
+	for i := 0; i < 20; i++ {
+		rel := fmt.Sprintf("House/track%02d.mp3", i)
+		f.addFile(rel)
+		pfils = append(pfils, "Old Drive/Music/"+rel)
+	}
+	f.writeDatabase(pfils...)
+	before, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.sync(Options{})
+	if !errors.Is(err, ErrPrefixMismatch) || !strings.Contains(err.Error(), `"Old Drive/Music"`) {
+		t.Fatalf("sync error = %v, want %v naming the other prefix", err, ErrPrefixMismatch)
+	}
+	f.assertUnchanged(before)
+}
+
+func TestRunAllowsDatabaseMostlyUnderPrefix(t *testing.T) {
+	f := newFixture(t)
+	pfils := []string{"Elsewhere/other.mp3"}
+	for i := 0; i < 20; i++ {
+		rel := fmt.Sprintf("House/track%02d.mp3", i)
+		f.addFile(rel)
+		if i < 5 {
+			pfils = append(pfils, f.ptrk(rel))
+		}
+	}
+	f.writeDatabase(pfils...)
+
+	result := f.mustSync(Options{})
+	if result.TracksAdded != 15 {
+		t.Errorf("TracksAdded = %d, want 15", result.TracksAdded)
+	}
+}
+
+func TestRunRenamesCratesWhenTransformIsTurnedOn(t *testing.T) {
+	f := newFixture(t, "deep_house/a.mp3", "deep_house/summer_2024/b.mp3")
+	f.mustSync(Options{})
+	if got, want := f.crateFiles(), []string{"deep_house%%summer_2024.crate", "deep_house.crate"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("crates = %v, want %v", got, want)
+	}
+
+	f.cfg.CrateNameUnderscores = true
+	f.cfg.CrateNameTitleCase = true
+	f.mustSync(Options{DryRun: true})
+	if got, want := f.crateFiles(), []string{"deep_house%%summer_2024.crate", "deep_house.crate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run renamed crates: %v", got)
+	}
+
+	result := f.mustSync(Options{})
+	if got, want := f.crateFiles(), []string{"Deep House%%Summer 2024.crate", "Deep House.crate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("crates = %v, want %v", got, want)
+	}
+	if result.CratesRenamed != 2 {
+		t.Errorf("CratesRenamed = %d, want 2", result.CratesRenamed)
+	}
+	if got, want := f.crate("Deep House%%Summer 2024.crate"), []string{f.ptrk("deep_house/summer_2024/b.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("renamed crate holds %v, want %v", got, want)
+	}
+}
+
+func TestRunLogLevels(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	var logged logLines
+	f.mustSync(Options{Log: logged.add})
+	for _, line := range logged {
+		if !strings.HasPrefix(line, string(LevelInfo)+": ") {
+			t.Errorf("clean sync logged %q", line)
+		}
+	}
+
+	// The guard of a misconfigured library fails a dry run too.
+	g := newFixture(t)
+	var pfils []string
+	for i := 0; i < 20; i++ {
+		g.addFile(fmt.Sprintf("House/new%02d.mp3", i))
+		pfils = append(pfils, fmt.Sprintf("Elsewhere/old%02d.mp3", i))
+	}
+	g.writeDatabase(pfils...)
+	logged = nil
+	if _, err := g.sync(Options{DryRun: true, Log: logged.add}); !errors.Is(err, ErrPrefixMismatch) {
+		t.Fatalf("sync error = %v, want %v", err, ErrPrefixMismatch)
+	}
+	if !logged.has(LevelError, "Error: only 0 of the 20 database tracks") {
+		t.Errorf("prefix mismatch not logged as an error:\n%s", logged)
+	}
+}
+
+func TestRunKeepsManuallyAddedCrateTracks(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	f.mustSync(Options{})
+	manual := "Other Drive/Edits/manual.mp3"
+	crateFile := filepath.Join(f.serato, "Subcrates", "House.crate")
+	if _, err := serato.WriteCrateFile(crateFile, append(f.crate("House.crate"), manual)); err != nil {
+		t.Fatal(err)
+	}
+
+	f.addFile("House/b.mp3")
+	f.mustSync(Options{})
+	want := []string{f.ptrk("House/a.mp3"), manual, f.ptrk("House/b.mp3")}
+	if got := f.crate("House.crate"); !reflect.DeepEqual(got, want) {
+		t.Errorf("House crate = %v, want %v", got, want)
+	}
+}
+
+func TestRunPrunesDeletedTracks(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "House/b.mp3", "Techno/c.mp3")
+	f.mustSync(Options{})
+	other := "Other Drive/x.mp3"
+	db, err := serato.ReadDatabase(context.Background(), f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Records = append(db.Records, serato.Record{"pfil": other, "ttyp": "mp3"})
+	if err := serato.WriteDatabase(f.dbPath(), db); err != nil {
+		t.Fatal(err)
+	}
+	manual := filepath.Join(f.serato, "Subcrates", "Manual.crate")
+	if _, err := serato.WriteCrateFile(manual, []string{f.ptrk("House/b.mp3"), other}); err != nil {
+		t.Fatal(err)
+	}
+	f.removeFile("House/b.mp3")
+
+	// Without PruneMissing the deleted track stays.
+	f.mustSync(Options{})
+	if got := f.pfils(); len(got) != 4 {
+		t.Fatalf("database = %v, want all four tracks", got)
+	}
+
+	f.cfg.PruneMissing = true
+	result := f.mustSync(Options{})
+	if result.TracksPruned != 1 {
+		t.Errorf("TracksPruned = %d, want 1", result.TracksPruned)
+	}
+	want := []string{f.ptrk("House/a.mp3"), f.ptrk("Techno/c.mp3"), other}
+	sort.Strings(want)
+	if got := f.pfils(); !reflect.DeepEqual(got, want) {
+		t.Errorf("database = %v, want %v", got, want)
+	}
+	if got, want := f.crate("House.crate"), []string{f.ptrk("House/a.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("House crate = %v, want %v", got, want)
+	}
+	if got, want := f.crate("Techno.crate"), []string{f.ptrk("Techno/c.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Techno crate = %v, want %v", got, want)
+	}
+	if got, want := f.crate("Manual.crate"), []string{other}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Manual crate = %v, want %v", got, want)
+	}
+}
+
+func TestRunResultCounts(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "House/b.mp3", "Techno/c.mp3", "House/cover.jpg")
+	type counts struct {
+		FilesScanned, TracksBefore, TracksAfter, TracksAdded, TracksExisting, CratesWritten, TracksWritten int
+	}
+	countsOf := func(r *Result) counts {
+		return counts{r.FilesScanned, r.TracksBefore, r.TracksAfter, r.TracksAdded, r.TracksExisting, r.CratesWritten, r.TracksWritten}
+	}
+
+	if got, want := countsOf(f.mustSync(Options{})), (counts{3, 0, 3, 3, 0, 2, 3}); got != want {
+		t.Errorf("first sync counted %+v, want %+v", got, want)
+	}
+	// Only the crate that changed is written, with all of its tracks.
+	f.addFile("Techno/d.mp3")
+	result := f.mustSync(Options{})
+	if got, want := countsOf(result), (counts{4, 3, 4, 1, 3, 1, 2}); got != want {
+		t.Errorf("second sync counted %+v, want %+v", got, want)
+	}
+	if want := []string{f.ptrk("Techno/d.mp3")}; !reflect.DeepEqual(result.NewTracks, want) {
+		t.Errorf("second sync added %v, want %v", result.NewTracks, want)
+	}
+}
+
+func TestRunCountsTracksOutsideLibrary(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "House/b.mp3", "Techno/c.mp3")
+	f.cfg.MinPrefixMatch = -1
+	f.writeDatabase(f.ptrk("House/a.mp3"), "Elsewhere/x.mp3", "Other Drive/Music/y.mp3")
+
+	var logged logLines
+	result, err := f.sync(Options{Log: logged.add})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TracksAdded != 2 || result.TracksExisting != 1 || result.TracksOutsideLibrary != 2 {
+		t.Errorf("counted %d added, %d existing, %d outside, want 2, 1, 2", result.TracksAdded, result.TracksExisting, result.TracksOutsideLibrary)
+	}
+	if !logged.has(LevelInfo, "Found 2 new tracks; 1 are already in the database.") {
+		t.Errorf("breakdown not logged: %v", logged)
+	}
+}
+
+func TestRunWarnsAboutUndisplayablePaths(t *testing.T) {
+	for _, astral := range []bool{false, true} {
+		f := newFixture(t, "House/a.mp3", "House/bell\a.mp3", "House/🔥.mp3")
+		f.cfg.WarnAstralPaths = astral
+		var logged logLines
+		result, err := f.sync(Options{Log: logged.add})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{filepath.Join(f.library, "House", "bell\a.mp3")}
+		if astral {
+			want = append(want, filepath.Join(f.library, "House", "🔥.mp3"))
+		}
+		if !reflect.DeepEqual(result.Undisplayable, want) {
+			t.Errorf("astral %v: Undisplayable = %q, want %q", astral, result.Undisplayable, want)
+		}
+		if !logged.has(LevelWarn, "Warning: Serato may not display") {
+			t.Errorf("astral %v: no warning logged: %v", astral, logged)
+		}
+		// The tracks are warned about, not skipped.
+		if result.TracksAdded != 3 {
+			t.Errorf("astral %v: TracksAdded = %d, want 3", astral, result.TracksAdded)
+		}
+	}
+}
+
+func TestRunRefusesFileThatIsNotADatabase(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	if _, err := serato.WriteCrateFile(f.dbPath(), []string{"Music/House/b.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.sync(Options{}); err == nil || !strings.Contains(err.Error(), "not a Serato database") {
+		t.Errorf("sync error = %v, want one saying the file is not a Serato database", err)
+	}
+	f.assertUnchanged(before)
+}
+
+func TestRunWritesCratesConcurrently(t *testing.T) {
+	const folders = 200
+	var files []string
+	for i := 0; i < folders; i++ {
+		files = append(files, fmt.Sprintf("Genre %03d/a.mp3", i), fmt.Sprintf("Genre %03d/b.mp3", i))
+	}
+	for _, workers := range []int{1, 16} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			f := newFixture(t, files...)
+			f.cfg.CrateWorkers = workers
+			// One crate fails to write, which mustn't stop the others.
+			useDisk(t, failingDisk{serato.Disk, "Genre 007.crate"})
+			var logged logLines
+			result := f.mustSync(Options{Log: logged.add})
+
+			if result.CratesWritten != folders-1 || result.TracksWritten != 2*(folders-1) {
+				t.Errorf("wrote %d crates with %d tracks, want %d with %d", result.CratesWritten, result.TracksWritten, folders-1, 2*(folders-1))
+			}
+			if !logged.has(LevelError, "Error writing crate file") {
+				t.Errorf("failed crate not logged:\n%s", logged)
+			}
+			if crates := f.crateFiles(); len(crates) != folders-1 {
+				t.Errorf("found %d crate files, want %d", len(crates), folders-1)
+			}
+			for i := 0; i < folders; i++ {
+				if i == 7 {
+					continue
+				}
+				folder := fmt.Sprintf("Genre %03d", i)
+				want := []string{f.ptrk(folder + "/a.mp3"), f.ptrk(folder + "/b.mp3")}
+				if got := f.crate(folder + ".crate"); !reflect.DeepEqual(got, want) {
+					t.Errorf("crate %s holds %v, want %v", folder, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRemovesEmptiedCrates(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep=%v", keep), func(t *testing.T) {
+			f := newFixture(t, "House/a.mp3", "Techno/b.mp3", "Techno/c.mp3")
+			f.cfg.PruneMissing = true
+			f.cfg.KeepEmptyCrates = keep
+			f.mustSync(Options{})
+			// A crate the user made from the same tracks is never removed.
+			manual := filepath.Join(f.serato, "Subcrates", "Favourites.crate")
+			if _, err := serato.WriteCrateFile(manual, []string{f.ptrk("Techno/b.mp3"), f.ptrk("Techno/c.mp3")}); err != nil {
+				t.Fatal(err)
+			}
+			f.removeFile("Techno/b.mp3")
+			f.removeFile("Techno/c.mp3")
+
+			var logged logLines
+			result := f.mustSync(Options{Log: logged.add})
+			want, wantRemoved := []string{"Favourites.crate", "House.crate"}, 1
+			if keep {
+				want, wantRemoved = []string{"Favourites.crate", "House.crate", "Techno.crate"}, 0
+			}
+			if got := f.crateFiles(); !reflect.DeepEqual(got, want) {
+				t.Errorf("crates = %v, want %v", got, want)
+			}
+			if result.CratesRemoved != wantRemoved {
+				t.Errorf("CratesRemoved = %d, want %d", result.CratesRemoved, wantRemoved)
+			}
+			if got := logged.has(LevelInfo, "Removed empty crate Techno.crate"); got == keep {
+				t.Errorf("removal logged: %v, want %v\n%s", got, !keep, logged)
+			}
+			if got := f.crate("Favourites.crate"); len(got) != 0 {
+				t.Errorf("hand-made crate holds %v after pruning", got)
+			}
+		})
+	}
+}
+
+func TestRunCaseInsensitivePaths(t *testing.T) {
+	for _, caseInsensitive := range []bool{false, true} {
+		t.Run(fmt.Sprintf("%v", caseInsensitive), func(t *testing.T) {
+			f := newFixture(t, "House/Song.MP3")
+			f.writeDatabase(f.ptrk("house/song.mp3"))
+			f.cfg.CaseInsensitivePaths = &caseInsensitive
+
+			result := f.mustSync(Options{})
+			want := []string{f.ptrk("house/song.mp3")}
+			if !caseInsensitive {
+				// The file is taken for a new track and added again.
+				want = append([]string{f.ptrk("House/Song.MP3")}, want...)
+			}
+			sort.Strings(want)
+			if got := f.pfils(); !reflect.DeepEqual(got, want) {
+				t.Errorf("database = %v, want %v", got, want)
+			}
+			if (len(result.NewTracks) == 0) != caseInsensitive {
+				t.Errorf("new tracks = %v", result.NewTracks)
+			}
+		})
+	}
+}
+
+func TestRunSetsDefaultTags(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "House/b.FLAC")
+	before := time.Now().Unix()
+	f.mustSync(Options{})
+	db, err := serato.ReadDatabase(context.Background(), f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Records) != 2 {
+		t.Fatalf("database holds %d records, want 2", len(db.Records))
+	}
+	for i, ttyp := range []string{"mp3", "flac"} {
+		record := db.Records[i]
+		if record["ttyp"] != ttyp {
+			t.Errorf("%v has ttyp %v, want %q", record["pfil"], record["ttyp"], ttyp)
+		}
+		uadd, _ := record["uadd"].(uint32)
+		if int64(uadd) < before || int64(uadd) > time.Now().Unix() {
+			t.Errorf("%v has uadd %v", record["pfil"], record["uadd"])
+		}
+		if record["tadd"] != fmt.Sprint(uadd) {
+			t.Errorf("%v has tadd %v, want %d", record["pfil"], record["tadd"], uadd)
+		}
+	}
+}
+
+func TestRunMatchesUnicodeForms(t *testing.T) {
+	// On disk in NFD, as macOS hands out names; in the database in NFC.
+	f := newFixture(t, "Cafe\u0301/Beyonce\u0301.mp3")
+	f.writeDatabase(f.ptrk("Café/Beyoncé.mp3"))
+	f.addFile("Cafe\u0301/Ne\u0301e.mp3")
+
+	result := f.mustSync(Options{})
+	// Only the new track is added, in the form it has on disk.
+	want := []string{f.ptrk("Cafe\u0301/Ne\u0301e.mp3")}
+	if !reflect.DeepEqual(result.NewTracks, want) {
+		t.Errorf("new tracks = %+q, want %+q", result.NewTracks, want)
+	}
+	if got := f.pfils(); len(got) != 2 || !strings.Contains(got[0]+got[1], "Ne\u0301e.mp3") {
+		t.Errorf("database = %+q", got)
+	}
+	// A second sync finds nothing new.
+	if result := f.mustSync(Options{}); len(result.NewTracks) != 0 {
+		t.Errorf("second sync added %+q", result.NewTracks)
+	}
+}
+
+func TestRunFlatCratesUnderParent(t *testing.T) {
+	f := newFixture(t, "House/Deep/a.mp3", "Techno/Deep/b.mp3", "Techno/c.mp3")
+	f.cfg.CrateParent = "Auto-Imported"
+	f.cfg.FlatCrates = true
+	f.mustSync(Options{})
+
+	want := []string{"Auto-Imported%%Deep.crate", "Auto-Imported%%Techno.crate", "Auto-Imported.crate"}
+	if got := f.crateFiles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("crates = %v, want %v", got, want)
+	}
+	tracks := f.crate("Auto-Imported%%Deep.crate")
+	sort.Strings(tracks)
+	if want := []string{f.ptrk("House/Deep/a.mp3"), f.ptrk("Techno/Deep/b.mp3")}; !reflect.DeepEqual(tracks, want) {
+		t.Errorf("Deep crate holds %v, want %v", tracks, want)
+	}
+}
+
+func TestRunNoCrateFolders(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "Bootlegs/b.mp3", "Bootlegs/90s/c.mp3")
+	f.cfg.NoCrateFolders = []string{"Bootlegs"}
+	f.mustSync(Options{})
+
+	// The tracks reach the database, but only House gets a crate.
+	want := []string{f.ptrk("Bootlegs/90s/c.mp3"), f.ptrk("Bootlegs/b.mp3"), f.ptrk("House/a.mp3")}
+	if got := f.pfils(); !reflect.DeepEqual(got, want) {
+		t.Errorf("database = %v, want %v", got, want)
+	}
+	if got := f.crateFiles(); !reflect.DeepEqual(got, []string{"House.crate"}) {
+		t.Errorf("crates = %v, want only House.crate", got)
+	}
+}
+
+func TestRunTrackFilter(t *testing.T) {
+	f := newFixture(t, "House/a.flac", "House/b.mp3", "Techno/c.mp3")
+	f.mustSync(Options{Include: []string{"**/*.flac"}})
+
+	if got, want := f.pfils(), []string{f.ptrk("House/a.flac")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("database = %v, want %v", got, want)
+	}
+	if got, want := f.crateFiles(), []string{"House.crate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("crates = %v, want %v", got, want)
+	}
+	if got, want := f.crate("House.crate"), []string{f.ptrk("House/a.flac")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("House crate = %v, want %v", got, want)
+	}
+}
+
+func TestRunTrackFilterInvalidPattern(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	before, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.sync(Options{Exclude: []string{"[a-"}}); err == nil {
+		t.Fatal("Run accepted a malformed pattern")
+	}
+	f.assertUnchanged(before)
+}
+
+func TestRunRenamedFolder(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "House/Dub/b.mp3", "Techno/c.mp3")
+	f.mustSync(Options{})
+	if err := os.Rename(filepath.Join(f.library, "House"), filepath.Join(f.library, "Deep House")); err != nil {
+		t.Fatal(err)
+	}
+
+	f.cfg.DetectRenamedFolders = true
+	result := f.mustSync(Options{})
+	if result.CratesRenamed != 1 {
+		t.Errorf("CratesRenamed = %d, want 1", result.CratesRenamed)
+	}
+	want := []string{"Deep House%%Dub.crate", "Deep House.crate", "Techno.crate"}
+	if got := f.crateFiles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("crates = %v, want %v", got, want)
+	}
+	if got := f.crate("Deep House.crate"); !slices.Contains(got, f.ptrk("Deep House/a.mp3")) {
+		t.Errorf("Deep House crate = %v, want it to hold the renamed folder's track", got)
+	}
+}
+
+func TestRunDatabaseFileAndVersion(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	f.cfg.DatabaseFile = "database V3"
+	f.cfg.DatabaseVersion = "3.0/Serato DJ Database"
+	path := filepath.Join(f.serato, "database V3")
+	if err := serato.WriteDatabase(path, &serato.Database{Version: serato.DatabaseVrsn}); err != nil {
+		t.Fatal(err)
+	}
+	f.mustSync(Options{})
+
+	version, err := serato.ReadDatabaseVersion(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != f.cfg.DatabaseVersion {
+		t.Errorf("version = %q, want %q", version, f.cfg.DatabaseVersion)
+	}
+	db, err := serato.ReadDatabase(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Records) != 1 {
+		t.Errorf("database V3 holds %d records, want 1", len(db.Records))
+	}
+	// The default database is left alone.
+	if got := f.pfils(); len(got) != 0 {
+		t.Errorf("database V2 = %v, want it empty", got)
+	}
+}
+
+func TestRunFirstSyncOnNewInstall(t *testing.T) {
+	for _, name := range []string{"empty.database", "header-only.database"} {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t, "House/a.mp3")
+			data, err := os.ReadFile(filepath.Join("..", "serato", "testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(f.dbPath(), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			f.mustSync(Options{})
+
+			if got, want := f.pfils(), []string{f.ptrk("House/a.mp3")}; !reflect.DeepEqual(got, want) {
+				t.Errorf("database = %v, want %v", got, want)
+			}
+			if got := f.crateFiles(); !reflect.DeepEqual(got, []string{"House.crate"}) {
+				t.Errorf("crates = %v, want House.crate", got)
+			}
+		})
+	}
+}
+
+func TestRunRootTracks(t *testing.T) {
+	f := newFixture(t, "a.mp3", "House/b.mp3")
+	var logged logLines
+	f.mustSync(Options{Log: logged.add})
+
+	// Without a root crate, root tracks reach the database only.
+	if got, want := f.pfils(), []string{f.ptrk("House/b.mp3"), f.ptrk("a.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("database = %v, want %v", got, want)
+	}
+	if got := f.crateFiles(); !reflect.DeepEqual(got, []string{"House.crate"}) {
+		t.Errorf("crates = %v, want House.crate", got)
+	}
+	if !logged.has(LevelInfo, "1 tracks directly in") {
+		t.Errorf("database-only root tracks weren't logged:\n%s", logged)
+	}
+
+	// With one, they get a crate named after the library folder.
+	g := newFixture(t, "a.mp3", "House/b.mp3")
+	g.cfg.RootCrate = true
+	g.mustSync(Options{})
+	if got := g.crateFiles(); !reflect.DeepEqual(got, []string{"House.crate", "Music.crate"}) {
+		t.Errorf("crates = %v, want House.crate and Music.crate", got)
+	}
+	if got, want := g.crate("Music.crate"), []string{g.ptrk("a.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Music crate = %v, want %v", got, want)
+	}
+}
+
+func TestRunSkipsUnchangedCrates(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "Techno/b.mp3")
+	// The database lost the tracks, but the House crate still has them.
+	houseCrate := filepath.Join(f.serato, "Subcrates", "House.crate")
+	if _, err := serato.WriteCrateFile(houseCrate, []string{f.ptrk("House/a.mp3")}); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(houseCrate, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	result := f.mustSync(Options{})
+	if result.CratesUnchanged != 1 {
+		t.Errorf("CratesUnchanged = %d, want 1", result.CratesUnchanged)
+	}
+	if want := []string{filepath.Join(f.serato, "Subcrates", "Techno.crate")}; !reflect.DeepEqual(result.CratesToWrite, want) {
+		t.Errorf("CratesToWrite = %v, want %v", result.CratesToWrite, want)
+	}
+	info, err := os.Stat(houseCrate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Error("the unchanged House crate was rewritten")
+	}
+}
+
+func TestRunMatchesMovedFiles(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	f.cfg.ScanCache = true
+	f.cfg.MatchMovedFiles = true
+	opts := Options{CachePath: filepath.Join(t.TempDir(), "scan-cache.json")}
+	f.mustSync(opts)
+
+	if err := os.MkdirAll(filepath.Join(f.library, "Archive"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(f.library, "House", "a.mp3"), filepath.Join(f.library, "Archive", "a.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	result := f.mustSync(opts)
+
+	if result.TracksMoved != 1 || len(result.NewTracks) != 0 {
+		t.Errorf("moved %d tracks and added %v, want 1 moved and none added", result.TracksMoved, result.NewTracks)
+	}
+	if got, want := f.pfils(), []string{f.ptrk("Archive/a.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("database = %v, want %v", got, want)
+	}
+}
+
+func TestRunBackupDir(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	f.cfg.BackupDir = filepath.Join(t.TempDir(), "backups")
+	f.mustSync(Options{})
+
+	backups, err := serato.ListBackupsIn(f.dbPath(), f.cfg.BackupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("%d backups in the backup folder, want 1", len(backups))
+	}
+	if nextTo, _ := serato.ListBackups(f.dbPath()); len(nextTo) != 0 {
+		t.Errorf("backups next to the database: %v", nextTo)
+	}
+}
+
+func TestRunAbortsOnFullDisk(t *testing.T) {
+	f := newFixture(t, "House/a.mp3", "Techno/b.mp3")
+	f.cfg.BackupDir = filepath.Join(t.TempDir(), "backups")
+	before, err := os.ReadFile(f.dbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := serato.FreeSpace
+	t.Cleanup(func() { serato.FreeSpace = saved })
+
+	// First the Serato drive is full, then only the backup drive.
+	for _, fullDir := range []string{f.serato, f.cfg.BackupDir} {
+		serato.FreeSpace = func(dir string) (int64, error) {
+			if dir == fullDir {
+				return 0, nil
+			}
+			return 1 << 40, nil
+		}
+		var logged logLines
+		_, err := f.sync(Options{Log: logged.add})
+		if !errors.Is(err, serato.ErrDiskFull) || !strings.Contains(err.Error(), fullDir) {
+			t.Fatalf("sync with %s full: error = %v, want %v naming it", fullDir, err, serato.ErrDiskFull)
+		}
+		if !logged.has(LevelError, "Error: not enough free space") {
+			t.Errorf("abort not logged: %v", logged)
+		}
+		f.assertUnchanged(before)
+		if backups, _ := serato.ListBackupsIn(f.dbPath(), f.cfg.BackupDir); len(backups) != 0 {
+			t.Errorf("backups written: %v", backups)
+		}
+	}
+
+	// A dry run writes nothing, so it isn't stopped.
+	serato.FreeSpace = func(string) (int64, error) { return 0, nil }
+	if _, err := f.sync(Options{DryRun: true}); err != nil {
+		t.Errorf("dry run on a full disk: %v", err)
+	}
+}
+
+func TestRunSyncModes(t *testing.T) {
+	tests := []struct {
+		mode       config.SyncMode
+		wantTracks []string
+		wantCrates []string
+		wantHouse  []string
+		wantLog    string
+	}{
+		{
+			config.SyncAddOnly,
+			[]string{"House/a.mp3", "House/b.mp3", "House/d.mp3", "Techno/c.mp3"},
+			[]string{"House.crate", "Techno.crate"},
+			[]string{"House/a.mp3", "House/b.mp3", "House/d.mp3"},
+			"Sync Mode: Add Only",
+		},
+		{
+			config.SyncMirror,
+			[]string{"House/a.mp3", "House/d.mp3"},
+			[]string{"House.crate"},
+			[]string{"House/a.mp3", "House/d.mp3"},
+			"Sync Mode: Mirror",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			f := newFixture(t, "House/b.mp3", "House/a.mp3", "Techno/c.mp3")
+			f.mustSync(Options{})
+			f.removeFile("House/b.mp3")
+			f.removeFile("Techno/c.mp3")
+			f.addFile("House/d.mp3")
+
+			f.cfg.SyncMode = tt.mode
+			var logged logLines
+			result := f.mustSync(Options{Log: logged.add})
+			if result.SyncMode != f.cfg.Mode() {
+				t.Errorf("SyncMode = %q, want %q", result.SyncMode, f.cfg.Mode())
+			}
+			if !logged.has(LevelInfo, tt.wantLog) {
+				t.Errorf("summary doesn't state the mode:\n%s", logged)
+			}
+
+			var want []string
+			for _, rel := range tt.wantTracks {
+				want = append(want, f.ptrk(rel))
+			}
+			if got := f.pfils(); !reflect.DeepEqual(got, want) {
+				t.Errorf("database = %v, want %v", got, want)
+			}
+			if got := f.crateFiles(); !reflect.DeepEqual(got, tt.wantCrates) {
+				t.Errorf("crates = %v, want %v", got, tt.wantCrates)
+			}
+			want = nil
+			for _, rel := range tt.wantHouse {
+				want = append(want, f.ptrk(rel))
+			}
+			if got := f.crate("House.crate"); !reflect.DeepEqual(got, want) {
+				t.Errorf("House crate = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRunCratePrefixesPerRoot(t *testing.T) {
+	f := newFixture(t, "House/a.mp3")
+	external := filepath.Join(filepath.Dir(f.library), "External")
+	if err := os.MkdirAll(filepath.Join(external, "House"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(external, "House", "b.mp3"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.cfg.MusicLibraryPath = ""
+	f.cfg.MusicLibraryPaths = []string{f.library, external}
+	f.cfg.CratePrefixes = map[string]string{f.library: "Internal", external: "External"}
+	f.mustSync(Options{})
+
+	want := []string{"External%%House.crate", "External.crate", "Internal%%House.crate", "Internal.crate"}
+	if got := f.crateFiles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("crates = %v, want %v", got, want)
+	}
+	externalTrack := serato.BuildPtrk(serato.LibraryPrefix(external), "House/b.mp3")
+	if got := f.crate("External%%House.crate"); !reflect.DeepEqual(got, []string{externalTrack}) {
+		t.Errorf("External House crate = %v, want %v", got, []string{externalTrack})
+	}
+	if got := f.crate("Internal%%House.crate"); !reflect.DeepEqual(got, []string{f.ptrk("House/a.mp3")}) {
+		t.Errorf("Internal House crate = %v, want %v", got, []string{f.ptrk("House/a.mp3")})
+	}
+}
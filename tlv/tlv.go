@@ -3,6 +3,7 @@ package tlv
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
@@ -10,11 +11,23 @@ import (
 	"golang.org/x/text/transform"
 )
 
+// MaxChunkSize is the largest chunk IterTLV will allocate. A size field
+// beyond this almost certainly means the file is corrupt.
+var MaxChunkSize uint32 = 256 << 20
+
+// ErrChunkTooLarge is returned when a chunk header claims more data than
+// MaxChunkSize or than remains in the input.
+var ErrChunkTooLarge = errors.New("chunk size exceeds limit")
+
 // Chunk represents a TLV chunk.
 type Chunk struct {
 	Tag   string
 	Size  uint32
 	Value []byte
+	// Offset is where the chunk's header starts: from the start of the
+	// reader for IterTLV and IterTLVFunc, or of the buffer for
+	// IterNestedTLV and IterTLVLenient.
+	Offset int64
 }
 
 // MakeChunk creates a TLV chunk as a byte slice.
@@ -32,13 +45,17 @@ func WriteChunk(writer io.Writer, tag string, payload []byte) error {
 	return err
 }
 
-// EncodeU16BE encodes a string to UTF-16BE.
+// EncodeU16BE encodes a string to UTF-16BE. Characters outside the Basic
+// Multilingual Plane, such as emoji, become surrogate pairs, as Serato
+// stores them; invalid UTF-8 becomes U+FFFD.
 func EncodeU16BE(s string) ([]byte, error) {
 	encoder := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder()
 	return encoder.Bytes([]byte(s))
 }
 
-// DecodeU16BE decodes a UTF-16BE byte slice to a string.
+// DecodeU16BE decodes a UTF-16BE byte slice to a string. Surrogate pairs
+// decode to the character they encode, so text written by EncodeU16BE
+// comes back unchanged; an unpaired surrogate decodes to U+FFFD.
 func DecodeU16BE(b []byte) (string, error) {
 	reader := transform.NewReader(bytes.NewReader(b), unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder())
 	result, err := io.ReadAll(reader)
@@ -48,33 +65,204 @@ func DecodeU16BE(b []byte) (string, error) {
 	return string(result), nil
 }
 
+// DecodeU16 decodes UTF-16 text that may start with a byte order mark: FE FF
+// for big-endian or FF FE for little-endian, as some third-party tools
+// write. Without one the text is taken to be big-endian, as Serato writes
+// it. The byte order mark is not part of the result. Use EncodeU16BE to
+// write text back.
+func DecodeU16(b []byte) (string, error) {
+	reader := transform.NewReader(bytes.NewReader(b), unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder())
+	result, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// HasU16BOM reports whether b starts with a UTF-16 byte order mark in
+// either byte order.
+func HasU16BOM(b []byte) bool {
+	return len(b) >= 2 && ((b[0] == 0xFE && b[1] == 0xFF) || (b[0] == 0xFF && b[1] == 0xFE))
+}
+
+// ErrStop can be returned from an IterTLVFunc callback to end iteration
+// early without IterTLVFunc reporting an error.
+var ErrStop = errors.New("stop iteration")
+
 // IterTLV reads TLV chunks from an io.Reader.
 func IterTLV(reader io.Reader) ([]*Chunk, error) {
 	var chunks []*Chunk
+	err := IterTLVFunc(reader, func(chunk *Chunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// IterTLVFunc reads TLV chunks from an io.Reader one at a time, calling fn
+// for each. Iteration stops at the first error returned by fn, which is
+// passed back to the caller unless it is ErrStop.
+func IterTLVFunc(reader io.Reader, fn func(*Chunk) error) error {
+	var offset int64
 	for {
 		header := make([]byte, 8)
 		_, err := io.ReadFull(reader, header)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, fmt.Errorf("failed to read chunk header: %w", err)
+			return fmt.Errorf("failed to read chunk header at offset %d: %w", offset, err)
 		}
 
 		tag := string(header[0:4])
 		size := binary.BigEndian.Uint32(header[4:8])
 
-		value := make([]byte, size)
-		_, err = io.ReadFull(reader, value)
+		if size > MaxChunkSize {
+			return fmt.Errorf("chunk %q at offset %d claims %d bytes (max %d): %w", tag, offset, size, MaxChunkSize, ErrChunkTooLarge)
+		}
+		remaining, seekable := remainingBytes(reader)
+		if seekable && int64(size) > remaining {
+			return fmt.Errorf("chunk %q at offset %d claims %d bytes but only %d remain: %w", tag, offset, size, remaining, ErrChunkTooLarge)
+		}
+
+		var value []byte
+		if seekable {
+			value = make([]byte, size)
+			_, err = io.ReadFull(reader, value)
+		} else {
+			// Without knowing how much is left, the value grows as it is
+			// read, so a corrupt size can't make us allocate far more than
+			// the input holds.
+			value, err = io.ReadAll(io.LimitReader(reader, int64(size)))
+			if err == nil && int64(len(value)) < int64(size) {
+				err = io.ErrUnexpectedEOF
+			}
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read chunk value for tag %s: %w", tag, err)
+			return fmt.Errorf("failed to read chunk value for tag %s at offset %d: %w", tag, offset, err)
 		}
 
-		chunks = append(chunks, &Chunk{Tag: tag, Size: size, Value: value})
+		chunk := &Chunk{Tag: tag, Size: size, Value: value, Offset: offset}
+		offset += 8 + int64(size)
+		err = fn(chunk)
+		if err == ErrStop {
+			return nil
+		} else if err != nil {
+			return err
+		}
 	}
-	return chunks, nil
+	return nil
+}
+
+// remainingBytes reports how many bytes are left in reader, if it can seek.
+func remainingBytes(reader io.Reader) (int64, bool) {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return end - current, true
+}
+
+// Damage is a stretch of input that IterTLVLenient skipped because it
+// didn't hold valid chunks.
+type Damage struct {
+	Offset int
+	Length int
+}
+
+// IterTLVLenient is IterTLVFunc for input that may be damaged, such as a
+// database with a truncated record in the middle. A chunk is only passed to
+// fn if its header is sound, its value fits in data and it is followed by
+// the end of data or another sound header. Otherwise the input is skipped
+// up to the next chunk tagged with one of resyncTags that passes the same
+// checks, and the skipped stretch is reported as Damage. A chunk that fits
+// but is followed by junk with no such chunk after it is the last one
+// before trailing garbage, and is passed to fn with only the junk reported.
+// Chunk values share memory with data.
+func IterTLVLenient(data []byte, resyncTags []string, fn func(*Chunk) error) ([]Damage, error) {
+	var damage []Damage
+	pos := 0
+	for pos < len(data) {
+		end, ok := soundChunk(data, pos)
+		if !ok {
+			next := len(data)
+			for i := pos + 1; i+8 <= len(data); i++ {
+				if !hasTag(data[i:i+4], resyncTags) {
+					continue
+				}
+				if _, ok := soundChunk(data, i); ok {
+					next = i
+					break
+				}
+			}
+			if next < len(data) || !soundHeader(data, pos) {
+				damage = append(damage, Damage{Offset: pos, Length: next - pos})
+				pos = next
+				continue
+			}
+			// The last chunk, followed by trailing garbage.
+			end = pos + 8 + int(binary.BigEndian.Uint32(data[pos+4:pos+8]))
+		}
+		err := fn(&Chunk{Tag: string(data[pos : pos+4]), Size: uint32(end - pos - 8), Value: data[pos+8 : end], Offset: int64(pos)})
+		if err == ErrStop {
+			return damage, nil
+		} else if err != nil {
+			return damage, err
+		}
+		pos = end
+	}
+	return damage, nil
 }
 
-// IterNestedTLV iterates over nested TLV chunks in a byte slice.
+// soundChunk checks the chunk starting at pos in data as described for
+// IterTLVLenient, returning where it ends.
+func soundChunk(data []byte, pos int) (int, bool) {
+	if !soundHeader(data, pos) {
+		return 0, false
+	}
+	end := pos + 8 + int(binary.BigEndian.Uint32(data[pos+4:pos+8]))
+	return end, end == len(data) || soundHeader(data, end)
+}
+
+// soundHeader reports whether a plausible chunk header starts at pos: a
+// tag of ASCII letters and digits and a size that fits in the rest of data.
+func soundHeader(data []byte, pos int) bool {
+	if pos+8 > len(data) {
+		return false
+	}
+	for _, c := range data[pos : pos+4] {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	size := binary.BigEndian.Uint32(data[pos+4 : pos+8])
+	return size <= MaxChunkSize && int64(size) <= int64(len(data)-pos-8)
+}
+
+func hasTag(tag []byte, tags []string) bool {
+	for _, t := range tags {
+		if string(tag) == t {
+			return true
+		}
+	}
+	return false
+}
+
+// IterNestedTLV iterates over nested TLV chunks in a byte slice. A
+// trailing chunk that doesn't fit in buf is left out.
 func IterNestedTLV(buf []byte) ([]*Chunk, error) {
 	var chunks []*Chunk
 	pos := 0
@@ -83,11 +271,13 @@ func IterNestedTLV(buf []byte) ([]*Chunk, error) {
 		tag := string(buf[pos : pos+4])
 		size := binary.BigEndian.Uint32(buf[pos+4 : pos+8])
 		start := pos + 8
-		end := start + int(size)
-		if end > n {
+		// Compared before adding, so a huge size can't wrap around where
+		// int is 32 bits.
+		if int64(size) > int64(n-start) {
 			break
 		}
-		chunks = append(chunks, &Chunk{Tag: tag, Size: uint32(size), Value: buf[start:end]})
+		end := start + int(size)
+		chunks = append(chunks, &Chunk{Tag: tag, Size: uint32(size), Value: buf[start:end], Offset: int64(pos)})
 		pos = end
 	}
 	return chunks, nil
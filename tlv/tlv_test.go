@@ -0,0 +1,324 @@
+package tlv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// record returns an otrk chunk for a track, laid out as Serato writes one.
+func record(t testing.TB, pfil string) []byte {
+	t.Helper()
+	path, err := EncodeU16BE(pfil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kind, err := EncodeU16BE("mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := append(MakeChunk("ttyp", kind), MakeChunk("pfil", path)...)
+	nested = append(nested, MakeChunk("bmis", []byte{0})...)
+	nested = append(nested, MakeChunk("utme", []byte{0, 0, 0, 42})...)
+	return MakeChunk("otrk", nested)
+}
+
+// database returns a small Database V2 file.
+func database(t testing.TB, pfils ...string) []byte {
+	t.Helper()
+	version, err := EncodeU16BE("2.0/Serato Scratch LIVE Database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := MakeChunk("vrsn", version)
+	for _, pfil := range pfils {
+		data = append(data, record(t, pfil)...)
+	}
+	return data
+}
+
+// addSeeds adds valid input to f, along with the same input cut short and
+// with a size field made too large.
+func addSeeds(f *testing.F, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+		f.Add(seed[:len(seed)-1])
+		f.Add(seed[:len(seed)/2])
+		grown := bytes.Clone(seed)
+		grown[4] = 0xFF
+		f.Add(grown)
+	}
+	f.Add([]byte{})
+	f.Add([]byte("otrk"))
+}
+
+// nonSeeker hides the Seek method of a reader.
+type nonSeeker struct {
+	io.Reader
+}
+
+// joinChunks encodes chunks back into their bytes.
+func joinChunks(chunks []*Chunk) []byte {
+	var data []byte
+	for _, chunk := range chunks {
+		data = append(data, MakeChunk(chunk.Tag, chunk.Value)...)
+	}
+	return data
+}
+
+func FuzzIterTLV(f *testing.F) {
+	addSeeds(f, database(f, "Music/a.mp3", "Music/Café/🎵.mp3"), record(f, "Music/b.mp3"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		chunks, err := IterTLV(bytes.NewReader(data))
+		if err != nil {
+			if _, streamErr := IterTLV(nonSeeker{bytes.NewReader(data)}); streamErr == nil {
+				t.Fatalf("IterTLV failed with %v, but not on a reader that can't seek", err)
+			}
+			return
+		}
+		if got := joinChunks(chunks); !bytes.Equal(got, data) {
+			t.Fatalf("chunks encode to %x, want %x", got, data)
+		}
+		var offset int64
+		for _, chunk := range chunks {
+			if chunk.Offset != offset || int(chunk.Size) != len(chunk.Value) {
+				t.Fatalf("chunk %q has offset %d and size %d for %d bytes, want offset %d", chunk.Tag, chunk.Offset, chunk.Size, len(chunk.Value), offset)
+			}
+			offset += 8 + int64(chunk.Size)
+		}
+
+		streamed, err := IterTLV(nonSeeker{bytes.NewReader(data)})
+		if err != nil {
+			t.Fatalf("IterTLV failed on a reader that can't seek: %v", err)
+		}
+		if got := joinChunks(streamed); !bytes.Equal(got, data) {
+			t.Fatalf("chunks from a reader that can't seek encode to %x, want %x", got, data)
+		}
+	})
+}
+
+func FuzzIterNestedTLV(f *testing.F) {
+	addSeeds(f, database(f, "Music/a.mp3"), record(f, "Music/b.mp3")[8:])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		chunks, err := IterNestedTLV(data)
+		if err != nil {
+			return
+		}
+		got := joinChunks(chunks)
+		if !bytes.HasPrefix(data, got) {
+			t.Fatalf("chunks encode to %x, which doesn't start %x", got, data)
+		}
+		// Only a chunk that doesn't fit may be left out.
+		if rest := data[len(got):]; len(rest) >= 8 {
+			if size := binary.BigEndian.Uint32(rest[4:8]); int64(size) <= int64(len(rest)-8) {
+				t.Fatalf("chunk %q of %d bytes left out, with %d bytes left", rest[:4], size, len(rest)-8)
+			}
+		}
+	})
+}
+
+func FuzzIterTLVLenient(f *testing.F) {
+	addSeeds(f, database(f, "Music/a.mp3", "Music/b.mp3"), record(f, "Music/c.mp3"))
+	valid := database(f, "Music/a.mp3", "Music/b.mp3")
+	damaged := append(bytes.Clone(valid[:len(valid)/2]), record(f, "Music/c.mp3")...)
+	f.Add(damaged)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var chunks []*Chunk
+		damage, err := IterTLVLenient(data, []string{"vrsn", "otrk"}, func(chunk *Chunk) error {
+			chunks = append(chunks, chunk)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The chunks and the damage must cover data in order, each byte
+		// once.
+		pos := 0
+		for len(chunks) > 0 || len(damage) > 0 {
+			switch {
+			case len(damage) > 0 && damage[0].Offset == pos:
+				if damage[0].Length <= 0 {
+					t.Fatalf("empty damage at %d", pos)
+				}
+				pos += damage[0].Length
+				damage = damage[1:]
+			case len(chunks) > 0 && chunks[0].Offset == int64(pos):
+				end := pos + 8 + len(chunks[0].Value)
+				if end > len(data) || !bytes.Equal(data[pos:end], MakeChunk(chunks[0].Tag, chunks[0].Value)) {
+					t.Fatalf("chunk %q at %d doesn't match the input", chunks[0].Tag, pos)
+				}
+				pos = end
+				chunks = chunks[1:]
+			default:
+				t.Fatalf("nothing covers offset %d", pos)
+			}
+		}
+		if pos != len(data) {
+			t.Fatalf("chunks and damage cover %d bytes, want %d", pos, len(data))
+		}
+	})
+}
+
+// astralStrings hold characters outside the Basic Multilingual Plane, which
+// UTF-16 stores as surrogate pairs.
+var astralStrings = []string{
+	"🎵",
+	"Music/🎵 Mix/01 - 🔥🔥.mp3",
+	"𠀋𠂢𡈽",
+	"Music/日本/𠮷野家.flac",
+	"👩🏽‍🎤 Beyoncé",
+	"\U0010FFFD",
+}
+
+func TestU16BERoundTripAstral(t *testing.T) {
+	for _, s := range astralStrings {
+		encoded, err := EncodeU16BE(s)
+		if err != nil {
+			t.Fatalf("EncodeU16BE(%q): %v", s, err)
+		}
+		chunks, err := IterNestedTLV(append(MakeChunk("tsng", encoded), MakeChunk("bmis", []byte{0})...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunks) != 2 || chunks[0].Tag != "tsng" {
+			t.Fatalf("read back %d chunks for %q", len(chunks), s)
+		}
+		got, err := DecodeU16BE(chunks[0].Value)
+		if err != nil {
+			t.Fatalf("DecodeU16BE(%q): %v", s, err)
+		}
+		if got != s {
+			t.Errorf("%q round-trips as %q", s, got)
+		}
+		if got, err := DecodeU16(chunks[0].Value); err != nil || got != s {
+			t.Errorf("DecodeU16 of %q = %q, %v", s, got, err)
+		}
+	}
+}
+
+func TestEncodeU16BESurrogatePair(t *testing.T) {
+	// U+1F3B5 is D83C DFB5 in UTF-16.
+	encoded, err := EncodeU16BE("🎵")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0xD8, 0x3C, 0xDF, 0xB5}; !bytes.Equal(encoded, want) {
+		t.Errorf("EncodeU16BE(🎵) = % X, want % X", encoded, want)
+	}
+	// An unpaired surrogate decodes to U+FFFD.
+	if got, err := DecodeU16BE([]byte{0xD8, 0x3C, 0x00, 0x41}); err != nil || got != "�A" {
+		t.Errorf("DecodeU16BE of an unpaired surrogate = %q, %v", got, err)
+	}
+}
+
+func TestIterTLVOversizedChunk(t *testing.T) {
+	huge := []byte("otrk\xff\xff\xff\xf0")
+	tests := []struct {
+		name   string
+		reader func(data []byte) io.Reader
+	}{
+		{"seekable", func(data []byte) io.Reader { return bytes.NewReader(data) }},
+		{"stream", func(data []byte) io.Reader { return nonSeeker{bytes.NewReader(data)} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Beyond MaxChunkSize.
+			data := append(database(t, "Music/a.mp3"), huge...)
+			if _, err := IterTLV(tt.reader(data)); !errors.Is(err, ErrChunkTooLarge) {
+				t.Errorf("IterTLV error = %v, want %v", err, ErrChunkTooLarge)
+			}
+			// Within MaxChunkSize but beyond the input.
+			data = append(database(t, "Music/a.mp3"), MakeChunk("otrk", make([]byte, 64))[:40]...)
+			_, err := IterTLV(tt.reader(data))
+			if err == nil {
+				t.Fatal("IterTLV of a truncated chunk succeeded")
+			}
+			if tt.name == "seekable" && !errors.Is(err, ErrChunkTooLarge) {
+				t.Errorf("IterTLV error = %v, want %v", err, ErrChunkTooLarge)
+			}
+		})
+	}
+	if chunks, _ := IterNestedTLV(huge); len(chunks) != 0 {
+		t.Errorf("IterNestedTLV read %d chunks from a header claiming 4 GB", len(chunks))
+	}
+}
+
+func TestDecodeU16ByteOrders(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"big-endian", []byte{0, 'C', 0, 'a', 0, 'f', 0, 0xE9}, "Café"},
+		{"big-endian with BOM", []byte{0xFE, 0xFF, 0, 'C', 0, 'a', 0, 'f', 0, 0xE9}, "Café"},
+		{"little-endian with BOM", []byte{0xFF, 0xFE, 'C', 0, 'a', 0, 'f', 0, 0xE9, 0}, "Café"},
+		{"little-endian surrogate pair", []byte{0xFF, 0xFE, 0x3C, 0xD8, 0xB5, 0xDF}, "🎵"},
+		{"empty", nil, ""},
+		{"only a BOM", []byte{0xFF, 0xFE}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeU16(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("DecodeU16(% X) = %q, want %q", tt.in, got, tt.want)
+			}
+			if bom := len(tt.in) >= 2 && tt.in[0] != 0; HasU16BOM(tt.in) != bom {
+				t.Errorf("HasU16BOM(% X) = %v, want %v", tt.in, !bom, bom)
+			}
+		})
+	}
+	// Without a BOM the text is read as big-endian, as Serato writes it.
+	if got, err := DecodeU16([]byte{'C', 0}); err != nil || got != "䌀" {
+		t.Errorf("DecodeU16 of little-endian text without a BOM = %q, %v", got, err)
+	}
+	// The writer never adds a BOM.
+	if encoded, err := EncodeU16BE("Café"); err != nil || HasU16BOM(encoded) {
+		t.Errorf("EncodeU16BE(Café) = % X, %v", encoded, err)
+	}
+}
+
+func TestChunkOffsets(t *testing.T) {
+	// Chunks of 3, 0 and 5 bytes, the last holding two nested chunks.
+	nested := append(MakeChunk("ab  ", nil), MakeChunk("cd  ", []byte{9})...)
+	data := append(MakeChunk("vrsn", []byte{1, 2, 3}), MakeChunk("empt", nil)...)
+	data = append(data, MakeChunk("otrk", nested)...)
+	data = append(data, MakeChunk("last", []byte{1, 2, 3, 4, 5})...)
+
+	chunks, err := IterTLV(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for _, chunk := range chunks {
+		offsets = append(offsets, chunk.Offset)
+	}
+	if want := []int64{0, 11, 19, 44}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("IterTLV offsets = %v, want %v", offsets, want)
+	}
+
+	// Nested offsets count from the start of the parent's value.
+	inner, err := IterNestedTLV(chunks[2].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets = nil
+	for _, chunk := range inner {
+		offsets = append(offsets, chunk.Offset)
+	}
+	if want := []int64{0, 8}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("IterNestedTLV offsets = %v, want %v", offsets, want)
+	}
+
+	// Errors say where the bad chunk starts.
+	_, err = IterTLV(nonSeeker{bytes.NewReader(data[:len(data)-2])})
+	if err == nil || !strings.Contains(err.Error(), "at offset 44") {
+		t.Errorf("IterTLV of a truncated chunk: %v, want an error at offset 44", err)
+	}
+}